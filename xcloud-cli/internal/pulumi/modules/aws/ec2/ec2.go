@@ -123,6 +123,8 @@ func CreateInstances(ctx *pulumi.Context, configs []InstanceConfig, subnets map[
 		outs[c.Name+"_id"] = inst.ID().ToStringOutput()
 		outs[c.Name+"_public_ip"] = inst.PublicIp
 		outs[c.Name+"_private_ip"] = inst.PrivateIp
+		outs[c.Name+"_env"] = pulumi.String(c.Env).ToStringOutput()
+		outs[c.Name+"_owner"] = pulumi.String(c.Owner).ToStringOutput()
 	}
 	return outs, nil
 }
@@ -4,6 +4,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
@@ -27,14 +28,31 @@ var playbookCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		become, err := becomeOptions(cmd)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+
+		loadPlugins(filepath.Join(filepath.Dir(file), "plugins"))
+
+		ctx, cancel := runContext()
+		defer cancel()
+
 		exec := executor.New(AggregateOutput, CheckMode, DiffMode)
 		exec.MaxWorkers = MaxWorkers
-		exec.Execute(plays, inventoryPath)
+		exec.TaskTimeout = TaskTimeout
+		exec.Become = become
+		exec.Execute(ctx, plays, inventoryPath)
+		if code := exitCode(ctx); code != 0 {
+			cancel()
+			os.Exit(code)
+		}
 	},
 }
 
 func init() {
-	playbookCmd.Flags().StringVarP(&inventoryPath, "inventory", "i", "hosts.yaml", "Inventory file")
+	playbookCmd.Flags().StringVarP(&inventoryPath, "inventory", "i", "hosts.yaml", "Inventory file, directory, executable script or plugin config")
 	playbookCmd.Flags().IntVarP(&MaxWorkers, "forks", "f", 5, "Max parallel tasks")
 	playbookCmd.Flags().BoolVarP(&AggregateOutput, "aggregate", "A", false, "Aggregate output from identical results")
 	playbookCmd.Flags().BoolVarP(&CheckMode, "check", "C", false, "Dry-run mode")
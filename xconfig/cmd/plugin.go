@@ -1,18 +1,152 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/spf13/cobra"
+	"xconfig/internal/modules"
+)
+
+var (
+	pluginInstallName  string
+	pluginInstallForce bool
 )
 
 var pluginCmd = &cobra.Command{
 	Use:   "plugin",
 	Short: "Run or manage Xconfig plugins",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("[Xconfig] Plugin system not implemented yet.")
+	Long: `插件是以 JSON 通过 stdin/stdout 通信的外部模块，放在插件目录中即可在 playbook 中按名称使用：
+
+  - name: Drain web1
+    haproxy_backend:
+      backend: web
+      server: web1
+      state: drain`,
+}
+
+var pluginListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List installed plugins",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		plugins, err := modules.DiscoverPlugins(PluginDirs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		}
+		if len(plugins) == 0 {
+			fmt.Printf("No plugins found in %s\n", strings.Join(PluginDirs, ", "))
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tCHECK MODE\tPATH\tDESCRIPTION")
+		for _, p := range plugins {
+			name := p.Name
+			if _, builtin := modules.GetHandler(p.Name); builtin {
+				name += " (shadowed by built-in)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\n", name, orDash(p.Version), p.CheckMode, p.Path, p.Description)
+		}
+		return w.Flush()
+	},
+}
+
+var pluginInstallCmd = &cobra.Command{
+	Use:   "install SOURCE",
+	Short: "Install a plugin from an executable, a plugin directory or a .tar.gz archive (path or URL)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(PluginDirs) == 0 {
+			return fmt.Errorf("no plugin directory configured")
+		}
+		p, err := modules.InstallPlugin(context.Background(), args[0], PluginDirs[0], modules.InstallOptions{Name: pluginInstallName, Force: pluginInstallForce})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Installed plugin %s (%s) to %s\n", p.Name, orDash(p.Version), filepath.Join(p.Dir, p.Name))
+		return nil
 	},
 }
 
+var pluginInfoCmd = &cobra.Command{
+	Use:   "info NAME",
+	Short: "Show details of an installed plugin",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		plugins, err := modules.DiscoverPlugins(PluginDirs)
+		for _, p := range plugins {
+			if p.Name != args[0] {
+				continue
+			}
+			fmt.Printf("Name:        %s\n", p.Name)
+			fmt.Printf("Version:     %s\n", orDash(p.Version))
+			fmt.Printf("Author:      %s\n", orDash(p.Author))
+			fmt.Printf("Description: %s\n", orDash(p.Description))
+			fmt.Printf("Executable:  %s\n", p.Path)
+			fmt.Printf("Check mode:  %v\n", p.CheckMode)
+			fmt.Printf("Protocol:    json v%d (stdin/stdout)\n", modules.PluginProtocol)
+			if len(p.Options) > 0 {
+				fmt.Println("Options:")
+				names := make([]string, 0, len(p.Options))
+				for name := range p.Options {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Printf("  %-16s %s\n", name, p.Options[name])
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		}
+		return fmt.Errorf("plugin %s not found in %s", args[0], strings.Join(PluginDirs, ", "))
+	},
+}
+
+// loadPlugins registers the plugins of the configured directories, and of
+// extra directories such as the plugins/ folder next to a playbook, as
+// modules. Problems are reported without stopping the run.
+func loadPlugins(extra ...string) {
+	modules.PluginDirs = append(extra, PluginDirs...)
+	shadowed, err := modules.LoadPlugins()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+	}
+	for _, name := range shadowed {
+		fmt.Fprintf(os.Stderr, "⚠️ plugin %s is shadowed by the built-in module of the same name\n", name)
+	}
+}
+
+// defaultPluginDirs reads XCONFIG_PLUGIN_PATH (colon separated), falling back
+// to ~/.xconfig/plugins.
+func defaultPluginDirs() []string {
+	if env := os.Getenv("XCONFIG_PLUGIN_PATH"); env != "" {
+		return filepath.SplitList(env)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return []string{".xconfig/plugins"}
+	}
+	return []string{filepath.Join(home, ".xconfig", "plugins")}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func init() {
+	pluginInstallCmd.Flags().StringVar(&pluginInstallName, "name", "", "module name for a bare executable (default: file name without extension)")
+	pluginInstallCmd.Flags().BoolVar(&pluginInstallForce, "force", false, "replace an installed plugin with the same name")
+	pluginCmd.AddCommand(pluginListCmd, pluginInstallCmd, pluginInfoCmd)
 	addCommandOnce(rootCmd, pluginCmd)
 }
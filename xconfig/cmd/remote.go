@@ -2,13 +2,16 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 	"xconfig/core/executor"
 	"xconfig/core/parser"
 	"xconfig/internal/inventory"
+	"xconfig/internal/modules"
 	"xconfig/internal/ssh"
 )
 
@@ -25,6 +28,12 @@ var remoteCmd = &cobra.Command{
 			return
 		}
 
+		become, err := becomeOptions(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
 		task := parser.Task{}
 		switch module {
 		case "shell":
@@ -36,8 +45,25 @@ var remoteCmd = &cobra.Command{
 			if len(parts) == 2 {
 				task.Template = &parser.Template{Src: parts[0], Dest: parts[1]}
 			}
+		case "fetch":
+			task.Fetch = &parser.Fetch{}
+			if err := (&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: args}).Decode(task.Fetch); err != nil {
+				fmt.Println(err)
+				return
+			}
+		default:
+			if _, builtin := modules.GetHandler(module); builtin {
+				fmt.Printf("Module %s is not supported by remote\n", module)
+				return
+			}
+			loadPlugins()
+			task.External = map[string]interface{}{module: args}
 		}
 
+		task.Timeout = TaskTimeout
+		ctx, cancel := runContext()
+		defer cancel()
+
 		collector := &executor.MemoryCollector{}
 		var wg sync.WaitGroup
 		sem := make(chan struct{}, MaxWorkers)
@@ -48,16 +74,13 @@ var remoteCmd = &cobra.Command{
 				defer wg.Done()
 				sem <- struct{}{}
 				defer func() { <-sem }()
-				if CheckMode {
-					fmt.Printf("%s | SKIPPED\n", h.Name)
-					return
-				}
-
-				res := executor.ExecuteTask(task, h, nil, DiffMode)
-				collector.Collect(res)
+				h = executor.ApplyBecome(h, become)
+				res := executor.ExecuteTask(ctx, task, h, executor.CloneVars(h.Vars), DiffMode, CheckMode)
+				collector.Collect(executor.MaskResult(res))
 			}(h)
 		}
 		wg.Wait()
+		ssh.CloseAll()
 
 		results := collector.Results
 
@@ -68,11 +91,15 @@ var remoteCmd = &cobra.Command{
 				fmt.Printf("%s | %s | rc=%d >>\n%s\n", r.Host, r.ReturnMsg, r.ReturnCode, r.Output)
 			}
 		}
+		if code := exitCode(ctx); code != 0 {
+			cancel()
+			os.Exit(code)
+		}
 	},
 }
 
 func init() {
-	remoteCmd.Flags().StringVarP(&InventoryPath, "inventory", "i", "hosts.yaml", "Inventory file")
+	remoteCmd.Flags().StringVarP(&InventoryPath, "inventory", "i", "hosts.yaml", "Inventory file, directory, executable script or plugin config")
 	remoteCmd.Flags().StringVarP(&module, "module", "m", "shell", "Module to execute")
 	remoteCmd.Flags().StringVarP(&args, "args", "a", "", "Arguments for the module")
 	remoteCmd.Flags().IntVarP(&MaxWorkers, "forks", "f", 5, "Max parallel tasks")
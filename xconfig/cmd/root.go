@@ -1,16 +1,45 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+	"xconfig/internal/vault"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "xconfig",
 	Short: "Xconfig - 执行与编织任务和架构的现代工具",
 	Long:  `Xconfig 是一个现代化的 DevOps CLI 工具，融合任务执行、架构编排、拓扑建模与插件生态。`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		mode, err := ssh.NormalizeHostKeyChecking(HostKeyChecking)
+		if err != nil {
+			return err
+		}
+		ssh.DefaultHostKeyChecking = mode
+		ssh.DefaultKnownHostsFile = KnownHostsFile
+		ssh.DefaultPassphraseFile = PassphraseFile
+		inventory.CacheTTL = InventoryCacheTTL
+		modules.FactCacheDir = FactCacheDir
+		modules.FactCacheTTL = FactCacheTTL
+		vault.PasswordFile = VaultPasswordFile
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			ssh.PassphrasePrompt = promptPassphrase
+			vault.PasswordPrompt = promptVaultPassword
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		printBanner()
 	},
@@ -37,6 +66,91 @@ func init() {
 		false,
 		"when changing (small) files and templates, show the differences in those files",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&HostKeyChecking,
+		"host-key-checking",
+		ssh.HostKeyAcceptNew,
+		"SSH host key checking mode: strict, accept-new or off (per host: ansible_host_key_checking)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&KnownHostsFile,
+		"known-hosts",
+		"",
+		"known_hosts file used for host key checking (default ~/.ssh/known_hosts)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&PassphraseFile,
+		"private-key-passphrase-file",
+		"",
+		"file containing the passphrase for encrypted private keys (prompted on a terminal otherwise)",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&InventoryCacheTTL,
+		"inventory-cache-ttl",
+		0,
+		"reuse dynamic inventory (scripts, plugins) results for this long, e.g. 10m (0 disables the cache)",
+	)
+	rootCmd.PersistentFlags().StringVar(&FactCacheDir, "fact-cache", "", "directory caching gathered facts as JSON, one file per host (empty disables)")
+	rootCmd.PersistentFlags().DurationVar(&FactCacheTTL, "fact-cache-ttl", 24*time.Hour, "how long cached facts are reused")
+	rootCmd.PersistentFlags().DurationVar(&RunTimeout, "timeout", 0, "stop the whole run after this long, e.g. 30m (0 disables)")
+	rootCmd.PersistentFlags().IntVar(&TaskTimeout, "task-timeout", 0, "default timeout in seconds for each task (0 disables; per task: timeout)")
+	rootCmd.PersistentFlags().StringVar(&VaultPasswordFile, "vault-password-file", os.Getenv("XCONFIG_VAULT_PASSWORD_FILE"), "file containing the vault password, run when executable (env XCONFIG_VAULT_PASSWORD_FILE)")
+	rootCmd.PersistentFlags().StringSliceVar(&PluginDirs, "plugin-dir", defaultPluginDirs(), "directories searched for plugin modules; install uses the first (env XCONFIG_PLUGIN_PATH)")
+	rootCmd.PersistentFlags().BoolVarP(&Become, "become", "b", false, "run operations with become (privilege escalation)")
+	rootCmd.PersistentFlags().StringVar(&BecomeUser, "become-user", "", "run operations as this user (default root)")
+	rootCmd.PersistentFlags().StringVar(&BecomeMethod, "become-method", "", "privilege escalation method: sudo, su or doas (default sudo)")
+	rootCmd.PersistentFlags().StringVar(&BecomePasswordFile, "become-password-file", "", "file containing the become password")
+}
+
+// becomeOptions 将命令行提权参数转换为 executor 的默认提权设置，
+// 未显式指定 --become 时保持继承 inventory 的配置
+func becomeOptions(cmd *cobra.Command) (parser.BecomeOptions, error) {
+	opts := parser.BecomeOptions{BecomeUser: BecomeUser, BecomeMethod: BecomeMethod}
+	if cmd.Flags().Changed("become") {
+		become := Become
+		opts.Become = &become
+	}
+	if BecomePasswordFile != "" {
+		data, err := os.ReadFile(BecomePasswordFile)
+		if err != nil {
+			return opts, fmt.Errorf("read become password file: %v", err)
+		}
+		opts.BecomePassword = strings.TrimRight(string(data), "\r\n")
+	}
+	return opts, nil
+}
+
+// runContext 返回本次运行的 context：Ctrl-C / SIGTERM 取消运行，
+// 设置了 --timeout 时到期自动取消
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if RunTimeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, RunTimeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// exitCode 在运行被取消或超时时返回对应的退出码（130 / 124），否则返回 0
+func exitCode(ctx context.Context) int {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return 124
+	case ctx.Err() != nil:
+		return 130
+	}
+	return 0
+}
+
+// promptPassphrase 在终端中无回显地读取私钥口令
+func promptPassphrase(keyFile string) ([]byte, error) {
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", keyFile)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
 }
 
 // 启动时打印 ASCII Banner
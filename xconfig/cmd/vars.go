@@ -1,10 +1,26 @@
 // cmd/vars.go
 package cmd
 
+import "time"
+
 var (
-	AggregateOutput bool   // --aggregate / -A
-	CheckMode       bool   // --check / -C
-	DiffMode        bool   // --diff / -D
-	InventoryPath   string // --inventory / -i
-	MaxWorkers      int    // --forks / -f
+	AggregateOutput    bool          // --aggregate / -A
+	CheckMode          bool          // --check / -C
+	DiffMode           bool          // --diff / -D
+	InventoryPath      string        // --inventory / -i
+	MaxWorkers         int           // --forks / -f
+	HostKeyChecking    string        // --host-key-checking
+	KnownHostsFile     string        // --known-hosts
+	PassphraseFile     string        // --private-key-passphrase-file
+	Become             bool          // --become / -b
+	BecomeUser         string        // --become-user
+	BecomeMethod       string        // --become-method
+	BecomePasswordFile string        // --become-password-file
+	InventoryCacheTTL  time.Duration // --inventory-cache-ttl
+	RunTimeout         time.Duration // --timeout
+	TaskTimeout        int           // --task-timeout
+	FactCacheDir       string        // --fact-cache
+	FactCacheTTL       time.Duration // --fact-cache-ttl
+	VaultPasswordFile  string        // --vault-password-file
+	PluginDirs         []string      // --plugin-dir
 )
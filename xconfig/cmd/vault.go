@@ -1,18 +1,341 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"xconfig/internal/vault"
+)
+
+var (
+	vaultOutput              string
+	vaultNewPasswordFile     string
+	vaultEncryptedStringName string
 )
 
 var vaultCmd = &cobra.Command{
 	Use:   "vault",
 	Short: "Encrypt/decrypt secrets",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("[Xconfig] Vault feature is not implemented yet.")
+	Long: `加密 / 解密敏感数据（AES-256-GCM，密钥由口令经 scrypt 派生）。
+加密文件与 !vault 标记的值会在加载 playbook、vars_files、inventory 及 group_vars/host_vars 时自动解密，
+解密出的值在任务输出与日志中显示为 ********。`,
+}
+
+var vaultEncryptCmd = &cobra.Command{
+	Use:   "encrypt [file...]",
+	Short: "Encrypt files in place (stdin to stdout without files)",
+	RunE: func(cmd *cobra.Command, files []string) error {
+		password, err := newVaultPassword()
+		if err != nil {
+			return err
+		}
+		return transformFiles(files, func(path string, data []byte) ([]byte, error) {
+			if vault.IsEncrypted(data) {
+				return nil, fmt.Errorf("%s is already encrypted", path)
+			}
+			return vault.Encrypt(data, password)
+		})
+	},
+}
+
+var vaultDecryptCmd = &cobra.Command{
+	Use:   "decrypt [file...]",
+	Short: "Decrypt files in place (stdin to stdout without files)",
+	RunE: func(cmd *cobra.Command, files []string) error {
+		return transformFiles(files, func(path string, data []byte) ([]byte, error) {
+			if !vault.IsEncrypted(data) {
+				return nil, fmt.Errorf("%s is not encrypted", path)
+			}
+			return decryptVault(path, data)
+		})
+	},
+}
+
+var vaultViewCmd = &cobra.Command{
+	Use:   "view file...",
+	Short: "Print decrypted files",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, files []string) error {
+		for _, path := range files {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			plain, err := decryptVault(path, data)
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(plain)
+		}
+		return nil
+	},
+}
+
+var vaultEditCmd = &cobra.Command{
+	Use:   "edit file",
+	Short: "Edit an encrypted file with $EDITOR (creates it when missing)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		var plain []byte
+		var password []byte
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if plain, err = decryptVault(path, data); err != nil {
+				return err
+			}
+			if password, err = vault.Password(); err != nil {
+				return err
+			}
+		case errors.Is(err, os.ErrNotExist):
+			if password, err = newVaultPassword(); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		edited, err := editInEditor(plain, filepath.Ext(path))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, plain) && data != nil {
+			fmt.Fprintln(os.Stderr, "No changes, file left untouched.")
+			return nil
+		}
+		out, err := vault.Encrypt(edited, password)
+		if err != nil {
+			return err
+		}
+		return writeFileKeepMode(path, out)
 	},
 }
 
+var vaultRekeyCmd = &cobra.Command{
+	Use:   "rekey file...",
+	Short: "Re-encrypt files with a new password",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, files []string) error {
+		plains := make([][]byte, len(files))
+		for i, path := range files {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if plains[i], err = decryptVault(path, data); err != nil {
+				return err
+			}
+		}
+		var password []byte
+		var err error
+		if vaultNewPasswordFile != "" {
+			password, err = vault.ReadPasswordFile(vaultNewPasswordFile)
+		} else {
+			password, err = promptNewVaultPassword()
+		}
+		if err != nil {
+			return err
+		}
+		for i, path := range files {
+			out, err := vault.Encrypt(plains[i], password)
+			if err != nil {
+				return err
+			}
+			if err := writeFileKeepMode(path, out); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Rekeyed %s\n", path)
+		}
+		return nil
+	},
+}
+
+var vaultEncryptStringCmd = &cobra.Command{
+	Use:     "encrypt-string [value]",
+	Aliases: []string{"encrypt_string"},
+	Short:   "Encrypt a single value for use as a !vault tagged YAML value",
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var value []byte
+		if len(args) == 1 {
+			value = []byte(args[0])
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			value = bytes.TrimRight(data, "\r\n")
+		}
+		password, err := newVaultPassword()
+		if err != nil {
+			return err
+		}
+		out, err := vault.Encrypt(value, password)
+		if err != nil {
+			return err
+		}
+		fmt.Print(vault.FormatInline(vaultEncryptedStringName, out))
+		return nil
+	},
+}
+
+func decryptVault(path string, data []byte) ([]byte, error) {
+	plain, err := vault.DecryptWithPassword(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// transformFiles rewrites each file with fn, or filters stdin to stdout when
+// no file is given. --output redirects a single result ("-" is stdout).
+func transformFiles(files []string, fn func(path string, data []byte) ([]byte, error)) error {
+	if len(files) == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		out, err := fn("stdin", data)
+		if err != nil {
+			return err
+		}
+		return writeOutput(vaultOutput, out)
+	}
+	if vaultOutput != "" && len(files) > 1 {
+		return errors.New("--output needs exactly one input file")
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := fn(path, data)
+		if err != nil {
+			return err
+		}
+		if vaultOutput != "" {
+			return writeOutput(vaultOutput, out)
+		}
+		if err := writeFileKeepMode(path, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeOutput(path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeFileKeepMode(path, data)
+}
+
+// writeFileKeepMode replaces path atomically, keeping its permissions (0600
+// for new files).
+func writeFileKeepMode(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// editInEditor writes content to a private temp file, opens $EDITOR (vi by
+// default) on it and returns the result.
+func editInEditor(content []byte, ext string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "xconfig-vault-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "edit"+ext)
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		return nil, err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	c := exec.Command("sh", "-c", editor+` "$1"`, "sh", file)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("editor %s: %w", editor, err)
+	}
+	return os.ReadFile(file)
+}
+
+// newVaultPassword returns the password for new encrypted data: the
+// configured password file, or a confirmed interactive prompt.
+func newVaultPassword() ([]byte, error) {
+	if VaultPasswordFile != "" {
+		return vault.Password()
+	}
+	return promptNewVaultPassword()
+}
+
+func promptNewVaultPassword() ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("no vault password: use --vault-password-file")
+	}
+	first, err := readSecret("New vault password: ")
+	if err != nil {
+		return nil, err
+	}
+	second, err := readSecret("Confirm new vault password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(first, second) {
+		return nil, errors.New("vault passwords do not match")
+	}
+	if len(first) == 0 {
+		return nil, errors.New("empty vault password")
+	}
+	return first, nil
+}
+
+// promptVaultPassword 在终端中无回显地读取 vault 口令
+func promptVaultPassword() ([]byte, error) {
+	return readSecret("Vault password: ")
+}
+
+func readSecret(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return []byte(strings.TrimRight(string(p), "\r\n")), err
+}
+
 func init() {
+	vaultEncryptCmd.Flags().StringVar(&vaultOutput, "output", "", "write the result to this file instead (\"-\" for stdout)")
+	vaultDecryptCmd.Flags().StringVar(&vaultOutput, "output", "", "write the result to this file instead (\"-\" for stdout)")
+	vaultRekeyCmd.Flags().StringVar(&vaultNewPasswordFile, "new-vault-password-file", "", "file containing the new vault password")
+	vaultEncryptStringCmd.Flags().StringVarP(&vaultEncryptedStringName, "name", "n", "", "variable name to print with the encrypted value")
+	vaultCmd.AddCommand(vaultEncryptCmd, vaultDecryptCmd, vaultViewCmd, vaultEditCmd, vaultRekeyCmd, vaultEncryptStringCmd)
 	addCommandOnce(rootCmd, vaultCmd)
 }
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+// ApplyBecome returns a copy of host with the privilege escalation settings
+// of each layer applied in order, so later layers (play, then task) override
+// earlier ones (CLI defaults, inventory). Only fields a layer sets are applied.
+func ApplyBecome(host inventory.Host, layers ...parser.BecomeOptions) inventory.Host {
+	for _, l := range layers {
+		if l.Become != nil {
+			host.Become = *l.Become
+		}
+		if l.BecomeUser != "" {
+			host.BecomeUser = l.BecomeUser
+		}
+		if l.BecomeMethod != "" {
+			host.BecomeMethod = l.BecomeMethod
+		}
+		if l.BecomePassword != "" {
+			host.BecomePassword = l.BecomePassword
+		}
+	}
+	return host
+}
+
+// becomePasswordFromVars falls back to the ansible_become_password variable
+// when no layer provided a password.
+func becomePasswordFromVars(host inventory.Host, vars map[string]interface{}) inventory.Host {
+	if !host.Become || host.BecomePassword != "" {
+		return host
+	}
+	for _, key := range []string{"ansible_become_password", "ansible_become_pass"} {
+		if pw, ok := vars[key].(string); ok && pw != "" {
+			host.BecomePassword = pw
+			break
+		}
+	}
+	return host
+}
@@ -0,0 +1,33 @@
+package executor
+
+import (
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+func TestApplyBecomePrecedence(t *testing.T) {
+	yes, no := true, false
+	host := inventory.Host{Name: "web1", Become: true, BecomeUser: "deploy"}
+
+	play := parser.BecomeOptions{Become: &yes, BecomeMethod: "doas"}
+	task := parser.BecomeOptions{BecomeUser: "postgres"}
+	got := ApplyBecome(host, play, task)
+	if !got.Become || got.BecomeUser != "postgres" || got.BecomeMethod != "doas" {
+		t.Fatalf("unexpected become settings: %+v", got)
+	}
+
+	got = ApplyBecome(host, parser.BecomeOptions{Become: &no})
+	if got.Become {
+		t.Fatalf("expected task to disable become")
+	}
+	if host.BecomeUser != "deploy" {
+		t.Fatalf("ApplyBecome must not modify its input")
+	}
+
+	vars := map[string]interface{}{"ansible_become_password": "s3cret"}
+	if got := becomePasswordFromVars(ApplyBecome(host), vars); got.BecomePassword != "s3cret" {
+		t.Fatalf("expected password from vars, got %q", got.BecomePassword)
+	}
+}
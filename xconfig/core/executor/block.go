@@ -0,0 +1,197 @@
+package executor
+
+import (
+	"context"
+	"sync"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/ssh"
+)
+
+// playState is the per-play bookkeeping shared by tasks, blocks and handlers.
+type playState struct {
+	// ctx is the run's context; once it is done no further task starts.
+	ctx      context.Context
+	play     *parser.Play
+	hostVars map[string]map[string]interface{}
+	stats    map[string]*hostStats
+	pending  *notifications
+
+	mu sync.Mutex
+	// lastFailure holds the most recent failed result per host; rescue tasks
+	// see it as ansible_failed_result / ansible_failed_task.
+	lastFailure map[string]failure
+	// aborted is set when any_errors_fatal stops the play.
+	aborted bool
+	// free is set for the free strategy, where hosts run independently.
+	free bool
+}
+
+type failure struct {
+	task   parser.Task
+	result ssh.CommandResult
+}
+
+func newPlayState(ctx context.Context, play *parser.Play, hostVars map[string]map[string]interface{}, stats map[string]*hostStats) *playState {
+	return &playState{
+		ctx:         ctx,
+		play:        play,
+		hostVars:    hostVars,
+		stats:       stats,
+		pending:     newNotifications(),
+		lastFailure: make(map[string]failure),
+	}
+}
+
+func (ps *playState) abort() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.aborted = true
+}
+
+// isAborted reports whether the play must stop, either because of
+// any_errors_fatal or because the run was cancelled.
+func (ps *playState) isAborted() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.aborted || ps.ctx.Err() != nil
+}
+
+func (ps *playState) recordFailure(host string, task parser.Task, res ssh.CommandResult) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.lastFailure[host] = failure{task: task, result: res}
+}
+
+// failedResult reports whether res stops the host.
+func failedResult(res ssh.CommandResult) bool {
+	return res.ReturnMsg == "FAILED" || res.ReturnMsg == "UNREACHABLE"
+}
+
+// runTasks runs tasks in order on hosts, the way the linear strategy does:
+// each task runs on every remaining host before the next one starts. A host
+// that fails receives no further tasks from the list. It returns the hosts
+// whose failure was not rescued. top marks the play's own task list, where
+// the play level any_errors_fatal applies.
+func (e *Executor) runTasks(ps *playState, tasks []parser.Task, hosts []inventory.Host, top bool) map[string]bool {
+	failed := make(map[string]bool)
+	for _, task := range tasks {
+		if ps.isAborted() {
+			break
+		}
+		active := activeHosts(hosts, failed)
+		if len(active) == 0 {
+			break
+		}
+
+		taskFailed := make(map[string]bool)
+		switch {
+		case task.Meta != "":
+			taskFailed = e.runMeta(ps, task, active)
+		case task.IsBlock():
+			taskFailed = e.runBlock(ps, task, active)
+		default:
+			for _, r := range e.runOnHosts(ps, task, "TASK", active) {
+				if failedResult(r) && !(task.IgnoreErrors && r.ReturnMsg == "FAILED") {
+					taskFailed[r.Host] = true
+				}
+			}
+		}
+		mergeHosts(failed, taskFailed)
+		if len(taskFailed) > 0 && (task.AnyErrorsFatal || (top && ps.play.AnyErrorsFatal)) {
+			ps.abort()
+		}
+	}
+	return failed
+}
+
+// runBlock runs a block on hosts, then rescue on the hosts where the block
+// failed and always on every host. A host is rescued when its rescue tasks
+// succeed: its earlier failure is no longer counted and it carries on.
+func (e *Executor) runBlock(ps *playState, block parser.Task, hosts []inventory.Host) map[string]bool {
+	// Failures inside the block are discounted when the host is rescued.
+	failedBefore := make(map[string]int, len(hosts))
+	for _, h := range hosts {
+		if hs := ps.stats[h.Name]; hs != nil {
+			failedBefore[h.Name] = hs.Failed
+		}
+	}
+
+	failed := e.runTasks(ps, inheritAll(block, block.Block), hosts, false)
+
+	if len(block.Rescue) > 0 && len(failed) > 0 && !ps.isAborted() {
+		var rescueHosts []inventory.Host
+		for _, h := range hosts {
+			if failed[h.Name] {
+				rescueHosts = append(rescueHosts, h)
+				ps.mu.Lock()
+				f, ok := ps.lastFailure[h.Name]
+				ps.mu.Unlock()
+				if ok {
+					vars := ps.hostVars[h.Name]
+					vars["ansible_failed_task"] = map[string]interface{}{"name": f.task.Name, "action": f.task.Type()}
+					vars["ansible_failed_result"] = f.result.Registered()
+				}
+			}
+		}
+		stillFailed := e.runTasks(ps, inheritAll(block, block.Rescue), rescueHosts, false)
+		for _, h := range rescueHosts {
+			if stillFailed[h.Name] {
+				continue
+			}
+			if hs := ps.stats[h.Name]; hs != nil {
+				hs.Failed = failedBefore[h.Name]
+				hs.Rescued++
+			}
+			delete(failed, h.Name)
+		}
+	}
+
+	if len(block.Always) > 0 && !ps.isAborted() {
+		mergeHosts(failed, e.runTasks(ps, inheritAll(block, block.Always), hosts, false))
+	}
+	return failed
+}
+
+// inheritAll applies the keywords set on a block to the tasks inside it.
+func inheritAll(block parser.Task, tasks []parser.Task) []parser.Task {
+	out := make([]parser.Task, len(tasks))
+	for i, t := range tasks {
+		t.When.Expressions = append(append([]string(nil), block.When.Expressions...), t.When.Expressions...)
+		if t.Become == nil {
+			t.Become = block.Become
+		}
+		if t.BecomeUser == "" {
+			t.BecomeUser = block.BecomeUser
+		}
+		if t.BecomeMethod == "" {
+			t.BecomeMethod = block.BecomeMethod
+		}
+		if t.BecomePassword == "" {
+			t.BecomePassword = block.BecomePassword
+		}
+		t.IgnoreErrors = t.IgnoreErrors || block.IgnoreErrors
+		t.AnyErrorsFatal = t.AnyErrorsFatal || block.AnyErrorsFatal
+		t.Notify = append(append(parser.StringList(nil), block.Notify...), t.Notify...)
+		out[i] = t
+	}
+	return out
+}
+
+// activeHosts returns the hosts not in failed.
+func activeHosts(hosts []inventory.Host, failed map[string]bool) []inventory.Host {
+	var out []inventory.Host
+	for _, h := range hosts {
+		if !failed[h.Name] {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func mergeHosts(dst, src map[string]bool) {
+	for h := range src {
+		dst[h] = true
+	}
+}
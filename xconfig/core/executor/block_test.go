@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+// runPlay runs play's tasks on the named hosts and returns the stats and the
+// hosts left failed.
+func runPlay(t *testing.T, play *parser.Play, names ...string) (map[string]*hostStats, map[string]bool) {
+	t.Helper()
+	var hosts []inventory.Host
+	hostVars := make(map[string]map[string]interface{})
+	stats := make(map[string]*hostStats)
+	for _, n := range names {
+		hosts = append(hosts, inventory.Host{Name: n})
+		hostVars[n] = map[string]interface{}{"inventory_hostname": n}
+		stats[n] = &hostStats{}
+	}
+	e := New(false, false, false)
+	e.MaxWorkers = 1
+	var failed map[string]bool
+	captureStdout(t, func() {
+		failed = e.runTasks(newPlayState(context.Background(), play, hostVars, stats), play.Tasks, hosts, true)
+	})
+	return stats, failed
+}
+
+func onHost(name string) parser.When {
+	return parser.When{Expressions: []string{"inventory_hostname == '" + name + "'"}}
+}
+
+func sortedCalls(calls []string) []string {
+	sort.Strings(calls)
+	return calls
+}
+
+func TestFailedHostStopsReceivingTasks(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{Tasks: []parser.Task{
+		{Name: "break web1", Command: "fail", When: onHost("web1")},
+		{Name: "next", Command: "next"},
+	}}
+	stats, failed := runPlay(t, play, "web1", "web2")
+
+	if !reflect.DeepEqual(sortedCalls(calls()), []string{"web1:fail", "web2:next"}) {
+		t.Fatalf("unexpected calls: %v", calls())
+	}
+	if !failed["web1"] || failed["web2"] || stats["web1"].Failed != 1 {
+		t.Fatalf("unexpected failure state: %v %+v", failed, stats["web1"])
+	}
+}
+
+func TestBlockRescueAlways(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{Tasks: []parser.Task{{
+		Name: "deploy",
+		Block: []parser.Task{
+			{Name: "step", Command: "fail", When: onHost("web1")},
+			{Name: "after", Command: "after"},
+		},
+		Rescue: []parser.Task{{Name: "rescue", Command: "rescue {{ ansible_failed_task.name }}"}},
+		Always: []parser.Task{{Name: "always", Command: "always"}},
+	}, {
+		Name: "next", Command: "next",
+	}}}
+	stats, failed := runPlay(t, play, "web1", "web2")
+
+	want := []string{"web1:always", "web1:fail", "web1:next", "web1:rescue step", "web2:after", "web2:always", "web2:next"}
+	if got := sortedCalls(calls()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	if len(failed) != 0 {
+		t.Fatalf("expected no failed hosts, got %v", failed)
+	}
+	if s := stats["web1"]; s.Failed != 0 || s.Rescued != 1 {
+		t.Fatalf("unexpected web1 stats: %+v", s)
+	}
+}
+
+func TestUnrescuedBlockRunsAlwaysThenStops(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{Tasks: []parser.Task{{
+		Name:   "deploy",
+		Block:  []parser.Task{{Name: "step", Command: "fail"}},
+		Rescue: []parser.Task{{Name: "rescue", Command: "fail again"}},
+		Always: []parser.Task{{Name: "always", Command: "always"}},
+	}, {
+		Name: "next", Command: "next",
+	}}}
+	stats, failed := runPlay(t, play, "web1")
+
+	if got := calls(); !reflect.DeepEqual(got, []string{"web1:fail", "web1:fail again", "web1:always"}) {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+	if !failed["web1"] || stats["web1"].Rescued != 0 {
+		t.Fatalf("expected web1 to stay failed: %v %+v", failed, stats["web1"])
+	}
+}
+
+func TestIgnoreErrorsAndFailedWhen(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{Tasks: []parser.Task{
+		{Name: "ignored", Command: "fail", IgnoreErrors: true},
+		{Name: "tolerated", Command: "fail", FailedWhen: parser.When{Expressions: []string{"out.rc > 1"}}, Register: "out"},
+		{Name: "check", Debug: &parser.MessageAction{Msg: "ERROR: disk full"}, Register: "msg",
+			FailedWhen: parser.When{Expressions: []string{"'ERROR' in msg.stdout"}}},
+		{Name: "never", Command: "never"},
+	}}
+	stats, failed := runPlay(t, play, "web1")
+
+	if got := calls(); !reflect.DeepEqual(got, []string{"web1:fail", "web1:fail"}) {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+	s := stats["web1"]
+	if s.Ignored != 1 || s.Changed != 1 || s.Failed != 1 || !failed["web1"] {
+		t.Fatalf("unexpected stats: %+v (failed %v)", s, failed)
+	}
+}
+
+func TestAnyErrorsFatalStopsAllHosts(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{AnyErrorsFatal: true, Tasks: []parser.Task{
+		{Name: "break web1", Command: "fail", When: onHost("web1")},
+		{Name: "next", Command: "next"},
+	}}
+	runPlay(t, play, "web1", "web2")
+
+	if got := calls(); !reflect.DeepEqual(got, []string{"web1:fail"}) {
+		t.Fatalf("expected the play to stop after the failure, got %v", got)
+	}
+}
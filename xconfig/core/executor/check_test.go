@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+)
+
+// captureStdout returns what fn prints.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	fn()
+	w.Close()
+	os.Stdout = orig
+	return <-done
+}
+
+func TestCheckModePredictsChanges(t *testing.T) {
+	orig, _ := modules.GetHandler("copy")
+	defer modules.Register("copy", orig)
+	var checks []bool
+	modules.Register("copy", func(ctx modules.Context, task parser.Task) ssh.CommandResult {
+		checks = append(checks, ctx.Check)
+		if task.Copy.Dest == "/etc/drifted" {
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED", Output: "would update /etc/drifted"}
+		}
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK"}
+	})
+
+	inv := writeInventory(t, "web1\n")
+	play := parser.Play{
+		Hosts: "all",
+		Tasks: []parser.Task{
+			{Name: "in sync", Copy: &parser.Copy{Src: "a", Dest: "/etc/synced"}},
+			{Name: "drifted", Copy: &parser.Copy{Src: "b", Dest: "/etc/drifted"}, Notify: parser.StringList{"reload"}},
+		},
+		Handlers: []parser.Task{{Name: "reload", Copy: &parser.Copy{Src: "c", Dest: "/etc/handler"}}},
+	}
+
+	e := New(false, true, false)
+	out := captureStdout(t, func() { e.Execute(context.Background(), []parser.Play{play}, inv) })
+
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 handler calls including the notified handler, got %d", len(checks))
+	}
+	for _, c := range checks {
+		if !c {
+			t.Fatalf("handler was called without ctx.Check in check mode")
+		}
+	}
+	for _, want := range []string{"PLAY RECAP (check mode", "would change: drifted", "would update /etc/drifted"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "dry-run") {
+		t.Fatalf("check mode should no longer skip tasks:\n%s", out)
+	}
+}
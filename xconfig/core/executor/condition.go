@@ -1,65 +1,90 @@
 package executor
 
-import "xconfig/core/parser"
+import (
+	"fmt"
 
-// EvaluateWhen returns true if the given when clause evaluates to true.
-// Currently this supports basic variable lookup: if each expression corresponds
-// to a variable name and that variable's value is truthy (not empty, "false" or
-// "0"), the condition is true. An empty when clause evaluates to true.
-func EvaluateWhen(when parser.When, vars map[string]interface{}) bool {
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// EvaluateWhen returns true if the given when clause evaluates to true. Each
+// expression is evaluated with the expression language in expr.go and all of
+// them must hold. An empty when clause evaluates to true. Invalid expressions
+// and references to undefined variables are returned as errors so that a typo
+// fails the task instead of silently skipping it.
+func EvaluateWhen(when parser.When, vars map[string]interface{}) (bool, error) {
 	if when.IsEmpty() {
-		return true
+		return true, nil
 	}
 	for _, expr := range when.Expressions {
-		if !evaluateExpression(expr, vars) {
-			return false
+		if expr == "" {
+			continue
+		}
+		ok, err := EvaluateExpression(expr, vars)
+		if err != nil || !ok {
+			return false, err
 		}
 	}
-	return true
+	return true, nil
 }
 
-func evaluateExpression(expr string, vars map[string]interface{}) bool {
-	if expr == "" {
-		return true
+// applyResultConditions applies the task's failed_when and changed_when
+// clauses to res. The result is registered first so the clauses can refer to
+// it (e.g. `changed_when: "'Created' in out.stdout"`). Skipped and
+// unreachable results are left alone, and changed_when is not applied to a
+// failed result.
+func applyResultConditions(task parser.Task, res ssh.CommandResult, vars map[string]interface{}) ssh.CommandResult {
+	if task.FailedWhen.IsEmpty() && task.ChangedWhen.IsEmpty() {
+		return res
+	}
+	if res.ReturnMsg == "SKIPPED" || res.ReturnMsg == "UNREACHABLE" {
+		return res
 	}
-	if val, ok := vars[expr]; ok {
-		switch v := val.(type) {
-		case string:
-			switch v {
-			case "", "false", "0":
-				return false
-			default:
-				return true
+	register := func() {
+		if task.Register != "" {
+			vars[task.Register] = res.Registered()
+		}
+	}
+
+	if !task.FailedWhen.IsEmpty() {
+		register()
+		failed, err := EvaluateWhen(task.FailedWhen, vars)
+		switch {
+		case err != nil:
+			return conditionError(res, "failed_when", err)
+		case failed:
+			res.ReturnMsg = "FAILED"
+			if res.ReturnCode == 0 {
+				res.ReturnCode = 1
 			}
-		case bool:
-			return v
-		case int:
-			return v != 0
-		case int8:
-			return v != 0
-		case int16:
-			return v != 0
-		case int32:
-			return v != 0
-		case int64:
-			return v != 0
-		case uint:
-			return v != 0
-		case uint8:
-			return v != 0
-		case uint16:
-			return v != 0
-		case uint32:
-			return v != 0
-		case uint64:
-			return v != 0
-		case float32:
-			return v != 0
-		case float64:
-			return v != 0
+		case res.ReturnMsg == "FAILED":
+			// Commands always report a change when they run.
+			res.ReturnMsg = "OK"
+			switch task.Type() {
+			case "shell", "command", "script":
+				res.ReturnMsg = "CHANGED"
+			}
+		}
+	}
+
+	if !task.ChangedWhen.IsEmpty() && res.ReturnMsg != "FAILED" {
+		register()
+		changed, err := EvaluateWhen(task.ChangedWhen, vars)
+		switch {
+		case err != nil:
+			return conditionError(res, "changed_when", err)
+		case changed:
+			res.ReturnMsg = "CHANGED"
 		default:
-			return v != nil
+			res.ReturnMsg = "OK"
 		}
 	}
-	return expr == "true"
+	return res
+}
+
+func conditionError(res ssh.CommandResult, keyword string, err error) ssh.CommandResult {
+	res.ReturnMsg = "FAILED"
+	res.ReturnCode = 1
+	res.Output = fmt.Sprintf("%s: %v", keyword, err)
+	return res
 }
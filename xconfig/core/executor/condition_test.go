@@ -9,27 +9,133 @@ import (
 func TestEvaluateWhen(t *testing.T) {
 	vars := map[string]interface{}{"flag": true, "count": 1, "empty": "", "number": 0}
 
-	if !EvaluateWhen(parser.When{}, vars) {
+	eval := func(when parser.When) bool {
+		t.Helper()
+		ok, err := EvaluateWhen(when, vars)
+		if err != nil {
+			t.Fatalf("EvaluateWhen(%v): %v", when.Expressions, err)
+		}
+		return ok
+	}
+
+	if !eval(parser.When{}) {
 		t.Fatalf("expected empty condition to evaluate true")
 	}
 
 	single := parser.When{Expressions: []string{"flag"}}
-	if !EvaluateWhen(single, vars) {
+	if !eval(single) {
 		t.Fatalf("expected flag to evaluate true")
 	}
 
 	multi := parser.When{Expressions: []string{"flag", "count"}}
-	if !EvaluateWhen(multi, vars) {
+	if !eval(multi) {
 		t.Fatalf("expected both expressions to evaluate true")
 	}
 
 	falseExpr := parser.When{Expressions: []string{"empty"}}
-	if EvaluateWhen(falseExpr, vars) {
+	if eval(falseExpr) {
 		t.Fatalf("expected empty string to evaluate false")
 	}
 
 	zeroExpr := parser.When{Expressions: []string{"number"}}
-	if EvaluateWhen(zeroExpr, vars) {
+	if eval(zeroExpr) {
 		t.Fatalf("expected zero to evaluate false")
 	}
+
+	if _, err := EvaluateWhen(parser.When{Expressions: []string{"flag", "missing"}}, vars); err == nil {
+		t.Fatalf("expected undefined variable to return an error")
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	vars := map[string]interface{}{
+		"os":       "Debian",
+		"version":  22.04,
+		"count":    3,
+		"packages": []interface{}{"nginx", "curl"},
+		"env":      "PROD",
+		"result": map[string]interface{}{
+			"rc":           0,
+			"failed":       false,
+			"changed":      true,
+			"stdout_lines": []interface{}{"a", "b", "c"},
+			"stat":         map[string]interface{}{"exists": true},
+		},
+		"facts": map[string]interface{}{"ansible_distribution": "Ubuntu"},
+		"loop": map[string]interface{}{"results": []interface{}{
+			map[string]interface{}{"stdout": "first", "rc": 0},
+			map[string]interface{}{"stdout": "second", "rc": 2},
+		}},
+	}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"true", true},
+		{"os == 'Debian'", true},
+		{`os != "Debian"`, false},
+		{"version >= 20.04 and count < 5", true},
+		{"count > 3 or os == 'Debian'", true},
+		{"not (count == 3)", false},
+		{"'nginx' in packages", true},
+		{"'apache2' not in packages", true},
+		{"'deb' in os | lower", true},
+		{"result.rc == 0", true},
+		{"result['stat']['exists']", true},
+		{"result.stdout_lines[-1] == 'c'", true},
+		{"result.stdout_lines | length > 2", true},
+		{"result is succeeded and result is changed", true},
+		{"result is failed", false},
+		{"missing is defined", false},
+		{"missing is not defined", true},
+		{"missing is undefined and result.stat.exists", true},
+		{"result.missing is defined", false},
+		{"missing.attr is defined", false},
+		{"missing.attr.deeper is undefined", true},
+		{"missing.attr | default('x') == 'x'", true},
+		{"result.missing.attr is not defined", true},
+		{"loop.results.0.stdout == 'first'", true},
+		{"loop.results.1.rc == 2 and loop.results[0].rc == 0", true},
+		{"loop.results.5 is defined", false},
+		{"version == 22.04 and 1.5 > 1", true},
+		{"missing is defined and missing > 1", false},
+		{"env | lower == 'prod'", true},
+		{"other | default('dev') == 'dev'", true},
+		{"facts.ansible_distribution in ['Ubuntu', 'Debian']", true},
+		{"{{ count == 3 }}", true},
+		{"'' | default('x', true) == 'x'", true},
+	}
+	for _, c := range cases {
+		got, err := EvaluateExpression(c.expr, vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	vars := map[string]interface{}{"count": 3, "name": "web"}
+	for _, expr := range []string{
+		"count ==",
+		"count = 3",
+		"(count > 1",
+		"count > 1 extra",
+		"name | nosuchfilter",
+		"name is nosuchtest",
+		"'unterminated",
+		"missing == 1",
+		"missing.attr",
+		"missing.attr == 1",
+		"count.",
+		"name > 1",
+	} {
+		if _, err := EvaluateExpression(expr, vars); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
 }
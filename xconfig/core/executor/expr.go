@@ -0,0 +1,857 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the expression language used by `when` clauses. It
+// follows the Jinja2 subset commonly found in Ansible conditionals:
+//
+//	ansible_os_family == "Debian"
+//	result.rc != 0 and not skip_install
+//	'nginx' in packages
+//	result is defined and result.stdout_lines | length > 2
+//	env | default('dev') | lower == 'prod'
+//	facts['ansible_distribution'] in ['Ubuntu', 'Debian']
+//
+// Supported: literals (strings, numbers, true/false, none, lists), variables
+// with dotted and indexed access, comparisons, in / not in, and / or / not,
+// parentheses, tests (is [not] defined, undefined, none, true, false, string,
+// number, failed, succeeded, changed, skipped) and the filters listed in
+// exprFilters.
+
+// undefined marks a variable or attribute that does not exist. It may only be
+// consumed by `is defined` style tests and the default filter.
+type undefined struct{ name string }
+
+func (u undefined) err() error { return fmt.Errorf("'%s' is undefined", u.name) }
+
+// expr is a compiled expression.
+type expr func(vars map[string]interface{}) (interface{}, error)
+
+// CompileExpression parses s into an evaluable expression.
+func CompileExpression(s string) (expr, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{{") && strings.HasSuffix(s, "}}") {
+		s = strings.TrimSpace(s[2 : len(s)-2])
+	}
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return e, nil
+}
+
+// EvaluateExpression evaluates s against vars and returns its truthiness.
+func EvaluateExpression(s string, vars map[string]interface{}) (bool, error) {
+	e, err := CompileExpression(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	v, err := e(vars)
+	if err != nil {
+		return false, fmt.Errorf("evaluating %q: %v", s, err)
+	}
+	b, err := truthy(v)
+	if err != nil {
+		return false, fmt.Errorf("evaluating %q: %v", s, err)
+	}
+	return b, nil
+}
+
+// ---- lexer ----
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, token{tokString, b.String()})
+			i = j + 1
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && unicode.IsDigit(rune(s[j])) {
+				j++
+			}
+			// A fraction needs a digit after the dot, and after an attribute
+			// dot (result.results.0.stdout) the digits are an index.
+			afterDot := len(toks) > 0 && toks[len(toks)-1].kind == tokPunct && toks[len(toks)-1].text == "."
+			if !afterDot && j+1 < len(s) && s[j] == '.' && unicode.IsDigit(rune(s[j+1])) {
+				for j++; j < len(s) && unicode.IsDigit(rune(s[j])); j++ {
+				}
+			}
+			toks = append(toks, token{tokNumber, s[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "==", "!=", "<=", ">=":
+					toks = append(toks, token{tokPunct, two})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("<>()[].,|-", c) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, token{tokPunct, string(c)})
+			i++
+		}
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// ---- parser ----
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *exprParser) peek() token { return p.toks[p.pos] }
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && t.text == word
+}
+
+func (p *exprParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == s
+}
+
+func (p *exprParser) expect(s string) error {
+	if !p.isPunct(s) {
+		return fmt.Errorf("expected %q, got %q", s, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(vars map[string]interface{}) (interface{}, error) {
+			lv, err := evalBool(l, vars)
+			if err != nil || lv {
+				return lv, err
+			}
+			return evalBool(right, vars)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(vars map[string]interface{}) (interface{}, error) {
+			lv, err := evalBool(l, vars)
+			if err != nil || !lv {
+				return lv, err
+			}
+			return evalBool(right, vars)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.isKeyword("not") {
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			v, err := evalBool(inner, vars)
+			return !v, err
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseFiltered()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokPunct && comparisonOps[t.text]:
+			p.next()
+			right, err := p.parseFiltered()
+			if err != nil {
+				return nil, err
+			}
+			left = comparison(t.text, left, right)
+		case p.isKeyword("in"):
+			p.next()
+			right, err := p.parseFiltered()
+			if err != nil {
+				return nil, err
+			}
+			left = membership(left, right, false)
+		case p.isKeyword("not") && p.toks[p.pos+1].kind == tokIdent && p.toks[p.pos+1].text == "in":
+			p.next()
+			p.next()
+			right, err := p.parseFiltered()
+			if err != nil {
+				return nil, err
+			}
+			left = membership(left, right, true)
+		case p.isKeyword("is"):
+			p.next()
+			negate := false
+			if p.isKeyword("not") {
+				p.next()
+				negate = true
+			}
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, fmt.Errorf("expected test name after 'is', got %q", name.text)
+			}
+			test, ok := exprTests[name.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown test %q", name.text)
+			}
+			inner := left
+			left = func(vars map[string]interface{}) (interface{}, error) {
+				v, err := inner(vars)
+				if err != nil {
+					return nil, err
+				}
+				ok, err := test(v)
+				if err != nil {
+					return nil, err
+				}
+				return ok != negate, nil
+			}
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *exprParser) parseFiltered() (expr, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("|") {
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, fmt.Errorf("expected filter name after '|', got %q", name.text)
+		}
+		filter, ok := exprFilters[name.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q", name.text)
+		}
+		var args []expr
+		if p.isPunct("(") {
+			if args, err = p.parseArgs(")"); err != nil {
+				return nil, err
+			}
+		}
+		inner := left
+		left = func(vars map[string]interface{}) (interface{}, error) {
+			v, err := inner(vars)
+			if err != nil {
+				return nil, err
+			}
+			argv := make([]interface{}, len(args))
+			for i, a := range args {
+				if argv[i], err = a(vars); err != nil {
+					return nil, err
+				}
+			}
+			return filter(v, argv)
+		}
+	}
+	return left, nil
+}
+
+// parseArgs parses a comma separated list after the opening bracket.
+func (p *exprParser) parseArgs(closing string) ([]expr, error) {
+	p.next()
+	var args []expr
+	for !p.isPunct(closing) {
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(closing)
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isPunct("."):
+			p.next()
+			name := p.next()
+			switch name.kind {
+			case tokIdent:
+				base = attribute(base, constant(name.text))
+			case tokNumber:
+				// list.0 indexes a list like list[0]
+				i, err := strconv.Atoi(name.text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q after '.'", name.text)
+				}
+				base = attribute(base, constant(i))
+			default:
+				return nil, fmt.Errorf("expected attribute name after '.', got %q", name.text)
+			}
+		case p.isPunct("["):
+			p.next()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			base = attribute(base, index)
+		default:
+			return base, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.next()
+		return constant(t.text), nil
+	case tokNumber:
+		p.next()
+		if i, err := strconv.Atoi(t.text); err == nil {
+			return constant(i), nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return constant(f), nil
+	case tokIdent:
+		p.next()
+		switch t.text {
+		case "true", "True":
+			return constant(true), nil
+		case "false", "False":
+			return constant(false), nil
+		case "none", "None", "null":
+			return constant(nil), nil
+		case "and", "or", "not", "in", "is":
+			return nil, fmt.Errorf("unexpected keyword %q", t.text)
+		}
+		name := t.text
+		return func(vars map[string]interface{}) (interface{}, error) {
+			if v, ok := vars[name]; ok {
+				return v, nil
+			}
+			return undefined{name}, nil
+		}, nil
+	case tokPunct:
+		switch t.text {
+		case "-":
+			p.next()
+			inner, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			return func(vars map[string]interface{}) (interface{}, error) {
+				v, err := defined(inner, vars)
+				if err != nil {
+					return nil, err
+				}
+				if i, ok := v.(int); ok {
+					return -i, nil
+				}
+				f, ok := toFloat(v)
+				if !ok {
+					return nil, fmt.Errorf("cannot negate %T", v)
+				}
+				return -f, nil
+			}, nil
+		case "(":
+			p.next()
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			items, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return func(vars map[string]interface{}) (interface{}, error) {
+				list := make([]interface{}, len(items))
+				for i, item := range items {
+					v, err := item(vars)
+					if err != nil {
+						return nil, err
+					}
+					if u, ok := v.(undefined); ok {
+						return nil, u.err()
+					}
+					list[i] = v
+				}
+				return list, nil
+			}, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// ---- evaluation helpers ----
+
+func constant(v interface{}) expr {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
+
+func evalBool(e expr, vars map[string]interface{}) (bool, error) {
+	v, err := e(vars)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v)
+}
+
+// attribute implements a.b and a[b]. Missing keys yield undefined so that
+// `result.rc is defined` works; an attribute of undefined stays undefined
+// (naming the missing root), so `a.b is defined` and `a.b | default(x)`
+// work when a is missing while any other use still reports it.
+func attribute(base, key expr) expr {
+	return func(vars map[string]interface{}) (interface{}, error) {
+		b, err := base(vars)
+		if err != nil {
+			return nil, err
+		}
+		if u, ok := b.(undefined); ok {
+			return u, nil
+		}
+		k, err := key(vars)
+		if err != nil {
+			return nil, err
+		}
+		if u, ok := k.(undefined); ok {
+			return nil, u.err()
+		}
+		switch c := b.(type) {
+		case map[string]interface{}:
+			name := fmt.Sprint(k)
+			if v, ok := c[name]; ok {
+				return v, nil
+			}
+			return undefined{name}, nil
+		case map[interface{}]interface{}:
+			if v, ok := c[k]; ok {
+				return v, nil
+			}
+			return undefined{fmt.Sprint(k)}, nil
+		}
+		rv := reflect.ValueOf(b)
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array || rv.Kind() == reflect.String {
+			i, ok := toInt(k)
+			if !ok {
+				return nil, fmt.Errorf("list index must be an integer, got %v", k)
+			}
+			if i < 0 {
+				i += rv.Len()
+			}
+			if i < 0 || i >= rv.Len() {
+				return undefined{fmt.Sprint(k)}, nil
+			}
+			if rv.Kind() == reflect.String {
+				return string(rv.String()[i]), nil
+			}
+			return rv.Index(i).Interface(), nil
+		}
+		return nil, fmt.Errorf("cannot access %v on %T", k, b)
+	}
+}
+
+func comparison(op string, left, right expr) expr {
+	return func(vars map[string]interface{}) (interface{}, error) {
+		l, err := defined(left, vars)
+		if err != nil {
+			return nil, err
+		}
+		r, err := defined(right, vars)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return equal(l, r), nil
+		case "!=":
+			return !equal(l, r), nil
+		}
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+}
+
+func membership(item, container expr, negate bool) expr {
+	return func(vars map[string]interface{}) (interface{}, error) {
+		needle, err := defined(item, vars)
+		if err != nil {
+			return nil, err
+		}
+		haystack, err := defined(container, vars)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		switch c := haystack.(type) {
+		case string:
+			found = strings.Contains(c, fmt.Sprint(needle))
+		case map[string]interface{}:
+			_, found = c[fmt.Sprint(needle)]
+		default:
+			rv := reflect.ValueOf(haystack)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return nil, fmt.Errorf("'in' needs a string, list or mapping, got %T", haystack)
+			}
+			for i := 0; i < rv.Len(); i++ {
+				if equal(rv.Index(i).Interface(), needle) {
+					found = true
+					break
+				}
+			}
+		}
+		return found != negate, nil
+	}
+}
+
+// defined evaluates e and fails when the result is undefined.
+func defined(e expr, vars map[string]interface{}) (interface{}, error) {
+	v, err := e(vars)
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := v.(undefined); ok {
+		return nil, u.err()
+	}
+	return v, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toInt(v interface{}) (int, bool) {
+	f, ok := toFloat(v)
+	if !ok || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compare(a, b interface{}) (int, error) {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if okA && okB {
+		return strings.Compare(sa, sb), nil
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+// truthy follows Jinja2 truthiness, additionally treating the strings
+// "false" and "0" as false for compatibility with string typed variables.
+func truthy(v interface{}) (bool, error) {
+	switch val := v.(type) {
+	case undefined:
+		return false, val.err()
+	case nil:
+		return false, nil
+	case bool:
+		return val, nil
+	case string:
+		switch strings.ToLower(val) {
+		case "", "false", "0":
+			return false, nil
+		}
+		return true, nil
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() > 0, nil
+	}
+	return true, nil
+}
+
+// ---- tests and filters ----
+
+var exprTests = map[string]func(v interface{}) (bool, error){
+	"defined":   func(v interface{}) (bool, error) { _, u := v.(undefined); return !u, nil },
+	"undefined": func(v interface{}) (bool, error) { _, u := v.(undefined); return u, nil },
+	"none":      func(v interface{}) (bool, error) { return v == nil, nil },
+	"true":      func(v interface{}) (bool, error) { return v == true, nil },
+	"false":     func(v interface{}) (bool, error) { return v == false, nil },
+	"string": func(v interface{}) (bool, error) {
+		_, ok := v.(string)
+		return ok, nil
+	},
+	"number": func(v interface{}) (bool, error) {
+		_, ok := toFloat(v)
+		return ok, nil
+	},
+	"failed":    resultTest("failed", true),
+	"succeeded": resultTest("failed", false),
+	"success":   resultTest("failed", false),
+	"changed":   resultTest("changed", true),
+	"skipped":   resultTest("skipped", true),
+}
+
+// resultTest inspects a registered task result.
+func resultTest(field string, want bool) func(v interface{}) (bool, error) {
+	return func(v interface{}) (bool, error) {
+		if u, ok := v.(undefined); ok {
+			return false, u.err()
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("the %s test expects a registered result, got %T", field, v)
+		}
+		b, _ := m[field].(bool)
+		return b == want, nil
+	}
+}
+
+var exprFilters = map[string]func(v interface{}, args []interface{}) (interface{}, error){
+	"default": defaultFilter,
+	"d":       defaultFilter,
+	"lower":   stringFilter(strings.ToLower),
+	"upper":   stringFilter(strings.ToUpper),
+	"trim":    stringFilter(strings.TrimSpace),
+	"length":  lengthFilter,
+	"count":   lengthFilter,
+	"string": func(v interface{}, _ []interface{}) (interface{}, error) {
+		if u, ok := v.(undefined); ok {
+			return nil, u.err()
+		}
+		return fmt.Sprint(v), nil
+	},
+	"int": func(v interface{}, _ []interface{}) (interface{}, error) {
+		if u, ok := v.(undefined); ok {
+			return nil, u.err()
+		}
+		if f, ok := toFloat(v); ok {
+			return int(f), nil
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(v)))
+		if err != nil {
+			return 0, nil
+		}
+		return i, nil
+	},
+	"bool": func(v interface{}, _ []interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			switch strings.ToLower(strings.TrimSpace(s)) {
+			case "yes", "on", "true", "1", "y":
+				return true, nil
+			}
+			return false, nil
+		}
+		return truthy(v)
+	},
+}
+
+// defaultFilter returns args[0] when v is undefined (or, with a second true
+// argument, when v is falsy).
+func defaultFilter(v interface{}, args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("default filter needs a value")
+	}
+	if _, ok := v.(undefined); ok {
+		return args[0], nil
+	}
+	if len(args) > 1 {
+		if b, _ := truthy(args[1]); b {
+			if t, _ := truthy(v); !t {
+				return args[0], nil
+			}
+		}
+	}
+	return v, nil
+}
+
+func stringFilter(f func(string) string) func(interface{}, []interface{}) (interface{}, error) {
+	return func(v interface{}, _ []interface{}) (interface{}, error) {
+		if u, ok := v.(undefined); ok {
+			return nil, u.err()
+		}
+		return f(fmt.Sprint(v)), nil
+	}
+}
+
+func lengthFilter(v interface{}, _ []interface{}) (interface{}, error) {
+	if u, ok := v.(undefined); ok {
+		return nil, u.err()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), nil
+	}
+	return nil, fmt.Errorf("object of type %T has no length", v)
+}
@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+)
+
+func TestGatherFactsRunsFirstAndFeedsConditions(t *testing.T) {
+	calls := fakeCommand(t)
+	orig, _ := modules.GetHandler("setup")
+	t.Cleanup(func() { modules.Register("setup", orig) })
+	modules.Register("setup", func(ctx modules.Context, task parser.Task) ssh.CommandResult {
+		family := "Debian"
+		if ctx.Host.Name == "db1" {
+			family = "RedHat"
+		}
+		facts := map[string]interface{}{"os_family": family, "processor_vcpus": 4}
+		ctx.Vars["ansible_facts"] = facts
+		for k, v := range facts {
+			ctx.Vars["ansible_"+k] = v
+		}
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK"}
+	})
+
+	inv := writeInventory(t, "web1\ndb1\n")
+	play := parser.Play{
+		Hosts:       "all",
+		GatherFacts: true,
+		Tasks: []parser.Task{
+			{Name: "apt", Command: "apt-get update", When: parser.When{Expressions: []string{"ansible_facts.os_family == 'Debian'"}}},
+			{Name: "dnf", Command: "dnf makecache", When: parser.When{Expressions: []string{"ansible_os_family == 'RedHat' and ansible_processor_vcpus > 2"}}},
+		},
+	}
+	e := New(false, false, false)
+	out := captureStdout(t, func() { e.Execute(context.Background(), []parser.Play{play}, inv) })
+
+	if got := sortedCalls(calls()); !reflect.DeepEqual(got, []string{"db1:dnf makecache", "web1:apt-get update"}) {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+	if i := strings.Index(out, "TASK [Gathering Facts]"); i < 0 || i > strings.Index(out, "TASK [apt]") {
+		t.Fatalf("expected facts to be gathered before the tasks:\n%s", out)
+	}
+}
@@ -0,0 +1,126 @@
+package executor
+
+import (
+	"fmt"
+	"sync"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+// notifications records, per host, the handler names and listen topics that
+// changed tasks have notified. A set keeps repeated notifications from
+// running a handler more than once per flush.
+type notifications struct {
+	mu      sync.Mutex
+	pending map[string]map[string]bool
+}
+
+func newNotifications() *notifications {
+	return &notifications{pending: make(map[string]map[string]bool)}
+}
+
+func (n *notifications) notify(host string, names []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pending[host] == nil {
+		n.pending[host] = make(map[string]bool)
+	}
+	for _, name := range names {
+		n.pending[host][name] = true
+	}
+}
+
+// take reports whether handler was notified on host and clears the
+// notification.
+func (n *notifications) take(host string, handler parser.Task) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	set := n.pending[host]
+	found := false
+	for _, name := range append([]string{handler.Name}, handler.Listen...) {
+		if set[name] {
+			found = true
+			delete(set, name)
+		}
+	}
+	return found
+}
+
+// checkNotify fails when a task notifies a handler that does not exist, so a
+// typo does not silently drop a restart.
+func checkNotify(play *parser.Play) error {
+	known := make(map[string]bool)
+	for _, h := range play.Handlers {
+		known[h.Name] = true
+		for _, topic := range h.Listen {
+			known[topic] = true
+		}
+	}
+	var check func(tasks []parser.Task) error
+	check = func(tasks []parser.Task) error {
+		for _, t := range tasks {
+			for _, name := range t.Notify {
+				if !known[name] {
+					return fmt.Errorf("task %q notifies unknown handler %q", t.Name, name)
+				}
+			}
+			for _, nested := range [][]parser.Task{t.Block, t.Rescue, t.Always} {
+				if err := check(nested); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := check(play.Tasks); err != nil {
+		return err
+	}
+	return check(play.Handlers)
+}
+
+// flushHandlers runs, in definition order, every handler notified on at least
+// one host, only on those hosts. Handlers may notify later handlers, which
+// run in the same flush. It returns the hosts on which a handler failed.
+func (e *Executor) flushHandlers(ps *playState, hosts []inventory.Host) map[string]bool {
+	failed := make(map[string]bool)
+	for pass := 0; pass <= len(ps.play.Handlers); pass++ {
+		ran := false
+		for _, handler := range ps.play.Handlers {
+			var targets []inventory.Host
+			for _, h := range activeHosts(hosts, failed) {
+				if ps.pending.take(h.Name, handler) {
+					targets = append(targets, h)
+				}
+			}
+			if len(targets) == 0 {
+				continue
+			}
+			ran = true
+			for _, r := range e.runOnHosts(ps, handler, "RUNNING HANDLER", targets) {
+				if hs := ps.stats[r.Host]; hs != nil {
+					hs.addHandler(handler.Name)
+				}
+				if failedResult(r) && !(handler.IgnoreErrors && r.ReturnMsg == "FAILED") {
+					failed[r.Host] = true
+				}
+			}
+		}
+		if !ran {
+			break
+		}
+	}
+	return failed
+}
+
+// runMeta executes executor level actions requested with `meta:` and returns
+// the hosts that failed while doing so.
+func (e *Executor) runMeta(ps *playState, task parser.Task, hosts []inventory.Host) map[string]bool {
+	switch task.Meta {
+	case "flush_handlers":
+		return e.flushHandlers(ps, hosts)
+	default:
+		fmt.Printf("⚠️  Unsupported meta action %q\n", task.Meta)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+)
+
+// fakeCommand replaces the command module with one that records calls and
+// reports CHANGED for commands starting with "change" and FAILED for those
+// starting with "fail".
+func fakeCommand(t *testing.T) func() []string {
+	t.Helper()
+	orig, _ := modules.GetHandler("command")
+	t.Cleanup(func() { modules.Register("command", orig) })
+	var mu sync.Mutex
+	var calls []string
+	modules.Register("command", func(ctx modules.Context, task parser.Task) ssh.CommandResult {
+		mu.Lock()
+		calls = append(calls, ctx.Host.Name+":"+task.Command)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(task.Command, "change"):
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED"}
+		case strings.HasPrefix(task.Command, "fail"):
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "boom"}
+		}
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK"}
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+func writeInventory(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHandlersRunOncePerNotifiedHost(t *testing.T) {
+	calls := fakeCommand(t)
+	inv := writeInventory(t, "[web]\nweb1\nweb2\n")
+	play := parser.Play{
+		Hosts: "web",
+		Tasks: []parser.Task{
+			{Name: "template", Command: "change {{ inventory_hostname }}", When: parser.When{Expressions: []string{"inventory_hostname == 'web1'"}}, Notify: parser.StringList{"restart nginx"}},
+			{Name: "again", Command: "change again", When: parser.When{Expressions: []string{"inventory_hostname == 'web1'"}}, Notify: parser.StringList{"restart nginx"}},
+			{Name: "unchanged", Command: "noop", Notify: parser.StringList{"reload"}},
+			{Name: "flush", Meta: "flush_handlers"},
+			{Name: "last", Command: "change last", Notify: parser.StringList{"web services"}},
+		},
+		Handlers: []parser.Task{
+			{Name: "restart nginx", Command: "restart"},
+			{Name: "reload", Command: "reload"},
+			{Name: "restart app", Command: "restart-app", Listen: parser.StringList{"web services"}},
+		},
+	}
+
+	e := New(false, false, false)
+	e.MaxWorkers = 1
+	e.Execute(context.Background(), []parser.Play{play}, inv)
+
+	got := calls()
+	var handlers []string
+	for _, c := range got {
+		if strings.HasSuffix(c, ":restart") || strings.HasSuffix(c, ":reload") || strings.HasSuffix(c, ":restart-app") {
+			handlers = append(handlers, c)
+		}
+	}
+	sort.Strings(handlers)
+	want := []string{"web1:restart", "web1:restart-app", "web2:restart-app"}
+	if !reflect.DeepEqual(handlers, want) {
+		t.Fatalf("handlers ran as %v, want %v (calls: %v)", handlers, want, got)
+	}
+	// flush_handlers runs the restart before the last task.
+	if idx(got, "web1:restart") > idx(got, "web1:change last") {
+		t.Fatalf("expected flush_handlers to run before the last task: %v", got)
+	}
+}
+
+func TestUnknownHandlerSkipsPlay(t *testing.T) {
+	calls := fakeCommand(t)
+	inv := writeInventory(t, "web1\n")
+	play := parser.Play{
+		Hosts: "all",
+		Tasks: []parser.Task{{Name: "t", Command: "change", Notify: parser.StringList{"missing"}}},
+	}
+	New(false, false, false).Execute(context.Background(), []parser.Play{play}, inv)
+	if len(calls()) != 0 {
+		t.Fatalf("expected play to be rejected, got calls %v", calls())
+	}
+}
+
+func idx(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,297 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/ssh"
+)
+
+// batchModules accept several packages in one transaction, so a loop over
+// them is collapsed into a single invocation.
+var batchModules = map[string]bool{"apt": true, "yum": true, "package": true}
+
+// runTask runs task on one host: it evaluates `when`, expands loops, renders
+// `{{ expr }}` parameters and stores the registered result. ran is false when
+// the condition skipped the task entirely.
+func (e *Executor) runTask(ctx context.Context, task parser.Task, h inventory.Host, vars map[string]interface{}) (res ssh.CommandResult, ran bool) {
+	if !task.HasLoop() {
+		res, ran = e.runItem(ctx, task, h, vars)
+		if task.Register != "" {
+			vars[task.Register] = res.Registered()
+		}
+		return res, ran
+	}
+
+	items, err := loopItems(task, vars)
+	if err != nil {
+		res = ssh.CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+		if task.Register != "" {
+			vars[task.Register] = res.Registered()
+		}
+		return res, true
+	}
+
+	loopVar := "item"
+	var pause time.Duration
+	if lc := task.LoopControl; lc != nil {
+		if lc.LoopVar != "" {
+			loopVar = lc.LoopVar
+		}
+		pause = time.Duration(lc.Pause * float64(time.Second))
+	}
+	saved, hadSaved := vars[loopVar]
+	defer func() {
+		if hadSaved {
+			vars[loopVar] = saved
+		} else {
+			delete(vars, loopVar)
+		}
+	}()
+
+	if batchModules[task.Type()] {
+		if res, ok := e.runBatch(ctx, task, h, vars, loopVar, items); ok {
+			if task.Register != "" {
+				reg := res.Registered()
+				reg["results"] = []interface{}{res.Registered()}
+				vars[task.Register] = reg
+			}
+			return res, res.ReturnMsg != "SKIPPED"
+		}
+	}
+
+	start := time.Now()
+	var results []ssh.CommandResult
+	var registered []interface{}
+	var out strings.Builder
+	for i, item := range items {
+		if i > 0 && pause > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+		}
+		vars[loopVar] = item
+		r, itemRan := e.runItem(ctx, task, h, vars)
+		if !itemRan {
+			r.ReturnMsg = "SKIPPED"
+		}
+		results = append(results, r)
+
+		reg := r.Registered()
+		reg[loopVar] = item
+		reg["ansible_loop_var"] = loopVar
+		registered = append(registered, reg)
+
+		fmt.Fprintf(&out, "(item=%s) %s rc=%d\n", loopLabel(task, item, vars), r.ReturnMsg, r.ReturnCode)
+		if r.Output != "" {
+			out.WriteString(strings.TrimRight(r.Output, "\n"))
+			out.WriteString("\n")
+		}
+	}
+
+	res = ssh.CommandResult{Host: h.Name, ReturnMsg: loopStatus(results), Output: out.String(), Start: start, End: time.Now()}
+	for _, r := range results {
+		if r.ReturnCode != 0 {
+			res.ReturnCode = r.ReturnCode
+			break
+		}
+	}
+	if task.Register != "" {
+		reg := res.Registered()
+		reg["results"] = registered
+		reg["msg"] = "All items completed"
+		vars[task.Register] = reg
+	}
+	return res, res.ReturnMsg != "SKIPPED"
+}
+
+// runItem evaluates the condition and runs a single (possibly looped)
+// invocation of task.
+func (e *Executor) runItem(ctx context.Context, task parser.Task, h inventory.Host, vars map[string]interface{}) (ssh.CommandResult, bool) {
+	ok, err := EvaluateWhen(task.When, vars)
+	if err != nil {
+		return ssh.CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("when: %v", err)}, true
+	}
+	if !ok {
+		return ssh.CommandResult{Host: h.Name, ReturnMsg: "SKIPPED", Output: "conditional result was false"}, false
+	}
+	rendered, err := renderTask(task, vars)
+	if err != nil {
+		return ssh.CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}, true
+	}
+	rendered.Register = ""
+	if rendered.Timeout == 0 {
+		rendered.Timeout = e.TaskTimeout
+	}
+	res := ExecuteTask(ctx, rendered, h, vars, e.DiffMode, e.CheckMode)
+	return applyResultConditions(task, res, vars), true
+}
+
+// runBatch collapses a loop over a package module into one transaction. It
+// reports false when the items differ in more than the package name, in which
+// case the caller falls back to running them one by one.
+func (e *Executor) runBatch(ctx context.Context, task parser.Task, h inventory.Host, vars map[string]interface{}, loopVar string, items []interface{}) (ssh.CommandResult, bool) {
+	var merged *parser.Task
+	var names []string
+	for _, item := range items {
+		vars[loopVar] = item
+		ok, err := EvaluateWhen(task.When, vars)
+		if err != nil || !ok {
+			if err != nil {
+				return ssh.CommandResult{}, false
+			}
+			continue
+		}
+		rendered, err := renderTask(task, vars)
+		if err != nil {
+			return ssh.CommandResult{}, false
+		}
+		pkg := packageAction(&rendered)
+		names = append(names, pkg.Name)
+		pkg.Name = ""
+		if merged == nil {
+			merged = &rendered
+		} else if !reflect.DeepEqual(*packageAction(merged), *pkg) {
+			return ssh.CommandResult{}, false
+		}
+	}
+	if merged == nil {
+		return ssh.CommandResult{Host: h.Name, ReturnMsg: "SKIPPED", Output: "conditional result was false"}, true
+	}
+	packageAction(merged).Name = strings.Join(names, ",")
+	merged.Register = ""
+	if merged.Timeout == 0 {
+		merged.Timeout = e.TaskTimeout
+	}
+	return applyResultConditions(task, ExecuteTask(ctx, *merged, h, vars, e.DiffMode, e.CheckMode), vars), true
+}
+
+func packageAction(t *parser.Task) *parser.PackageAction {
+	switch {
+	case t.Apt != nil:
+		return t.Apt
+	case t.Package != nil:
+		return t.Package
+	}
+	return t.Yum
+}
+
+// loopItems resolves the loop keyword of task to the items for one host.
+func loopItems(task parser.Task, vars map[string]interface{}) ([]interface{}, error) {
+	switch {
+	case task.Loop != nil:
+		v, err := loopValue(task.Loop, vars)
+		if err != nil {
+			return nil, fmt.Errorf("loop: %v", err)
+		}
+		items, ok := toList(v)
+		if !ok {
+			return nil, fmt.Errorf("loop requires a list, got %T", v)
+		}
+		return items, nil
+	case task.WithItems != nil:
+		v, err := loopValue(task.WithItems, vars)
+		if err != nil {
+			return nil, fmt.Errorf("with_items: %v", err)
+		}
+		list, ok := toList(v)
+		if !ok {
+			list = []interface{}{v}
+		}
+		// with_items flattens one level of nesting.
+		var items []interface{}
+		for _, item := range list {
+			if nested, ok := toList(item); ok {
+				items = append(items, nested...)
+			} else {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		v, err := loopValue(task.WithDict, vars)
+		if err != nil {
+			return nil, fmt.Errorf("with_dict: %v", err)
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("with_dict requires a mapping, got %T", v)
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]interface{}, len(keys))
+		for i, k := range keys {
+			items[i] = map[string]interface{}{"key": k, "value": m[k]}
+		}
+		return items, nil
+	}
+}
+
+// loopValue renders a loop keyword. A plain string names a variable
+// (`with_items: packages`) or, when no such variable exists, is taken
+// literally; anything else is rendered as data.
+func loopValue(v interface{}, vars map[string]interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return renderData(v, vars)
+	}
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "{{") {
+		return RenderValue(s, vars)
+	}
+	e, err := CompileExpression(s)
+	if err != nil {
+		return s, nil
+	}
+	val, err := e(vars)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := val.(undefined); ok {
+		return s, nil
+	}
+	return val, nil
+}
+
+func toList(v interface{}) ([]interface{}, bool) {
+	switch l := v.(type) {
+	case []interface{}:
+		return l, true
+	case []string:
+		return stringsToValues(l), true
+	}
+	return nil, false
+}
+
+// loopLabel renders loop_control.label, defaulting to the item itself.
+func loopLabel(task parser.Task, item interface{}, vars map[string]interface{}) string {
+	if task.LoopControl != nil && task.LoopControl.Label != "" {
+		if v, err := RenderValue(task.LoopControl.Label, vars); err == nil {
+			return renderString(v)
+		}
+		return task.LoopControl.Label
+	}
+	return renderString(item)
+}
+
+// loopStatus summarises the item results: any failure wins, then changes.
+func loopStatus(results []ssh.CommandResult) string {
+	status := "SKIPPED"
+	rank := map[string]int{"SKIPPED": 0, "OK": 1, "CHANGED": 2, "FAILED": 3, "UNREACHABLE": 4}
+	for _, r := range results {
+		if rank[r.ReturnMsg] > rank[status] {
+			status = r.ReturnMsg
+		}
+	}
+	return status
+}
@@ -0,0 +1,133 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+)
+
+func TestRunTaskLoopRegistersResults(t *testing.T) {
+	e := New(false, false, false)
+	vars := map[string]interface{}{"packages": []interface{}{"nginx", "curl", "vim"}, "skip": "curl"}
+	task := parser.Task{
+		Name:     "echo",
+		Debug:    &parser.MessageAction{Msg: "pkg={{ item }}"},
+		Loop:     "{{ packages }}",
+		When:     parser.When{Expressions: []string{"item != skip"}},
+		Register: "out",
+	}
+
+	res, ran := e.runTask(context.Background(), task, inventory.Host{Name: "web"}, vars)
+	if !ran || res.ReturnMsg != "OK" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if !strings.Contains(res.Output, "(item=nginx) OK") || !strings.Contains(res.Output, "(item=curl) SKIPPED") {
+		t.Fatalf("unexpected loop output:\n%s", res.Output)
+	}
+	if _, ok := vars["item"]; ok {
+		t.Fatalf("loop variable leaked into host vars")
+	}
+
+	results := vars["out"].(map[string]interface{})["results"].([]interface{})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	first := results[0].(map[string]interface{})
+	if first["item"] != "nginx" || first["stdout"] != "pkg=nginx" {
+		t.Fatalf("unexpected first result: %v", first)
+	}
+	if results[1].(map[string]interface{})["skipped"] != true {
+		t.Fatalf("expected second item to be skipped")
+	}
+}
+
+func TestLoopItems(t *testing.T) {
+	vars := map[string]interface{}{
+		"users": map[string]interface{}{"bob": 2, "alice": 1},
+		"extra": []interface{}{"c", "d"},
+	}
+	cases := []struct {
+		task parser.Task
+		want []interface{}
+	}{
+		{parser.Task{Loop: []interface{}{"a", "{{ users.bob }}"}}, []interface{}{"a", 2}},
+		{parser.Task{WithItems: []interface{}{"a", []interface{}{"b"}, "{{ extra }}"}}, []interface{}{"a", "b", "c", "d"}},
+		{parser.Task{WithItems: "extra"}, []interface{}{"c", "d"}},
+		{parser.Task{WithItems: "nginx"}, []interface{}{"nginx"}},
+		{parser.Task{WithDict: "{{ users }}"}, []interface{}{
+			map[string]interface{}{"key": "alice", "value": 1},
+			map[string]interface{}{"key": "bob", "value": 2},
+		}},
+	}
+	for i, c := range cases {
+		got, err := loopItems(c.task, vars)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("case %d: got %#v, want %#v", i, got, c.want)
+		}
+	}
+
+	if _, err := loopItems(parser.Task{Loop: "{{ users }}"}, vars); err == nil {
+		t.Fatalf("expected error looping over a mapping")
+	}
+}
+
+func TestRunTaskLoopControl(t *testing.T) {
+	e := New(false, false, false)
+	vars := map[string]interface{}{"item": "outer"}
+	task := parser.Task{
+		Debug:       &parser.MessageAction{Msg: "{{ user.value.uid }}"},
+		WithDict:    map[string]interface{}{"bob": map[string]interface{}{"uid": 1001}},
+		LoopControl: &parser.LoopControl{LoopVar: "user", Label: "{{ user.key }}"},
+	}
+	res, _ := e.runTask(context.Background(), task, inventory.Host{Name: "web"}, vars)
+	if !strings.Contains(res.Output, "(item=bob) OK") || !strings.Contains(res.Output, "1001") {
+		t.Fatalf("unexpected output:\n%s", res.Output)
+	}
+	if vars["item"] != "outer" {
+		t.Fatalf("expected item to be untouched, got %v", vars["item"])
+	}
+	if _, ok := vars["user"]; ok {
+		t.Fatalf("loop_var leaked into host vars")
+	}
+}
+
+func TestRunTaskBatchesPackageLoops(t *testing.T) {
+	orig, _ := modules.GetHandler("apt")
+	defer modules.Register("apt", orig)
+	var calls []string
+	modules.Register("apt", func(ctx modules.Context, task parser.Task) ssh.CommandResult {
+		calls = append(calls, task.Apt.Name)
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED"}
+	})
+
+	e := New(false, false, false)
+	vars := map[string]interface{}{}
+	task := parser.Task{
+		Apt:      &parser.PackageAction{Name: "{{ item }}", State: "present"},
+		Loop:     []interface{}{"nginx", "curl", "vim"},
+		When:     parser.When{Expressions: []string{"item != 'vim'"}},
+		Register: "pkgs",
+	}
+	res, ran := e.runTask(context.Background(), task, inventory.Host{Name: "web"}, vars)
+	if !ran || res.ReturnMsg != "CHANGED" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if !reflect.DeepEqual(calls, []string{"nginx,curl"}) {
+		t.Fatalf("expected one batched transaction, got %v", calls)
+	}
+	if task.Apt.Name != "{{ item }}" {
+		t.Fatalf("playbook task was modified: %q", task.Apt.Name)
+	}
+	if vars["pkgs"].(map[string]interface{})["changed"] != true {
+		t.Fatalf("expected registered result to be changed")
+	}
+}
@@ -1,12 +1,16 @@
 package executor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	"xconfig/core/parser"
 	"xconfig/internal/inventory"
 	"xconfig/internal/ssh"
+	"xconfig/internal/vault"
 )
 
 // Executor executes playbooks with configurable behaviour.
@@ -15,7 +19,12 @@ type Executor struct {
 	CheckMode       bool
 	DiffMode        bool
 	MaxWorkers      int
-	Logger          LogCollector
+	// TaskTimeout is the default task timeout in seconds; zero means none.
+	TaskTimeout int
+	Logger      LogCollector
+	// Become holds CLI level privilege escalation defaults. Plays and tasks
+	// override them.
+	Become parser.BecomeOptions
 }
 
 // New creates a new Executor.
@@ -26,108 +35,226 @@ func New(aggregate, check, diff bool) *Executor {
 // SetLogger configures a log collector for execution results.
 func (e *Executor) SetLogger(l LogCollector) { e.Logger = l }
 
-// Execute processes and runs the given playbook.
-func (e *Executor) Execute(playbook []parser.Play, inventoryPath string) {
+// Execute processes and runs the given playbook. When ctx is cancelled or
+// times out, running commands are stopped, no further task starts and the
+// recap covers what ran so far.
+func (e *Executor) Execute(ctx context.Context, playbook []parser.Play, inventoryPath string) {
+	defer ssh.CloseAll()
+
 	stats := make(map[string]*hostStats)
+	// Hosts that failed are not targeted by later plays either.
+	failed := make(map[string]bool)
+
+	inv, err := inventory.Load(inventoryPath)
+	if err != nil {
+		fmt.Printf("❌ Failed to load inventory: %v\n", err)
+		return
+	}
+
 	for i := range playbook {
+		if ctx.Err() != nil {
+			break
+		}
 		play := &playbook[i]
 		if play.Vars == nil {
 			play.Vars = make(map[string]interface{})
 		}
 
-		copyVars := func(src map[string]interface{}) map[string]interface{} {
-			dst := make(map[string]interface{}, len(src))
-			for k, v := range src {
-				dst[k] = cloneValue(v)
-			}
-			return dst
-		}
-
 		fmt.Printf("\n🎯 Play: %s (hosts: %s)\n", play.Name, play.Hosts)
 
-		hosts, err := inventory.Parse(inventoryPath, play.Hosts)
+		if err := checkNotify(play); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		if err := checkStrategy(play); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+
+		selected, err := inv.Select(play.Hosts)
 		if err != nil {
 			fmt.Printf("❌ Failed to resolve hosts: %v\n", err)
 			continue
 		}
+		var hosts []inventory.Host
+		for _, h := range selected {
+			if !failed[h.Name] {
+				hosts = append(hosts, h)
+			}
+		}
+		if len(hosts) == 0 {
+			fmt.Printf("⚠️  No hosts matched pattern %q\n", play.Hosts)
+			continue
+		}
 
+		// Play vars take precedence over inventory group and host vars.
+		groups := groupsVar(inv)
 		hostVars := make(map[string]map[string]interface{}, len(hosts))
 		for _, h := range hosts {
-			hostVars[h.Name] = copyVars(play.Vars)
+			vars := CloneVars(h.Vars)
+			vars["inventory_hostname"] = h.Name
+			vars["group_names"] = stringsToValues(h.Groups)
+			vars["groups"] = groups
+			for k, v := range play.Vars {
+				vars[k] = cloneValue(v)
+			}
+			hostVars[h.Name] = vars
 			if _, ok := stats[h.Name]; !ok {
 				stats[h.Name] = &hostStats{}
 			}
 		}
 
-		for _, task := range play.Tasks {
-			fmt.Printf("\nTASK [%s] ********************************************************\n", task.Name)
+		batches, err := serialBatches(play.Serial, hosts)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		tasks := play.Tasks
+		if play.GatherFacts {
+			tasks = append([]parser.Task{{Name: "Gathering Facts", Setup: true}}, tasks...)
+		}
+		ps := newPlayState(ctx, play, hostVars, stats)
+		for n, batch := range batches {
+			if len(batches) > 1 {
+				fmt.Printf("\n🚚 Batch %d/%d (%d hosts): %s\n", n+1, len(batches), len(batch), hostNames(batch))
+			}
+			batchFailed := e.runStrategy(ps, tasks, batch)
+			if ps.isAborted() {
+				mergeHosts(failed, batchFailed)
+				if ctx.Err() == nil {
+					fmt.Printf("❌ Play aborted: a host failed and any_errors_fatal is set\n")
+				}
+				break
+			}
+			// Handlers do not run on hosts that failed.
+			mergeHosts(batchFailed, e.flushHandlers(ps, activeHosts(batch, batchFailed)))
+			mergeHosts(failed, batchFailed)
 
-			var results []ssh.CommandResult
-			var mu sync.Mutex
-			var wg sync.WaitGroup
-			sem := make(chan struct{}, e.MaxWorkers)
+			if len(batches) > 1 {
+				fmt.Printf("🚚 Batch %d/%d done: %d/%d hosts failed\n", n+1, len(batches), len(batchFailed), len(batch))
+			}
+			if failPercentageExceeded(play, len(batchFailed), len(batch)) {
+				fmt.Printf("❌ Play aborted: %d of %d hosts in batch %d failed, above max_fail_percentage %v%%\n",
+					len(batchFailed), len(batch), n+1, *play.MaxFailPercentage)
+				break
+			}
+			if len(batchFailed) == len(batch) && n+1 < len(batches) {
+				fmt.Printf("❌ Play aborted: all hosts in batch %d failed\n", n+1)
+				break
+			}
+		}
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		fmt.Printf("\n⏱️  Run timed out, the recap below is partial\n")
+	case ctx.Err() != nil:
+		fmt.Printf("\n⛔ Run interrupted, the recap below is partial\n")
+	}
+	printRecap(stats, e.CheckMode)
+}
+
+// runOnHosts runs task on hosts in parallel, updates the statistics, records
+// handler notifications and failures and prints the results.
+func (e *Executor) runOnHosts(ps *playState, task parser.Task, kind string, hosts []inventory.Host) []ssh.CommandResult {
+	mode := ""
+	if e.CheckMode {
+		mode = " (check mode)"
+	}
+	if ps.free {
+		// Hosts interleave under the free strategy, so name the host.
+		mode += " " + hostNames(hosts)
+	}
+	fmt.Printf("\n%s [%s]%s ********************************************************\n", kind, task.Name, mode)
 
-			for _, host := range hosts {
-				vars := hostVars[host.Name]
-				wg.Add(1)
-				go func(h inventory.Host, vars map[string]interface{}) {
-					defer wg.Done()
-					sem <- struct{}{}
-					defer func() { <-sem }()
+	var results []ssh.CommandResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, e.MaxWorkers)
 
-					if !EvaluateWhen(task.When, vars) {
-						return
-					}
+	for _, host := range hosts {
+		vars := ps.hostVars[host.Name]
+		wg.Add(1)
+		go func(h inventory.Host, vars map[string]interface{}) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-					if e.CheckMode {
-						res := ssh.CommandResult{Host: h.Name, ReturnMsg: "SKIPPED", ReturnCode: 0, Output: fmt.Sprintf("dry-run: %s", task.Name)}
-						mu.Lock()
-						results = append(results, res)
-						hs := stats[h.Name]
-						if hs != nil {
-							hs.Skipped++
-						}
-						mu.Unlock()
-						if e.Logger != nil {
-							e.Logger.Collect(res)
-						}
-						return
-					}
+			h = ApplyBecome(h, e.Become, ps.play.BecomeOptions, task.BecomeOptions)
+			h = becomePasswordFromVars(h, vars)
 
-					res := ExecuteTask(task, h, vars, e.DiffMode)
-					mu.Lock()
-					results = append(results, res)
-					hs := stats[h.Name]
-					if hs != nil {
-						switch res.ReturnMsg {
-						case "OK":
-							hs.OK++
-						case "CHANGED":
-							hs.Changed++
-						case "FAILED":
-							hs.Failed++
-						case "SKIPPED":
-							hs.Skipped++
-						}
+			res, ran := e.runTask(ps.ctx, task, h, vars)
+			if !ran {
+				return
+			}
+			if res.ReturnMsg == "CHANGED" && len(task.Notify) > 0 {
+				ps.pending.notify(h.Name, task.Notify)
+			}
+			ignored := res.ReturnMsg == "FAILED" && task.IgnoreErrors
+			if ignored {
+				res.Output = strings.TrimRight(res.Output, "\n") + "\n...ignoring"
+			}
+			res = MaskResult(res)
+			mu.Lock()
+			results = append(results, res)
+			hs := ps.stats[h.Name]
+			if hs != nil {
+				switch res.ReturnMsg {
+				case "OK":
+					hs.OK++
+				case "CHANGED":
+					hs.Changed++
+					if e.CheckMode {
+						hs.Predicted = appendUnique(hs.Predicted, task.Name)
 					}
-					mu.Unlock()
-					if e.Logger != nil {
-						e.Logger.Collect(res)
+				case "FAILED":
+					if ignored {
+						hs.Ignored++
+					} else {
+						hs.Failed++
 					}
-				}(host, vars)
-			}
-			wg.Wait()
-
-			if e.AggregateOutput {
-				ssh.AggregatedPrint(results)
-			} else {
-				for _, r := range results {
-					fmt.Printf("%s | %s | rc=%d >>\n%s\n", r.Host, r.ReturnMsg, r.ReturnCode, r.Output)
+				case "UNREACHABLE":
+					hs.Unreachable++
+				case "SKIPPED":
+					hs.Skipped++
 				}
 			}
+			mu.Unlock()
+			if failedResult(res) && !ignored {
+				ps.recordFailure(h.Name, task, res)
+			}
+			if e.Logger != nil {
+				e.Logger.Collect(res)
+			}
+		}(host, vars)
+	}
+	wg.Wait()
+
+	if e.AggregateOutput {
+		ssh.AggregatedPrint(results)
+	} else {
+		for _, r := range results {
+			fmt.Printf("%s | %s | rc=%d >>\n%s\n", r.Host, r.ReturnMsg, r.ReturnCode, r.Output)
 		}
 	}
-	printRecap(stats)
+	return results
+}
+
+// MaskResult hides vault secrets in the output of res before it is printed
+// or logged. Registered variables keep the real values.
+func MaskResult(res ssh.CommandResult) ssh.CommandResult {
+	res.Output = vault.MaskSecrets(res.Output)
+	res.Stdout = vault.MaskSecrets(res.Stdout)
+	res.Stderr = vault.MaskSecrets(res.Stderr)
+	return res
+}
+
+// CloneVars deep copies a variable map so hosts never share mutable state.
+func CloneVars(src map[string]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		dst[k] = cloneValue(v)
+	}
+	return dst
 }
 
 func cloneValue(v interface{}) interface{} {
@@ -148,3 +275,20 @@ func cloneValue(v interface{}) interface{} {
 		return v
 	}
 }
+
+func stringsToValues(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
+
+// groupsVar exposes the inventory groups as the Ansible "groups" variable.
+func groupsVar(inv *inventory.Inventory) map[string]interface{} {
+	groups := make(map[string]interface{})
+	for name, hosts := range inv.Groups() {
+		groups[name] = stringsToValues(hosts)
+	}
+	return groups
+}
@@ -3,6 +3,7 @@ package executor
 import (
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // hostStats stores counts of task results per host.
@@ -14,6 +15,23 @@ type hostStats struct {
 	Unreachable int
 	Rescued     int
 	Ignored     int
+	// Handlers lists the handlers that ran on the host, in order.
+	Handlers []string
+	// Predicted lists the tasks check mode expects to change.
+	Predicted []string
+}
+
+func (s *hostStats) addHandler(name string) {
+	s.Handlers = appendUnique(s.Handlers, name)
+}
+
+func appendUnique(list []string, name string) []string {
+	for _, v := range list {
+		if v == name {
+			return list
+		}
+	}
+	return append(list, name)
 }
 
 var (
@@ -27,12 +45,18 @@ func colorize(val int, color string) string {
 	return fmt.Sprintf("%s%d%s", color, val, colorReset)
 }
 
-// printRecap displays a PLAY RECAP summary similar to Ansible.
-func printRecap(stats map[string]*hostStats) {
+// printRecap displays a PLAY RECAP summary similar to Ansible. In check mode
+// the changed column counts predicted changes and each drifting host lists
+// the tasks that would change it.
+func printRecap(stats map[string]*hostStats, check bool) {
 	if len(stats) == 0 {
 		return
 	}
-	fmt.Println("\nPLAY RECAP ****************************************************************")
+	if check {
+		fmt.Println("\nPLAY RECAP (check mode, changes are predicted) ***************************")
+	} else {
+		fmt.Println("\nPLAY RECAP ****************************************************************")
+	}
 	hosts := make([]string, 0, len(stats))
 	for h := range stats {
 		hosts = append(hosts, h)
@@ -45,5 +69,11 @@ func printRecap(stats map[string]*hostStats) {
 		failedStr := colorize(s.Failed, colorRed)
 		fmt.Printf("%-20s : ok=%s changed=%s unreachable=%d failed=%s skipped=%d rescued=%d ignored=%d\n",
 			h, okStr, changedStr, s.Unreachable, failedStr, s.Skipped, s.Rescued, s.Ignored)
+		if len(s.Predicted) > 0 {
+			fmt.Printf("%-20s   would change: %s\n", "", strings.Join(s.Predicted, ", "))
+		}
+		if len(s.Handlers) > 0 {
+			fmt.Printf("%-20s   handlers: %s\n", "", strings.Join(s.Handlers, ", "))
+		}
 	}
 }
@@ -2,7 +2,12 @@ package executor
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
 	"text/template"
+
+	"xconfig/core/parser"
 )
 
 // RenderString renders a template string with the provided variables.
@@ -17,3 +22,153 @@ func RenderString(tmplStr string, vars map[string]string) (string, error) {
 	}
 	return buf.String(), nil
 }
+
+// RenderValue expands every `{{ expr }}` in s using the `when` expression
+// language. When s consists of a single expression the value keeps its type
+// (so "{{ packages }}" yields a list); otherwise the pieces are joined into a
+// string. Segments that are not valid expressions, such as Go template
+// actions like `{{ .item }}`, are left untouched for the module to handle.
+func RenderValue(s string, vars map[string]interface{}) (interface{}, error) {
+	var b strings.Builder
+	rest := s
+	for {
+		open := strings.Index(rest, "{{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(rest[open:], "}}")
+		if end < 0 {
+			break
+		}
+		end += open + 2
+		segment := rest[open:end]
+		b.WriteString(rest[:open])
+		rest = rest[end:]
+
+		e, err := CompileExpression(segment)
+		if err != nil {
+			b.WriteString(segment)
+			continue
+		}
+		v, err := defined(e, vars)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %q: %v", segment, err)
+		}
+		if strings.TrimSpace(s) == segment {
+			return v, nil
+		}
+		b.WriteString(renderString(v))
+	}
+	b.WriteString(rest)
+	return b.String(), nil
+}
+
+// renderString formats a rendered value for interpolation into a string.
+func renderString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// renderData renders strings nested anywhere inside v.
+func renderData(v interface{}, vars map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		if !strings.Contains(val, "{{") {
+			return val, nil
+		}
+		return RenderValue(val, vars)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			r, err := renderData(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			r, err := renderData(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// unrenderedFields are task keywords evaluated by the executor itself.
+var unrenderedFields = map[string]bool{
+	"Name": true, "When": true, "Register": true, "Notify": true, "Listen": true, "Meta": true, "ChangedWhen": true, "FailedWhen": true,
+	"Block": true, "Rescue": true, "Always": true,
+	"Loop": true, "WithItems": true, "WithDict": true, "LoopControl": true,
+}
+
+// renderTask returns a copy of task with `{{ expr }}` expanded in every module
+// parameter. Pointer fields are copied so the playbook itself is never
+// modified.
+func renderTask(task parser.Task, vars map[string]interface{}) (parser.Task, error) {
+	v := reflect.ValueOf(&task).Elem()
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		if unrenderedFields[t.Field(i).Name] {
+			continue
+		}
+		if err := renderField(v.Field(i), vars); err != nil {
+			return task, fmt.Errorf("%s: %v", t.Field(i).Name, err)
+		}
+	}
+	return task, nil
+}
+
+func renderField(f reflect.Value, vars map[string]interface{}) error {
+	if !f.CanSet() {
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		if !strings.Contains(f.String(), "{{") {
+			return nil
+		}
+		out, err := RenderValue(f.String(), vars)
+		if err != nil {
+			return err
+		}
+		f.SetString(renderString(out))
+	case reflect.Ptr:
+		if f.IsNil() || f.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		cp := reflect.New(f.Elem().Type())
+		cp.Elem().Set(f.Elem())
+		if err := renderField(cp.Elem(), vars); err != nil {
+			return err
+		}
+		f.Set(cp)
+	case reflect.Struct:
+		for i := 0; i < f.NumField(); i++ {
+			if err := renderField(f.Field(i), vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Map, reflect.Slice, reflect.Interface:
+		if f.IsNil() {
+			return nil
+		}
+		out, err := renderData(f.Interface(), vars)
+		if err != nil {
+			return err
+		}
+		rv := reflect.ValueOf(out)
+		if rv.Type().AssignableTo(f.Type()) {
+			f.Set(rv)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+// serialBatches splits hosts according to the play's serial keyword. Each
+// entry is a host count or a percentage of all hosts; the last entry repeats
+// until every host is placed. An empty serial yields a single batch.
+func serialBatches(serial []string, hosts []inventory.Host) ([][]inventory.Host, error) {
+	if len(serial) == 0 {
+		return [][]inventory.Host{hosts}, nil
+	}
+	sizes := make([]int, len(serial))
+	for i, s := range serial {
+		size, err := batchSize(s, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+
+	var batches [][]inventory.Host
+	for i, rest := 0, hosts; len(rest) > 0; i++ {
+		size := sizes[len(sizes)-1]
+		if i < len(sizes) {
+			size = sizes[i]
+		}
+		if size > len(rest) {
+			size = len(rest)
+		}
+		batches = append(batches, rest[:size])
+		rest = rest[size:]
+	}
+	return batches, nil
+}
+
+// batchSize converts "3" or "30%" into a host count of at least one.
+func batchSize(s string, total int) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || pct <= 0 {
+			return 0, fmt.Errorf("invalid serial percentage %q", s)
+		}
+		n := int(math.Floor(float64(total) * pct / 100))
+		if n < 1 {
+			n = 1
+		}
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid serial value %q", s)
+	}
+	if n == 0 {
+		// serial: 0 means all hosts at once, as in Ansible.
+		return total, nil
+	}
+	return n, nil
+}
+
+// checkStrategy rejects unknown strategy names before anything runs.
+func checkStrategy(play *parser.Play) error {
+	switch play.Strategy {
+	case "", "linear", "free":
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q (expected linear or free)", play.Strategy)
+}
+
+// runStrategy runs the play's tasks on one batch of hosts and returns the
+// hosts that failed.
+func (e *Executor) runStrategy(ps *playState, tasks []parser.Task, hosts []inventory.Host) map[string]bool {
+	if ps.play.Strategy != "free" {
+		return e.runTasks(ps, tasks, hosts, true)
+	}
+
+	// free: every host works through the task list without waiting for the
+	// others; MaxWorkers bounds how many hosts run at once.
+	ps.free = true
+	failed := make(map[string]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, e.MaxWorkers)
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h inventory.Host) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			f := e.runTasks(ps, tasks, []inventory.Host{h}, true)
+			mu.Lock()
+			mergeHosts(failed, f)
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+	return failed
+}
+
+// failPercentageExceeded reports whether a batch's failures exceed the
+// play's max_fail_percentage.
+func failPercentageExceeded(play *parser.Play, failed, total int) bool {
+	if play.MaxFailPercentage == nil || total == 0 {
+		return false
+	}
+	return float64(failed)*100/float64(total) > *play.MaxFailPercentage
+}
+
+func hostNames(hosts []inventory.Host) string {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,117 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+func TestSerialBatches(t *testing.T) {
+	var hosts []inventory.Host
+	for _, n := range []string{"h1", "h2", "h3", "h4", "h5", "h6", "h7", "h8", "h9", "h10"} {
+		hosts = append(hosts, inventory.Host{Name: n})
+	}
+	cases := []struct {
+		serial []string
+		sizes  []int
+	}{
+		{nil, []int{10}},
+		{[]string{"3"}, []int{3, 3, 3, 1}},
+		{[]string{"25%"}, []int{2, 2, 2, 2, 2}},
+		{[]string{"1", "10%", "50%"}, []int{1, 1, 5, 3}},
+		{[]string{"1%"}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{[]string{"0"}, []int{10}},
+	}
+	for _, c := range cases {
+		batches, err := serialBatches(c.serial, hosts)
+		if err != nil {
+			t.Fatalf("serial %v: %v", c.serial, err)
+		}
+		var sizes []int
+		for _, b := range batches {
+			sizes = append(sizes, len(b))
+		}
+		if !reflect.DeepEqual(sizes, c.sizes) {
+			t.Fatalf("serial %v: batch sizes %v, want %v", c.serial, sizes, c.sizes)
+		}
+	}
+	if _, err := serialBatches([]string{"many"}, hosts); err == nil {
+		t.Fatalf("expected an error for an invalid serial value")
+	}
+}
+
+func TestSerialStopsAtMaxFailPercentage(t *testing.T) {
+	calls := fakeCommand(t)
+	inv := writeInventory(t, "[web]\nweb1\nweb2\nweb3\nweb4\nweb5\nweb6\n")
+	limit := 40.0
+	play := parser.Play{
+		Hosts:             "web",
+		Serial:            parser.StringList{"1", "3"},
+		MaxFailPercentage: &limit,
+		Tasks: []parser.Task{
+			{Name: "deploy", Command: "fail", When: parser.When{Expressions: []string{"inventory_hostname in ['web3', 'web4']"}}},
+			{Name: "check", Command: "check"},
+		},
+	}
+
+	e := New(false, false, false)
+	e.MaxWorkers = 1
+	out := captureStdout(t, func() { e.Execute(context.Background(), []parser.Play{play}, inv) })
+
+	want := []string{"web1:check", "web2:check", "web3:fail", "web4:fail"}
+	if got := sortedCalls(calls()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	for _, s := range []string{"Batch 1/3", "Batch 2/3", "above max_fail_percentage"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected %q in output:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "Batch 3/3 (") {
+		t.Fatalf("expected the last batch to be skipped:\n%s", out)
+	}
+}
+
+func TestFreeStrategyLetsHostsProceed(t *testing.T) {
+	calls := fakeCommand(t)
+	play := &parser.Play{Strategy: "free", Tasks: []parser.Task{
+		{Name: "break web1", Command: "fail", When: onHost("web1")},
+		{Name: "next", Command: "next"},
+		{Name: "last", Command: "last"},
+	}}
+	hosts := []inventory.Host{{Name: "web1"}, {Name: "web2"}}
+	hostVars := map[string]map[string]interface{}{
+		"web1": {"inventory_hostname": "web1"},
+		"web2": {"inventory_hostname": "web2"},
+	}
+	stats := map[string]*hostStats{"web1": {}, "web2": {}}
+
+	e := New(false, false, false)
+	e.MaxWorkers = 1
+	var failed map[string]bool
+	out := captureStdout(t, func() {
+		failed = e.runStrategy(newPlayState(context.Background(), play, hostVars, stats), play.Tasks, hosts)
+	})
+
+	// web2 runs every task on its own; web1 stops after its failure.
+	got := calls()
+	if idx(got, "web2:next") > idx(got, "web2:last") || len(got) != 3 || idx(got, "web1:fail") < 0 {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+	if !failed["web1"] || failed["web2"] {
+		t.Fatalf("unexpected failed hosts: %v", failed)
+	}
+	if !strings.Contains(out, "TASK [next] web2") {
+		t.Fatalf("expected host names in free strategy headers:\n%s", out)
+	}
+}
+
+func TestUnknownStrategyIsRejected(t *testing.T) {
+	if err := checkStrategy(&parser.Play{Strategy: "random"}); err == nil {
+		t.Fatalf("expected an error for an unknown strategy")
+	}
+}
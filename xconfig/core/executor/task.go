@@ -1,7 +1,11 @@
 package executor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"xconfig/core/parser"
 	"xconfig/internal/inventory"
@@ -10,30 +14,60 @@ import (
 )
 
 // ExecuteTask dispatches the task to the appropriate module handler.
-func ExecuteTask(task parser.Task, host inventory.Host, vars map[string]interface{}, diff bool) ssh.CommandResult {
+// In check mode handlers only predict the result. A task timeout bounds the
+// handler through ctx; an expired timeout fails the task with rc=124.
+func ExecuteTask(parent context.Context, task parser.Task, host inventory.Host, vars map[string]interface{}, diff, check bool) ssh.CommandResult {
 	if vars == nil {
 		vars = make(map[string]interface{})
 	}
-	ctx := modules.Context{Host: host, Vars: vars, Diff: diff}
+	runCtx := parent
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(parent, time.Duration(task.Timeout)*time.Second)
+		defer cancel()
+	}
+	ctx := modules.Context{Ctx: runCtx, Host: host, Vars: vars, Diff: diff, Check: check}
 
+	start := time.Now()
 	var res ssh.CommandResult
 	if h, ok := modules.GetHandler(task.Type()); ok {
 		res = h(ctx, task)
 	} else {
 		switch {
+		case task.Type() == "" && len(task.External) > 0:
+			res = ssh.CommandResult{Host: host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("Unsupported task type in '%s': unknown keys %s", task.Name, strings.Join(task.ExternalNames(), ", "))}
+		case task.IsExternal():
+			res = ssh.CommandResult{Host: host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("couldn't resolve module '%s': not a built-in module or an installed plugin (see `xconfig plugin list`)", task.Type())}
+		case check:
+			res = ssh.CommandResult{Host: host.Name, ReturnMsg: "SKIPPED", Output: "skipped in check mode"}
 		case task.Shell != "":
-			res = ssh.RunShellCommand(host, task.Shell)
+			res = ssh.RunShellCommand(runCtx, host, task.Shell)
 		case task.Script != "":
-			res = ssh.RunRemoteScript(host, task.Script)
+			res = ssh.RunRemoteScript(runCtx, host, task.Script)
 		case task.Template != nil:
-			res = ssh.RenderTemplate(host, task.Template.Src, task.Template.Dest, vars, diff)
+			attrs := ssh.FileAttrs{Mode: task.Template.Mode, Owner: task.Template.Owner, Group: task.Template.Group}
+			res = ssh.RenderTemplate(runCtx, host, task.Template.Src, task.Template.Dest, attrs, vars, diff, false)
 		default:
 			res = ssh.CommandResult{Host: host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("Unsupported task type in '%s'", task.Name)}
 		}
 	}
 
+	if parent.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		res.ReturnMsg = "FAILED"
+		res.ReturnCode = 124
+		res.Output = strings.TrimRight(res.Output, "\n")
+		if res.Output != "" {
+			res.Output += "\n"
+		}
+		res.Output += fmt.Sprintf("task timed out after %ds", task.Timeout)
+	}
+
+	if res.Start.IsZero() {
+		res.Start, res.End = start, time.Now()
+	}
+
 	if task.Register != "" {
-		vars[task.Register] = res.Output
+		vars[task.Register] = res.Registered()
 	}
 	return res
 }
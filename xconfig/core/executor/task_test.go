@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/vault"
+)
+
+func TestExecuteTaskRegistersStructuredResult(t *testing.T) {
+	vars := map[string]interface{}{}
+	task := parser.Task{Name: "say", Debug: &parser.MessageAction{Msg: "hello\nworld"}, Register: "out"}
+	ExecuteTask(context.Background(), task, inventory.Host{Name: "web"}, vars, false, false)
+
+	for expr, want := range map[string]bool{
+		"out is defined and out is succeeded": true,
+		"out.rc == 0 and not out.changed":     true,
+		"out.stdout_lines | length == 2":      true,
+		"out.stdout_lines[0] == 'hello'":      true,
+		"out.start is defined":                true,
+	} {
+		got, err := EvaluateExpression(expr, vars)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v", expr, got, err)
+		}
+	}
+}
+
+func TestChangedWhenOverridesStatus(t *testing.T) {
+	e := New(false, false, false)
+	host := inventory.Host{Name: "web"}
+	vars := map[string]interface{}{}
+
+	task := parser.Task{Debug: &parser.MessageAction{Msg: "Created user"}, Register: "out",
+		ChangedWhen: parser.When{Expressions: []string{"'Created' in out.stdout"}}}
+	if res, _ := e.runTask(context.Background(), task, host, vars); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s", res.ReturnMsg)
+	}
+	if vars["out"].(map[string]interface{})["changed"] != true {
+		t.Fatalf("expected registered result to be changed")
+	}
+
+	task.ChangedWhen = parser.When{Expressions: []string{"false"}}
+	if res, _ := e.runTask(context.Background(), task, host, vars); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK, got %s", res.ReturnMsg)
+	}
+
+	task.ChangedWhen = parser.When{Expressions: []string{"missing.rc == 0"}}
+	if res, _ := e.runTask(context.Background(), task, host, vars); res.ReturnMsg != "FAILED" {
+		t.Fatalf("expected FAILED for invalid changed_when, got %s", res.ReturnMsg)
+	}
+}
+
+func TestMaskResultHidesVaultSecrets(t *testing.T) {
+	vault.AddSecret("hunter22", true)
+	t.Cleanup(vault.ResetSecrets)
+	e := New(false, false, false)
+	vars := map[string]interface{}{"db_pass": "hunter22"}
+	task := parser.Task{Debug: &parser.MessageAction{Msg: "password is {{ db_pass }}"}, Register: "out"}
+
+	res, _ := e.runTask(context.Background(), task, inventory.Host{Name: "db"}, vars)
+	res = MaskResult(res)
+	if strings.Contains(res.Output, "hunter22") || !strings.Contains(res.Output, "password is "+vault.Mask) {
+		t.Fatalf("secret not masked: %q", res.Output)
+	}
+	if vars["out"].(map[string]interface{})["stdout"] != "password is hunter22" {
+		t.Fatalf("registered result should keep the real value: %v", vars["out"])
+	}
+}
+
+func TestExecuteTaskUnknownModule(t *testing.T) {
+	task := parser.Task{Name: "kv", External: map[string]interface{}{"consul_kv": "key=a value=b"}}
+	res := ExecuteTask(context.Background(), task, inventory.Host{Name: "web"}, nil, false, true)
+	if res.ReturnMsg != "FAILED" || !strings.Contains(res.Output, "couldn't resolve module 'consul_kv'") {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	task.External["tags"] = "kv"
+	res = ExecuteTask(context.Background(), task, inventory.Host{Name: "web"}, nil, false, false)
+	if res.ReturnMsg != "FAILED" || !strings.Contains(res.Output, "unknown keys consul_kv, tags") {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+	"xconfig/internal/modules"
+	"xconfig/internal/ssh"
+)
+
+// blockingCommand replaces the command module with one that waits for the
+// task context unless the command is "quick". It calls onStart for every
+// command it receives.
+func blockingCommand(t *testing.T, onStart func(cmd string)) {
+	t.Helper()
+	orig, _ := modules.GetHandler("command")
+	t.Cleanup(func() { modules.Register("command", orig) })
+	modules.Register("command", func(ctx modules.Context, task parser.Task) ssh.CommandResult {
+		onStart(task.Command)
+		if task.Command == "quick" {
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK"}
+		}
+		select {
+		case <-ctx.Ctx.Done():
+			return ssh.Interrupted(ctx.Host, ctx.Ctx.Err(), ssh.CommandResult{})
+		case <-time.After(10 * time.Second):
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED"}
+		}
+	})
+}
+
+func TestTaskTimeout(t *testing.T) {
+	blockingCommand(t, func(string) {})
+	start := time.Now()
+	res := ExecuteTask(context.Background(), parser.Task{Name: "hang", Command: "apt-get upgrade", Timeout: 1}, inventory.Host{Name: "web1"}, nil, false, false)
+	if res.ReturnMsg != "FAILED" || res.ReturnCode != 124 || !strings.HasSuffix(res.Output, "task timed out after 1s") {
+		t.Fatalf("expected a timeout failure, got %s rc=%d %q", res.ReturnMsg, res.ReturnCode, res.Output)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("timeout did not stop the task: %s", time.Since(start))
+	}
+}
+
+func TestDefaultTaskTimeoutAppliesToTasks(t *testing.T) {
+	blockingCommand(t, func(string) {})
+	e := New(false, false, false)
+	e.TaskTimeout = 1
+	vars := map[string]interface{}{}
+	res, _ := e.runItem(context.Background(), parser.Task{Name: "hang", Command: "sleep"}, inventory.Host{Name: "web1"}, vars)
+	if res.ReturnCode != 124 {
+		t.Fatalf("expected the default timeout to apply, got %s rc=%d", res.ReturnMsg, res.ReturnCode)
+	}
+}
+
+func TestCancelStopsRunWithPartialRecap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var started []string
+	blockingCommand(t, func(cmd string) {
+		started = append(started, cmd)
+		if cmd == "hang" {
+			cancel()
+		}
+	})
+	inv := writeInventory(t, "web1\n")
+	plays := []parser.Play{
+		{Hosts: "all", Tasks: []parser.Task{
+			{Name: "first", Command: "quick"},
+			{Name: "stuck", Command: "hang", Notify: parser.StringList{"restart"}},
+			{Name: "never", Command: "quick"},
+		}, Handlers: []parser.Task{{Name: "restart", Command: "quick"}}},
+		{Hosts: "all", Tasks: []parser.Task{{Name: "next play", Command: "quick"}}},
+	}
+
+	e := New(false, false, false)
+	out := captureStdout(t, func() { e.Execute(ctx, plays, inv) })
+
+	if strings.Join(started, ",") != "quick,hang" {
+		t.Fatalf("expected the run to stop after the interrupted task, started %v", started)
+	}
+	if !strings.Contains(out, "rc=130") || !strings.Contains(out, "Run interrupted") || !strings.Contains(out, "PLAY RECAP") {
+		t.Fatalf("expected an interrupted task and a partial recap:\n%s", out)
+	}
+}
@@ -5,15 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+	"xconfig/internal/vault"
 )
 
 type Template struct {
-	Src  string `yaml:"src"`
-	Dest string `yaml:"dest"`
-	Mode string `yaml:"mode,omitempty"`
+	Src   string `yaml:"src"`
+	Dest  string `yaml:"dest"`
+	Mode  string `yaml:"mode,omitempty"`
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
 }
 
 func (t *Template) UnmarshalYAML(value *yaml.Node) error {
@@ -29,6 +34,8 @@ func (t *Template) UnmarshalYAML(value *yaml.Node) error {
 		t.Src = assignments["src"]
 		t.Dest = assignments["dest"]
 		t.Mode = assignments["mode"]
+		t.Owner = assignments["owner"]
+		t.Group = assignments["group"]
 		return nil
 	case yaml.MappingNode:
 		var tmp plain
@@ -42,10 +49,15 @@ func (t *Template) UnmarshalYAML(value *yaml.Node) error {
 	}
 }
 
+// Copy uploads Src, a file or a directory copied recursively, or writes the
+// inline Content when Src is empty.
 type Copy struct {
-	Src  string `yaml:"src"`
-	Dest string `yaml:"dest"`
-	Mode string `yaml:"mode,omitempty"`
+	Src     string `yaml:"src"`
+	Dest    string `yaml:"dest"`
+	Content string `yaml:"content,omitempty"`
+	Mode    string `yaml:"mode,omitempty"`
+	Owner   string `yaml:"owner,omitempty"`
+	Group   string `yaml:"group,omitempty"`
 }
 
 func (c *Copy) UnmarshalYAML(value *yaml.Node) error {
@@ -60,7 +72,10 @@ func (c *Copy) UnmarshalYAML(value *yaml.Node) error {
 		assignments := parseKeyValueAssignments(raw)
 		c.Src = assignments["src"]
 		c.Dest = assignments["dest"]
+		c.Content = assignments["content"]
 		c.Mode = assignments["mode"]
+		c.Owner = assignments["owner"]
+		c.Group = assignments["group"]
 		return nil
 	case yaml.MappingNode:
 		var tmp plain
@@ -74,20 +89,474 @@ func (c *Copy) UnmarshalYAML(value *yaml.Node) error {
 	}
 }
 
+// Fetch downloads Src from the host into Dest/<host>/<Src>, or to Dest
+// itself when Flat is set (Dest ending with "/" then receives the file
+// under its base name).
+type Fetch struct {
+	Src  string `yaml:"src"`
+	Dest string `yaml:"dest"`
+	Flat bool   `yaml:"flat,omitempty"`
+	// FailOnMissing fails the task when Src does not exist (default true).
+	FailOnMissing *bool `yaml:"fail_on_missing,omitempty"`
+	// ValidateChecksum compares the downloaded file with the remote SHA-256
+	// (default true).
+	ValidateChecksum *bool `yaml:"validate_checksum,omitempty"`
+}
+
+func (f *Fetch) UnmarshalYAML(value *yaml.Node) error {
+	type plain Fetch
+	*f = Fetch{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		assignments := parseKeyValueAssignments(raw)
+		f.Src = assignments["src"]
+		f.Dest = assignments["dest"]
+		var err error
+		if f.Flat, err = parseBoolArg("flat", assignments["flat"]); err != nil {
+			return err
+		}
+		for key, dst := range map[string]**bool{"fail_on_missing": &f.FailOnMissing, "validate_checksum": &f.ValidateChecksum} {
+			if v, ok := assignments[key]; ok {
+				b, err := parseBoolArg(key, v)
+				if err != nil {
+					return err
+				}
+				*dst = &b
+			}
+		}
+		return nil
+	case yaml.MappingNode:
+		var tmp plain
+		if err := value.Decode(&tmp); err != nil {
+			return err
+		}
+		*f = Fetch(tmp)
+		return nil
+	default:
+		return fmt.Errorf("unsupported fetch format: %v", value.Kind)
+	}
+}
+
+// parseBoolArg reads a boolean key=value argument; yes/no and on/off are
+// accepted like in YAML.
+func parseBoolArg(key, v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "", "false", "no", "off", "0":
+		return false, nil
+	case "true", "yes", "on", "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("%s: expected a boolean, got %q", key, v)
+}
+
+// FileAction manages a path: state is directory, file, link, absent or
+// touch. Without a state it is link when Src is set, directory with Recurse
+// and file otherwise.
+type FileAction struct {
+	Path  string `yaml:"path"`
+	State string `yaml:"state,omitempty"`
+	// Src is the target of a symbolic link.
+	Src   string `yaml:"src,omitempty"`
+	Mode  string `yaml:"mode,omitempty"`
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	// Recurse applies mode, owner and group to everything under a directory.
+	Recurse bool `yaml:"recurse,omitempty"`
+	// Force replaces an existing file with the link.
+	Force bool `yaml:"force,omitempty"`
+}
+
+func (f *FileAction) UnmarshalYAML(value *yaml.Node) error {
+	type plain FileAction
+	*f = FileAction{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		a := parseKeyValueAssignments(raw)
+		*f = FileAction{Path: a["path"], State: a["state"], Src: a["src"], Mode: a["mode"], Owner: a["owner"], Group: a["group"]}
+		var err error
+		if f.Recurse, err = parseBoolArg("recurse", a["recurse"]); err != nil {
+			return err
+		}
+		if f.Force, err = parseBoolArg("force", a["force"]); err != nil {
+			return err
+		}
+		if f.Path == "" {
+			f.Path = firstNonEmpty(a["dest"], a["name"])
+		}
+		return nil
+	case yaml.MappingNode:
+		var tmp struct {
+			plain `yaml:",inline"`
+			Dest  string `yaml:"dest"`
+			Name  string `yaml:"name"`
+		}
+		if err := value.Decode(&tmp); err != nil {
+			return err
+		}
+		*f = FileAction(tmp.plain)
+		if f.Path == "" {
+			f.Path = firstNonEmpty(tmp.Dest, tmp.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported file format: %v", value.Kind)
+	}
+}
+
+// LineInFile makes sure a line is present in (or absent from) a file.
+// Regexp selects the line to replace or remove; with Backrefs the line may
+// refer to its groups (\1) and nothing is inserted when Regexp does not
+// match. New lines go after the last match of InsertAfter or before the
+// last match of InsertBefore ("BOF"/"EOF" are accepted), at the end by
+// default.
+type LineInFile struct {
+	Path         string `yaml:"path"`
+	Line         string `yaml:"line,omitempty"`
+	Regexp       string `yaml:"regexp,omitempty"`
+	State        string `yaml:"state,omitempty"`
+	InsertAfter  string `yaml:"insertafter,omitempty"`
+	InsertBefore string `yaml:"insertbefore,omitempty"`
+	Backrefs     bool   `yaml:"backrefs,omitempty"`
+	// Create starts from an empty file when Path does not exist.
+	Create bool   `yaml:"create,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+	Owner  string `yaml:"owner,omitempty"`
+	Group  string `yaml:"group,omitempty"`
+}
+
+func (l *LineInFile) UnmarshalYAML(value *yaml.Node) error {
+	type plain LineInFile
+	*l = LineInFile{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		a := parseKeyValueAssignments(raw)
+		*l = LineInFile{Path: firstNonEmpty(a["path"], a["dest"]), Line: a["line"], Regexp: a["regexp"], State: a["state"],
+			InsertAfter: a["insertafter"], InsertBefore: a["insertbefore"], Mode: a["mode"], Owner: a["owner"], Group: a["group"]}
+		var err error
+		if l.Backrefs, err = parseBoolArg("backrefs", a["backrefs"]); err != nil {
+			return err
+		}
+		l.Create, err = parseBoolArg("create", a["create"])
+		return err
+	case yaml.MappingNode:
+		var tmp struct {
+			plain `yaml:",inline"`
+			Dest  string `yaml:"dest"`
+		}
+		if err := value.Decode(&tmp); err != nil {
+			return err
+		}
+		*l = LineInFile(tmp.plain)
+		l.Path = firstNonEmpty(l.Path, tmp.Dest)
+		return nil
+	default:
+		return fmt.Errorf("unsupported lineinfile format: %v", value.Kind)
+	}
+}
+
+// BlockInFile manages a block of lines between two marker lines. Marker
+// contains "{mark}", replaced by MarkerBegin ("BEGIN") and MarkerEnd
+// ("END"). An existing block is replaced in place; a new one is placed like
+// a LineInFile line.
+type BlockInFile struct {
+	Path         string `yaml:"path"`
+	Block        string `yaml:"block,omitempty"`
+	Marker       string `yaml:"marker,omitempty"`
+	MarkerBegin  string `yaml:"marker_begin,omitempty"`
+	MarkerEnd    string `yaml:"marker_end,omitempty"`
+	State        string `yaml:"state,omitempty"`
+	InsertAfter  string `yaml:"insertafter,omitempty"`
+	InsertBefore string `yaml:"insertbefore,omitempty"`
+	Create       bool   `yaml:"create,omitempty"`
+	Mode         string `yaml:"mode,omitempty"`
+	Owner        string `yaml:"owner,omitempty"`
+	Group        string `yaml:"group,omitempty"`
+}
+
+func (b *BlockInFile) UnmarshalYAML(value *yaml.Node) error {
+	type plain BlockInFile
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("unsupported blockinfile format: %v", value.Kind)
+	}
+	var tmp struct {
+		plain `yaml:",inline"`
+		Dest  string `yaml:"dest"`
+		// Content is the old name of block.
+		Content string `yaml:"content"`
+	}
+	if err := value.Decode(&tmp); err != nil {
+		return err
+	}
+	*b = BlockInFile(tmp.plain)
+	b.Path = firstNonEmpty(b.Path, tmp.Dest)
+	b.Block = firstNonEmpty(b.Block, tmp.Content)
+	return nil
+}
+
+// UserAction manages a local account with useradd/usermod/userdel. Groups
+// is a comma separated list (a YAML list is accepted too) of supplementary
+// groups; without Append the user leaves the groups that are not listed.
+// Password is a crypt(3) hash, as written to /etc/shadow.
+type UserAction struct {
+	Name     string `yaml:"name"`
+	State    string `yaml:"state,omitempty"`
+	UID      string `yaml:"uid,omitempty"`
+	Group    string `yaml:"group,omitempty"`
+	Groups   string `yaml:"groups,omitempty"`
+	Append   bool   `yaml:"append,omitempty"`
+	Shell    string `yaml:"shell,omitempty"`
+	Home     string `yaml:"home,omitempty"`
+	MoveHome bool   `yaml:"move_home,omitempty"`
+	// CreateHome creates the home directory of a new user (default true).
+	CreateHome *bool  `yaml:"create_home,omitempty"`
+	Comment    string `yaml:"comment,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	// UpdatePassword is "always" (default) or "on_create".
+	UpdatePassword string `yaml:"update_password,omitempty"`
+	System         bool   `yaml:"system,omitempty"`
+	// Remove deletes the home directory and mail spool with state=absent.
+	Remove bool `yaml:"remove,omitempty"`
+
+	// GroupsSet records that groups was given, so an empty list removes the
+	// user from every supplementary group.
+	GroupsSet bool `yaml:"-"`
+}
+
+func (u *UserAction) UnmarshalYAML(value *yaml.Node) error {
+	type plain UserAction
+	*u = UserAction{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		a := parseKeyValueAssignments(raw)
+		*u = UserAction{Name: a["name"], State: a["state"], UID: a["uid"], Group: a["group"], Groups: a["groups"],
+			Shell: a["shell"], Home: a["home"], Comment: a["comment"], Password: a["password"], UpdatePassword: a["update_password"]}
+		_, u.GroupsSet = a["groups"]
+		for key, dst := range map[string]*bool{"append": &u.Append, "move_home": &u.MoveHome, "system": &u.System, "remove": &u.Remove} {
+			b, err := parseBoolArg(key, a[key])
+			if err != nil {
+				return err
+			}
+			*dst = b
+		}
+		if v, ok := a["create_home"]; ok {
+			b, err := parseBoolArg("create_home", v)
+			if err != nil {
+				return err
+			}
+			u.CreateHome = &b
+		}
+		return nil
+	case yaml.MappingNode:
+		var tmp plain
+		if err := joinSequences(value, "groups").Decode(&tmp); err != nil {
+			return err
+		}
+		*u = UserAction(tmp)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i].Value == "groups" && value.Content[i+1].ShortTag() != "!!null" {
+				u.GroupsSet = true
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported user format: %v", value.Kind)
+	}
+}
+
+// GroupAction manages a local group with groupadd/groupmod/groupdel.
+type GroupAction struct {
+	Name   string `yaml:"name"`
+	State  string `yaml:"state,omitempty"`
+	GID    string `yaml:"gid,omitempty"`
+	System bool   `yaml:"system,omitempty"`
+}
+
+func (g *GroupAction) UnmarshalYAML(value *yaml.Node) error {
+	type plain GroupAction
+	*g = GroupAction{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		a := parseKeyValueAssignments(raw)
+		*g = GroupAction{Name: a["name"], State: a["state"], GID: a["gid"]}
+		var err error
+		g.System, err = parseBoolArg("system", a["system"])
+		return err
+	case yaml.MappingNode:
+		var tmp plain
+		if err := value.Decode(&tmp); err != nil {
+			return err
+		}
+		*g = GroupAction(tmp)
+		return nil
+	default:
+		return fmt.Errorf("unsupported group format: %v", value.Kind)
+	}
+}
+
+// AuthorizedKey manages public keys in a user's authorized_keys file. Key
+// may hold several keys, one per line; with Exclusive every other key is
+// removed. KeyOptions (e.g. `from="10.0.0.0/8",no-pty`) are put in front
+// of each key.
+type AuthorizedKey struct {
+	User       string `yaml:"user"`
+	Key        string `yaml:"key"`
+	State      string `yaml:"state,omitempty"`
+	Path       string `yaml:"path,omitempty"`
+	KeyOptions string `yaml:"key_options,omitempty"`
+	Exclusive  bool   `yaml:"exclusive,omitempty"`
+	// ManageDir creates ~/.ssh with mode 0700 (default true).
+	ManageDir *bool `yaml:"manage_dir,omitempty"`
+}
+
+func (k *AuthorizedKey) UnmarshalYAML(value *yaml.Node) error {
+	type plain AuthorizedKey
+	*k = AuthorizedKey{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		a := parseKeyValueAssignments(raw)
+		*k = AuthorizedKey{User: a["user"], Key: a["key"], State: a["state"], Path: a["path"], KeyOptions: a["key_options"]}
+		var err error
+		if k.Exclusive, err = parseBoolArg("exclusive", a["exclusive"]); err != nil {
+			return err
+		}
+		if v, ok := a["manage_dir"]; ok {
+			b, err := parseBoolArg("manage_dir", v)
+			if err != nil {
+				return err
+			}
+			k.ManageDir = &b
+		}
+		return nil
+	case yaml.MappingNode:
+		var tmp plain
+		if err := value.Decode(&tmp); err != nil {
+			return err
+		}
+		*k = AuthorizedKey(tmp)
+		return nil
+	default:
+		return fmt.Errorf("unsupported authorized_key format: %v", value.Kind)
+	}
+}
+
+// joinSequences returns a copy of the mapping node in which the YAML lists
+// under keys are replaced by their comma separated form.
+func joinSequences(value *yaml.Node, keys ...string) *yaml.Node {
+	node := *value
+	node.Content = append([]*yaml.Node(nil), value.Content...)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		item := node.Content[i+1]
+		if item.Kind != yaml.SequenceNode || !slices.Contains(keys, node.Content[i].Value) {
+			continue
+		}
+		values := make([]string, 0, len(item.Content))
+		for _, v := range item.Content {
+			values = append(values, v.Value)
+		}
+		node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.Join(values, ",")}
+	}
+	return &node
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 type Stat struct {
 	Path string `yaml:"path"`
 }
 
+// PackageAction is shared by the package, apt and yum modules. Name is a
+// package, a comma separated list (a YAML list is accepted too), or a local
+// package file; "name=version" pins a version.
 type PackageAction struct {
 	Name  string `yaml:"name,omitempty"`
 	Deb   string `yaml:"deb,omitempty"`
 	State string `yaml:"state,omitempty"`
+	// UpdateCache refreshes the package index before acting.
+	UpdateCache bool `yaml:"update_cache,omitempty"`
+	// Use forces a package manager instead of detecting it (package only).
+	Use string `yaml:"use,omitempty"`
+}
+
+func (p *PackageAction) UnmarshalYAML(value *yaml.Node) error {
+	type plain PackageAction
+	*p = PackageAction{}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var raw string
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+		assignments := parseKeyValueAssignments(raw)
+		p.Name = assignments["name"]
+		p.Deb = assignments["deb"]
+		p.State = assignments["state"]
+		p.Use = assignments["use"]
+		var err error
+		p.UpdateCache, err = parseBoolArg("update_cache", assignments["update_cache"])
+		return err
+	case yaml.MappingNode:
+		// A list of names is kept as the comma separated form the loop
+		// batching already produces.
+		node := joinSequences(value, "name", "pkg")
+		var tmp struct {
+			plain `yaml:",inline"`
+			Pkg   string `yaml:"pkg"`
+		}
+		if err := node.Decode(&tmp); err != nil {
+			return err
+		}
+		*p = PackageAction(tmp.plain)
+		p.Name = firstNonEmpty(p.Name, tmp.Pkg)
+		return nil
+	default:
+		return fmt.Errorf("unsupported package format: %v", value.Kind)
+	}
 }
 
 type ServiceAction struct {
-	Name    string `yaml:"name"`
-	State   string `yaml:"state"`
-	Enabled bool   `yaml:"enabled,omitempty"`
+	Name  string `yaml:"name"`
+	State string `yaml:"state"`
+	// Enabled is nil when the task does not manage boot-time enablement.
+	Enabled *bool `yaml:"enabled,omitempty"`
+}
+
+// CommandArgs holds the `args:` of shell and command tasks.
+type CommandArgs struct {
+	// Creates skips the command when the path already exists.
+	Creates string `yaml:"creates,omitempty"`
+	// Removes skips the command when the path does not exist.
+	Removes string `yaml:"removes,omitempty"`
 }
 
 type MessageAction struct {
@@ -103,25 +572,135 @@ type VultrInstance struct {
 	Label  string `yaml:"label,omitempty"`
 }
 
+// BecomeOptions holds the privilege escalation keywords shared by plays and
+// tasks. A nil Become means "inherit from the enclosing play or inventory".
+type BecomeOptions struct {
+	Become         *bool  `yaml:"become,omitempty"`
+	BecomeUser     string `yaml:"become_user,omitempty"`
+	BecomeMethod   string `yaml:"become_method,omitempty"`
+	BecomePassword string `yaml:"become_password,omitempty"`
+}
+
 type Task struct {
-	Name     string                 `yaml:"name"`
-	When     When                   `yaml:"when,omitempty"`
-	Shell    string                 `yaml:"shell,omitempty"`
-	Script   string                 `yaml:"script,omitempty"`
-	Template *Template              `yaml:"template,omitempty"`
-	Command  string                 `yaml:"command,omitempty"`
-	Copy     *Copy                  `yaml:"copy,omitempty"`
-	Stat     *Stat                  `yaml:"stat,omitempty"`
-	Apt      *PackageAction         `yaml:"apt,omitempty"`
-	Yum      *PackageAction         `yaml:"yum,omitempty"`
-	Systemd  *ServiceAction         `yaml:"systemd,omitempty"`
-	Service  *ServiceAction         `yaml:"service,omitempty"`
-	Setup    bool                   `yaml:"setup,omitempty"`
-	SetFact  map[string]interface{} `yaml:"set_fact,omitempty"`
-	Fail     *MessageAction         `yaml:"fail,omitempty"`
-	Debug    *MessageAction         `yaml:"debug,omitempty"`
-	Vultr    *VultrInstance         `yaml:"vultr,omitempty"`
-	Register string                 `yaml:"register,omitempty"`
+	Name     string      `yaml:"name"`
+	When     When        `yaml:"when,omitempty"`
+	Shell    string      `yaml:"shell,omitempty"`
+	Script   string      `yaml:"script,omitempty"`
+	Template *Template   `yaml:"template,omitempty"`
+	Command  string      `yaml:"command,omitempty"`
+	Copy     *Copy       `yaml:"copy,omitempty"`
+	Fetch    *Fetch      `yaml:"fetch,omitempty"`
+	File     *FileAction `yaml:"file,omitempty"`
+	// LineInFile and BlockInFile edit a file in place.
+	LineInFile  *LineInFile            `yaml:"lineinfile,omitempty"`
+	BlockInFile *BlockInFile           `yaml:"blockinfile,omitempty"`
+	Stat        *Stat                  `yaml:"stat,omitempty"`
+	Apt         *PackageAction         `yaml:"apt,omitempty"`
+	Yum         *PackageAction         `yaml:"yum,omitempty"`
+	Package     *PackageAction         `yaml:"package,omitempty"`
+	Systemd     *ServiceAction         `yaml:"systemd,omitempty"`
+	Service     *ServiceAction         `yaml:"service,omitempty"`
+	Setup       bool                   `yaml:"setup,omitempty"`
+	SetFact     map[string]interface{} `yaml:"set_fact,omitempty"`
+	Fail        *MessageAction         `yaml:"fail,omitempty"`
+	Debug       *MessageAction         `yaml:"debug,omitempty"`
+	Vultr       *VultrInstance         `yaml:"vultr,omitempty"`
+	Register    string                 `yaml:"register,omitempty"`
+	Args        *CommandArgs           `yaml:"args,omitempty"`
+
+	// User, Group and AuthorizedKey manage local accounts.
+	User          *UserAction    `yaml:"user,omitempty"`
+	Group         *GroupAction   `yaml:"group,omitempty"`
+	AuthorizedKey *AuthorizedKey `yaml:"authorized_key,omitempty"`
+
+	// ChangedWhen overrides the changed status reported by the module. The
+	// registered result is available to the expressions.
+	ChangedWhen When `yaml:"changed_when,omitempty"`
+
+	// Notify names the handlers (or listen topics) to run when the task
+	// reports CHANGED. Listen lets a handler answer to extra topics.
+	Notify StringList `yaml:"notify,omitempty"`
+	Listen StringList `yaml:"listen,omitempty"`
+	// Meta runs an executor action instead of a module; "flush_handlers" runs
+	// pending handlers immediately.
+	Meta string `yaml:"meta,omitempty"`
+
+	// Block groups tasks; Rescue runs on hosts where a block task failed and
+	// Always runs afterwards regardless. when, become and ignore_errors set
+	// on the block apply to every task inside it.
+	Block  []Task `yaml:"block,omitempty"`
+	Rescue []Task `yaml:"rescue,omitempty"`
+	Always []Task `yaml:"always,omitempty"`
+
+	// IgnoreErrors keeps running the host after the task fails.
+	IgnoreErrors bool `yaml:"ignore_errors,omitempty"`
+	// FailedWhen overrides whether the result is a failure.
+	FailedWhen When `yaml:"failed_when,omitempty"`
+	// AnyErrorsFatal aborts the play on all hosts when this task fails.
+	AnyErrorsFatal bool `yaml:"any_errors_fatal,omitempty"`
+
+	// Loop, WithItems and WithDict repeat the task once per item. Each may be
+	// a YAML list (or mapping for with_dict) or an expression such as
+	// "{{ packages }}" that is evaluated per host.
+	Loop        interface{}  `yaml:"loop,omitempty"`
+	WithItems   interface{}  `yaml:"with_items,omitempty"`
+	WithDict    interface{}  `yaml:"with_dict,omitempty"`
+	LoopControl *LoopControl `yaml:"loop_control,omitempty"`
+
+	// Timeout fails the task when it runs longer than this many seconds.
+	Timeout int `yaml:"timeout,omitempty"`
+	// Async runs a shell or command task as a background job on the host,
+	// allowed to run for at most this many seconds. The job is checked every
+	// Poll seconds (default 10); poll: 0 starts it and moves on, returning an
+	// ansible_job_id for async_status.
+	Async int  `yaml:"async,omitempty"`
+	Poll  *int `yaml:"poll,omitempty"`
+	// AsyncStatus waits for a job started with async and poll: 0.
+	AsyncStatus *AsyncStatusAction `yaml:"async_status,omitempty"`
+
+	BecomeOptions `yaml:",inline"`
+
+	// External collects the keys that are not built-in keywords. A task
+	// without a built-in module names an external (plugin) module this
+	// way, e.g. `haproxy_backend: {backend: web, state: drain}`.
+	External map[string]interface{} `yaml:",inline"`
+}
+
+// DefaultPoll is the async poll interval in seconds when poll is not set.
+const DefaultPoll = 10
+
+// PollInterval returns the task's async poll interval in seconds.
+func (t Task) PollInterval() int {
+	if t.Poll == nil {
+		return DefaultPoll
+	}
+	return *t.Poll
+}
+
+// AsyncStatusAction identifies a background job by the ansible_job_id its
+// task registered.
+type AsyncStatusAction struct {
+	Jid string `yaml:"jid"`
+}
+
+// LoopControl tunes how a looped task runs.
+type LoopControl struct {
+	// LoopVar renames the item variable (default "item").
+	LoopVar string `yaml:"loop_var,omitempty"`
+	// Label replaces the item in the output, e.g. "{{ item.name }}".
+	Label string `yaml:"label,omitempty"`
+	// Pause is the number of seconds to wait between items.
+	Pause float64 `yaml:"pause,omitempty"`
+}
+
+// IsBlock reports whether the task is a block/rescue/always group.
+func (t Task) IsBlock() bool {
+	return len(t.Block) > 0 || len(t.Rescue) > 0 || len(t.Always) > 0
+}
+
+// HasLoop reports whether the task uses any looping keyword.
+func (t Task) HasLoop() bool {
+	return t.Loop != nil || t.WithItems != nil || t.WithDict != nil
 }
 
 // When represents the conditional expressions associated with a task.
@@ -170,6 +749,32 @@ func (w *When) UnmarshalYAML(value *yaml.Node) error {
 // IsEmpty returns true when no expressions are defined.
 func (w When) IsEmpty() bool { return len(w.Expressions) == 0 }
 
+// StringList accepts either a single string or a sequence of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var s string
+		if err := value.Decode(&s); err != nil {
+			return err
+		}
+		if s = strings.TrimSpace(s); s != "" {
+			*l = StringList{s}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		*l = list
+		return nil
+	default:
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+}
+
 // Type returns the module name associated with this task.
 func (t Task) Type() string {
 	switch {
@@ -183,12 +788,28 @@ func (t Task) Type() string {
 		return "template"
 	case t.Copy != nil:
 		return "copy"
+	case t.Fetch != nil:
+		return "fetch"
+	case t.File != nil:
+		return "file"
+	case t.LineInFile != nil:
+		return "lineinfile"
+	case t.BlockInFile != nil:
+		return "blockinfile"
 	case t.Stat != nil:
 		return "stat"
 	case t.Apt != nil:
 		return "apt"
 	case t.Yum != nil:
 		return "yum"
+	case t.Package != nil:
+		return "package"
+	case t.User != nil:
+		return "user"
+	case t.Group != nil:
+		return "group"
+	case t.AuthorizedKey != nil:
+		return "authorized_key"
 	case t.Systemd != nil:
 		return "systemd"
 	case t.Service != nil:
@@ -203,9 +824,39 @@ func (t Task) Type() string {
 		return "debug"
 	case t.Vultr != nil:
 		return "vultr_instance"
-	default:
-		return ""
+	case t.AsyncStatus != nil:
+		return "async_status"
+	case t.Meta != "":
+		return "meta"
+	case t.IsBlock():
+		return "block"
+	case len(t.External) == 1:
+		for name := range t.External {
+			return name
+		}
 	}
+	return ""
+}
+
+// IsExternal reports whether the task runs an external (plugin) module.
+func (t Task) IsExternal() bool {
+	_, ok := t.External[t.Type()]
+	return ok
+}
+
+// ExternalArgs returns the arguments given to an external module.
+func (t Task) ExternalArgs() interface{} {
+	return t.External[t.Type()]
+}
+
+// ExternalNames returns the task's unknown keys, sorted.
+func (t Task) ExternalNames() []string {
+	names := make([]string, 0, len(t.External))
+	for name := range t.External {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 type RoleRef struct {
@@ -244,8 +895,31 @@ type Play struct {
 	Name  string                 `yaml:"name"`
 	Hosts string                 `yaml:"hosts"`
 	Vars  map[string]interface{} `yaml:"vars,omitempty"`
-	Roles []RoleRef              `yaml:"roles,omitempty"`
-	Tasks []Task                 `yaml:"tasks,omitempty"`
+	// VarsFiles are YAML files, relative to the playbook, merged over Vars.
+	// They may be vault encrypted.
+	VarsFiles []string  `yaml:"vars_files,omitempty"`
+	Roles     []RoleRef `yaml:"roles,omitempty"`
+	Tasks     []Task    `yaml:"tasks,omitempty"`
+	// AnyErrorsFatal aborts the play on all hosts after an unrescued
+	// failure on any host.
+	AnyErrorsFatal bool `yaml:"any_errors_fatal,omitempty"`
+	// Strategy is "linear" (default: every host finishes a task before the
+	// next starts) or "free" (each host runs through the tasks on its own).
+	Strategy string `yaml:"strategy,omitempty"`
+	// Serial splits the hosts into batches that run the whole play one after
+	// another: a count ("2"), a percentage ("30%") or a list of them, the
+	// last entry repeating ([1, "10%", "50%"]).
+	Serial StringList `yaml:"serial,omitempty"`
+	// MaxFailPercentage aborts the remaining batches once more than this
+	// share of a batch failed.
+	MaxFailPercentage *float64 `yaml:"max_fail_percentage,omitempty"`
+	// Handlers run at the end of the play (or on meta: flush_handlers) on
+	// the hosts where a task notified them.
+	Handlers []Task `yaml:"handlers,omitempty"`
+	// GatherFacts runs the setup module on every host before the tasks.
+	GatherFacts bool `yaml:"gather_facts,omitempty"`
+
+	BecomeOptions `yaml:",inline"`
 }
 
 // LoadPlaybook parses the given playbook YAML and expands any referenced roles.
@@ -256,28 +930,58 @@ func LoadPlaybook(path string) ([]Play, error) {
 	}
 
 	var plays []Play
-	if err := yaml.Unmarshal(data, &plays); err != nil {
+	if err := vault.Unmarshal(data, &plays); err != nil {
 		return nil, err
 	}
 
 	base := filepath.Dir(path)
 	for i := range plays {
-		var allTasks []Task
+		if err := loadVarsFiles(base, &plays[i]); err != nil {
+			return nil, err
+		}
+		var allTasks, allHandlers []Task
 		for _, r := range plays[i].Roles {
-			ts, err := loadRoleTasks(base, r.Name)
+			ts, hs, err := loadRole(base, r.Name)
 			if err != nil {
 				return nil, err
 			}
 			allTasks = append(allTasks, ts...)
+			allHandlers = append(allHandlers, hs...)
 		}
 		allTasks = append(allTasks, plays[i].Tasks...)
 		plays[i].Tasks = allTasks
+		plays[i].Handlers = append(allHandlers, plays[i].Handlers...)
 	}
 
 	return plays, nil
 }
 
-func loadRoleTasks(base, name string) ([]Task, error) {
+// loadVarsFiles merges the play's vars_files into its vars.
+func loadVarsFiles(base string, play *Play) error {
+	for _, file := range play.VarsFiles {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(base, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("vars_files: %w", err)
+		}
+		vars := make(map[string]interface{})
+		if err := vault.UnmarshalVars(data, &vars); err != nil {
+			return fmt.Errorf("vars_files %s: %w", file, err)
+		}
+		if play.Vars == nil {
+			play.Vars = make(map[string]interface{})
+		}
+		for k, v := range vars {
+			play.Vars[k] = v
+		}
+	}
+	return nil
+}
+
+// loadRole reads the tasks and (optional) handlers of a role.
+func loadRole(base, name string) ([]Task, []Task, error) {
 	cleanName := strings.TrimSuffix(name, string(filepath.Separator))
 	cleanName = filepath.Clean(cleanName)
 
@@ -295,10 +999,24 @@ func loadRoleTasks(base, name string) ([]Task, error) {
 	}
 
 	if roleDir == "" {
-		return nil, fmt.Errorf("role '%s' not found", name)
+		return nil, nil, fmt.Errorf("role '%s' not found", name)
 	}
 
-	dir := filepath.Join(roleDir, "tasks")
+	tasks, err := loadRoleFile(roleDir, "tasks")
+	if err != nil {
+		return nil, nil, err
+	}
+	handlers, err := loadRoleFile(roleDir, "handlers")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return tasks, handlers, nil
+}
+
+// loadRoleFile reads <roleDir>/<kind>/main.y(a)ml and resolves file
+// references relative to the role.
+func loadRoleFile(roleDir, kind string) ([]Task, error) {
+	dir := filepath.Join(roleDir, kind)
 	path := filepath.Join(dir, "main.yaml")
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		path = filepath.Join(dir, "main.yml")
@@ -308,9 +1026,16 @@ func loadRoleTasks(base, name string) ([]Task, error) {
 		return nil, err
 	}
 	var tasks []Task
-	if err := yaml.Unmarshal(data, &tasks); err != nil {
+	if err := vault.Unmarshal(data, &tasks); err != nil {
 		return nil, err
 	}
+	resolveRolePaths(roleDir, tasks)
+	return tasks, nil
+}
+
+// resolveRolePaths makes script, template and copy sources relative to the
+// role directory, including tasks nested in blocks.
+func resolveRolePaths(roleDir string, tasks []Task) {
 	for i := range tasks {
 		if tasks[i].Script != "" && !filepath.IsAbs(tasks[i].Script) {
 			tasks[i].Script = filepath.Join(roleDir, "scripts", tasks[i].Script)
@@ -319,15 +1044,36 @@ func loadRoleTasks(base, name string) ([]Task, error) {
 			tasks[i].Template.Src = filepath.Join(roleDir, "templates", tasks[i].Template.Src)
 		}
 		if tasks[i].Copy != nil && tasks[i].Copy.Src != "" && !filepath.IsAbs(tasks[i].Copy.Src) {
-			tasks[i].Copy.Src = filepath.Join(roleDir, "files", tasks[i].Copy.Src)
+			src := filepath.Join(roleDir, "files", tasks[i].Copy.Src)
+			// 保留结尾的 "/"：只复制目录中的内容
+			if strings.HasSuffix(tasks[i].Copy.Src, "/") {
+				src += "/"
+			}
+			tasks[i].Copy.Src = src
 		}
+		resolveRolePaths(roleDir, tasks[i].Block)
+		resolveRolePaths(roleDir, tasks[i].Rescue)
+		resolveRolePaths(roleDir, tasks[i].Always)
 	}
-	return tasks, nil
+}
+
+// ModuleArgs parses free-form `key=value ...` module arguments. It returns
+// nil when raw holds no assignment.
+func ModuleArgs(raw string) map[string]interface{} {
+	assignments := parseKeyValueAssignments(raw)
+	if len(assignments) == 0 {
+		return nil
+	}
+	args := make(map[string]interface{}, len(assignments))
+	for k, v := range assignments {
+		args[k] = v
+	}
+	return args
 }
 
 func parseKeyValueAssignments(raw string) map[string]string {
 	result := make(map[string]string)
-	for _, field := range strings.Fields(raw) {
+	for _, field := range splitArgs(raw) {
 		parts := strings.SplitN(field, "=", 2)
 		if len(parts) != 2 {
 			continue
@@ -336,3 +1082,36 @@ func parseKeyValueAssignments(raw string) map[string]string {
 	}
 	return result
 }
+
+// splitArgs splits key=value arguments on whitespace, keeping `{{ ... }}`
+// expressions together so that `name={{ item }}` stays a single field.
+func splitArgs(raw string) []string {
+	var fields []string
+	var cur strings.Builder
+	depth := 0
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case strings.HasPrefix(raw[i:], "{{"):
+			depth++
+			cur.WriteString("{{")
+			i++
+			continue
+		case strings.HasPrefix(raw[i:], "}}") && depth > 0:
+			depth--
+			cur.WriteString("}}")
+			i++
+			continue
+		case depth == 0 && (raw[i] == ' ' || raw[i] == '\t' || raw[i] == '\n'):
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteByte(raw[i])
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
@@ -1,9 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"xconfig/internal/vault"
 )
 
 func writeFile(t *testing.T, path, content string) {
@@ -193,3 +198,543 @@ func TestLoadPlaybookWithLegacyModuleArgs(t *testing.T) {
 		t.Fatalf("unexpected values: %#v", values)
 	}
 }
+
+func TestLoadPlaybookWithBecome(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- name: Escalate
+  hosts: web
+  become: true
+  become_method: su
+  tasks:
+    - name: As postgres
+      command: psql -c 'select 1'
+      become_user: postgres
+    - name: Without become
+      shell: id
+      become: false
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	play := plays[0]
+	if play.Become == nil || !*play.Become || play.BecomeMethod != "su" {
+		t.Fatalf("unexpected play become options: %+v", play.BecomeOptions)
+	}
+	if play.Tasks[0].Become != nil || play.Tasks[0].BecomeUser != "postgres" {
+		t.Fatalf("unexpected first task become options: %+v", play.Tasks[0].BecomeOptions)
+	}
+	if play.Tasks[1].Become == nil || *play.Tasks[1].Become {
+		t.Fatalf("expected second task to disable become")
+	}
+}
+
+func TestLoadPlaybookWithLoops(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - name: Install
+      apt: name={{ item }}
+      loop: [nginx, curl]
+    - name: Users
+      debug:
+        msg: "{{ item.key }}"
+      with_dict: "{{ users }}"
+      loop_control:
+        loop_var: user
+        label: "{{ user.key }}"
+        pause: 0.5
+    - name: Plain
+      shell: id
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	if tasks[0].Apt == nil || tasks[0].Apt.Name != "{{ item }}" {
+		t.Fatalf("unexpected apt args: %+v", tasks[0].Apt)
+	}
+	if items, ok := tasks[0].Loop.([]interface{}); !ok || len(items) != 2 || !tasks[0].HasLoop() {
+		t.Fatalf("unexpected loop: %#v", tasks[0].Loop)
+	}
+	if tasks[1].WithDict != "{{ users }}" || tasks[1].LoopControl == nil {
+		t.Fatalf("unexpected with_dict task: %+v", tasks[1])
+	}
+	if lc := tasks[1].LoopControl; lc.LoopVar != "user" || lc.Label != "{{ user.key }}" || lc.Pause != 0.5 {
+		t.Fatalf("unexpected loop_control: %+v", lc)
+	}
+	if tasks[2].HasLoop() {
+		t.Fatalf("expected plain task to have no loop")
+	}
+}
+
+func TestLoadPlaybookWithHandlers(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeFile(t, filepath.Join(tmpDir, "roles", "nginx", "tasks", "main.yml"), `- name: Render config
+  template: src=nginx.conf.j2 dest=/etc/nginx/nginx.conf
+  notify: restart nginx
+`)
+	writeFile(t, filepath.Join(tmpDir, "roles", "nginx", "handlers", "main.yml"), `- name: restart nginx
+  service: {name: nginx, state: restarted}
+`)
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  roles: [nginx]
+  tasks:
+    - name: Deploy app
+      copy: src=app dest=/srv/app
+      notify: [restart app, web services]
+    - meta: flush_handlers
+  handlers:
+    - name: restart app
+      command: systemctl restart app
+      listen: web services
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	play := plays[0]
+	if len(play.Handlers) != 2 || play.Handlers[0].Name != "restart nginx" || play.Handlers[1].Name != "restart app" {
+		t.Fatalf("unexpected handlers: %+v", play.Handlers)
+	}
+	if !reflect.DeepEqual(play.Handlers[1].Listen, StringList{"web services"}) {
+		t.Fatalf("unexpected listen: %v", play.Handlers[1].Listen)
+	}
+	if !reflect.DeepEqual(play.Tasks[0].Notify, StringList{"restart nginx"}) {
+		t.Fatalf("unexpected role notify: %v", play.Tasks[0].Notify)
+	}
+	if !reflect.DeepEqual(play.Tasks[1].Notify, StringList{"restart app", "web services"}) {
+		t.Fatalf("unexpected notify list: %v", play.Tasks[1].Notify)
+	}
+	if play.Tasks[2].Type() != "meta" || play.Tasks[2].Meta != "flush_handlers" {
+		t.Fatalf("unexpected meta task: %+v", play.Tasks[2])
+	}
+}
+
+func TestLoadPlaybookWithCommandGuards(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - name: Unpack
+      command: tar xf /tmp/app.tgz -C /opt
+      args:
+        creates: /opt/app
+      changed_when: false
+    - name: Enable
+      systemd: {name: nginx, state: started, enabled: false}
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	if tasks[0].Args == nil || tasks[0].Args.Creates != "/opt/app" {
+		t.Fatalf("unexpected args: %+v", tasks[0].Args)
+	}
+	if !reflect.DeepEqual(tasks[0].ChangedWhen.Expressions, []string{"false"}) {
+		t.Fatalf("unexpected changed_when: %v", tasks[0].ChangedWhen)
+	}
+	if tasks[1].Systemd.Enabled == nil || *tasks[1].Systemd.Enabled {
+		t.Fatalf("expected enabled: false to be preserved")
+	}
+}
+
+func TestLoadPlaybookWithBlocks(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeFile(t, filepath.Join(tmpDir, "roles", "app", "tasks", "main.yml"), `- block:
+    - copy: src=app.conf dest=/etc/app.conf
+  rescue:
+    - script: rollback.sh
+`)
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  any_errors_fatal: true
+  roles: [app]
+  tasks:
+    - name: Upgrade
+      when: upgrade
+      block:
+        - shell: ./upgrade.sh
+          failed_when: "'ERROR' in out.stderr"
+          register: out
+      always:
+        - debug: {msg: done}
+          ignore_errors: true
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	play := plays[0]
+	if !play.AnyErrorsFatal {
+		t.Fatalf("expected any_errors_fatal on play")
+	}
+	role := play.Tasks[0]
+	if role.Type() != "block" || role.Block[0].Copy.Src != filepath.Join(tmpDir, "roles", "app", "files", "app.conf") {
+		t.Fatalf("unexpected role block: %+v", role)
+	}
+	if role.Rescue[0].Script != filepath.Join(tmpDir, "roles", "app", "scripts", "rollback.sh") {
+		t.Fatalf("unexpected rescue script path: %s", role.Rescue[0].Script)
+	}
+	upgrade := play.Tasks[1]
+	if !upgrade.IsBlock() || len(upgrade.When.Expressions) != 1 || len(upgrade.Always) != 1 {
+		t.Fatalf("unexpected block: %+v", upgrade)
+	}
+	if upgrade.Block[0].FailedWhen.Expressions[0] != "'ERROR' in out.stderr" || !upgrade.Always[0].IgnoreErrors {
+		t.Fatalf("unexpected block tasks: %+v", upgrade.Block)
+	}
+}
+
+func TestLoadPlaybookWithStrategy(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  gather_facts: true
+  strategy: free
+  serial: [1, 10%, 50%]
+  max_fail_percentage: 20
+  tasks:
+    - shell: uptime
+- hosts: db
+  serial: 2
+  tasks:
+    - shell: uptime
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	if !plays[0].GatherFacts || plays[1].GatherFacts {
+		t.Fatalf("unexpected gather_facts: %v %v", plays[0].GatherFacts, plays[1].GatherFacts)
+	}
+	if plays[0].Strategy != "free" || !reflect.DeepEqual(plays[0].Serial, StringList{"1", "10%", "50%"}) {
+		t.Fatalf("unexpected strategy/serial: %q %v", plays[0].Strategy, plays[0].Serial)
+	}
+	if plays[0].MaxFailPercentage == nil || *plays[0].MaxFailPercentage != 20 {
+		t.Fatalf("unexpected max_fail_percentage: %v", plays[0].MaxFailPercentage)
+	}
+	if !reflect.DeepEqual(plays[1].Serial, StringList{"2"}) || plays[1].MaxFailPercentage != nil {
+		t.Fatalf("unexpected second play: %+v", plays[1])
+	}
+}
+
+func TestLoadPlaybookWithTimeoutsAndAsync(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - shell: apt-get -y upgrade
+      timeout: 600
+    - command: /opt/backup.sh
+      async: 3600
+      poll: 0
+      register: backup
+    - async_status:
+        jid: "{{ backup.ansible_job_id }}"
+    - shell: ./migrate.sh
+      async: 300
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	if tasks[0].Timeout != 600 || tasks[0].Async != 0 {
+		t.Fatalf("unexpected timeout task: %+v", tasks[0])
+	}
+	if tasks[1].Async != 3600 || tasks[1].PollInterval() != 0 {
+		t.Fatalf("unexpected async task: async=%d poll=%d", tasks[1].Async, tasks[1].PollInterval())
+	}
+	if tasks[2].Type() != "async_status" || tasks[2].AsyncStatus.Jid != "{{ backup.ansible_job_id }}" {
+		t.Fatalf("unexpected async_status task: %+v", tasks[2])
+	}
+	if tasks[3].PollInterval() != DefaultPoll {
+		t.Fatalf("expected the default poll interval, got %d", tasks[3].PollInterval())
+	}
+}
+
+func TestLoadPlaybookWithVault(t *testing.T) {
+	vault.SetPassword([]byte("s3cret"))
+	t.Cleanup(func() {
+		vault.SetPassword(nil)
+		vault.ResetSecrets()
+	})
+	secrets, err := vault.Encrypt([]byte("db_pass: hunter22\nhttp_port: 8080\n"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := vault.Encrypt([]byte("tok-123456"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	writeFile(t, filepath.Join(tmpDir, "vars", "secrets.yml"), string(secrets))
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: db
+  vars:
+    http_port: 80
+    `+strings.ReplaceAll(vault.FormatInline("api_token", token), "\n", "\n    ")+`
+  vars_files:
+    - vars/secrets.yml
+  tasks:
+    - shell: echo ok
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	vars := plays[0].Vars
+	if vars["db_pass"] != "hunter22" || vars["http_port"] != 8080 || vars["api_token"] != "tok-123456" {
+		t.Fatalf("unexpected vars: %v", vars)
+	}
+	if got := vault.MaskSecrets("hunter22 tok-123456"); got != vault.Mask+" "+vault.Mask {
+		t.Fatalf("decrypted values not registered as secrets: %q", got)
+	}
+
+	vault.SetPassword([]byte("wrong"))
+	if _, err := LoadPlaybook(playbookPath); !errors.Is(err, vault.ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt with the wrong password, got %v", err)
+	}
+}
+
+func TestLoadPlaybookWithExternalModule(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: lb
+  tasks:
+    - name: Drain web1
+      haproxy_backend:
+        backend: web
+        server: "{{ inventory_hostname }}"
+        state: drain
+      register: drained
+    - consul_kv: key=app/version value=1.4
+    - shell: uptime
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	drain := tasks[0]
+	if drain.Type() != "haproxy_backend" || !drain.IsExternal() || drain.Register != "drained" {
+		t.Fatalf("unexpected external task: %+v", drain)
+	}
+	if args := drain.ExternalArgs().(map[string]interface{}); args["backend"] != "web" || args["state"] != "drain" {
+		t.Fatalf("unexpected external args: %v", drain.ExternalArgs())
+	}
+	if tasks[1].Type() != "consul_kv" || !reflect.DeepEqual(ModuleArgs(tasks[1].ExternalArgs().(string)), map[string]interface{}{"key": "app/version", "value": "1.4"}) {
+		t.Fatalf("unexpected free-form external task: %+v", tasks[1])
+	}
+	if tasks[2].Type() != "shell" || tasks[2].IsExternal() {
+		t.Fatalf("built-in module treated as external: %+v", tasks[2])
+	}
+}
+
+func TestLoadPlaybookWithCopyContentAndOwnership(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeFile(t, filepath.Join(tmpDir, "roles", "web", "tasks", "main.yml"), `- copy:
+    src: site/
+    dest: /var/www
+    owner: www-data
+    group: www-data
+- copy:
+    content: "listen 80;\n"
+    dest: /etc/nginx/conf.d/port.conf
+    mode: "0644"
+- template: src=nginx.conf.j2 dest=/etc/nginx/nginx.conf owner=root group=root mode=0644
+`)
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  roles:
+    - roles/web
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	dir := tasks[0].Copy
+	if dir.Src != filepath.Join(tmpDir, "roles", "web", "files", "site")+"/" || dir.Owner != "www-data" || dir.Group != "www-data" {
+		t.Fatalf("unexpected directory copy: %+v", dir)
+	}
+	content := tasks[1].Copy
+	if content.Src != "" || content.Content != "listen 80;\n" || content.Mode != "0644" {
+		t.Fatalf("unexpected content copy: %+v", content)
+	}
+	tmpl := tasks[2].Template
+	if tmpl.Owner != "root" || tmpl.Group != "root" || tmpl.Mode != "0644" {
+		t.Fatalf("unexpected template: %+v", tmpl)
+	}
+}
+
+func TestLoadPlaybookWithFetch(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - fetch:
+        src: /var/log/app.log
+        dest: backups
+        fail_on_missing: no
+    - fetch: src=/etc/app.conf dest=configs/ flat=yes validate_checksum=false
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	logs := tasks[0].Fetch
+	if tasks[0].Type() != "fetch" || logs.Src != "/var/log/app.log" || logs.Dest != "backups" || logs.Flat ||
+		logs.FailOnMissing == nil || *logs.FailOnMissing || logs.ValidateChecksum != nil {
+		t.Fatalf("unexpected fetch: %+v", logs)
+	}
+	conf := tasks[1].Fetch
+	if conf.Dest != "configs/" || !conf.Flat || conf.ValidateChecksum == nil || *conf.ValidateChecksum || conf.FailOnMissing != nil {
+		t.Fatalf("unexpected key=value fetch: %+v", conf)
+	}
+
+	writeFile(t, playbookPath, "- hosts: web\n  tasks:\n    - fetch: src=/a dest=b flat=maybe\n")
+	if _, err := LoadPlaybook(playbookPath); err == nil {
+		t.Fatalf("expected an invalid boolean to be rejected")
+	}
+}
+
+func TestLoadPlaybookWithFileModules(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - file: path=/opt/app state=directory owner=app mode=0750 recurse=yes
+    - file:
+        dest: /opt/app/current
+        src: /opt/app/releases/42
+    - lineinfile:
+        path: /etc/sysctl.conf
+        regexp: '^vm\.swappiness'
+        line: vm.swappiness = 10
+        create: true
+    - blockinfile:
+        dest: /etc/hosts
+        block: |
+          10.0.0.1 web1
+        marker: "# {mark} web hosts"
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	dir := tasks[0].File
+	if tasks[0].Type() != "file" || dir.Path != "/opt/app" || dir.State != "directory" || dir.Owner != "app" || dir.Mode != "0750" || !dir.Recurse {
+		t.Fatalf("unexpected file task: %+v", dir)
+	}
+	if link := tasks[1].File; link.Path != "/opt/app/current" || link.Src != "/opt/app/releases/42" {
+		t.Fatalf("unexpected link task: %+v", link)
+	}
+	line := tasks[2].LineInFile
+	if tasks[2].Type() != "lineinfile" || line.Path != "/etc/sysctl.conf" || line.Regexp != `^vm\.swappiness` || line.Line != "vm.swappiness = 10" || !line.Create {
+		t.Fatalf("unexpected lineinfile task: %+v", line)
+	}
+	block := tasks[3].BlockInFile
+	if tasks[3].Type() != "blockinfile" || block.Path != "/etc/hosts" || block.Block != "10.0.0.1 web1\n" || block.Marker != "# {mark} web hosts" {
+		t.Fatalf("unexpected blockinfile task: %+v", block)
+	}
+}
+
+func TestLoadPlaybookWithPackage(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - package:
+        name:
+          - nginx=1.24.0
+          - curl
+        update_cache: yes
+    - package: name=files/app_1.0_amd64.deb use=apt
+    - package:
+        pkg: htop
+        state: absent
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	if p := tasks[0].Package; tasks[0].Type() != "package" || p.Name != "nginx=1.24.0,curl" || !p.UpdateCache {
+		t.Fatalf("unexpected package list: %+v", p)
+	}
+	if p := tasks[1].Package; p.Name != "files/app_1.0_amd64.deb" || p.Use != "apt" || p.UpdateCache {
+		t.Fatalf("unexpected key=value package: %+v", p)
+	}
+	if p := tasks[2].Package; p.Name != "htop" || p.State != "absent" {
+		t.Fatalf("unexpected pkg alias: %+v", p)
+	}
+}
+
+func TestLoadPlaybookWithAccounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	playbookPath := filepath.Join(tmpDir, "site.yml")
+	writeFile(t, playbookPath, `- hosts: web
+  tasks:
+    - group: name=ops gid=2000 system=yes
+    - user:
+        name: alice
+        uid: 1050
+        groups: [ops, docker]
+        append: yes
+        create_home: no
+        password: "$6$salt$hash"
+      register: alice
+    - user: name=bob state=absent remove=yes
+    - authorized_key:
+        user: alice
+        key: ssh-ed25519 AAAAkey alice@laptop
+        key_options: no-pty
+        exclusive: true
+    - user: {name: carol, groups: ''}
+`)
+
+	plays, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("LoadPlaybook returned error: %v", err)
+	}
+	tasks := plays[0].Tasks
+	if g := tasks[0].Group; tasks[0].Type() != "group" || g.Name != "ops" || g.GID != "2000" || !g.System {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+	u := tasks[1].User
+	if tasks[1].Type() != "user" || u.UID != "1050" || u.Groups != "ops,docker" || !u.Append || u.CreateHome == nil || *u.CreateHome || u.Password != "$6$salt$hash" || !u.GroupsSet {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+	if u := tasks[2].User; u.Name != "bob" || u.State != "absent" || !u.Remove || u.CreateHome != nil || u.GroupsSet {
+		t.Fatalf("unexpected key=value user: %+v", u)
+	}
+	if k := tasks[3].AuthorizedKey; tasks[3].Type() != "authorized_key" || k.User != "alice" || k.KeyOptions != "no-pty" || !k.Exclusive || k.ManageDir != nil {
+		t.Fatalf("unexpected authorized_key: %+v", k)
+	}
+	// An explicit empty list is kept apart from a missing one.
+	if u := tasks[4].User; u.Groups != "" || !u.GroupsSet {
+		t.Fatalf("expected groups: '' to be recorded, got %+v", u)
+	}
+}
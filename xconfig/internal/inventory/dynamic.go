@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// DynamicInventory is the Ansible dynamic inventory JSON document produced by
+// inventory scripts (`--list`) and built-in providers:
+//
+//	{
+//	  "web":  {"hosts": ["web1"], "vars": {"http_port": 80}, "children": ["canary"]},
+//	  "db":   ["db1", "db2"],
+//	  "_meta": {"hostvars": {"web1": {"ansible_host": "10.0.0.5"}}}
+//	}
+type DynamicInventory struct {
+	Groups   map[string]DynamicGroup
+	HostVars map[string]map[string]interface{}
+	// HasMeta is false when the document had no _meta section, in which case
+	// scripts are asked for each host's vars with --host.
+	HasMeta bool
+}
+
+// DynamicGroup is one group of a DynamicInventory.
+type DynamicGroup struct {
+	Hosts    []string               `json:"hosts,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+	Children []string               `json:"children,omitempty"`
+}
+
+// UnmarshalJSON accepts both the full group object and a plain host list.
+func (g *DynamicGroup) UnmarshalJSON(data []byte) error {
+	var hosts []string
+	if err := json.Unmarshal(data, &hosts); err == nil {
+		g.Hosts = hosts
+		return nil
+	}
+	type plain DynamicGroup
+	var tmp plain
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*g = DynamicGroup(tmp)
+	return nil
+}
+
+// UnmarshalJSON splits the _meta section from the groups.
+func (d *DynamicInventory) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	d.Groups = make(map[string]DynamicGroup)
+	d.HostVars = make(map[string]map[string]interface{})
+	for name, value := range raw {
+		if name == "_meta" {
+			var meta struct {
+				HostVars map[string]map[string]interface{} `json:"hostvars"`
+			}
+			if err := json.Unmarshal(value, &meta); err != nil {
+				return fmt.Errorf("_meta: %w", err)
+			}
+			d.HasMeta = true
+			for h, vars := range meta.HostVars {
+				d.HostVars[h] = vars
+			}
+			continue
+		}
+		var g DynamicGroup
+		if err := json.Unmarshal(value, &g); err != nil {
+			return fmt.Errorf("group %s: %w", name, err)
+		}
+		d.Groups[name] = g
+	}
+	return nil
+}
+
+// MarshalJSON writes the document back in the Ansible layout.
+func (d DynamicInventory) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(d.Groups)+1)
+	for name, g := range d.Groups {
+		out[name] = g
+	}
+	out["_meta"] = map[string]interface{}{"hostvars": d.HostVars}
+	return json.Marshal(out)
+}
+
+// HostNames returns every host mentioned in groups or hostvars, sorted.
+func (d *DynamicInventory) HostNames() []string {
+	seen := make(map[string]bool)
+	for _, g := range d.Groups {
+		for _, h := range g.Hosts {
+			seen[h] = true
+		}
+	}
+	for h := range d.HostVars {
+		seen[h] = true
+	}
+	names := make([]string, 0, len(seen))
+	for h := range seen {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddDynamic merges a dynamic inventory document into inv.
+func (inv *Inventory) AddDynamic(d *DynamicInventory) {
+	groups := make([]string, 0, len(d.Groups))
+	for name := range d.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for _, h := range d.HostNames() {
+		inv.AddHost(h, "", d.HostVars[h])
+	}
+	for _, name := range groups {
+		g := d.Groups[name]
+		group := inv.Group(name)
+		for k, v := range g.Vars {
+			group.Vars[k] = v
+		}
+		for _, h := range g.Hosts {
+			inv.AddHost(h, name, nil)
+		}
+		for _, c := range g.Children {
+			inv.AddChild(name, c)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultUser = "ubuntu"
+	defaultPort = "22"
+)
+
+// hostFromVars maps Ansible connection variables onto a Host.
+func hostFromVars(name string, vars map[string]interface{}) Host {
+	h := Host{
+		Name:    name,
+		Address: name,
+		User:    defaultUser,
+		Port:    defaultPort,
+		KeyFile: filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"),
+		Vars:    vars,
+	}
+	if v := stringVar(vars, "ansible_host", "ansible_ssh_host"); v != "" {
+		h.Address = v
+	}
+	if v := stringVar(vars, "ansible_user", "ansible_ssh_user"); v != "" {
+		h.User = v
+	}
+	if v := stringVar(vars, "ansible_port", "ansible_ssh_port"); v != "" {
+		h.Port = v
+	}
+	if v := stringVar(vars, "ansible_ssh_private_key_file", "ansible_private_key_file"); v != "" {
+		h.KeyFile = expandHome(v)
+	}
+	h.Password = stringVar(vars, "ansible_password", "ansible_ssh_pass", "ansible_ssh_password")
+	h.HostKeyChecking = stringVar(vars, "ansible_host_key_checking", "ansible_ssh_host_key_checking")
+	h.KnownHostsFile = expandHome(stringVar(vars, "ansible_ssh_known_hosts_file"))
+	h.CertFile = expandHome(stringVar(vars, "ansible_ssh_certificate_file"))
+	h.PassphraseFile = expandHome(stringVar(vars, "ansible_ssh_private_key_passphrase_file"))
+	h.ForwardAgent = boolVar(vars, "ansible_ssh_forward_agent")
+
+	for _, key := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		if jumps := ParseProxyJump(stringVar(vars, key)); len(jumps) > 0 {
+			h.JumpHosts = jumps
+		}
+	}
+	if v := stringVar(vars, "ansible_ssh_jump_hosts"); v != "" {
+		h.JumpHosts = splitJumpList(v)
+	}
+
+	h.Become = boolVar(vars, "ansible_become")
+	h.BecomeUser = stringVar(vars, "ansible_become_user")
+	h.BecomeMethod = stringVar(vars, "ansible_become_method")
+	h.BecomePassword = stringVar(vars, "ansible_become_password", "ansible_become_pass")
+	return h
+}
+
+func stringVar(vars map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := vars[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+func boolVar(vars map[string]interface{}, key string) bool {
+	switch v := vars[key].(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "yes", "1", "on":
+			return true
+		}
+	case int:
+		return v != 0
+	}
+	return false
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
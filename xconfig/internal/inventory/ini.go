@@ -0,0 +1,162 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseINI reads an Ansible INI inventory: host lines under [group],
+// key=value pairs under [group:vars] and group names under [group:children].
+// Hosts listed before the first section are ungrouped.
+func (inv *Inventory) parseINI(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	group, kind := "ungrouped", "hosts"
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind = strings.Trim(line, "[]"), "hosts"
+			if i := strings.LastIndex(group, ":"); i >= 0 {
+				group, kind = group[:i], group[i+1:]
+			}
+			switch kind {
+			case "hosts", "vars", "children":
+			default:
+				return fmt.Errorf("line %d: unknown section type %q", lineNo, kind)
+			}
+			inv.Group(group)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected key=value in [%s:vars]", lineNo, group)
+			}
+			inv.Group(group).Vars[strings.TrimSpace(key)] = parseValue(strings.TrimSpace(value))
+		case "children":
+			inv.AddChild(group, strings.Fields(line)[0])
+		default:
+			fields := SplitFields(line)
+			vars := make(map[string]interface{})
+			for _, f := range fields[1:] {
+				key, value, ok := strings.Cut(f, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected key=value after host, got %q", lineNo, f)
+				}
+				vars[key] = parseValue(value)
+			}
+			names, err := ExpandHostRange(fields[0])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			for _, name := range names {
+				inv.AddHost(name, group, vars)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// parseValue interprets an INI value the way Ansible does: quoted strings are
+// unquoted, numbers and booleans are typed and [..] / {..} literals become
+// lists and maps.
+func parseValue(raw string) interface{} {
+	if len(raw) >= 2 && (raw[0] == '\'' || raw[0] == '"') && raw[len(raw)-1] == raw[0] {
+		return raw[1 : len(raw)-1]
+	}
+	switch raw {
+	case "True", "true":
+		return true
+	case "False", "false":
+		return false
+	}
+	if i, err := strconv.Atoi(raw); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil && strings.Contains(raw, ".") && strings.Count(raw, ".") == 1 {
+		return f
+	}
+	if strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "{") {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(raw), &v); err == nil {
+			return v
+		}
+	}
+	return raw
+}
+
+// ExpandHostRange expands Ansible host ranges such as web[01:10].example.com,
+// db-[a:c] or node[0:20:5]. Names without a range are returned as is.
+func ExpandHostRange(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated host range in %q", pattern)
+	}
+	end += start
+	prefix, spec, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid host range %q", pattern)
+	}
+	step := 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s <= 0 {
+			return nil, fmt.Errorf("invalid host range step in %q", pattern)
+		}
+		step = s
+	}
+
+	var items []string
+	if lo, err := strconv.Atoi(parts[0]); err == nil {
+		hi, err := strconv.Atoi(parts[1])
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("invalid host range %q", pattern)
+		}
+		width := 0
+		if len(parts[0]) > 1 && parts[0][0] == '0' {
+			width = len(parts[0])
+		}
+		for i := lo; i <= hi; i += step {
+			items = append(items, fmt.Sprintf("%0*d", width, i))
+		}
+	} else {
+		if len(parts[0]) != 1 || len(parts[1]) != 1 || parts[0][0] > parts[1][0] {
+			return nil, fmt.Errorf("invalid host range %q", pattern)
+		}
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(step) {
+			items = append(items, string(c))
+			if int(c)+step > 255 {
+				break
+			}
+		}
+	}
+
+	var names []string
+	for _, item := range items {
+		rest, err := ExpandHostRange(suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rest {
+			names = append(names, prefix+item+r)
+		}
+	}
+	return names, nil
+}
@@ -1,9 +1,13 @@
 package inventory
 
 import (
-	"bufio"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+
+	"xconfig/internal/vault"
 )
 
 type Host struct {
@@ -13,52 +17,365 @@ type Host struct {
 	KeyFile  string
 	Port     string
 	Password string // ✅ 新增：支持密码登录
+
+	HostKeyChecking string // strict / accept-new / off，空值使用全局配置
+	KnownHostsFile  string // 空值使用全局配置或 ~/.ssh/known_hosts
+
+	CertFile       string // OpenSSH 用户证书，默认 <KeyFile>-cert.pub
+	PassphraseFile string // 加密私钥的口令文件
+	ForwardAgent   bool   // 将本地 ssh-agent 转发到远端
+
+	JumpHosts []string        // 跳板机链（[user@]host[:port]），按连接顺序排列
+	Bastions  map[string]Host // 在 inventory 中定义的跳板机，键为 JumpHosts 中的条目
+
+	Become         bool   // 以 BecomeUser 身份执行命令
+	BecomeUser     string // 默认 root
+	BecomeMethod   string // sudo（默认）/ su / doas
+	BecomePassword string // 提权口令，为空时以非交互方式提权
+
+	Groups []string               // 主机所属的全部分组（不含 all / ungrouped）
+	Vars   map[string]interface{} // 合并后的 inventory 变量（group_vars < host_vars）
+}
+
+// Group is an inventory group. Hosts and Children keep their definition order.
+type Group struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]interface{}
 }
 
+// Inventory is the parsed host/group model shared by every inventory source.
+type Inventory struct {
+	groups    map[string]*Group
+	hostVars  map[string]map[string]interface{}
+	hostOrder []string
+}
 
-func Parse(path, group string) ([]Host, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// New returns an empty inventory containing the implicit all and ungrouped
+// groups.
+func New() *Inventory {
+	inv := &Inventory{
+		groups:   make(map[string]*Group),
+		hostVars: make(map[string]map[string]interface{}),
+	}
+	inv.Group("all")
+	inv.Group("ungrouped")
+	return inv
+}
+
+// Group returns the named group, creating it when needed.
+func (inv *Inventory) Group(name string) *Group {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &Group{Name: name, Vars: make(map[string]interface{})}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// AddHost registers host in group (which may be empty) and merges vars into
+// the host's own variables.
+func (inv *Inventory) AddHost(name, group string, vars map[string]interface{}) {
+	hv, ok := inv.hostVars[name]
+	if !ok {
+		hv = make(map[string]interface{})
+		inv.hostVars[name] = hv
+		inv.hostOrder = append(inv.hostOrder, name)
+	}
+	for k, v := range vars {
+		hv[k] = v
+	}
+	if group != "" && group != "all" {
+		g := inv.Group(group)
+		if !contains(g.Hosts, name) {
+			g.Hosts = append(g.Hosts, name)
+		}
 	}
-	defer file.Close()
+}
 
-	var hosts []Host
-	scanner := bufio.NewScanner(file)
-	currentGroup := ""
-	defaultUser := "ubuntu"
-	defaultPort := "22"
-	keyFile := os.Getenv("HOME") + "/.ssh/id_rsa"
+// AddChild makes child a sub group of parent.
+func (inv *Inventory) AddChild(parent, child string) {
+	p := inv.Group(parent)
+	inv.Group(child)
+	if parent != child && !contains(p.Children, child) {
+		p.Children = append(p.Children, child)
+	}
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+// HasHost reports whether name is a known host.
+func (inv *Inventory) HasHost(name string) bool {
+	_, ok := inv.hostVars[name]
+	return ok
+}
+
+// HostNames returns every host in definition order.
+func (inv *Inventory) HostNames() []string {
+	return append([]string(nil), inv.hostOrder...)
+}
+
+// GroupNames returns every group name, sorted.
+func (inv *Inventory) GroupNames() []string {
+	names := make([]string, 0, len(inv.groups))
+	for n := range inv.groups {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GroupHosts returns the hosts of a group including those of its children.
+func (inv *Inventory) GroupHosts(name string) []string {
+	if name == "all" {
+		return inv.HostNames()
+	}
+	var hosts []string
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var walk func(string)
+	walk = func(n string) {
+		g, ok := inv.groups[n]
+		if !ok || visited[n] {
+			return
+		}
+		visited[n] = true
+		for _, h := range g.Hosts {
+			if !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+		for _, c := range g.Children {
+			walk(c)
+		}
+	}
+	walk(name)
+	return hosts
+}
+
+// Groups maps every group to its hosts, mirroring Ansible's groups variable.
+func (inv *Inventory) Groups() map[string][]string {
+	out := make(map[string][]string, len(inv.groups))
+	for n := range inv.groups {
+		out[n] = inv.GroupHosts(n)
+	}
+	return out
+}
+
+// finalize attaches top level groups to all and puts hosts without a group
+// into ungrouped, as Ansible does.
+func (inv *Inventory) finalize() {
+	isChild := make(map[string]bool)
+	for _, g := range inv.groups {
+		for _, c := range g.Children {
+			isChild[c] = true
+		}
+	}
+	for _, n := range inv.GroupNames() {
+		if n != "all" && !isChild[n] {
+			inv.AddChild("all", n)
 		}
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			currentGroup = strings.Trim(line, "[]")
+	}
+	grouped := make(map[string]bool)
+	for n, g := range inv.groups {
+		if n == "all" || n == "ungrouped" {
 			continue
 		}
-		if currentGroup == group {
-			parts := strings.Fields(line)
-			h := Host{Name: parts[0], Address: parts[0], User: defaultUser, Port: defaultPort, KeyFile: keyFile}
-			for _, p := range parts[1:] {
-				if strings.HasPrefix(p, "ansible_host=") {
-					h.Address = strings.TrimPrefix(p, "ansible_host=")
-				}
-				if strings.HasPrefix(p, "ansible_ssh_user=") {
-					h.User = strings.TrimPrefix(p, "ansible_ssh_user=")
-				}
-				if strings.HasPrefix(p, "ansible_port=") {
-					h.Port = strings.TrimPrefix(p, "ansible_port=")
-				}
-				if strings.HasPrefix(p, "ansible_ssh_private_key_file=") {
-					h.KeyFile = strings.TrimPrefix(p, "ansible_ssh_private_key_file=")
+		for _, h := range g.Hosts {
+			grouped[h] = true
+		}
+	}
+	ungrouped := inv.groups["ungrouped"]
+	ungrouped.Hosts = nil
+	for _, h := range inv.hostOrder {
+		if !grouped[h] {
+			ungrouped.Hosts = append(ungrouped.Hosts, h)
+		}
+	}
+}
+
+// depths returns the distance of every group from all. Deeper groups take
+// precedence when their variables are merged.
+func (inv *Inventory) depths() map[string]int {
+	depth := map[string]int{"all": 0}
+	queue := []string{"all"}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, c := range inv.groups[n].Children {
+			if d, ok := depth[c]; !ok || d < depth[n]+1 {
+				if depth[n]+1 > len(inv.groups) {
+					continue // cycle guard
 				}
+				depth[c] = depth[n] + 1
+				queue = append(queue, c)
+			}
+		}
+	}
+	return depth
+}
+
+// hostGroups returns every group containing host, ordered by variable
+// precedence (all first, deepest last, ties broken by name).
+func (inv *Inventory) hostGroups(host string) []string {
+	depth := inv.depths()
+	var groups []string
+	for n := range inv.groups {
+		if n == "all" || contains(inv.GroupHosts(n), host) {
+			groups = append(groups, n)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		di, dj := depth[groups[i]], depth[groups[j]]
+		if di != dj {
+			return di < dj
+		}
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+// HostVars merges group variables (by precedence) and host variables.
+func (inv *Inventory) HostVars(host string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, g := range inv.hostGroups(host) {
+		for k, v := range inv.groups[g].Vars {
+			vars[k] = v
+		}
+	}
+	for k, v := range inv.hostVars[host] {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Host builds the connection settings for host from its merged variables.
+func (inv *Inventory) Host(name string) Host {
+	vars := inv.HostVars(name)
+	var groups []string
+	for _, g := range inv.hostGroups(name) {
+		if g != "all" && g != "ungrouped" {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	h := hostFromVars(name, vars)
+	h.Groups = groups
+	for _, spec := range h.JumpHosts {
+		if _, host, _ := ParseJumpSpec(spec); host != name && inv.HasHost(host) {
+			if h.Bastions == nil {
+				h.Bastions = make(map[string]Host)
+			}
+			h.Bastions[spec] = inv.bastion(host)
+		}
+	}
+	return h
+}
+
+// bastion builds the connection settings of an inventory host used as a
+// jump host. User and KeyFile stay empty unless its variables set them, so
+// the hosts behind it can fill them in.
+func (inv *Inventory) bastion(name string) Host {
+	vars := inv.HostVars(name)
+	b := hostFromVars(name, vars)
+	if stringVar(vars, "ansible_user", "ansible_ssh_user") == "" {
+		b.User = ""
+	}
+	if stringVar(vars, "ansible_ssh_private_key_file", "ansible_private_key_file") == "" {
+		b.KeyFile = ""
+	}
+	b.JumpHosts = nil
+	return b
+}
+
+// Load reads an inventory source, or a directory of them, along with the
+// group_vars/ and host_vars/ directories next to it. A source is an INI or
+// YAML file, a JSON xcloud deployment result / Pulumi stack export, an
+// executable inventory script, or a YAML "plugin:" file naming a Provider.
+func Load(path string) (*Inventory, error) {
+	inv := New()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Dir(path)
+	if info.IsDir() {
+		base = path
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+				continue
+			}
+			if err := inv.parseFile(filepath.Join(path, name)); err != nil {
+				return nil, err
 			}
-			hosts = append(hosts, h)
+		}
+	} else if err := inv.parseFile(path); err != nil {
+		return nil, err
+	}
+	inv.finalize()
+	if err := inv.loadVarsDirs(base); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) parseFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isScript(path, info, data) {
+		return inv.loadScript(path)
+	}
+	if vault.IsEncrypted(data) {
+		if data, err = vault.DecryptWithPassword(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
 		}
 	}
-	return hosts, nil
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var handled bool
+		if handled, err = inv.parsePulumi(data); !handled {
+			err = inv.parseYAML(data)
+		}
+	case ".yml", ".yaml":
+		var handled bool
+		if handled, err = inv.parsePluginConfig(path, data); !handled {
+			err = inv.parseYAML(data)
+		}
+	default:
+		err = inv.parseINI(data)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Parse loads the inventory at path and returns the hosts matching pattern.
+func Parse(path, pattern string) ([]Host, error) {
+	inv, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return inv.Select(pattern)
 }
 
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xconfig/internal/vault"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+const iniInventory = `bastion ansible_host=203.0.113.10
+
+[web]
+web[01:03] ansible_user=deploy
+
+[db]
+db1 ansible_host=10.0.2.10 ansible_port=2222 role='primary'
+
+[prod:children]
+web
+db
+
+[prod:vars]
+env=prod
+http_port=80
+
+[all:vars]
+env=dev
+ansible_ssh_private_key_file=~/.ssh/deploy
+controller_ips=["10.10.10.10", "10.10.10.11"]
+`
+
+func TestLoadINIInventory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	writeFile(t, path, iniInventory)
+	writeFile(t, filepath.Join(dir, "group_vars", "web.yml"), "http_port: 8080\n")
+	writeFile(t, filepath.Join(dir, "host_vars", "web02", "main.yml"), "http_port: 9090\n")
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	wantHosts := []string{"bastion", "web01", "web02", "web03", "db1"}
+	if got := inv.HostNames(); !reflect.DeepEqual(got, wantHosts) {
+		t.Fatalf("unexpected hosts: %v", got)
+	}
+	if got := inv.GroupHosts("ungrouped"); !reflect.DeepEqual(got, []string{"bastion"}) {
+		t.Fatalf("unexpected ungrouped hosts: %v", got)
+	}
+	if got := inv.GroupHosts("prod"); !reflect.DeepEqual(got, []string{"web01", "web02", "web03", "db1"}) {
+		t.Fatalf("unexpected prod hosts: %v", got)
+	}
+
+	db := inv.Host("db1")
+	if db.Address != "10.0.2.10" || db.Port != "2222" || db.User != defaultUser {
+		t.Fatalf("unexpected db1 connection settings: %+v", db)
+	}
+	if db.KeyFile != filepath.Join(os.Getenv("HOME"), ".ssh", "deploy") {
+		t.Fatalf("expected ~ to be expanded in key file, got %s", db.KeyFile)
+	}
+	if db.Vars["env"] != "prod" || db.Vars["role"] != "primary" || db.Vars["http_port"] != 80 {
+		t.Fatalf("unexpected db1 vars: %v", db.Vars)
+	}
+	if !reflect.DeepEqual(db.Groups, []string{"db", "prod"}) {
+		t.Fatalf("unexpected db1 groups: %v", db.Groups)
+	}
+	ips, ok := db.Vars["controller_ips"].([]interface{})
+	if !ok || len(ips) != 2 {
+		t.Fatalf("expected list literal, got %#v", db.Vars["controller_ips"])
+	}
+
+	if got := inv.Host("web01"); got.User != "deploy" || got.Vars["http_port"] != 8080 {
+		t.Fatalf("expected group_vars/web.yml to override prod vars: %+v", got.Vars)
+	}
+	if got := inv.Host("web02").Vars["http_port"]; got != 9090 {
+		t.Fatalf("expected host_vars to win, got %v", got)
+	}
+	if got := inv.Host("bastion").Vars["env"]; got != "dev" {
+		t.Fatalf("expected all vars for ungrouped host, got %v", got)
+	}
+}
+
+func TestLoadYAMLInventory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts.yaml")
+	writeFile(t, path, `all:
+  vars:
+    ntp_server: ntp.example.com
+  hosts:
+    jump:
+      ansible_host: 203.0.113.10
+  children:
+    web:
+      hosts:
+        web[1:2]:
+          ansible_user: deploy
+      vars:
+        http_port: 80
+    prod:
+      children:
+        web:
+`)
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := inv.HostNames(); !reflect.DeepEqual(got, []string{"jump", "web1", "web2"}) {
+		t.Fatalf("unexpected hosts: %v", got)
+	}
+	web := inv.Host("web2")
+	if web.User != "deploy" || web.Vars["http_port"] != 80 || web.Vars["ntp_server"] != "ntp.example.com" {
+		t.Fatalf("unexpected web2: %+v", web)
+	}
+	if got := inv.GroupHosts("prod"); !reflect.DeepEqual(got, []string{"web1", "web2"}) {
+		t.Fatalf("unexpected prod hosts: %v", got)
+	}
+}
+
+func TestExpandHostRange(t *testing.T) {
+	cases := map[string][]string{
+		"web[01:03].example.com": {"web01.example.com", "web02.example.com", "web03.example.com"},
+		"db-[a:c]":               {"db-a", "db-b", "db-c"},
+		"node[0:10:5]":           {"node0", "node5", "node10"},
+		"plain":                  {"plain"},
+	}
+	for pattern, want := range cases {
+		got, err := ExpandHostRange(pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", pattern, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v", pattern, got)
+		}
+	}
+	if _, err := ExpandHostRange("web[3:1]"); err == nil {
+		t.Fatalf("expected error for reversed range")
+	}
+}
+
+func TestLoadInventoryWithVault(t *testing.T) {
+	vault.SetPassword([]byte("s3cret"))
+	t.Cleanup(func() {
+		vault.SetPassword(nil)
+		vault.ResetSecrets()
+	})
+	groupVars, err := vault.Encrypt([]byte("db_pass: hunter22\n"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	become, err := vault.Encrypt([]byte("sudo-pw-1"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts.yaml")
+	writeFile(t, path, `all:
+  children:
+    db:
+      hosts:
+        db1:
+          `+strings.ReplaceAll(vault.FormatInline("ansible_become_password", become), "\n", "\n          ")+`
+`)
+	writeFile(t, filepath.Join(dir, "group_vars", "db.yml"), string(groupVars))
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	db := inv.Host("db1")
+	if db.Vars["db_pass"] != "hunter22" || db.Vars["ansible_become_password"] != "sudo-pw-1" {
+		t.Fatalf("unexpected db1 vars: %v", db.Vars)
+	}
+	if got := vault.MaskSecrets("hunter22/sudo-pw-1"); got != vault.Mask+"/"+vault.Mask {
+		t.Fatalf("decrypted values not registered as secrets: %q", got)
+	}
+}
@@ -0,0 +1,231 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Select returns the hosts matching an Ansible host pattern.
+func (inv *Inventory) Select(pattern string) ([]Host, error) {
+	names, err := inv.Match(pattern)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]Host, 0, len(names))
+	for _, n := range names {
+		hosts = append(hosts, inv.Host(n))
+	}
+	return hosts, nil
+}
+
+// Match resolves a host pattern into host names in inventory order. Patterns
+// are terms separated by ',' or ':'; each term is a group or host name, a
+// wildcard (web*), a regex (~web\d+) or a subscript (web[0:5]). Terms prefixed
+// with & intersect and terms prefixed with ! exclude. Unions are applied
+// first, then intersections, then exclusions.
+func (inv *Inventory) Match(pattern string) ([]string, error) {
+	selected := make(map[string]bool)
+	var intersections, exclusions [][]string
+	hasUnion := false
+
+	for _, term := range splitPattern(pattern) {
+		switch {
+		case strings.HasPrefix(term, "&"):
+			hosts, err := inv.matchTerm(term[1:])
+			if err != nil {
+				return nil, err
+			}
+			intersections = append(intersections, hosts)
+		case strings.HasPrefix(term, "!"):
+			hosts, err := inv.matchTerm(term[1:])
+			if err != nil {
+				return nil, err
+			}
+			exclusions = append(exclusions, hosts)
+		default:
+			hosts, err := inv.matchTerm(term)
+			if err != nil {
+				return nil, err
+			}
+			hasUnion = true
+			for _, h := range hosts {
+				selected[h] = true
+			}
+		}
+	}
+	// A pattern made only of exclusions ("!db") starts from all hosts.
+	if !hasUnion {
+		for _, h := range inv.hostOrder {
+			selected[h] = true
+		}
+	}
+	for _, set := range intersections {
+		keep := make(map[string]bool, len(set))
+		for _, h := range set {
+			keep[h] = selected[h]
+		}
+		selected = keep
+	}
+	for _, set := range exclusions {
+		for _, h := range set {
+			delete(selected, h)
+		}
+	}
+
+	var names []string
+	for _, h := range inv.hostOrder {
+		if selected[h] {
+			names = append(names, h)
+		}
+	}
+	return names, nil
+}
+
+// splitPattern splits on ',' when present, otherwise on ':' outside of
+// brackets so subscripts such as web[0:5] stay intact.
+func splitPattern(pattern string) []string {
+	sep := ':'
+	if strings.Contains(pattern, ",") {
+		sep = ','
+	}
+	var terms []string
+	depth, start := 0, 0
+	for i, r := range pattern {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		case r == sep && depth == 0:
+			terms = append(terms, pattern[start:i])
+			start = i + 1
+		}
+	}
+	terms = append(terms, pattern[start:])
+
+	var out []string
+	for _, t := range terms {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func (inv *Inventory) matchTerm(term string) ([]string, error) {
+	if i := strings.Index(term, "["); i > 0 && strings.HasSuffix(term, "]") && !strings.HasPrefix(term, "~") {
+		hosts, err := inv.matchTerm(term[:i])
+		if err != nil {
+			return nil, err
+		}
+		return subscript(hosts, term[i+1:len(term)-1])
+	}
+
+	switch {
+	case term == "all" || term == "*":
+		return inv.HostNames(), nil
+	case strings.HasPrefix(term, "~"):
+		re, err := regexp.Compile(term[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %v", term, err)
+		}
+		return inv.matchFunc(re.MatchString), nil
+	case strings.ContainsAny(term, "*?"):
+		if _, err := filepath.Match(term, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %v", term, err)
+		}
+		return inv.matchFunc(func(s string) bool {
+			ok, _ := filepath.Match(term, s)
+			return ok
+		}), nil
+	}
+
+	if _, ok := inv.groups[term]; ok {
+		return inv.GroupHosts(term), nil
+	}
+	if inv.HasHost(term) {
+		return []string{term}, nil
+	}
+	return nil, nil
+}
+
+// matchFunc returns hosts in groups whose name matches, plus matching hosts.
+func (inv *Inventory) matchFunc(match func(string) bool) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(h string) {
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	for _, g := range inv.GroupNames() {
+		if match(g) {
+			for _, h := range inv.GroupHosts(g) {
+				add(h)
+			}
+		}
+	}
+	for _, h := range inv.hostOrder {
+		if match(h) {
+			add(h)
+		}
+	}
+	return hosts
+}
+
+// subscript applies web[0], web[-1], web[1:] or web[0:5]; like Ansible the
+// end of a range is inclusive.
+func subscript(hosts []string, spec string) ([]string, error) {
+	index := func(s string, def int) (int, error) {
+		if s == "" {
+			return def, nil
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid host subscript [%s]", spec)
+		}
+		if i < 0 {
+			i += len(hosts)
+		}
+		return i, nil
+	}
+	clamp := func(i int) int {
+		if i < 0 {
+			return 0
+		}
+		if i > len(hosts) {
+			return len(hosts)
+		}
+		return i
+	}
+
+	lo, hi, isRange := strings.Cut(spec, ":")
+	if !isRange {
+		i, err := index(lo, 0)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(hosts) {
+			return nil, nil
+		}
+		return []string{hosts[i]}, nil
+	}
+	start, err := index(lo, 0)
+	if err != nil {
+		return nil, err
+	}
+	end, err := index(hi, len(hosts)-1)
+	if err != nil {
+		return nil, err
+	}
+	end++
+	start, end = clamp(start), clamp(end)
+	if start >= end {
+		return nil, nil
+	}
+	return hosts[start:end], nil
+}
@@ -0,0 +1,47 @@
+package inventory
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	writeFile(t, path, iniInventory)
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	cases := map[string][]string{
+		"all":          {"bastion", "web01", "web02", "web03", "db1"},
+		"web:db":       {"web01", "web02", "web03", "db1"},
+		"web,db1":      {"web01", "web02", "web03", "db1"},
+		"prod:&web":    {"web01", "web02", "web03"},
+		"prod:!db":     {"web01", "web02", "web03"},
+		"!prod":        {"bastion"},
+		"web[0:2]":     {"web01", "web02", "web03"},
+		"web[0:1]":     {"web01", "web02"},
+		"web[1:-1]":    {"web02", "web03"},
+		"web[-1]":      {"web03"},
+		"web[1:]":      {"web02", "web03"},
+		"web0*":        {"web01", "web02", "web03"},
+		"~(db|bas).*":  {"bastion", "db1"},
+		"db1":          {"db1"},
+		"missing":      nil,
+		"web:&missing": nil,
+	}
+	for pattern, want := range cases {
+		got, err := inv.Match(pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", pattern, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", pattern, got, want)
+		}
+	}
+	if _, err := inv.Match("~("); err == nil {
+		t.Fatalf("expected invalid regex error")
+	}
+}
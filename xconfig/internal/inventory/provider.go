@@ -0,0 +1,119 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"xconfig/internal/vault"
+)
+
+// Provider is a built-in dynamic inventory source. It is selected by a YAML
+// inventory file whose top level "plugin" key names it; the whole file is
+// passed as config, with "inventory_dir" set to the file's directory.
+type Provider interface {
+	Inventory(config map[string]interface{}) (*DynamicInventory, error)
+}
+
+// ProviderFunc adapts an ordinary function to the Provider interface.
+type ProviderFunc func(config map[string]interface{}) (*DynamicInventory, error)
+
+// Inventory calls f(config).
+func (f ProviderFunc) Inventory(config map[string]interface{}) (*DynamicInventory, error) {
+	return f(config)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// RegisterProvider makes a provider available under name.
+func RegisterProvider(name string, p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = p
+}
+
+// GetProvider retrieves a provider by name.
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// ProviderNames lists the registered providers.
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsePluginConfig runs the provider named by a "plugin:" YAML file. It
+// reports false when data is an ordinary YAML inventory.
+func (inv *Inventory) parsePluginConfig(path string, data []byte) (bool, error) {
+	var config map[string]interface{}
+	if vault.Unmarshal(data, &config) != nil {
+		return false, nil
+	}
+	name, ok := config["plugin"].(string)
+	if !ok {
+		return false, nil
+	}
+	p, ok := GetProvider(name)
+	if !ok {
+		return true, fmt.Errorf("unknown inventory plugin %q (available: %v)", name, ProviderNames())
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return true, err
+	}
+	config["inventory_dir"] = filepath.Dir(abs)
+
+	d, err := cachedDynamic("plugin:"+abs, func() (*DynamicInventory, error) {
+		return p.Inventory(config)
+	})
+	if err != nil {
+		return true, fmt.Errorf("inventory plugin %s: %w", name, err)
+	}
+	inv.AddDynamic(d)
+	return true, nil
+}
+
+// pulumiProvider reads an xcloud deployment result or Pulumi stack export:
+//
+//	plugin: pulumi
+//	path: deployment.json
+func pulumiProvider(config map[string]interface{}) (*DynamicInventory, error) {
+	path, _ := config["path"].(string)
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	if dir, ok := config["inventory_dir"].(string); ok && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	instances, ok, err := pulumiInstances(data)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is neither a deployment result nor a stack export", path)
+	}
+	return cloudInventory(instances), nil
+}
+
+func init() {
+	RegisterProvider("pulumi", ProviderFunc(pulumiProvider))
+}
@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseProxyJump extracts the jump host chain from OpenSSH style arguments as
+// found in ansible_ssh_common_args / ansible_ssh_extra_args. It understands
+// "-J a,b", "-o ProxyJump=a,b" and the common
+// "-o ProxyCommand='ssh -W %h:%p [-p port] [user@]bastion'" form.
+func ParseProxyJump(args string) []string {
+	fields := SplitFields(args)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		var opt string
+		switch {
+		case f == "-J" && i+1 < len(fields):
+			return splitJumpList(fields[i+1])
+		case strings.HasPrefix(f, "-J"):
+			return splitJumpList(f[2:])
+		case f == "-o" && i+1 < len(fields):
+			i++
+			opt = fields[i]
+		case strings.HasPrefix(f, "-o"):
+			opt = f[2:]
+		default:
+			continue
+		}
+
+		key, value := splitOption(opt)
+		switch strings.ToLower(key) {
+		case "proxyjump":
+			return splitJumpList(value)
+		case "proxycommand":
+			if jump := proxyCommandJump(value); jump != "" {
+				return []string{jump}
+			}
+		}
+	}
+	return nil
+}
+
+// ParseJumpSpec splits a ProxyJump entry of the form [ssh://][user@]host[:port]
+// into its parts. Missing parts are returned empty.
+func ParseJumpSpec(spec string) (user, host, port string) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		user, spec = spec[:i], spec[i+1:]
+	}
+	if strings.HasPrefix(spec, "[") || strings.Count(spec, ":") == 1 {
+		if h, p, err := net.SplitHostPort(spec); err == nil {
+			return user, h, p
+		}
+	}
+	return user, strings.Trim(spec, "[]"), ""
+}
+
+func splitOption(opt string) (string, string) {
+	if i := strings.IndexAny(opt, "= "); i >= 0 {
+		return opt[:i], strings.TrimSpace(opt[i+1:])
+	}
+	return opt, ""
+}
+
+func splitJumpList(value string) []string {
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+	var jumps []string
+	for _, j := range strings.Split(value, ",") {
+		if j = strings.TrimSpace(j); j != "" {
+			jumps = append(jumps, j)
+		}
+	}
+	return jumps
+}
+
+// proxyCommandJump turns "ssh -W %h:%p -p 2222 user@bastion" into
+// "user@bastion:2222". Other proxy commands are not supported.
+func proxyCommandJump(cmd string) string {
+	fields := SplitFields(cmd)
+	if len(fields) == 0 || !strings.HasSuffix(fields[0], "ssh") {
+		return ""
+	}
+	var port, user, target string
+	forwarding := false
+	for i := 1; i < len(fields); i++ {
+		f := fields[i]
+		switch f {
+		case "-W":
+			forwarding = true
+			i++
+		case "-p":
+			if i+1 < len(fields) {
+				port = fields[i+1]
+			}
+			i++
+		case "-l":
+			if i+1 < len(fields) {
+				user = fields[i+1]
+			}
+			i++
+		case "-i", "-o", "-F":
+			i++
+		default:
+			if !strings.HasPrefix(f, "-") {
+				target = f
+			}
+		}
+	}
+	if !forwarding || target == "" {
+		return ""
+	}
+	if user != "" && !strings.Contains(target, "@") {
+		target = user + "@" + target
+	}
+	if port != "" {
+		target += ":" + port
+	}
+	return target
+}
+
+// SplitFields splits s on whitespace while keeping single or double quoted
+// sections together, so values like ansible_ssh_common_args='-J bastion'
+// survive as one field. Quotes are removed from the result.
+func SplitFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
@@ -0,0 +1,72 @@
+package inventory
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	cases := map[string][]string{
+		"-J ops@bastion":                                        {"ops@bastion"},
+		"-o ProxyJump=a,b:2222":                                 {"a", "b:2222"},
+		"-o StrictHostKeyChecking=no -oProxyJump=a":             {"a"},
+		`-o ProxyCommand="ssh -W %h:%p -p 2200 -q ops@bastion"`: {"ops@bastion:2200"},
+		"-o ProxyJump=none":                                     nil,
+		"-C":                                                    nil,
+	}
+	for args, want := range cases {
+		if got := ParseProxyJump(args); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %#v, want %#v", args, got, want)
+		}
+	}
+}
+
+func TestSplitFieldsKeepsQuotedValues(t *testing.T) {
+	got := SplitFields(`web1 ansible_host=10.0.1.5 ansible_ssh_common_args='-o ProxyJump=ops@bastion'`)
+	want := []string{"web1", "ansible_host=10.0.1.5", "ansible_ssh_common_args=-o ProxyJump=ops@bastion"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v", got)
+	}
+}
+
+func TestParseJumpSpec(t *testing.T) {
+	cases := []struct{ spec, user, host, port string }{
+		{"bastion", "", "bastion", ""},
+		{"ops@bastion:2222", "ops", "bastion", "2222"},
+		{"ssh://ops@10.0.0.1", "ops", "10.0.0.1", ""},
+		{"[fd00::1]:22", "", "fd00::1", "22"},
+	}
+	for _, c := range cases {
+		user, host, port := ParseJumpSpec(c.spec)
+		if user != c.user || host != c.host || port != c.port {
+			t.Fatalf("%s: got %q %q %q", c.spec, user, host, port)
+		}
+	}
+}
+
+func TestJumpHostsResolveInventoryBastions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	writeFile(t, path, `edge ansible_host=203.0.113.10 ansible_user=ops ansible_port=2222 ansible_password=edge-secret
+gate ansible_host=203.0.113.11
+
+[private]
+app1 ansible_host=10.0.1.12 ansible_user=deploy ansible_ssh_jump_hosts=edge,gate,ubuntu@10.0.0.5
+`)
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := inv.Host("app1")
+	if len(h.Bastions) != 2 {
+		t.Fatalf("expected edge and gate to resolve, got %+v", h.Bastions)
+	}
+	edge := h.Bastions["edge"]
+	if edge.Address != "203.0.113.10" || edge.User != "ops" || edge.Port != "2222" || edge.Password != "edge-secret" || edge.KeyFile != "" {
+		t.Fatalf("unexpected edge settings: %+v", edge)
+	}
+	if gate := h.Bastions["gate"]; gate.User != "" || gate.KeyFile != "" || gate.Port != defaultPort {
+		t.Fatalf("expected gate to leave user and key to the target, got %+v", gate)
+	}
+}
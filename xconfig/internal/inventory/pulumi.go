@@ -0,0 +1,234 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// deploymentResult mirrors the JSON printed by `xcloud up`
+// (xcloud-cli/internal/pulumi.DeploymentResult).
+type deploymentResult struct {
+	ActiveEnv string `json:"activeEnv"`
+	Targets   []struct {
+		Stack   string            `json:"stack"`
+		Cloud   string            `json:"cloud"`
+		Region  string            `json:"region"`
+		Status  string            `json:"status"`
+		Outputs map[string]string `json:"outputs"`
+	} `json:"targets"`
+}
+
+// stackExport mirrors the parts of `pulumi stack export` that describe
+// compute instances and the stack outputs.
+type stackExport struct {
+	Deployment struct {
+		Resources []struct {
+			URN     string                 `json:"urn"`
+			Type    string                 `json:"type"`
+			Outputs map[string]interface{} `json:"outputs"`
+		} `json:"resources"`
+	} `json:"deployment"`
+}
+
+// cloudInstance is one machine discovered in Pulumi state.
+type cloudInstance struct {
+	Name, ID, PublicIP, PrivateIP    string
+	Cloud, Region, Env, Owner, Stack string
+}
+
+// instanceTypes maps Pulumi resource types to their cloud.
+var instanceTypes = map[string]string{
+	"aws:ec2/instance:Instance": "aws",
+}
+
+// parsePulumi recognises a DeploymentResult or a Pulumi stack export and adds
+// its instances. It reports false when data is neither.
+func (inv *Inventory) parsePulumi(data []byte) (bool, error) {
+	instances, ok, err := pulumiInstances(data)
+	if !ok || err != nil {
+		return ok, err
+	}
+	inv.AddDynamic(cloudInventory(instances))
+	return true, nil
+}
+
+func pulumiInstances(data []byte) ([]cloudInstance, bool, error) {
+	var probe map[string]json.RawMessage
+	if json.Unmarshal(data, &probe) != nil {
+		return nil, false, nil
+	}
+	switch {
+	case probe["targets"] != nil:
+		var res deploymentResult
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, true, fmt.Errorf("deployment result: %w", err)
+		}
+		return instancesFromDeployment(res), true, nil
+	case probe["deployment"] != nil:
+		var export stackExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, true, fmt.Errorf("stack export: %w", err)
+		}
+		return instancesFromStackExport(export), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// instancesFromDeployment reads the <name>_public_ip / <name>_private_ip
+// (and optional <name>_id, _env, _owner) outputs exported by ec2module.
+func instancesFromDeployment(res deploymentResult) []cloudInstance {
+	var instances []cloudInstance
+	for _, t := range res.Targets {
+		if t.Status != "" && t.Status != "applied" {
+			continue
+		}
+		names := make(map[string]bool)
+		for k := range t.Outputs {
+			for _, suffix := range []string{"_public_ip", "_private_ip"} {
+				if strings.HasSuffix(k, suffix) {
+					names[strings.TrimSuffix(k, suffix)] = true
+				}
+			}
+		}
+		sorted := make([]string, 0, len(names))
+		for n := range names {
+			sorted = append(sorted, n)
+		}
+		sort.Strings(sorted)
+		for _, n := range sorted {
+			env := t.Outputs[n+"_env"]
+			if env == "" {
+				env = res.ActiveEnv
+			}
+			instances = append(instances, cloudInstance{
+				Name:      n,
+				ID:        t.Outputs[n+"_id"],
+				PublicIP:  t.Outputs[n+"_public_ip"],
+				PrivateIP: t.Outputs[n+"_private_ip"],
+				Cloud:     t.Cloud,
+				Region:    t.Region,
+				Env:       env,
+				Owner:     t.Outputs[n+"_owner"],
+				Stack:     t.Stack,
+			})
+		}
+	}
+	return instances
+}
+
+func instancesFromStackExport(export stackExport) []cloudInstance {
+	var stackCloud, stackRegion, stackName string
+	for _, r := range export.Deployment.Resources {
+		if r.Type == "pulumi:pulumi:Stack" {
+			stackCloud = outputString(r.Outputs, "cloud")
+			stackRegion = outputString(r.Outputs, "region")
+			stackName = urnName(r.URN)
+		}
+	}
+
+	var instances []cloudInstance
+	for _, r := range export.Deployment.Resources {
+		cloud, ok := instanceTypes[r.Type]
+		if !ok {
+			continue
+		}
+		tags, _ := r.Outputs["tags"].(map[string]interface{})
+		name := outputString(tags, "Name")
+		if name == "" {
+			name = urnName(r.URN)
+		}
+		region := stackRegion
+		if az := outputString(r.Outputs, "availabilityZone"); region == "" && len(az) > 1 {
+			region = az[:len(az)-1]
+		}
+		if stackCloud != "" {
+			cloud = stackCloud
+		}
+		instances = append(instances, cloudInstance{
+			Name:      name,
+			ID:        outputString(r.Outputs, "id"),
+			PublicIP:  outputString(r.Outputs, "publicIp"),
+			PrivateIP: outputString(r.Outputs, "privateIp"),
+			Cloud:     cloud,
+			Region:    region,
+			Env:       outputString(tags, "Environment"),
+			Owner:     outputString(tags, "Owner"),
+			Stack:     stackName,
+		})
+	}
+	return instances
+}
+
+// cloudInventory groups instances by cloud, region_<region>, env_<env> and
+// owner_<owner>. Hosts without a public IP are addressed by their private IP,
+// e.g. through a jump host set in group_vars.
+func cloudInventory(instances []cloudInstance) *DynamicInventory {
+	d := &DynamicInventory{
+		Groups:   make(map[string]DynamicGroup),
+		HostVars: make(map[string]map[string]interface{}),
+		HasMeta:  true,
+	}
+	for _, in := range instances {
+		name := in.Name
+		if _, dup := d.HostVars[name]; dup && in.Region != "" {
+			name = name + "." + in.Region
+		}
+		address := in.PublicIP
+		if address == "" {
+			address = in.PrivateIP
+		}
+		d.HostVars[name] = map[string]interface{}{
+			"ansible_host": address,
+			"public_ip":    in.PublicIP,
+			"private_ip":   in.PrivateIP,
+			"instance_id":  in.ID,
+			"cloud":        in.Cloud,
+			"region":       in.Region,
+			"env":          in.Env,
+			"owner":        in.Owner,
+			"stack":        in.Stack,
+		}
+		for _, g := range []string{
+			groupName("", in.Cloud),
+			groupName("region_", in.Region),
+			groupName("env_", in.Env),
+			groupName("owner_", in.Owner),
+		} {
+			if g != "" {
+				group := d.Groups[g]
+				group.Hosts = append(group.Hosts, name)
+				d.Groups[g] = group
+			}
+		}
+	}
+	return d
+}
+
+var invalidGroupChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// groupName builds a valid group name, or "" when value is empty.
+func groupName(prefix, value string) string {
+	if value == "" {
+		return ""
+	}
+	return prefix + invalidGroupChars.ReplaceAllString(strings.ToLower(value), "_")
+}
+
+func outputString(m map[string]interface{}, key string) string {
+	if v, ok := m[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// urnName returns the resource name, the last "::" segment of a Pulumi URN.
+func urnName(urn string) string {
+	if i := strings.LastIndex(urn, "::"); i >= 0 {
+		return urn[i+2:]
+	}
+	return urn
+}
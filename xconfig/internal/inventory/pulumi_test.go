@@ -0,0 +1,86 @@
+package inventory
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadDeploymentResult(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deployment.json")
+	writeFile(t, path, `{
+  "activeEnv": "sit",
+  "targets": [
+    {
+      "stack": "sit-aws-ap-northeast-1",
+      "cloud": "aws",
+      "region": "ap-northeast-1",
+      "status": "applied",
+      "outputs": {
+        "vpcId": "vpc-123",
+        "web1_id": "i-01",
+        "web1_public_ip": "54.1.2.3",
+        "web1_private_ip": "10.0.1.10",
+        "web1_owner": "Platform Team",
+        "db1_public_ip": "",
+        "db1_private_ip": "10.0.2.10",
+        "db1_env": "prod"
+      }
+    },
+    {"stack": "sit-gcp-asia", "cloud": "gcp", "region": "asia", "status": "skipped"}
+  ]
+}`)
+	writeFile(t, filepath.Join(dir, "group_vars", "region_ap_northeast_1.yml"), "ansible_user: ec2-user\n")
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := inv.HostNames(); !reflect.DeepEqual(got, []string{"db1", "web1"}) {
+		t.Fatalf("unexpected hosts: %v", got)
+	}
+
+	web := inv.Host("web1")
+	if web.Address != "54.1.2.3" || web.User != "ec2-user" || web.Vars["instance_id"] != "i-01" {
+		t.Fatalf("unexpected web1: %+v", web)
+	}
+	if !reflect.DeepEqual(web.Groups, []string{"aws", "env_sit", "owner_platform_team", "region_ap_northeast_1"}) {
+		t.Fatalf("unexpected web1 groups: %v", web.Groups)
+	}
+	if db := inv.Host("db1"); db.Address != "10.0.2.10" {
+		t.Fatalf("expected private address fallback, got %s", db.Address)
+	}
+	if got, _ := inv.Match("aws:&env_prod"); !reflect.DeepEqual(got, []string{"db1"}) {
+		t.Fatalf("unexpected env_prod hosts: %v", got)
+	}
+}
+
+func TestLoadStackExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stack.json")
+	writeFile(t, path, `{
+  "version": 3,
+  "deployment": {
+    "resources": [
+      {"urn": "urn:pulumi:prod::xcloud::pulumi:pulumi:Stack::xcloud-prod", "type": "pulumi:pulumi:Stack",
+       "outputs": {"cloud": "aws", "region": "us-east-1"}},
+      {"urn": "urn:pulumi:prod::xcloud::aws:ec2/instance:Instance::app-1", "type": "aws:ec2/instance:Instance",
+       "outputs": {"id": "i-9", "publicIp": "3.3.3.3", "privateIp": "10.1.0.5",
+                   "tags": {"Name": "app-1", "Environment": "prod", "Owner": "web"}}},
+      {"urn": "urn:pulumi:prod::xcloud::aws:ec2/vpc:Vpc::main", "type": "aws:ec2/vpc:Vpc", "outputs": {}}
+    ]
+  }
+}`)
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	app := inv.Host("app-1")
+	if app.Address != "3.3.3.3" || app.Vars["stack"] != "xcloud-prod" {
+		t.Fatalf("unexpected app-1: %+v", app)
+	}
+	if !reflect.DeepEqual(app.Groups, []string{"aws", "env_prod", "owner_web", "region_us_east_1"}) {
+		t.Fatalf("unexpected app-1 groups: %v", app.Groups)
+	}
+}
@@ -0,0 +1,117 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// CacheTTL controls how long dynamic inventory results (scripts and
+	// providers) are reused from CacheDir. Zero disables caching.
+	CacheTTL time.Duration
+	// CacheDir holds cached dynamic inventory documents. Empty means
+	// ~/.cache/xconfig/inventory.
+	CacheDir string
+	// ScriptTimeout bounds each invocation of an inventory script.
+	ScriptTimeout = 2 * time.Minute
+)
+
+// isScript reports whether path is an executable inventory script.
+func isScript(path string, info os.FileInfo, data []byte) bool {
+	return info.Mode().IsRegular() && info.Mode()&0o111 != 0 && bytes.HasPrefix(data, []byte("#!"))
+}
+
+// loadScript runs an Ansible compatible inventory script with --list, and
+// with --host <name> for each host when the output has no _meta section.
+func (inv *Inventory) loadScript(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	d, err := cachedDynamic("script:"+abs, func() (*DynamicInventory, error) {
+		out, err := runScript(abs, "--list")
+		if err != nil {
+			return nil, err
+		}
+		var d DynamicInventory
+		if err := json.Unmarshal(out, &d); err != nil {
+			return nil, fmt.Errorf("parse --list output: %w", err)
+		}
+		if !d.HasMeta {
+			for _, h := range d.HostNames() {
+				out, err := runScript(abs, "--host", h)
+				if err != nil {
+					return nil, err
+				}
+				var vars map[string]interface{}
+				if err := json.Unmarshal(out, &vars); err != nil {
+					return nil, fmt.Errorf("parse --host %s output: %w", h, err)
+				}
+				d.HostVars[h] = vars
+			}
+			d.HasMeta = true
+		}
+		return &d, nil
+	})
+	if err != nil {
+		return fmt.Errorf("inventory script %s: %w", path, err)
+	}
+	inv.AddDynamic(d)
+	return nil
+}
+
+func runScript(path string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ScriptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", filepath.Base(path), strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// cachedDynamic returns the cached document for key when it is younger than
+// CacheTTL, otherwise it calls load and refreshes the cache.
+func cachedDynamic(key string, load func() (*DynamicInventory, error)) (*DynamicInventory, error) {
+	if CacheTTL <= 0 {
+		return load()
+	}
+	dir := CacheDir
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache", "xconfig", "inventory")
+	}
+	sum := sha256.Sum256([]byte(key))
+	file := filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+
+	if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) < CacheTTL {
+		if data, err := os.ReadFile(file); err == nil {
+			var d DynamicInventory
+			if json.Unmarshal(data, &d) == nil {
+				return &d, nil
+			}
+		}
+	}
+
+	d, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(d); err == nil {
+		if os.MkdirAll(dir, 0o700) == nil {
+			os.WriteFile(file, data, 0o600)
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,108 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, path, body string) {
+	t.Helper()
+	writeFile(t, path, "#!/bin/sh\n"+body)
+	if err := os.Chmod(path, 0o755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+}
+
+func TestLoadInventoryScriptWithMeta(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "cmdb.sh")
+	writeScript(t, script, `echo x >> `+counter+`
+cat <<'JSON'
+{
+  "web": {"hosts": ["web1", "web2"], "vars": {"http_port": 80}, "children": ["canary"]},
+  "canary": ["web3"],
+  "_meta": {"hostvars": {"web1": {"ansible_host": "10.0.0.1"}}}
+}
+JSON
+`)
+
+	CacheTTL = time.Minute
+	CacheDir = filepath.Join(dir, "cache")
+	defer func() { CacheTTL, CacheDir = 0, "" }()
+
+	for i := 0; i < 2; i++ {
+		inv, err := Load(script)
+		if err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		if got := inv.GroupHosts("web"); !reflect.DeepEqual(got, []string{"web1", "web2", "web3"}) {
+			t.Fatalf("unexpected web hosts: %v", got)
+		}
+		web1 := inv.Host("web1")
+		if web1.Address != "10.0.0.1" || web1.Vars["http_port"] != float64(80) {
+			t.Fatalf("unexpected web1: %+v", web1)
+		}
+	}
+
+	calls, _ := os.ReadFile(counter)
+	if n := strings.Count(string(calls), "x"); n != 1 {
+		t.Fatalf("expected the cache to avoid a second run, script ran %d times", n)
+	}
+}
+
+func TestLoadInventoryScriptHostQueries(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "inv.sh")
+	writeScript(t, script, `if [ "$1" = "--list" ]; then
+  echo '{"db": ["db1"]}'
+else
+  echo "{\"ansible_host\": \"host-of-$2\"}"
+fi
+`)
+
+	inv, err := Load(script)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := inv.Host("db1").Address; got != "host-of-db1" {
+		t.Fatalf("expected --host vars, got %s", got)
+	}
+}
+
+func TestLoadInventoryScriptFailure(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "broken.sh")
+	writeScript(t, script, "echo 'cmdb unavailable' >&2\nexit 3\n")
+	if _, err := Load(script); err == nil || !strings.Contains(err.Error(), "cmdb unavailable") {
+		t.Fatalf("expected script stderr in error, got %v", err)
+	}
+}
+
+func TestLoadProviderPluginConfig(t *testing.T) {
+	dir := t.TempDir()
+	RegisterProvider("static_test", ProviderFunc(func(config map[string]interface{}) (*DynamicInventory, error) {
+		return &DynamicInventory{
+			Groups:   map[string]DynamicGroup{"lb": {Hosts: []string{"haproxy1"}}},
+			HostVars: map[string]map[string]interface{}{"haproxy1": {"ansible_port": config["port"]}},
+			HasMeta:  true,
+		}, nil
+	}))
+	path := filepath.Join(dir, "lb.yml")
+	writeFile(t, path, "plugin: static_test\nport: 2200\n")
+
+	inv, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if h := inv.Host("haproxy1"); h.Port != "2200" || len(h.Groups) != 1 || h.Groups[0] != "lb" {
+		t.Fatalf("unexpected provider host: %+v", h)
+	}
+
+	writeFile(t, path, "plugin: nope\n")
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected unknown plugin error")
+	}
+}
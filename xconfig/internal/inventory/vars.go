@@ -0,0 +1,86 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"xconfig/internal/vault"
+)
+
+// loadVarsDirs merges group_vars/<group> and host_vars/<host> found under
+// base. Each entry may be a YAML file (with or without a .yml/.yaml/.json
+// extension) or a directory of such files, read in lexical order. File vars
+// override vars declared inline in the inventory.
+func (inv *Inventory) loadVarsDirs(base string) error {
+	for _, name := range inv.GroupNames() {
+		vars, err := readVarsEntry(filepath.Join(base, "group_vars"), name)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			inv.groups[name].Vars[k] = v
+		}
+	}
+	for _, name := range inv.hostOrder {
+		vars, err := readVarsEntry(filepath.Join(base, "host_vars"), name)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			inv.hostVars[name][k] = v
+		}
+	}
+	return nil
+}
+
+func readVarsEntry(dir, name string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, candidate := range []string{name, name + ".yml", name + ".yaml", name + ".json"} {
+		path := filepath.Join(dir, candidate)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		var files []string
+		if info.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+					files = append(files, filepath.Join(path, e.Name()))
+				}
+			}
+			sort.Strings(files)
+		} else {
+			files = []string{path}
+		}
+		for _, f := range files {
+			vars, err := ReadVarsFile(f)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range vars {
+				merged[k] = v
+			}
+		}
+	}
+	return merged, nil
+}
+
+// ReadVarsFile parses a YAML/JSON mapping of variables.
+func ReadVarsFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]interface{})
+	if err := vault.UnmarshalVars(data, &vars); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vars, nil
+}
@@ -0,0 +1,88 @@
+package inventory
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+	"xconfig/internal/vault"
+)
+
+// parseYAML reads a YAML (or JSON) inventory:
+//
+//	all:
+//	  vars: {...}
+//	  hosts:
+//	    web1: {ansible_host: 10.0.0.1}
+//	  children:
+//	    web: {...}
+func (inv *Inventory) parseYAML(data []byte) error {
+	doc, err := vault.DecodeNode(data, false)
+	if err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("YAML inventory must be a mapping of groups")
+	}
+	// Walk the top level in document order so hosts keep their order.
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if err := inv.addYAMLGroup(root.Content[i].Value, root.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) addYAMLGroup(name string, node *yaml.Node) error {
+	g := inv.Group(name)
+	if node.Kind != yaml.MappingNode {
+		return nil // empty group, e.g. "web:" with no body
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch key {
+		case "vars":
+			var vars map[string]interface{}
+			if err := value.Decode(&vars); err != nil {
+				return fmt.Errorf("group %s vars: %w", name, err)
+			}
+			for k, v := range vars {
+				g.Vars[k] = v
+			}
+		case "hosts":
+			if value.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				var vars map[string]interface{}
+				if err := value.Content[j+1].Decode(&vars); err != nil {
+					return fmt.Errorf("host %s: %w", value.Content[j].Value, err)
+				}
+				names, err := ExpandHostRange(value.Content[j].Value)
+				if err != nil {
+					return err
+				}
+				for _, h := range names {
+					inv.AddHost(h, name, vars)
+				}
+			}
+		case "children":
+			if value.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				child := value.Content[j].Value
+				inv.AddChild(name, child)
+				if err := inv.addYAMLGroup(child, value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("group %s: unknown key %q (want hosts, vars or children)", name, key)
+		}
+	}
+	return nil
+}
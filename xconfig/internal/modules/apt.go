@@ -3,19 +3,15 @@ package modules
 import (
 	"xconfig/core/parser"
 	"xconfig/internal/ssh"
-	"fmt"
 )
 
+// aptHandler installs packages and .deb files (`deb`), uploading files that
+// exist on the control node first.
 func aptHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Apt == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing apt parameters"}
 	}
-	pkg := task.Apt.Name
-	if task.Apt.Deb != "" {
-		pkg = task.Apt.Deb
-	}
-	cmd := fmt.Sprintf("sudo apt-get -y install %s", pkg)
-	return ssh.RunShellCommand(ctx.Host, cmd)
+	return aptManager.ensure(ctx, *task.Apt)
 }
 
 func init() { Register("apt", aptHandler) }
@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// cleanupTimeout bounds the call that kills a job after its context ended.
+const cleanupTimeout = 10 * time.Second
+
+// runAsync starts cmd as a background job. With poll: 0 it returns at once;
+// otherwise it waits for the job, failing it once task.Async seconds pass.
+func runAsync(ctx Context, cmd string, task parser.Task) ssh.CommandResult {
+	jid := ssh.NewJobID()
+	res := ssh.StartAsync(ctx.Ctx, ctx.Host, cmd, jid)
+	if res.ReturnMsg != "CHANGED" || task.PollInterval() <= 0 {
+		return res
+	}
+	return waitAsync(ctx, jid, time.Duration(task.Async)*time.Second, task.PollInterval())
+}
+
+// waitAsync polls the job every poll seconds until it finishes. A limit of
+// zero waits until ctx ends.
+func waitAsync(ctx Context, jid string, limit time.Duration, poll int) ssh.CommandResult {
+	start := time.Now()
+	interval := time.Duration(poll) * time.Second
+	for {
+		wait := interval
+		if limit > 0 {
+			if left := limit - time.Since(start); left < wait {
+				wait = left
+			}
+		}
+		select {
+		case <-ctx.Ctx.Done():
+			killAsync(ctx, jid)
+			return ssh.Interrupted(ctx.Host, ctx.Ctx.Err(), ssh.CommandResult{Output: fmt.Sprintf("async job %s stopped", jid)})
+		case <-time.After(wait):
+		}
+
+		res, finished := ssh.AsyncStatus(ctx.Ctx, ctx.Host, jid)
+		if finished {
+			res.Start = start
+			res.End = time.Now()
+			return res
+		}
+		if res.ReturnMsg != "OK" {
+			return res
+		}
+		if limit > 0 && time.Since(start) >= limit {
+			killAsync(ctx, jid)
+			return ssh.CommandResult{
+				Host:       ctx.Host.Name,
+				ReturnMsg:  "FAILED",
+				ReturnCode: 124,
+				Output:     fmt.Sprintf("async job %s did not finish within %s", jid, limit),
+				Data:       map[string]interface{}{"ansible_job_id": jid, "started": 1, "finished": 0},
+			}
+		}
+	}
+}
+
+// killAsync stops the job even when ctx has already been cancelled.
+func killAsync(ctx Context, jid string) {
+	c, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	ssh.KillAsync(c, ctx.Host, jid)
+}
+
+// asyncStatusHandler waits for a job started with poll: 0 and returns its
+// result. It polls every poll seconds and is bounded by the task timeout.
+func asyncStatusHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	if task.AsyncStatus.Jid == "" {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "async_status requires jid"}
+	}
+	if ctx.Check {
+		return checkSkipped(ctx)
+	}
+	poll := task.PollInterval()
+	if poll <= 0 {
+		poll = parser.DefaultPoll
+	}
+	return waitAsync(ctx, task.AsyncStatus.Jid, 0, poll)
+}
+
+func init() { Register("async_status", asyncStatusHandler) }
@@ -0,0 +1,214 @@
+package modules
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// authKey is one public key line of an authorized_keys file.
+type authKey struct {
+	options, typ, blob, comment string
+}
+
+// id identifies the key regardless of its options and comment.
+func (k authKey) id() string { return k.typ + " " + k.blob }
+
+func (k authKey) String() string {
+	parts := []string{k.typ, k.blob}
+	if k.options != "" {
+		parts = append([]string{k.options}, parts...)
+	}
+	if k.comment != "" {
+		parts = append(parts, k.comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// isKeyType reports whether s names a public key algorithm.
+func isKeyType(s string) bool {
+	return strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "ecdsa-sha2-") || strings.HasPrefix(s, "sk-")
+}
+
+// parseAuthKey splits an authorized_keys line into options, key and
+// comment. ok is false for blank lines, comments and anything else that
+// holds no key.
+func parseAuthKey(line string) (authKey, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return authKey{}, false
+	}
+	// Options may hold quoted spaces: from="a, b",no-pty ssh-ed25519 ...
+	var fields []string
+	start, quoted := -1, false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if start >= 0 {
+				fields = append(fields, line[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, line[start:])
+	}
+	for i := 0; i+1 < len(fields); i++ {
+		if isKeyType(fields[i]) {
+			return authKey{
+				options: strings.Join(fields[:i], " "),
+				typ:     fields[i],
+				blob:    fields[i+1],
+				comment: strings.Join(fields[i+2:], " "),
+			}, true
+		}
+	}
+	return authKey{}, false
+}
+
+// applyAuthorizedKey returns the authorized_keys content edited as k
+// describes and a summary of the change. Lines that hold no key are kept.
+func applyAuthorizedKey(k parser.AuthorizedKey, content string) (string, string, error) {
+	var want []authKey
+	for _, line := range splitLines(k.Key) {
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		key, ok := parseAuthKey(line)
+		if !ok {
+			return "", "", fmt.Errorf("invalid public key %q", line)
+		}
+		if k.KeyOptions != "" {
+			key.options = k.KeyOptions
+		}
+		want = append(want, key)
+	}
+	if len(want) == 0 {
+		return "", "", fmt.Errorf("authorized_key needs key")
+	}
+	wanted := make(map[string]authKey, len(want))
+	for _, key := range want {
+		wanted[key.id()] = key
+	}
+
+	var out []string
+	var added, updated, removed int
+	switch k.State {
+	case "absent":
+		for _, line := range splitLines(content) {
+			if key, ok := parseAuthKey(line); ok {
+				if _, found := wanted[key.id()]; found {
+					removed++
+					continue
+				}
+			}
+			out = append(out, line)
+		}
+	case "", "present":
+		seen := make(map[string]bool, len(want))
+		for _, line := range splitLines(content) {
+			key, ok := parseAuthKey(line)
+			if !ok {
+				out = append(out, line)
+				continue
+			}
+			w, found := wanted[key.id()]
+			switch {
+			case found && !seen[key.id()]:
+				seen[key.id()] = true
+				// A key given without a comment keeps the existing one.
+				if w.comment == "" {
+					w.comment = key.comment
+				}
+				if line != w.String() {
+					updated++
+				}
+				out = append(out, w.String())
+			case k.Exclusive:
+				removed++
+			default:
+				out = append(out, line)
+			}
+		}
+		for _, w := range want {
+			if !seen[w.id()] {
+				seen[w.id()] = true
+				out = append(out, w.String())
+				added++
+			}
+		}
+	default:
+		return "", "", fmt.Errorf("unsupported authorized_key state %q (want present or absent)", k.State)
+	}
+
+	if added+updated+removed == 0 {
+		return content, "", nil
+	}
+	var summary []string
+	for _, c := range []struct {
+		n    int
+		verb string
+	}{{added, "added"}, {updated, "updated"}, {removed, "removed"}} {
+		if c.n > 0 {
+			summary = append(summary, fmt.Sprintf("%d key(s) %s", c.n, c.verb))
+		}
+	}
+	return joinLines(out), strings.Join(summary, ", "), nil
+}
+
+func authorizedKeyHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	k := task.AuthorizedKey
+	host := ctx.Host.Name
+	if k == nil || k.User == "" {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: "authorized_key needs user"}
+	}
+	a, err := queryAccount(ctx, k.User)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	if !a.exists {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("user %s does not exist", k.User)}
+	}
+	p := k.Path
+	switch {
+	case p == "":
+		p = path.Join(a.home, ".ssh", "authorized_keys")
+	case strings.HasPrefix(p, "~/"):
+		p = path.Join(a.home, p[2:])
+	}
+
+	present := k.State != "absent"
+	if present && !ctx.Check && (k.ManageDir == nil || *k.ManageDir) {
+		owner := ssh.ShellQuote(k.User + ":" + a.group)
+		script := fmt.Sprintf(`d=%s; [ -d "$d" ] || { mkdir -p "$d" && chmod 0700 "$d" && chown %s "$d"; }`, ssh.ShellQuote(path.Dir(p)), owner)
+		if res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, script); res.ReturnMsg != "CHANGED" {
+			return res
+		}
+	}
+
+	attrs := ssh.FileAttrs{Owner: k.User, Group: a.group, Mode: "0600"}
+	if !present {
+		attrs = ssh.FileAttrs{}
+	}
+	// A missing file is created for new keys; removing keys leaves it alone.
+	res := editFile(ctx, p, true, attrs, func(content string) (string, string, error) {
+		return applyAuthorizedKey(*k, content)
+	})
+	if res.Data == nil {
+		res.Data = map[string]interface{}{}
+	}
+	res.Data["user"] = k.User
+	res.Data["path"] = p
+	return res
+}
+
+func init() { Register("authorized_key", authorizedKeyHandler) }
@@ -0,0 +1,59 @@
+package modules
+
+import (
+	"testing"
+
+	"xconfig/core/parser"
+)
+
+const (
+	aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAlice alice@laptop"
+	bobKey   = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQBob bob@desk"
+)
+
+func TestParseAuthKey(t *testing.T) {
+	k, ok := parseAuthKey(`from="10.0.0.0/8, 192.168.0.0/16",no-pty ssh-ed25519 AAAAkey backup key`)
+	if !ok || k.options != `from="10.0.0.0/8, 192.168.0.0/16",no-pty` || k.typ != "ssh-ed25519" || k.blob != "AAAAkey" || k.comment != "backup key" {
+		t.Fatalf("unexpected key: %+v", k)
+	}
+	for _, line := range []string{"", "# comment", "not a key"} {
+		if _, ok := parseAuthKey(line); ok {
+			t.Errorf("parseAuthKey(%q) should hold no key", line)
+		}
+	}
+}
+
+func TestApplyAuthorizedKey(t *testing.T) {
+	existing := "# managed by hand\n" + bobKey + "\n"
+
+	out, msg, err := applyAuthorizedKey(parser.AuthorizedKey{User: "alice", Key: aliceKey}, existing)
+	if err != nil || out != existing+aliceKey+"\n" || msg != "1 key(s) added" {
+		t.Fatalf("add: %q %q %v", out, msg, err)
+	}
+	if again, msg, _ := applyAuthorizedKey(parser.AuthorizedKey{User: "alice", Key: aliceKey}, out); again != out || msg != "" {
+		t.Fatalf("expected adding twice to change nothing, got %q %q", again, msg)
+	}
+	// The comment of the existing line is kept when the key has none.
+	if again, _, _ := applyAuthorizedKey(parser.AuthorizedKey{Key: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAlice"}, out); again != out {
+		t.Fatalf("expected a key without comment to match, got %q", again)
+	}
+
+	out, msg, _ = applyAuthorizedKey(parser.AuthorizedKey{Key: aliceKey, KeyOptions: "no-pty"}, out)
+	if out != existing+"no-pty "+aliceKey+"\n" || msg != "1 key(s) updated" {
+		t.Fatalf("options: %q %q", out, msg)
+	}
+
+	out, msg, _ = applyAuthorizedKey(parser.AuthorizedKey{Key: aliceKey, Exclusive: true}, out)
+	if out != "# managed by hand\n"+aliceKey+"\n" || msg != "1 key(s) updated, 1 key(s) removed" {
+		t.Fatalf("exclusive: %q %q", out, msg)
+	}
+
+	out, msg, _ = applyAuthorizedKey(parser.AuthorizedKey{Key: aliceKey + "\n" + bobKey + "\n", State: "absent"}, out)
+	if out != "# managed by hand\n" || msg != "1 key(s) removed" {
+		t.Fatalf("absent: %q %q", out, msg)
+	}
+
+	if _, _, err := applyAuthorizedKey(parser.AuthorizedKey{Key: "garbage"}, ""); err == nil {
+		t.Fatalf("expected an invalid key to be rejected")
+	}
+}
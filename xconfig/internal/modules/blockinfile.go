@@ -0,0 +1,99 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// DefaultBlockMarker delimits the blocks managed by blockinfile.
+const DefaultBlockMarker = "# {mark} XCONFIG MANAGED BLOCK"
+
+func blockInFileHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	b := task.BlockInFile
+	if b == nil || b.Path == "" {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "blockinfile needs path"}
+	}
+	attrs := ssh.FileAttrs{Mode: b.Mode, Owner: b.Owner, Group: b.Group}
+	return editFile(ctx, b.Path, b.Create, attrs, func(content string) (string, string, error) {
+		return applyBlockInFile(*b, content)
+	})
+}
+
+// applyBlockInFile returns content with the managed block of b inserted,
+// replaced or removed, and a summary of the change. An empty block removes
+// the managed block.
+func applyBlockInFile(b parser.BlockInFile, content string) (string, string, error) {
+	marker := b.Marker
+	if marker == "" {
+		marker = DefaultBlockMarker
+	}
+	if !strings.Contains(marker, "{mark}") {
+		return "", "", fmt.Errorf("blockinfile marker must contain {mark}")
+	}
+	markBegin, markEnd := b.MarkerBegin, b.MarkerEnd
+	if markBegin == "" {
+		markBegin = "BEGIN"
+	}
+	if markEnd == "" {
+		markEnd = "END"
+	}
+	begin := strings.ReplaceAll(marker, "{mark}", markBegin)
+	end := strings.ReplaceAll(marker, "{mark}", markEnd)
+
+	lines := splitLines(content)
+	start, stop := -1, -1
+	for i, line := range lines {
+		switch strings.TrimRight(line, " \t\r") {
+		case begin:
+			if start < 0 {
+				start = i
+			}
+		case end:
+			if start >= 0 && stop < 0 {
+				stop = i
+			}
+		}
+	}
+	found := start >= 0 && stop > start
+
+	var state string
+	switch b.State {
+	case "", "present":
+		state = "present"
+		if b.Block == "" {
+			state = "absent"
+		}
+	case "absent":
+		state = "absent"
+	default:
+		return "", "", fmt.Errorf("unsupported blockinfile state %q (want present or absent)", b.State)
+	}
+
+	if state == "absent" {
+		if !found {
+			return content, "", nil
+		}
+		return joinLines(append(lines[:start:start], lines[stop+1:]...)), "block removed", nil
+	}
+
+	block := append(append([]string{begin}, splitLines(b.Block)...), end)
+	if found {
+		current := lines[start : stop+1]
+		if strings.Join(current, "\n") == strings.Join(block, "\n") {
+			return content, "", nil
+		}
+		out := append(append(lines[:start:start], block...), lines[stop+1:]...)
+		return joinLines(out), "block updated", nil
+	}
+	at, err := insertIndex(lines, b.InsertAfter, b.InsertBefore)
+	if err != nil {
+		return "", "", err
+	}
+	out := append(append(lines[:at:at], block...), lines[at:]...)
+	return joinLines(out), "block inserted", nil
+}
+
+func init() { Register("blockinfile", blockInFileHandler) }
@@ -6,7 +6,7 @@ import (
 )
 
 func commandHandler(ctx Context, task parser.Task) ssh.CommandResult {
-	return ssh.RunShellCommand(ctx.Host, task.Command)
+	return runCommand(ctx, task.Command, task)
 }
 
 func init() { Register("command", commandHandler) }
@@ -9,7 +9,19 @@ func copyHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Copy == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing copy parameters"}
 	}
-	return ssh.UploadFile(ctx.Host, task.Copy.Src, task.Copy.Dest, ctx.Diff)
+	c := task.Copy
+	attrs := ssh.FileAttrs{Mode: c.Mode, Owner: c.Owner, Group: c.Group}
+	switch {
+	case c.Dest == "":
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "copy needs dest"}
+	case c.Src == "" && c.Content == "":
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "copy needs src or content"}
+	case c.Src != "" && c.Content != "":
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "copy takes either src or content, not both"}
+	case c.Src == "":
+		return ssh.WriteContent(ctx.Ctx, ctx.Host, []byte(c.Content), c.Dest, attrs, ctx.Diff, ctx.Check)
+	}
+	return ssh.UploadFile(ctx.Ctx, ctx.Host, c.Src, c.Dest, attrs, ctx.Diff, ctx.Check)
 }
 
 func init() { Register("copy", copyHandler) }
@@ -0,0 +1,67 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"xconfig/internal/ssh"
+)
+
+// editFile applies edit to the remote file and writes the result back with
+// attrs. edit returns the new content and a summary of the change; content
+// equal to the current one (and matching attrs) leaves the file alone.
+func editFile(ctx Context, path string, create bool, attrs ssh.FileAttrs, edit func(content string) (string, string, error)) ssh.CommandResult {
+	host := ctx.Host.Name
+	if err := attrs.Validate(); err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	data, exists, err := ssh.ReadRemoteFile(ctx.Ctx, ctx.Host, path)
+	if err != nil {
+		if ctx.Ctx.Err() != nil {
+			return ssh.Interrupted(ctx.Host, ctx.Ctx.Err(), ssh.CommandResult{})
+		}
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	if !exists && !create {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("%s does not exist (set create: true to create it)", path)}
+	}
+	before := string(data)
+	after, msg, err := edit(before)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	if !exists && after == "" {
+		// Nothing to write: do not create an empty file.
+		return ssh.CommandResult{Host: host, ReturnMsg: "OK", Output: fmt.Sprintf("%s does not exist", path), Data: map[string]interface{}{"dest": path}}
+	}
+	if exists && after == before && attrs == (ssh.FileAttrs{}) {
+		return ssh.CommandResult{Host: host, ReturnMsg: "OK", Output: fmt.Sprintf("%s is up to date", path), Data: map[string]interface{}{"dest": path}}
+	}
+
+	res := ssh.WriteContent(ctx.Ctx, ctx.Host, []byte(after), path, attrs, ctx.Diff, ctx.Check)
+	if res.ReturnMsg == "CHANGED" && msg != "" && !ctx.Diff && (after != before || !exists) {
+		if ctx.Check {
+			msg = "would update " + path + ": " + msg
+		} else {
+			msg = path + ": " + msg
+		}
+		res.Output = msg
+	}
+	return res
+}
+
+// splitLines splits content into lines without their newlines.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// joinLines is the inverse of splitLines; the result ends with a newline.
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
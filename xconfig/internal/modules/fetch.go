@@ -0,0 +1,24 @@
+package modules
+
+import (
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+func fetchHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	if task.Fetch == nil {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing fetch parameters"}
+	}
+	f := task.Fetch
+	if f.Src == "" || f.Dest == "" {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "fetch needs src and dest"}
+	}
+	opts := ssh.FetchOptions{
+		Flat:             f.Flat,
+		FailOnMissing:    f.FailOnMissing == nil || *f.FailOnMissing,
+		ValidateChecksum: f.ValidateChecksum == nil || *f.ValidateChecksum,
+	}
+	return ssh.FetchFile(ctx.Ctx, ctx.Host, f.Src, f.Dest, opts, ctx.Diff, ctx.Check)
+}
+
+func init() { Register("fetch", fetchHandler) }
@@ -0,0 +1,202 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// fileScriptHead defines the helpers of the file module script. Every
+// change is reported as a "field<TAB>before<TAB>after" line; in check mode
+// run only reports.
+const fileScriptHead = `set -e
+p=%s
+note() { printf '%%s\t%%s\t%%s\n' "$1" "$2" "$3"; }
+if [ -L "$p" ]; then cur=link; elif [ -d "$p" ]; then cur=directory; elif [ -e "$p" ]; then cur=file; else cur=absent; fi
+`
+
+// fileAttrsScript compares the path with the wanted mode, owner and group
+// and fixes them. $1..$5 are the octal mode, uid, user, gid and group.
+const fileAttrsScript = `if [ -e "$p" ] || [ -L "$p" ]; then
+  set -- $(stat -c '%%a %%u %%U %%g %%G' "$p" 2>/dev/null || stat -f '%%Lp %%u %%Su %%g %%Sg' "$p")
+%s%s%sfi
+`
+
+func fileHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	f := task.File
+	host := ctx.Host.Name
+	if f == nil || f.Path == "" {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: "file needs path"}
+	}
+	state := fileState(*f)
+	script, err := fileScript(*f, state, ctx.Check)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, script)
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+
+	changes := parseFileChanges(res.Stdout)
+	res.Data = map[string]interface{}{"path": f.Path, "state": state}
+	if len(changes) == 0 {
+		res.ReturnMsg = "OK"
+		res.Output = fmt.Sprintf("%s is %s", f.Path, state)
+		return res
+	}
+	res.Output = describeChanges(ctx, f.Path, changes)
+	return res
+}
+
+// fileState returns the state of f, defaulting like Ansible.
+func fileState(f parser.FileAction) string {
+	switch {
+	case f.State != "":
+		return f.State
+	case f.Src != "":
+		return "link"
+	case f.Recurse:
+		return "directory"
+	}
+	return "file"
+}
+
+// fileScript builds the shell script that brings the path to state.
+func fileScript(f parser.FileAction, state string, check bool) (string, error) {
+	attrs := ssh.FileAttrs{Mode: f.Mode, Owner: f.Owner, Group: f.Group}
+	if err := attrs.Validate(); err != nil {
+		return "", err
+	}
+	run := ""
+	if check {
+		run = ": "
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, fileScriptHead, ssh.ShellQuote(f.Path))
+
+	switch state {
+	case "directory":
+		fmt.Fprintf(&b, `case $cur in
+directory) ;;
+link) [ -d "$p" ] || { echo "$p is a link to a non-directory" >&2; exit 1; } ;;
+absent) note state absent directory; %smkdir -p "$p" ;;
+*) echo "$p exists and is not a directory" >&2; exit 1 ;;
+esac
+`, run)
+	case "file":
+		b.WriteString(`case $cur in
+file) ;;
+link) [ -f "$p" ] || { echo "$p is a link to a non-file" >&2; exit 1; } ;;
+absent) echo "file $p does not exist (use state: touch to create it)" >&2; exit 1 ;;
+*) echo "$p is a $cur, not a file" >&2; exit 1 ;;
+esac
+`)
+	case "touch":
+		fmt.Fprintf(&b, "if [ $cur = absent ]; then note state absent file; %stouch \"$p\"; fi\n", run)
+	case "link":
+		if f.Src == "" {
+			return "", fmt.Errorf("file state=link needs src")
+		}
+		force := `echo "$p exists as a file, set force: true to replace it with a link" >&2; exit 1`
+		if f.Force {
+			force = ":"
+		}
+		fmt.Fprintf(&b, `src=%s
+before=$cur
+if [ $cur = link ]; then before="link -> $(readlink "$p")"; fi
+if [ "$before" != "link -> $src" ]; then
+  case $cur in
+  directory) echo "$p is a directory, refusing to replace it with a link" >&2; exit 1 ;;
+  file) %s ;;
+  esac
+  note state "$before" "link -> $src"
+  %sln -sfn "$src" "$p"
+fi
+`, ssh.ShellQuote(f.Src), force, run)
+	case "absent":
+		fmt.Fprintf(&b, "if [ $cur != absent ]; then note state $cur absent; %srm -rf \"$p\"; fi\n", run)
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported file state %q (want directory, file, link, touch or absent)", state)
+	}
+
+	var mode, owner, group string
+	// chmod follows links; a link has no mode of its own.
+	if attrs.Mode != "" && state != "link" {
+		m, _ := strconv.ParseUint(attrs.Mode, 8, 32)
+		want := fmt.Sprintf("%04o", m)
+		mode = fmt.Sprintf("  if [ \"$((0$1))\" -ne \"$((0%s))\" ]; then note mode \"$(printf %%04o $((0$1)))\" %s; %schmod %s \"$p\"; fi\n", want, want, run, want)
+	}
+	chown, chgrp := "chown", "chgrp"
+	if state == "link" {
+		chown, chgrp = "chown -h", "chgrp -h"
+	}
+	if attrs.Owner != "" {
+		q := ssh.ShellQuote(attrs.Owner)
+		owner = fmt.Sprintf("  if [ \"$3\" != %s ] && [ \"$2\" != %s ]; then note owner \"$3\" %s; %s%s %s \"$p\"; fi\n", q, q, q, run, chown, q)
+	}
+	if attrs.Group != "" {
+		q := ssh.ShellQuote(attrs.Group)
+		group = fmt.Sprintf("  if [ \"$5\" != %s ] && [ \"$4\" != %s ]; then note group \"$5\" %s; %s%s %s \"$p\"; fi\n", q, q, q, run, chgrp, q)
+	}
+	fmt.Fprintf(&b, fileAttrsScript, mode, owner, group)
+
+	if f.Recurse && state == "directory" {
+		// Links are skipped: their mode is meaningless and chown -R leaves
+		// them alone.
+		b.WriteString("if [ -d \"$p\" ]; then\n")
+		if attrs.Mode != "" {
+			m, _ := strconv.ParseUint(attrs.Mode, 8, 32)
+			fmt.Fprintf(&b, "  if [ -n \"$(find \"$p\" ! -type l ! -perm %04o | head -n 1)\" ]; then note recurse_mode mixed %04o; %schmod -R %04o \"$p\"; fi\n", m, m, run, m)
+		}
+		if attrs.Owner != "" {
+			q := ssh.ShellQuote(attrs.Owner)
+			fmt.Fprintf(&b, "  if [ -n \"$(find \"$p\" ! -type l ! -user %s | head -n 1)\" ]; then note recurse_owner mixed %s; %schown -R %s \"$p\"; fi\n", q, q, run, q)
+		}
+		if attrs.Group != "" {
+			q := ssh.ShellQuote(attrs.Group)
+			fmt.Fprintf(&b, "  if [ -n \"$(find \"$p\" ! -type l ! -group %s | head -n 1)\" ]; then note recurse_group mixed %s; %schgrp -R %s \"$p\"; fi\n", q, q, run, q)
+		}
+		b.WriteString("fi\n")
+	}
+	return b.String(), nil
+}
+
+// describeChanges summarizes "field before after" changes of name, as a
+// diff of the fields in diff mode.
+func describeChanges(ctx Context, name string, changes [][3]string) string {
+	var summary []string
+	var before, after strings.Builder
+	for _, c := range changes {
+		summary = append(summary, fmt.Sprintf("%s %s -> %s", c[0], c[1], c[2]))
+		fmt.Fprintf(&before, "%s: %s\n", c[0], c[1])
+		fmt.Fprintf(&after, "%s: %s\n", c[0], c[2])
+	}
+	if ctx.Diff {
+		return ssh.Diff(before.String(), after.String(), name)
+	}
+	out := name + ": " + strings.Join(summary, ", ")
+	if ctx.Check {
+		out = "would change " + out
+	}
+	return out
+}
+
+// parseFileChanges reads the "field<TAB>before<TAB>after" lines of the
+// file script.
+func parseFileChanges(out string) [][3]string {
+	var changes [][3]string
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.SplitN(strings.TrimRight(line, "\r"), "\t", 3)
+		if len(f) == 3 {
+			changes = append(changes, [3]string{f[0], f[1], f[2]})
+		}
+	}
+	return changes
+}
+
+func init() { Register("file", fileHandler) }
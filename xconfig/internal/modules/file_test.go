@@ -0,0 +1,135 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+)
+
+// runFileScript runs the file module script with the local sh and returns
+// the reported changes.
+func runFileScript(t *testing.T, f parser.FileAction, check bool) ([][3]string, error) {
+	t.Helper()
+	script, err := fileScript(f, fileState(f), check)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return parseFileChanges(string(out)), nil
+}
+
+func TestFileDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "app", "releases")
+	f := parser.FileAction{Path: dir, State: "directory", Mode: "750"}
+
+	changes, err := runFileScript(t, f, true)
+	if err != nil || len(changes) != 1 || changes[0] != [3]string{"state", "absent", "directory"} {
+		t.Fatalf("check mode: unexpected changes %v, %v", changes, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("check mode created the directory")
+	}
+
+	changes, err = runFileScript(t, f, false)
+	if err != nil || len(changes) != 2 || changes[1][0] != "mode" || changes[1][2] != "0750" {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || info.Mode().Perm() != 0o750 {
+		t.Fatalf("directory not created with mode 0750: %v", err)
+	}
+	if changes, err := runFileScript(t, f, false); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no change on rerun, got %v, %v", changes, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "app.bin"), []byte("x"), 0o644)
+	f.Recurse = true
+	changes, err = runFileScript(t, f, false)
+	if err != nil || len(changes) != 1 || changes[0][0] != "recurse_mode" {
+		t.Fatalf("expected a recursive mode change, got %v, %v", changes, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "app.bin")); info.Mode().Perm() != 0o750 {
+		t.Fatalf("recurse did not apply the mode: %v", info.Mode())
+	}
+
+	file := filepath.Join(t.TempDir(), "plain")
+	os.WriteFile(file, nil, 0o644)
+	if _, err := runFileScript(t, parser.FileAction{Path: file, State: "directory"}, false); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expected an existing file to be refused, got %v", err)
+	}
+}
+
+func TestFileTouchAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ready")
+	if _, err := runFileScript(t, parser.FileAction{Path: path}, false); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected state=file on a missing path to fail, got %v", err)
+	}
+	changes, err := runFileScript(t, parser.FileAction{Path: path, State: "touch", Mode: "0600"}, false)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("touch did not create the file with mode 0600: %v", err)
+	}
+	if changes, err := runFileScript(t, parser.FileAction{Path: path, State: "touch", Mode: "0600"}, false); err != nil || len(changes) != 0 {
+		t.Fatalf("expected touch to be idempotent, got %v, %v", changes, err)
+	}
+	changes, err = runFileScript(t, parser.FileAction{Path: path, Mode: "0644"}, false)
+	if err != nil || len(changes) != 1 || changes[0] != [3]string{"mode", "0600", "0644"} {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+}
+
+func TestFileLinkAndAbsent(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current")
+	f := parser.FileAction{Path: current, Src: filepath.Join(dir, "releases", "1")}
+
+	changes, err := runFileScript(t, f, false)
+	if err != nil || len(changes) != 1 || changes[0][1] != "absent" || changes[0][2] != "link -> "+f.Src {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+	if target, _ := os.Readlink(current); target != f.Src {
+		t.Fatalf("link points to %q", target)
+	}
+	if changes, err := runFileScript(t, f, false); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no change on rerun, got %v, %v", changes, err)
+	}
+	f.Src = filepath.Join(dir, "releases", "2")
+	if changes, err := runFileScript(t, f, false); err != nil || len(changes) != 1 || !strings.HasSuffix(changes[0][1], "releases/1") {
+		t.Fatalf("expected the link to be repointed, got %v, %v", changes, err)
+	}
+
+	plain := filepath.Join(dir, "plain")
+	os.WriteFile(plain, nil, 0o644)
+	if _, err := runFileScript(t, parser.FileAction{Path: plain, Src: "/etc/hosts"}, false); err == nil {
+		t.Fatalf("expected a file not to be replaced without force")
+	}
+	if _, err := runFileScript(t, parser.FileAction{Path: plain, Src: "/etc/hosts", Force: true}, false); err != nil {
+		t.Fatalf("force: %v", err)
+	}
+
+	changes, err = runFileScript(t, parser.FileAction{Path: current, State: "absent"}, false)
+	if err != nil || len(changes) != 1 || changes[0][2] != "absent" {
+		t.Fatalf("unexpected changes %v, %v", changes, err)
+	}
+	if _, err := os.Lstat(current); !os.IsNotExist(err) {
+		t.Fatalf("link not removed")
+	}
+	if changes, err := runFileScript(t, parser.FileAction{Path: current, State: "absent"}, false); err != nil || len(changes) != 0 {
+		t.Fatalf("expected absent to be idempotent, got %v, %v", changes, err)
+	}
+	if _, err := fileScript(parser.FileAction{Path: current, State: "hard"}, "hard", false); err == nil {
+		t.Fatalf("expected an unknown state to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// groupQuery prints the group entry of "$n", or nothing when it is missing.
+const groupQuery = `export n=%s; getent group "$n" 2>/dev/null || awk -F: '$1 == ENVIRON["n"]' /etc/group`
+
+// groupPlan compares g with the current gid (empty when the group is
+// missing) and returns the changes and the command that makes them; cmd is
+// empty when nothing changes.
+func groupPlan(g parser.GroupAction, exists bool, gid string) (changes [][3]string, cmd string, err error) {
+	if g.GID != "" {
+		if _, err := strconv.ParseUint(g.GID, 10, 32); err != nil {
+			return nil, "", fmt.Errorf("group gid must be a number, got %q", g.GID)
+		}
+	}
+	name := ssh.ShellQuote(g.Name)
+	switch g.State {
+	case "absent":
+		if !exists {
+			return nil, "", nil
+		}
+		return [][3]string{{"state", "present", "absent"}}, "groupdel " + name, nil
+	case "", "present":
+	default:
+		return nil, "", fmt.Errorf("unsupported group state %q (want present or absent)", g.State)
+	}
+
+	if !exists {
+		changes = [][3]string{{"state", "absent", "present"}}
+		cmd = "groupadd "
+		if g.GID != "" {
+			changes = append(changes, [3]string{"gid", "", g.GID})
+			cmd += "-g " + g.GID + " "
+		}
+		if g.System {
+			cmd += "-r "
+		}
+		return changes, cmd + name, nil
+	}
+	if g.GID != "" && g.GID != gid {
+		return [][3]string{{"gid", gid, g.GID}}, "groupmod -g " + g.GID + " " + name, nil
+	}
+	return nil, "", nil
+}
+
+// queryGroup returns whether the group exists and its gid.
+func queryGroup(ctx Context, name string) (bool, string, error) {
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, fmt.Sprintf(groupQuery, ssh.ShellQuote(name)))
+	if res.ReturnMsg != "CHANGED" {
+		return false, "", fmt.Errorf("query group %s: %s", name, strings.TrimSpace(res.Output))
+	}
+	// name:x:gid:members
+	f := strings.Split(strings.TrimSpace(res.Stdout), ":")
+	if len(f) < 3 {
+		return false, "", nil
+	}
+	return true, f[2], nil
+}
+
+func groupHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	g := task.Group
+	host := ctx.Host.Name
+	if g == nil || g.Name == "" {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: "group needs name"}
+	}
+	exists, gid, err := queryGroup(ctx, g.Name)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	changes, cmd, err := groupPlan(*g, exists, gid)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	data := func(exists bool, gid string) map[string]interface{} {
+		d := map[string]interface{}{"name": g.Name, "state": "absent", "system": g.System}
+		if exists {
+			d["state"] = "present"
+			d["gid"] = gid
+			if n, err := strconv.Atoi(gid); err == nil {
+				d["gid"] = n
+			}
+		}
+		return d
+	}
+	if cmd == "" {
+		return ssh.CommandResult{Host: host, ReturnMsg: "OK", Output: fmt.Sprintf("group %s is %s", g.Name, data(exists, gid)["state"]), Data: data(exists, gid)}
+	}
+	if ctx.Check {
+		return ssh.CommandResult{Host: host, ReturnMsg: "CHANGED", Output: describeChanges(ctx, "group "+g.Name, changes), Data: data(exists, gid)}
+	}
+
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+	if exists, gid, err = queryGroup(ctx, g.Name); err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	res.Output = describeChanges(ctx, "group "+g.Name, changes)
+	res.Data = data(exists, gid)
+	return res
+}
+
+func init() { Register("group", groupHandler) }
@@ -0,0 +1,141 @@
+package modules
+
+import (
+	"fmt"
+	"regexp"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+func lineInFileHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	l := task.LineInFile
+	if l == nil || l.Path == "" {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "lineinfile needs path"}
+	}
+	attrs := ssh.FileAttrs{Mode: l.Mode, Owner: l.Owner, Group: l.Group}
+	return editFile(ctx, l.Path, l.Create, attrs, func(content string) (string, string, error) {
+		return applyLineInFile(*l, content)
+	})
+}
+
+// applyLineInFile returns content edited as l describes and a summary of
+// the change. Unchanged content is returned as is.
+func applyLineInFile(l parser.LineInFile, content string) (string, string, error) {
+	var re *regexp.Regexp
+	if l.Regexp != "" {
+		var err error
+		if re, err = regexp.Compile(l.Regexp); err != nil {
+			return "", "", fmt.Errorf("invalid regexp: %v", err)
+		}
+	}
+	lines := splitLines(content)
+
+	switch l.State {
+	case "absent":
+		if re == nil && l.Line == "" {
+			return "", "", fmt.Errorf("lineinfile state=absent needs regexp or line")
+		}
+		kept := lines[:0:0]
+		for _, line := range lines {
+			if (re != nil && re.MatchString(line)) || (re == nil && line == l.Line) {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if removed := len(lines) - len(kept); removed > 0 {
+			return joinLines(kept), fmt.Sprintf("%d line(s) removed", removed), nil
+		}
+		return content, "", nil
+	case "", "present":
+	default:
+		return "", "", fmt.Errorf("unsupported lineinfile state %q (want present or absent)", l.State)
+	}
+
+	if l.Backrefs && re == nil {
+		return "", "", fmt.Errorf("lineinfile backrefs needs regexp")
+	}
+	if re != nil {
+		if i := lastMatch(lines, re); i >= 0 {
+			want := l.Line
+			if l.Backrefs {
+				want = string(re.ExpandString(nil, backrefTemplate(l.Line), lines[i], re.FindStringSubmatchIndex(lines[i])))
+			}
+			if lines[i] == want {
+				return content, "", nil
+			}
+			lines[i] = want
+			return joinLines(lines), "line replaced", nil
+		}
+		if l.Backrefs {
+			return content, "", nil
+		}
+	}
+	for _, line := range lines {
+		if line == l.Line {
+			return content, "", nil
+		}
+	}
+
+	at, err := insertIndex(lines, l.InsertAfter, l.InsertBefore)
+	if err != nil {
+		return "", "", err
+	}
+	lines = append(lines[:at], append([]string{l.Line}, lines[at:]...)...)
+	return joinLines(lines), "line added", nil
+}
+
+// insertIndex returns where new lines go: after the last line matching
+// after, before the last line matching before ("BOF" and "EOF" name the
+// ends of the file), or at the end.
+func insertIndex(lines []string, after, before string) (int, error) {
+	switch {
+	case before == "BOF":
+		return 0, nil
+	case before != "":
+		re, err := regexp.Compile(before)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insertbefore: %v", err)
+		}
+		if i := lastMatch(lines, re); i >= 0 {
+			return i, nil
+		}
+	case after != "" && after != "EOF":
+		re, err := regexp.Compile(after)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insertafter: %v", err)
+		}
+		if i := lastMatch(lines, re); i >= 0 {
+			return i + 1, nil
+		}
+	}
+	return len(lines), nil
+}
+
+func lastMatch(lines []string, re *regexp.Regexp) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if re.MatchString(lines[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+var backrefPattern = regexp.MustCompile(`\\(\d+)|\\g<(\w+)>|\$`)
+
+// backrefTemplate turns the \1 references of an Ansible-style line into
+// the ${1} form regexp.Expand understands, escaping other "$".
+func backrefTemplate(line string) string {
+	return backrefPattern.ReplaceAllStringFunc(line, func(m string) string {
+		switch {
+		case m == "$":
+			return "$$"
+		case m[1] == 'g':
+			return "${" + m[3:len(m)-1] + "}"
+		default:
+			return "${" + m[1:] + "}"
+		}
+	})
+}
+
+func init() { Register("lineinfile", lineInFileHandler) }
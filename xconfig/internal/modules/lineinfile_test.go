@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+)
+
+const sysctlConf = `# kernel tuning
+vm.swappiness = 60
+net.ipv4.ip_forward = 0
+`
+
+func TestApplyLineInFile(t *testing.T) {
+	cases := []struct {
+		name string
+		l    parser.LineInFile
+		in   string
+		want string
+		msg  string
+	}{
+		{"replace last match", parser.LineInFile{Regexp: `^vm\.swappiness`, Line: "vm.swappiness = 10"}, sysctlConf,
+			"# kernel tuning\nvm.swappiness = 10\nnet.ipv4.ip_forward = 0\n", "line replaced"},
+		{"already present", parser.LineInFile{Regexp: `^vm\.swappiness`, Line: "vm.swappiness = 60"}, sysctlConf, sysctlConf, ""},
+		{"append", parser.LineInFile{Line: "fs.file-max = 100000"}, "# kernel tuning", "# kernel tuning\nfs.file-max = 100000\n", "line added"},
+		{"exact line kept", parser.LineInFile{Regexp: `^nomatch`, Line: "vm.swappiness = 60"}, sysctlConf, sysctlConf, ""},
+		{"insertafter", parser.LineInFile{Line: "kernel.pid_max = 65536", InsertAfter: `^#`}, sysctlConf,
+			"# kernel tuning\nkernel.pid_max = 65536\nvm.swappiness = 60\nnet.ipv4.ip_forward = 0\n", "line added"},
+		{"insertbefore BOF", parser.LineInFile{Line: "# managed", InsertBefore: "BOF"}, sysctlConf, "# managed\n" + sysctlConf, "line added"},
+		{"insertbefore without match appends", parser.LineInFile{Line: "x = 1", InsertBefore: `^nomatch`}, "a\n", "a\nx = 1\n", "line added"},
+		{"backrefs", parser.LineInFile{Regexp: `^(net\.ipv4\.ip_forward) = \d`, Line: `\1 = 1`, Backrefs: true}, sysctlConf,
+			"# kernel tuning\nvm.swappiness = 60\nnet.ipv4.ip_forward = 1\n", "line replaced"},
+		{"backrefs without match", parser.LineInFile{Regexp: `^nomatch(.*)`, Line: `\1`, Backrefs: true}, sysctlConf, sysctlConf, ""},
+		{"absent by regexp", parser.LineInFile{Regexp: `^(vm|net)\.`, State: "absent"}, sysctlConf, "# kernel tuning\n", "2 line(s) removed"},
+		{"absent already", parser.LineInFile{Line: "foo", State: "absent"}, sysctlConf, sysctlConf, ""},
+	}
+	for _, c := range cases {
+		got, msg, err := applyLineInFile(c.l, c.in)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.want || msg != c.msg {
+			t.Errorf("%s: got %q (%q), want %q (%q)", c.name, got, msg, c.want, c.msg)
+		}
+	}
+
+	if _, _, err := applyLineInFile(parser.LineInFile{Regexp: "(", Line: "x"}, ""); err == nil || !strings.Contains(err.Error(), "invalid regexp") {
+		t.Fatalf("expected an invalid regexp to be reported, got %v", err)
+	}
+	if _, _, err := applyLineInFile(parser.LineInFile{Line: "x", Backrefs: true}, ""); err == nil {
+		t.Fatalf("expected backrefs without regexp to be rejected")
+	}
+}
+
+func TestApplyBlockInFile(t *testing.T) {
+	hosts := "127.0.0.1 localhost\n"
+	b := parser.BlockInFile{Block: "10.0.0.1 web1\n10.0.0.2 web2\n"}
+
+	got, msg, err := applyBlockInFile(b, hosts)
+	want := hosts + "# BEGIN XCONFIG MANAGED BLOCK\n10.0.0.1 web1\n10.0.0.2 web2\n# END XCONFIG MANAGED BLOCK\n"
+	if err != nil || got != want || msg != "block inserted" {
+		t.Fatalf("insert: got %q (%q), %v", got, msg, err)
+	}
+	if again, msg, _ := applyBlockInFile(b, got); again != got || msg != "" {
+		t.Fatalf("expected the block to be left alone, got %q (%q)", again, msg)
+	}
+
+	b.Block = "10.0.0.3 web3"
+	updated, msg, _ := applyBlockInFile(b, got+"::1 localhost6\n")
+	want = hosts + "# BEGIN XCONFIG MANAGED BLOCK\n10.0.0.3 web3\n# END XCONFIG MANAGED BLOCK\n::1 localhost6\n"
+	if updated != want || msg != "block updated" {
+		t.Fatalf("update in place: got %q (%q)", updated, msg)
+	}
+
+	removed, msg, _ := applyBlockInFile(parser.BlockInFile{State: "absent"}, updated)
+	if removed != hosts+"::1 localhost6\n" || msg != "block removed" {
+		t.Fatalf("remove: got %q (%q)", removed, msg)
+	}
+
+	custom := parser.BlockInFile{Block: "Port 2222", Marker: "## {mark} sshd", MarkerBegin: "start", MarkerEnd: "stop", InsertBefore: "BOF"}
+	got, _, _ = applyBlockInFile(custom, "PermitRootLogin no\n")
+	if got != "## start sshd\nPort 2222\n## stop sshd\nPermitRootLogin no\n" {
+		t.Fatalf("custom marker: got %q", got)
+	}
+	if _, _, err := applyBlockInFile(parser.BlockInFile{Block: "x", Marker: "# managed"}, ""); err == nil {
+		t.Fatalf("expected a marker without {mark} to be rejected")
+	}
+}
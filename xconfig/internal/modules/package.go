@@ -0,0 +1,61 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// pkgMgrScript prints the first package manager found on the host; setup
+// reports it as the pkg_mgr fact.
+const pkgMgrScript = `for m in apt-get dnf yum apk zypper; do if command -v $m >/dev/null 2>&1; then echo $m; break; fi; done`
+
+// pkgMgrName maps the output of pkgMgrScript to a pkgManagers key.
+func pkgMgrName(out string) string {
+	switch name := strings.TrimSpace(out); name {
+	case "":
+		return "unknown"
+	case "apt-get":
+		return "apt"
+	default:
+		return name
+	}
+}
+
+// detectPkgManager picks the manager named by use, the pkg_mgr fact, or the
+// one found on the host.
+func detectPkgManager(ctx Context, use string) (pkgManager, error) {
+	name := use
+	if name == "" || name == "auto" {
+		name, _ = ctx.Vars["ansible_pkg_mgr"].(string)
+	}
+	if name == "" || name == "auto" || name == "unknown" {
+		res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, pkgMgrScript)
+		if res.ReturnMsg != "CHANGED" {
+			return pkgManager{}, fmt.Errorf("detect package manager: %s", strings.TrimSpace(res.Output))
+		}
+		name = pkgMgrName(res.Stdout)
+	}
+	m, ok := pkgManagers[name]
+	if !ok {
+		return pkgManager{}, fmt.Errorf("unsupported package manager %q (want apt, dnf, yum, apk or zypper)", name)
+	}
+	return m, nil
+}
+
+// packageHandler runs the package manager of the host, detected from the
+// pkg_mgr fact or probed, unless `use` names one.
+func packageHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	if task.Package == nil {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing package parameters"}
+	}
+	m, err := detectPkgManager(ctx, task.Package.Use)
+	if err != nil {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	return m.ensure(ctx, *task.Package)
+}
+
+func init() { Register("package", packageHandler) }
@@ -0,0 +1,123 @@
+package modules
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionPins(t *testing.T) {
+	name, version := splitPin("nginx=1.24.0")
+	if name != "nginx" || version != "1.24.0" {
+		t.Fatalf("splitPin = %q, %q", name, version)
+	}
+	if name, version := splitPin("curl"); name != "curl" || version != "" {
+		t.Fatalf("splitPin without a pin = %q, %q", name, version)
+	}
+	if got := yumManager.pin("nginx", "1.24.0"); got != "nginx-1.24.0" {
+		t.Errorf("yum pin = %q", got)
+	}
+	if got := aptManager.pin("nginx", "1.24.0-1ubuntu1"); got != "nginx=1.24.0-1ubuntu1" {
+		t.Errorf("apt pin = %q", got)
+	}
+
+	for _, tc := range []struct {
+		installed, pin string
+		want           bool
+	}{
+		{"1.24.0-1ubuntu1", "1.24.0-1ubuntu1", true},
+		{"1.24.0-1ubuntu1", "1.24.0", true},
+		{"1:1.24.0-2.el9", "1.24.0", true},
+		{"1:1.24.0-2.el9", "1:1.24.0-2.el9", true},
+		{"1.24.0-1", "1.24", false},
+		{"1.26.1-1", "1.24.0", false},
+		{"", "1.24.0", false},
+	} {
+		if got := versionMatches(tc.installed, tc.pin); got != tc.want {
+			t.Errorf("versionMatches(%q, %q) = %v, want %v", tc.installed, tc.pin, got, tc.want)
+		}
+	}
+}
+
+func TestDetectPkgManager(t *testing.T) {
+	for out, want := range map[string]string{"apt-get\n": "apt", "dnf": "dnf", "zypper": "zypper", "": "unknown"} {
+		if got := pkgMgrName(out); got != want {
+			t.Errorf("pkgMgrName(%q) = %q, want %q", out, got, want)
+		}
+	}
+
+	ctx := Context{Vars: map[string]interface{}{"ansible_pkg_mgr": "dnf"}}
+	if m, err := detectPkgManager(ctx, ""); err != nil || m.name != "dnf" {
+		t.Fatalf("expected dnf from facts, got %q, %v", m.name, err)
+	}
+	if m, err := detectPkgManager(ctx, "apk"); err != nil || m.name != "apk" {
+		t.Fatalf("expected use to override facts, got %q, %v", m.name, err)
+	}
+	if _, err := detectPkgManager(ctx, "pacman"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Fatalf("expected an unsupported manager error, got %v", err)
+	}
+
+	out, err := exec.Command("sh", "-c", pkgMgrScript).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := pkgMgrName(string(out)); name != "unknown" {
+		if _, ok := pkgManagers[name]; !ok {
+			t.Fatalf("probe found %q, which is not a supported manager", name)
+		}
+	}
+}
+
+func TestApkFileInfo(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".PKGINFO"), []byte("# Generated by abuild\npkgname = hello\npkgver = 2.12-r1\narch = x86_64\n"), 0o644)
+	pkg := filepath.Join(dir, "hello-2.12-r1.apk")
+	if out, err := exec.Command("tar", "-czf", pkg, "-C", dir, ".PKGINFO").CombinedOutput(); err != nil {
+		t.Fatalf("tar: %v: %s", err, out)
+	}
+
+	cmd := exec.Command("sh", "-c", apkManager.fileInfo)
+	cmd.Env = append(os.Environ(), "f="+pkg)
+	out, err := cmd.Output()
+	if err != nil || strings.TrimSpace(string(out)) != "hello 2.12-r1" {
+		t.Fatalf("unexpected package info %q, %v", out, err)
+	}
+}
+
+func TestPackageFiles(t *testing.T) {
+	for n, want := range map[string]bool{"./nginx_1.24.0_amd64.deb": true, "pkgs/app.rpm": true, "hello.apk": true, "nginx": false, "nginx=1.24.0": false} {
+		if got := isPackageFile(n); got != want {
+			t.Errorf("isPackageFile(%q) = %v, want %v", n, got, want)
+		}
+	}
+	if _, _, err := yumManager.stageFiles(Context{}, []string{"app.deb"}); err == nil || !strings.Contains(err.Error(), ".rpm") {
+		t.Fatalf("expected yum to reject a .deb, got %v", err)
+	}
+}
+
+func TestPackageLatestCommand(t *testing.T) {
+	want := map[string]string{
+		"apt":    "DEBIAN_FRONTEND=noninteractive apt-get -y install 'curl' && DEBIAN_FRONTEND=noninteractive apt-get -y install --only-upgrade 'nginx'",
+		"yum":    "yum -y install 'curl' && yum -y update 'nginx'",
+		"dnf":    "dnf -y install 'curl' && dnf -y upgrade 'nginx'",
+		"apk":    "apk add 'curl' && apk add --upgrade 'nginx'",
+		"zypper": "zypper --non-interactive install 'curl' && zypper --non-interactive update 'nginx'",
+	}
+	for name, m := range pkgManagers {
+		// curl is missing and installed; nginx is installed and upgraded.
+		if got := m.command("latest", []string{"curl"}, []string{"nginx"}, false, false); got != want[name] {
+			t.Errorf("%s latest command = %q, want %q", name, got, want[name])
+		}
+	}
+	if got := dnfManager.command("latest", nil, []string{"nginx"}, false, false); got != "dnf -y upgrade 'nginx'" {
+		t.Errorf("upgrade only command = %q", got)
+	}
+	if got := apkManager.command("absent", []string{"nginx"}, nil, false, false); got != "apk del 'nginx'" {
+		t.Errorf("remove command = %q", got)
+	}
+}
@@ -0,0 +1,429 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// packageNames splits a package list such as "nginx,curl" or "nginx curl"
+// (looped tasks are batched into the former).
+func packageNames(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+}
+
+// quoteAll shell quotes every name and joins them with spaces.
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = ssh.ShellQuote(n)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// pkgManager describes how to query and change packages for one package
+// manager so that handlers only act when the installed state differs.
+type pkgManager struct {
+	name string
+	// query prints the installed version of "$p", or nothing when missing.
+	query   string
+	install string
+	remove  string
+	// upgrade brings installed packages to their latest version; install
+	// leaves installed packages alone on most package managers.
+	upgrade string
+	// pending exits 0 when upgrading the packages would change something;
+	// it is used to predict state=latest in check mode.
+	pending     string
+	updateCache string
+	// pin turns a "name=version" request into the install argument.
+	pin func(name, version string) string
+	// pinFlags are added to install when a version is pinned.
+	pinFlags string
+	// fileInfo prints "<name> <version>" for the package file "$f";
+	// fileExt is the extension of such files and fileFlags are added to
+	// install when it installs files.
+	fileInfo  string
+	fileExt   string
+	fileFlags string
+}
+
+const rpmQuery = `rpm -q --qf '%{VERSION}-%{RELEASE}\n' "$p" 2>/dev/null | grep -v 'not installed' | head -n1`
+
+var (
+	aptManager = pkgManager{
+		name:        "apt",
+		query:       `dpkg-query -W -f='${Status} ${Version}' "$p" 2>/dev/null | sed -n 's/^install ok installed //p'`,
+		install:     "DEBIAN_FRONTEND=noninteractive apt-get -y install",
+		remove:      "DEBIAN_FRONTEND=noninteractive apt-get -y remove",
+		upgrade:     "DEBIAN_FRONTEND=noninteractive apt-get -y install --only-upgrade",
+		pending:     "apt-get -s -y install %s | grep -q '^Inst '",
+		updateCache: "apt-get update",
+		pin:         func(n, v string) string { return n + "=" + v },
+		pinFlags:    "--allow-downgrades",
+		fileInfo:    `echo "$(dpkg-deb -f "$f" Package) $(dpkg-deb -f "$f" Version)"`,
+		fileExt:     ".deb",
+	}
+	yumManager = pkgManager{
+		name:        "yum",
+		query:       rpmQuery,
+		install:     "yum -y install",
+		remove:      "yum -y remove",
+		upgrade:     "yum -y update",
+		pending:     "yum -q check-update %s >/dev/null; [ $? -eq 100 ]",
+		updateCache: "yum -y makecache",
+		pin:         func(n, v string) string { return n + "-" + v },
+		fileInfo:    `rpm -qp --qf '%{NAME} %{VERSION}-%{RELEASE}\n' "$f" 2>/dev/null`,
+		fileExt:     ".rpm",
+	}
+	dnfManager = pkgManager{
+		name:        "dnf",
+		query:       rpmQuery,
+		install:     "dnf -y install",
+		remove:      "dnf -y remove",
+		upgrade:     "dnf -y upgrade",
+		pending:     "dnf -q check-update %s >/dev/null; [ $? -eq 100 ]",
+		updateCache: "dnf -y makecache",
+		pin:         func(n, v string) string { return n + "-" + v },
+		fileInfo:    yumManager.fileInfo,
+		fileExt:     ".rpm",
+	}
+	apkManager = pkgManager{
+		name:        "apk",
+		query:       `awk -v p="$p" '/^P:/{n=substr($0,3)} /^V:/{if(n==p){print substr($0,3); exit}}' /lib/apk/db/installed 2>/dev/null`,
+		install:     "apk add",
+		remove:      "apk del",
+		upgrade:     "apk add --upgrade",
+		pending:     "apk add --simulate --upgrade %s 2>/dev/null | grep -qE '(Installing|Upgrading)'",
+		updateCache: "apk update",
+		pin:         func(n, v string) string { return n + "=" + v },
+		fileInfo:    `tar -xzOf "$f" .PKGINFO 2>/dev/null | awk -F' = ' '$1=="pkgname"{n=$2} $1=="pkgver"{v=$2} END{print n, v}'`,
+		fileExt:     ".apk",
+		fileFlags:   "--allow-untrusted",
+	}
+	zypperManager = pkgManager{
+		name:    "zypper",
+		query:   rpmQuery,
+		install: "zypper --non-interactive install",
+		remove:  "zypper --non-interactive remove",
+		upgrade: "zypper --non-interactive update",
+		pending: `u=$(zypper --non-interactive --quiet list-updates 2>/dev/null | awk -F'|' 'NR>2{gsub(/ /,"",$3); print $3}'); ` +
+			`for p in %s; do echo "$u" | grep -qx "$p" && exit 0; done; exit 1`,
+		updateCache: "zypper --non-interactive refresh",
+		pin:         func(n, v string) string { return n + "=" + v },
+		fileInfo:    yumManager.fileInfo,
+		fileExt:     ".rpm",
+	}
+
+	pkgManagers = map[string]pkgManager{
+		"apt": aptManager, "yum": yumManager, "dnf": dnfManager, "apk": apkManager, "zypper": zypperManager,
+	}
+)
+
+// splitPin splits "nginx=1.24.0" into the package name and the version.
+func splitPin(n string) (string, string) {
+	name, version, _ := strings.Cut(n, "=")
+	return name, version
+}
+
+// versionMatches reports whether the installed version satisfies a pin;
+// the pin may leave out the epoch and the release.
+func versionMatches(installed, pin string) bool {
+	if _, v, ok := strings.Cut(installed, ":"); ok && !strings.Contains(pin, ":") {
+		installed = v
+	}
+	return installed == pin || strings.HasPrefix(installed, pin+"-")
+}
+
+// isPackageFile reports whether n names a package file rather than a package.
+func isPackageFile(n string) bool {
+	return strings.HasSuffix(n, ".deb") || strings.HasSuffix(n, ".rpm") || strings.HasSuffix(n, ".apk")
+}
+
+// pkgFile is a package file to install: its path on the host and the
+// package it contains.
+type pkgFile struct {
+	src, path, name, version string
+}
+
+// versions returns the installed version of each package ("" when missing).
+func (m pkgManager) versions(ctx Context, names []string) (map[string]string, error) {
+	out := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return out, nil
+	}
+	cmd := fmt.Sprintf(`for p in %s; do printf '%%s\t%%s\n' "$p" "$(%s)"; done`, quoteAll(names), m.query)
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+	if res.ReturnMsg != "CHANGED" {
+		return nil, fmt.Errorf("query packages: %s", strings.TrimSpace(res.Output))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(res.Stdout), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) == 2 {
+			out[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return out, nil
+}
+
+// stageFiles makes the package files available on the host: files found on
+// the control node are uploaded to a temporary directory, other paths are
+// taken as paths on the host. cleanup removes the uploads.
+func (m pkgManager) stageFiles(ctx Context, files []string) ([]pkgFile, func(), error) {
+	cleanup := func() {}
+	if len(files) == 0 {
+		return nil, cleanup, nil
+	}
+	staged := make([]pkgFile, len(files))
+	var dir string
+	for i, f := range files {
+		if !strings.HasSuffix(f, m.fileExt) {
+			return nil, cleanup, fmt.Errorf("%s is not a %s package, %s needs %s files", f, m.fileExt, m.name, m.fileExt)
+		}
+		staged[i] = pkgFile{src: f, path: f}
+		if info, err := os.Stat(f); err != nil || info.IsDir() {
+			// apt-get only treats arguments containing a slash as files.
+			if !strings.Contains(f, "/") {
+				staged[i].path = "./" + f
+			}
+			continue
+		}
+		if dir == "" {
+			res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, "mktemp -d /tmp/xconfig-pkg.XXXXXX")
+			if res.ReturnMsg != "CHANGED" {
+				return nil, cleanup, fmt.Errorf("create staging directory: %s", strings.TrimSpace(res.Output))
+			}
+			dir = strings.TrimSpace(res.Stdout)
+			cleanup = func() {
+				ssh.RunShellCommand(context.WithoutCancel(ctx.Ctx), ctx.Host, "rm -rf "+ssh.ShellQuote(dir))
+			}
+		}
+		staged[i].path = path.Join(dir, filepath.Base(f))
+		if res := ssh.UploadFile(ctx.Ctx, ctx.Host, f, staged[i].path, ssh.FileAttrs{}, false, false); res.ReturnMsg == "FAILED" {
+			return nil, cleanup, fmt.Errorf("upload %s: %s", f, res.Output)
+		}
+	}
+
+	paths := make([]string, len(staged))
+	for i, f := range staged {
+		paths[i] = f.path
+	}
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, fmt.Sprintf(`for f in %s; do printf '%%s\t' "$f"; %s; done`, quoteAll(paths), m.fileInfo))
+	if res.ReturnMsg != "CHANGED" {
+		return nil, cleanup, fmt.Errorf("read package files: %s", strings.TrimSpace(res.Output))
+	}
+	info := make(map[string][]string)
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		if p, fields, ok := strings.Cut(line, "\t"); ok {
+			info[p] = strings.Fields(fields)
+		}
+	}
+	for i, f := range staged {
+		if fields := info[f.path]; len(fields) == 2 {
+			staged[i].name, staged[i].version = fields[0], fields[1]
+		} else {
+			return nil, cleanup, fmt.Errorf("%s is not a readable %s package on %s", f.src, m.fileExt, ctx.Host.Name)
+		}
+	}
+	return staged, cleanup, nil
+}
+
+// ensure brings the packages of p to its state (present, absent or latest)
+// and reports CHANGED only when the installed versions actually changed.
+// Names may pin versions ("nginx=1.24.0") or be package files, which are
+// uploaded first when they exist on the control node.
+func (m pkgManager) ensure(ctx Context, p parser.PackageAction) ssh.CommandResult {
+	fail := func(err error) ssh.CommandResult {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	var pkgs, files []string
+	for _, n := range append(packageNames(p.Name), packageNames(p.Deb)...) {
+		if isPackageFile(n) {
+			files = append(files, n)
+		} else {
+			pkgs = append(pkgs, n)
+		}
+	}
+
+	var state string
+	switch p.State {
+	case "", "present", "installed":
+		state = "present"
+	case "absent", "removed":
+		state = "absent"
+	case "latest":
+		state = "latest"
+	default:
+		return fail(fmt.Errorf("unsupported package state %q", p.State))
+	}
+	if len(pkgs)+len(files) == 0 && !p.UpdateCache {
+		return fail(fmt.Errorf("no package name given"))
+	}
+	if len(files) > 0 && state == "absent" {
+		return fail(fmt.Errorf("state=absent takes package names, not files"))
+	}
+
+	data := map[string]interface{}{"pkg_mgr": m.name, "cache_updated": false}
+	if p.UpdateCache && !ctx.Check {
+		res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, m.updateCache)
+		if res.ReturnMsg != "CHANGED" {
+			return res
+		}
+		data["cache_updated"] = true
+	}
+	if len(pkgs)+len(files) == 0 {
+		// A refreshed index alone does not change what is installed.
+		msg := "package cache updated"
+		if ctx.Check {
+			msg = "would update the package cache"
+		}
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: msg, Data: data}
+	}
+
+	staged, cleanup, err := m.stageFiles(ctx, files)
+	defer cleanup()
+	if err != nil {
+		return fail(err)
+	}
+	var bases []string
+	for _, n := range pkgs {
+		name, _ := splitPin(n)
+		bases = append(bases, name)
+	}
+	for _, f := range staged {
+		bases = append(bases, f.name)
+	}
+	before, err := m.versions(ctx, bases)
+	if err != nil {
+		return fail(err)
+	}
+	data["packages"] = versionData(before)
+
+	var todo, upgrade []string
+	pinned, withFiles := false, false
+	for _, n := range pkgs {
+		name, version := splitPin(n)
+		installed := before[name]
+		switch {
+		case state == "absent":
+			if installed != "" {
+				todo = append(todo, name)
+			}
+		case version != "":
+			if !versionMatches(installed, version) {
+				todo = append(todo, m.pin(name, version))
+				pinned = true
+			}
+		case state == "latest" && installed != "":
+			upgrade = append(upgrade, name)
+		case installed == "":
+			todo = append(todo, name)
+		}
+	}
+	for _, f := range staged {
+		if before[f.name] != f.version {
+			todo = append(todo, f.path)
+			withFiles = true
+		}
+	}
+	if len(upgrade) > 0 && ctx.Check && !m.upgradable(ctx, upgrade) {
+		upgrade = nil
+	}
+
+	if len(todo)+len(upgrade) == 0 {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: fmt.Sprintf("%s already %s", strings.Join(append(pkgs, files...), ", "), stateWord(state)), Data: data}
+	}
+	if ctx.Check {
+		verb := "install"
+		if state == "absent" {
+			verb = "remove"
+		}
+		var plan []string
+		if len(todo) > 0 {
+			plan = append(plan, fmt.Sprintf("%s %s", verb, strings.Join(todo, ", ")))
+		}
+		if len(upgrade) > 0 {
+			plan = append(plan, "upgrade "+strings.Join(upgrade, ", "))
+		}
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED", Output: "would " + strings.Join(plan, " and "), Data: data}
+	}
+
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, m.command(state, todo, upgrade, pinned, withFiles))
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+	after, err := m.versions(ctx, bases)
+	if err != nil {
+		return fail(err)
+	}
+	data["packages"] = versionData(after)
+	if equalVersions(before, after) {
+		res.ReturnMsg = "OK"
+	}
+	res.Data = data
+	return res
+}
+
+// command builds the transaction: todo is installed (or removed for
+// state=absent) and upgrade, installed packages wanted at their latest
+// version, is upgraded.
+func (m pkgManager) command(state string, todo, upgrade []string, pinned, withFiles bool) string {
+	var cmds []string
+	if len(todo) > 0 {
+		cmd := m.install
+		if state == "absent" {
+			cmd = m.remove
+		}
+		if pinned && m.pinFlags != "" {
+			cmd += " " + m.pinFlags
+		}
+		if withFiles && m.fileFlags != "" {
+			cmd += " " + m.fileFlags
+		}
+		cmds = append(cmds, cmd+" "+quoteAll(todo))
+	}
+	if len(upgrade) > 0 {
+		cmds = append(cmds, m.upgrade+" "+quoteAll(upgrade))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// upgradable predicts in check mode whether upgrading the installed
+// packages would change anything.
+func (m pkgManager) upgradable(ctx Context, names []string) bool {
+	return ssh.RunShellCommand(ctx.Ctx, ctx.Host, fmt.Sprintf(m.pending, quoteAll(names))).ReturnMsg == "CHANGED"
+}
+
+func stateWord(state string) string {
+	switch state {
+	case "absent", "removed":
+		return "absent"
+	case "latest":
+		return "at the latest version"
+	}
+	return "installed"
+}
+
+func versionData(v map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(v))
+	for k, ver := range v {
+		out[k] = ver
+	}
+	return out
+}
+
+func equalVersions(a, b map[string]string) bool {
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
@@ -0,0 +1,349 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// 外部模块（插件）协议 v1：
+//
+// 插件是控制端上的可执行文件。每次执行任务时以 JSON 请求写入其 stdin：
+//
+//	{"protocol": 1, "module": "consul_kv", "args": {...}, "task": {"name": "..."},
+//	 "host": {"name": "web1", "address": "10.0.0.1", ...}, "vars": {...},
+//	 "check": false, "diff": false}
+//
+// 插件在 stdout 输出一个 JSON 结果：
+//
+//	{"changed": true, "failed": false, "skipped": false, "msg": "...", "rc": 0,
+//	 "stdout": "...", "stderr": "...", "diff": {"before": "...", "after": "..."}, ...}
+//
+// 其余字段原样合并到 register 的结果中。
+
+// PluginProtocol is the version of the external module protocol.
+const PluginProtocol = 1
+
+// ManifestFile describes a plugin installed as a directory.
+const ManifestFile = "plugin.yml"
+
+// PluginDirs are searched in order for plugins; the first plugin found with
+// a given name wins.
+var PluginDirs []string
+
+// Plugin is an external module discovered in a plugins directory. It is
+// either a bare executable named after the module, or a directory holding
+// a plugin.yml manifest and the executable.
+type Plugin struct {
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Author      string `yaml:"author,omitempty"`
+	// Exec is the executable in the plugin directory (default: Name).
+	Exec string `yaml:"exec,omitempty"`
+	// CheckMode tells that the plugin honours "check" in the request. Other
+	// plugins are skipped in check mode.
+	CheckMode bool `yaml:"check_mode,omitempty"`
+	// Options documents the module arguments, shown by `xconfig plugin info`.
+	Options map[string]string `yaml:"options,omitempty"`
+
+	// Path is the plugin executable; Dir is the directory it was found in.
+	Path string `yaml:"-"`
+	Dir  string `yaml:"-"`
+}
+
+// DiscoverPlugins lists the plugins in dirs, sorted by name. Missing
+// directories are ignored; a broken plugin is reported in the error while
+// the others are still returned.
+func DiscoverPlugins(dirs []string) ([]Plugin, error) {
+	seen := make(map[string]bool)
+	var plugins []Plugin
+	var errs []error
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			p, err := LoadPlugin(filepath.Join(dir, e.Name()))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if seen[p.Name] {
+				continue
+			}
+			seen[p.Name] = true
+			p.Dir = dir
+			plugins = append(plugins, p)
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, errors.Join(errs...)
+}
+
+var (
+	pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	pluginExecPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// checkPluginName rejects module names that are not plain identifiers; the
+// name is used as a path in the plugins directory.
+func checkPluginName(name string) error {
+	if !pluginNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q: use letters, digits, '_' and '-'", name)
+	}
+	return nil
+}
+
+// LoadPlugin reads the plugin at path: an executable file or a directory
+// with a manifest.
+func LoadPlugin(path string) (Plugin, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Plugin{}, err
+	}
+	if !info.IsDir() {
+		if info.Mode()&0o111 == 0 {
+			return Plugin{}, fmt.Errorf("plugin %s is not executable", path)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err := checkPluginName(name); err != nil {
+			return Plugin{}, fmt.Errorf("plugin %s: %w", path, err)
+		}
+		return Plugin{Name: name, Path: path}, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, ManifestFile))
+	if err != nil {
+		return Plugin{}, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	var p Plugin
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Plugin{}, fmt.Errorf("plugin %s: %s: %w", path, ManifestFile, err)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(path)
+	}
+	if p.Exec == "" {
+		p.Exec = p.Name
+	}
+	// The manifest may come from a downloaded archive: keep both inside
+	// the plugin directory.
+	if err := checkPluginName(p.Name); err != nil {
+		return Plugin{}, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	if !pluginExecPattern.MatchString(p.Exec) || strings.Contains(p.Exec, "..") {
+		return Plugin{}, fmt.Errorf("plugin %s: invalid exec %q: must be a file name in the plugin directory", path, p.Exec)
+	}
+	p.Path = filepath.Join(path, p.Exec)
+	if info, err := os.Stat(p.Path); err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+		return Plugin{}, fmt.Errorf("plugin %s: %s is not an executable file", p.Name, p.Path)
+	}
+	return p, nil
+}
+
+// LoadPlugins registers every plugin in PluginDirs as a module. Built-in
+// modules keep precedence; the names of shadowed plugins are returned.
+func LoadPlugins() ([]string, error) {
+	plugins, err := DiscoverPlugins(PluginDirs)
+	var shadowed []string
+	for _, p := range plugins {
+		if _, ok := registry[p.Name]; ok && !pluginHandlers[p.Name] {
+			shadowed = append(shadowed, p.Name)
+			continue
+		}
+		registerPlugin(p)
+	}
+	return shadowed, err
+}
+
+// pluginHandlers records the registry entries that belong to plugins.
+var pluginHandlers = make(map[string]bool)
+
+func registerPlugin(p Plugin) {
+	pluginHandlers[p.Name] = true
+	Register(p.Name, func(ctx Context, task parser.Task) ssh.CommandResult {
+		return runPlugin(ctx, p, task)
+	})
+}
+
+type pluginRequest struct {
+	Protocol int                    `json:"protocol"`
+	Module   string                 `json:"module"`
+	Args     interface{}            `json:"args"`
+	Task     pluginTask             `json:"task"`
+	Host     pluginHost             `json:"host"`
+	Vars     map[string]interface{} `json:"vars"`
+	Check    bool                   `json:"check"`
+	Diff     bool                   `json:"diff"`
+}
+
+type pluginTask struct {
+	Name string `json:"name"`
+}
+
+type pluginHost struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Port    string   `json:"port"`
+	User    string   `json:"user"`
+	Groups  []string `json:"groups"`
+}
+
+// pluginResponse holds the fields of a result that xconfig interprets.
+type pluginResponse struct {
+	Changed bool        `json:"changed"`
+	Failed  bool        `json:"failed"`
+	Skipped bool        `json:"skipped"`
+	Msg     string      `json:"msg"`
+	RC      *int        `json:"rc"`
+	Stdout  string      `json:"stdout"`
+	Stderr  string      `json:"stderr"`
+	Diff    interface{} `json:"diff"`
+}
+
+// reservedResultKeys are set by xconfig in the registered result.
+var reservedResultKeys = map[string]bool{
+	"changed": true, "failed": true, "skipped": true, "msg": true, "rc": true,
+	"stdout": true, "stderr": true, "diff": true,
+}
+
+func runPlugin(ctx Context, p Plugin, task parser.Task) ssh.CommandResult {
+	host := ctx.Host.Name
+	if ctx.Check && !p.CheckMode {
+		return ssh.CommandResult{Host: host, ReturnMsg: "SKIPPED", Output: fmt.Sprintf("plugin %s does not support check mode", p.Name)}
+	}
+
+	args := task.ExternalArgs()
+	if s, ok := args.(string); ok {
+		if kv := parser.ModuleArgs(s); kv != nil {
+			args = kv
+		}
+	}
+	req := pluginRequest{
+		Protocol: PluginProtocol,
+		Module:   p.Name,
+		Args:     args,
+		Task:     pluginTask{Name: task.Name},
+		Host:     pluginHost{Name: host, Address: ctx.Host.Address, Port: ctx.Host.Port, User: ctx.Host.User, Groups: ctx.Host.Groups},
+		Vars:     ctx.Vars,
+		Check:    ctx.Check,
+		Diff:     ctx.Diff,
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("plugin %s: encode request: %v", p.Name, err)}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx.Ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("XCONFIG_PLUGIN_PROTOCOL=%d", PluginProtocol))
+	runErr := cmd.Run()
+	if ctx.Ctx.Err() != nil {
+		return ssh.Interrupted(ctx.Host, ctx.Ctx.Err(), ssh.CommandResult{Output: strings.TrimSpace(stderr.String())})
+	}
+
+	var resp pluginResponse
+	var fields map[string]interface{}
+	out := bytes.TrimSpace(stdout.Bytes())
+	if err := json.Unmarshal(out, &resp); err != nil || json.Unmarshal(out, &fields) != nil {
+		msg := fmt.Sprintf("plugin %s returned invalid JSON", p.Name)
+		if runErr != nil {
+			msg = fmt.Sprintf("plugin %s: %v", p.Name, runErr)
+		}
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			msg += "\n" + s
+		}
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: exitStatus(runErr), Output: msg, Stdout: stdout.String(), Stderr: stderr.String()}
+	}
+
+	res := ssh.CommandResult{Host: host, Output: resp.Msg, Stdout: resp.Stdout, Stderr: resp.Stderr}
+	if resp.RC != nil {
+		res.ReturnCode = *resp.RC
+	}
+	if runErr != nil && !resp.Failed {
+		resp.Failed = true
+		res.ReturnCode = exitStatus(runErr)
+		if res.Output == "" {
+			res.Output = fmt.Sprintf("plugin %s: %v", p.Name, runErr)
+		}
+	}
+	switch {
+	case resp.Failed:
+		res.ReturnMsg = "FAILED"
+		if res.ReturnCode == 0 {
+			res.ReturnCode = 1
+		}
+	case resp.Skipped:
+		res.ReturnMsg = "SKIPPED"
+	case resp.Changed:
+		res.ReturnMsg = "CHANGED"
+	default:
+		res.ReturnMsg = "OK"
+	}
+	if res.Output == "" {
+		res.Output = strings.TrimRight(resp.Stdout, "\n")
+	}
+	if ctx.Diff && res.ReturnMsg == "CHANGED" {
+		if d := pluginDiff(resp.Diff); d != "" {
+			res.Output = d
+		}
+	}
+
+	for k, v := range fields {
+		if reservedResultKeys[k] {
+			continue
+		}
+		if res.Data == nil {
+			res.Data = make(map[string]interface{})
+		}
+		res.Data[k] = v
+	}
+	return res
+}
+
+// pluginDiff renders the diff of a plugin result: either ready-made text or
+// {"before": ..., "after": ..., "path": ...}.
+func pluginDiff(d interface{}) string {
+	switch d := d.(type) {
+	case string:
+		return d
+	case map[string]interface{}:
+		before, _ := d["before"].(string)
+		after, _ := d["after"].(string)
+		path, _ := d["path"].(string)
+		if before == after {
+			return ""
+		}
+		return ssh.Diff(before, after, path)
+	}
+	return ""
+}
+
+func exitStatus(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InstallOptions tune InstallPlugin.
+type InstallOptions struct {
+	// Name overrides the module name of a bare executable.
+	Name string
+	// Force replaces an installed plugin with the same name.
+	Force bool
+}
+
+// InstallPlugin installs the plugin at src into dir. src is an executable,
+// a plugin directory with a manifest, or a .tar.gz/.tgz archive of either;
+// executables and archives may also be http(s) URLs.
+func InstallPlugin(ctx context.Context, src, dir string, opts InstallOptions) (Plugin, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Plugin{}, err
+	}
+	stage, err := os.MkdirTemp(dir, ".install-")
+	if err != nil {
+		return Plugin{}, err
+	}
+	defer os.RemoveAll(stage)
+
+	local := src
+	if isURL(src) {
+		if local, err = download(ctx, src, stage); err != nil {
+			return Plugin{}, err
+		}
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return Plugin{}, err
+	}
+
+	var staged string
+	switch {
+	case info.IsDir():
+		staged = filepath.Join(stage, "plugin")
+		if err := copyTree(local, staged); err != nil {
+			return Plugin{}, err
+		}
+	case isArchive(src):
+		root := filepath.Join(stage, "unpacked")
+		if err := extractTarGz(local, root); err != nil {
+			return Plugin{}, fmt.Errorf("%s: %w", src, err)
+		}
+		if staged, err = archiveRoot(root); err != nil {
+			return Plugin{}, fmt.Errorf("%s: %w", src, err)
+		}
+	default:
+		name := opts.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+		}
+		if err := checkPluginName(name); err != nil {
+			return Plugin{}, err
+		}
+		staged = filepath.Join(stage, name)
+		if err := copyFile(local, staged, 0o755); err != nil {
+			return Plugin{}, err
+		}
+	}
+
+	p, err := LoadPlugin(staged)
+	if err != nil {
+		return Plugin{}, err
+	}
+	if _, ok := registry[p.Name]; ok && !pluginHandlers[p.Name] {
+		return Plugin{}, fmt.Errorf("plugin %s would be shadowed by the built-in module of the same name", p.Name)
+	}
+
+	dest := filepath.Join(dir, p.Name)
+	if rel, err := filepath.Rel(dir, dest); err != nil || rel != p.Name {
+		return Plugin{}, fmt.Errorf("plugin %s would be installed outside %s", p.Name, dir)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		if !opts.Force {
+			return Plugin{}, fmt.Errorf("plugin %s is already installed in %s (use --force to replace it)", p.Name, dir)
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			return Plugin{}, err
+		}
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		return Plugin{}, err
+	}
+	p, err = LoadPlugin(dest)
+	p.Dir = dir
+	return p, err
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func isArchive(s string) bool {
+	return strings.HasSuffix(s, ".tar.gz") || strings.HasSuffix(s, ".tgz")
+}
+
+func download(ctx context.Context, url, dir string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	path := filepath.Join(dir, "download")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// archiveRoot finds the plugin in an unpacked archive: the archive root
+// itself or its only top-level directory.
+func archiveRoot(root string) (string, error) {
+	if _, err := os.Stat(filepath.Join(root, ManifestFile)); err == nil {
+		return root, nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(root, entries[0].Name()), nil
+	}
+	return "", fmt.Errorf("archive does not contain a %s", ManifestFile)
+}
+
+func extractTarGz(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, hdr.Name)
+		if rel, err := filepath.Rel(dest, target); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("unsafe path %q in archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry %q in archive", hdr.Name)
+		}
+	}
+}
+
+func copyTree(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("unsupported file %s in plugin", path)
+		}
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dest, mode)
+}
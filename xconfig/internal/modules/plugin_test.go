@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+	"xconfig/internal/inventory"
+)
+
+// echoPlugin answers with a fixed result and echoes the request back under
+// "request".
+const echoPlugin = `#!/bin/sh
+input=$(cat)
+printf '{"changed": true, "msg": "applied", "backend": "web", "request": %s}' "$input"
+`
+
+func writeExec(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func usePluginDirs(t *testing.T, dirs ...string) {
+	t.Helper()
+	PluginDirs = dirs
+	t.Cleanup(func() {
+		for name := range pluginHandlers {
+			delete(registry, name)
+			delete(pluginHandlers, name)
+		}
+		PluginDirs = nil
+	})
+}
+
+func TestDiscoverPlugins(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeExec(t, filepath.Join(first, "consul_kv.sh"), echoPlugin)
+	writeExec(t, filepath.Join(first, "haproxy", "haproxy-module"), echoPlugin)
+	writeExec(t, filepath.Join(first, "haproxy", ManifestFile), `name: haproxy_backend
+version: 1.2.0
+description: Manage HAProxy backend servers
+exec: haproxy-module
+check_mode: true
+options:
+  backend: backend name
+`)
+	if err := os.WriteFile(filepath.Join(first, "README.md"), []byte("docs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeExec(t, filepath.Join(second, "consul_kv"), echoPlugin)
+
+	plugins, err := DiscoverPlugins([]string{first, second, filepath.Join(first, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "README.md is not executable") {
+		t.Fatalf("expected the non-executable file to be reported, got %v", err)
+	}
+	if len(plugins) != 2 {
+		t.Fatalf("expected 2 plugins, got %+v", plugins)
+	}
+	kv, haproxy := plugins[0], plugins[1]
+	if kv.Name != "consul_kv" || kv.Dir != first {
+		t.Fatalf("expected the first directory to win, got %+v", kv)
+	}
+	if haproxy.Name != "haproxy_backend" || haproxy.Version != "1.2.0" || !haproxy.CheckMode ||
+		haproxy.Path != filepath.Join(first, "haproxy", "haproxy-module") || haproxy.Options["backend"] == "" {
+		t.Fatalf("unexpected manifest plugin: %+v", haproxy)
+	}
+}
+
+func TestRunPlugin(t *testing.T) {
+	dir := t.TempDir()
+	writeExec(t, filepath.Join(dir, "echo_mod"), echoPlugin)
+	writeExec(t, filepath.Join(dir, "broken_mod"), "#!/bin/sh\necho 'backend unreachable' >&2\nexit 3\n")
+	writeExec(t, filepath.Join(dir, "diff_mod", ManifestFile), "name: diff_mod\ncheck_mode: true\n")
+	writeExec(t, filepath.Join(dir, "diff_mod", "diff_mod"), `#!/bin/sh
+cat >/dev/null
+cat <<'EOF'
+{"changed": true, "msg": "would set key", "diff": {"before": "a\n", "after": "b\n", "path": "kv/app"}}
+EOF
+`)
+	usePluginDirs(t, dir)
+	if shadowed, err := LoadPlugins(); err != nil || len(shadowed) != 0 {
+		t.Fatalf("LoadPlugins = %v, %v", shadowed, err)
+	}
+
+	host := inventory.Host{Name: "web1", Address: "10.0.0.1"}
+	ctx := Context{Ctx: context.Background(), Host: host, Vars: map[string]interface{}{"env": "prod"}}
+	task := parser.Task{Name: "drain", External: map[string]interface{}{"echo_mod": "backend=web server=web1"}}
+
+	h, ok := GetHandler(task.Type())
+	if !ok {
+		t.Fatalf("plugin echo_mod not registered")
+	}
+	res := h(ctx, task)
+	if res.ReturnMsg != "CHANGED" || res.Output != "applied" || res.Data["backend"] != "web" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	req := res.Data["request"].(map[string]interface{})
+	args := req["args"].(map[string]interface{})
+	if req["module"] != "echo_mod" || args["server"] != "web1" || req["vars"].(map[string]interface{})["env"] != "prod" ||
+		req["host"].(map[string]interface{})["address"] != "10.0.0.1" || req["task"].(map[string]interface{})["name"] != "drain" {
+		t.Fatalf("unexpected request: %v", req)
+	}
+
+	ctx.Check = true
+	if res := h(ctx, task); res.ReturnMsg != "SKIPPED" {
+		t.Fatalf("expected a plugin without check_mode to be skipped, got %+v", res)
+	}
+	ctx.Check = false
+
+	broken, _ := GetHandler("broken_mod")
+	res = broken(ctx, parser.Task{External: map[string]interface{}{"broken_mod": nil}})
+	if res.ReturnMsg != "FAILED" || res.ReturnCode != 3 || !strings.Contains(res.Output, "backend unreachable") {
+		t.Fatalf("unexpected failure result: %+v", res)
+	}
+
+	ctx.Check, ctx.Diff = true, true
+	withDiff, _ := GetHandler("diff_mod")
+	res = withDiff(ctx, parser.Task{External: map[string]interface{}{"diff_mod": map[string]interface{}{"key": "app"}}})
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "-a") || !strings.Contains(res.Output, "+b") {
+		t.Fatalf("expected a diff, got %+v", res)
+	}
+}
+
+func TestLoadPluginsKeepsBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	writeExec(t, filepath.Join(dir, "debug"), echoPlugin)
+	usePluginDirs(t, dir)
+
+	shadowed, err := LoadPlugins()
+	if err != nil || len(shadowed) != 1 || shadowed[0] != "debug" {
+		t.Fatalf("LoadPlugins = %v, %v", shadowed, err)
+	}
+	h, _ := GetHandler("debug")
+	res := h(Context{Ctx: context.Background()}, parser.Task{Debug: &parser.MessageAction{Msg: "hi"}})
+	if res.Output != "hi" {
+		t.Fatalf("built-in debug was replaced: %+v", res)
+	}
+}
+
+func TestInstallPlugin(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	exe := filepath.Join(src, "consul-kv.sh")
+	writeExec(t, exe, echoPlugin)
+
+	p, err := InstallPlugin(context.Background(), exe, dir, InstallOptions{Name: "consul_kv"})
+	if err != nil {
+		t.Fatalf("InstallPlugin: %v", err)
+	}
+	if p.Name != "consul_kv" || p.Path != filepath.Join(dir, "consul_kv") {
+		t.Fatalf("unexpected plugin: %+v", p)
+	}
+	if _, err := InstallPlugin(context.Background(), exe, dir, InstallOptions{Name: "consul_kv"}); err == nil {
+		t.Fatalf("expected reinstalling without force to fail")
+	}
+	if _, err := InstallPlugin(context.Background(), exe, dir, InstallOptions{Name: "consul_kv", Force: true}); err != nil {
+		t.Fatalf("InstallPlugin --force: %v", err)
+	}
+
+	archive := filepath.Join(src, "haproxy.tar.gz")
+	writeTarGz(t, archive, map[string]string{
+		"haproxy/" + ManifestFile: "name: haproxy_backend\nversion: 0.1.0\n",
+		"haproxy/haproxy_backend": echoPlugin,
+	})
+	p, err = InstallPlugin(context.Background(), archive, dir, InstallOptions{})
+	if err != nil {
+		t.Fatalf("InstallPlugin archive: %v", err)
+	}
+	if p.Name != "haproxy_backend" || p.Version != "0.1.0" || p.Path != filepath.Join(dir, "haproxy_backend", "haproxy_backend") {
+		t.Fatalf("unexpected plugin: %+v", p)
+	}
+
+	evil := filepath.Join(src, "evil.tgz")
+	writeTarGz(t, evil, map[string]string{"../escape": "x"})
+	if _, err := InstallPlugin(context.Background(), evil, dir, InstallOptions{}); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Fatalf("expected unsafe archive to be rejected, got %v", err)
+	}
+	// Names and exec paths from a manifest must stay inside the plugins
+	// directory, even with --force.
+	victim := filepath.Join(filepath.Dir(dir), "victim")
+	if err := os.MkdirAll(victim, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for manifest, file := range map[string]string{
+		"name: ../victim\n":                  "plugin",
+		"name: ok_mod\nexec: ../../victim\n": "plugin",
+	} {
+		bad := filepath.Join(src, "bad.tgz")
+		writeTarGz(t, bad, map[string]string{"bad/" + ManifestFile: manifest, "bad/" + file: echoPlugin})
+		if _, err := InstallPlugin(context.Background(), bad, dir, InstallOptions{Force: true}); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected manifest %q to be rejected, got %v", manifest, err)
+		}
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Fatalf("directory outside the plugins dir was touched: %v", err)
+	}
+	if _, err := InstallPlugin(context.Background(), exe, dir, InstallOptions{Name: "../victim", Force: true}); err == nil || !strings.Contains(err.Error(), "invalid plugin name") {
+		t.Fatalf("expected --name with a path to be rejected, got %v", err)
+	}
+	if _, err := InstallPlugin(context.Background(), exe, dir, InstallOptions{Name: "shell"}); err == nil {
+		t.Fatalf("expected a plugin named like a built-in to be rejected")
+	}
+
+	plugins, err := DiscoverPlugins([]string{dir})
+	if err != nil || len(plugins) != 2 {
+		t.Fatalf("DiscoverPlugins after install = %+v, %v", plugins, err)
+	}
+}
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,7 +6,10 @@ import (
 )
 
 func scriptHandler(ctx Context, task parser.Task) ssh.CommandResult {
-	return ssh.RunRemoteScript(ctx.Host, task.Script)
+	if ctx.Check {
+		return checkSkipped(ctx)
+	}
+	return ssh.RunRemoteScript(ctx.Ctx, ctx.Host, task.Script)
 }
 
 func init() {
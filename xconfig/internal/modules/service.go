@@ -1,28 +1,249 @@
 package modules
 
 import (
+	"fmt"
+	"strings"
+
 	"xconfig/core/parser"
 	"xconfig/internal/ssh"
-	"fmt"
 )
 
+// serviceMgrScript prints the init system of the host; setup reports it as
+// the service_mgr fact.
+const serviceMgrScript = `if [ -d /run/systemd/system ]; then echo systemd; elif command -v openrc >/dev/null 2>&1; then echo openrc; elif command -v launchctl >/dev/null 2>&1; then echo launchd; else cat /proc/1/comm 2>/dev/null; fi`
+
+// serviceMgrName maps the output of serviceMgrScript to a serviceManagers key.
+func serviceMgrName(out string) string {
+	switch name := strings.TrimSpace(out); name {
+	case "":
+		return "unknown"
+	case "init":
+		return "sysvinit"
+	default:
+		return name
+	}
+}
+
+// serviceManager holds the commands of one init system as format strings of
+// the quoted service name.
+type serviceManager struct {
+	name string
+	// active prints active or inactive; enabled prints the boot state, which
+	// is unknown when it is empty.
+	active, enabled string
+	// control runs a verb, the second argument, on the service.
+	control string
+	// enable and disable change the boot state; empty when the init system
+	// has no notion of it that xconfig understands.
+	enable, disable string
+}
+
+var (
+	systemdServices = serviceManager{
+		name:    "systemd",
+		active:  `systemctl is-active %[1]s 2>/dev/null`,
+		enabled: `systemctl is-enabled %[1]s 2>/dev/null`,
+		control: `systemctl %[2]s %[1]s`,
+		enable:  `systemctl enable %[1]s`,
+		disable: `systemctl disable %[1]s`,
+	}
+	openrcServices = serviceManager{
+		name:    "openrc",
+		active:  `rc-service %[1]s status >/dev/null 2>&1 && echo active || echo inactive`,
+		enabled: `rc-update show default 2>/dev/null | awk -v s=%[1]s '$1 == s { f = 1 } END { print f ? "enabled" : "disabled" }'`,
+		control: `rc-service %[1]s %[2]s`,
+		enable:  `rc-update add %[1]s default`,
+		disable: `rc-update del %[1]s default`,
+	}
+	// sysvinitServices uses chkconfig on Red Hat style hosts and update-rc.d
+	// on Debian style ones.
+	sysvinitServices = serviceManager{
+		name:   "sysvinit",
+		active: `service %[1]s status >/dev/null 2>&1 && echo active || echo inactive`,
+		enabled: `if command -v chkconfig >/dev/null 2>&1; then chkconfig --list %[1]s 2>/dev/null | grep -q ':on' && echo enabled || echo disabled; ` +
+			`else ls /etc/rc[2345].d/S[0-9][0-9]%[1]s >/dev/null 2>&1 && echo enabled || echo disabled; fi`,
+		control: `service %[1]s %[2]s`,
+		enable:  `if command -v chkconfig >/dev/null 2>&1; then chkconfig %[1]s on; else update-rc.d %[1]s defaults && update-rc.d %[1]s enable; fi`,
+		disable: `if command -v chkconfig >/dev/null 2>&1; then chkconfig %[1]s off; else update-rc.d %[1]s disable; fi`,
+	}
+)
+
+var serviceManagers = map[string]serviceManager{
+	"systemd":  systemdServices,
+	"openrc":   openrcServices,
+	"sysvinit": sysvinitServices,
+}
+
+// detectServiceManager picks the manager named by the service_mgr fact or
+// the one found on the host. Init systems without boot state support still
+// start and stop services with the service command.
+func detectServiceManager(ctx Context) (serviceManager, error) {
+	name, _ := ctx.Vars["ansible_service_mgr"].(string)
+	if name == "" || name == "unknown" {
+		res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, serviceMgrScript)
+		if res.ReturnMsg != "CHANGED" {
+			return serviceManager{}, fmt.Errorf("detect service manager: %s", strings.TrimSpace(res.Output))
+		}
+		name = serviceMgrName(res.Stdout)
+	}
+	if m, ok := serviceManagers[name]; ok {
+		return m, nil
+	}
+	return serviceManager{
+		name:    name,
+		active:  sysvinitServices.active,
+		control: sysvinitServices.control,
+	}, nil
+}
+
+// command returns the command that runs verb on the service.
+func (m serviceManager) command(name, verb string) string {
+	return fmt.Sprintf(m.control, ssh.ShellQuote(name), verb)
+}
+
+// boot returns the command that enables or disables the service at boot.
+func (m serviceManager) boot(name string, enable bool) (string, error) {
+	cmd := m.disable
+	if enable {
+		cmd = m.enable
+	}
+	if cmd == "" {
+		return "", fmt.Errorf("enabled is not supported with service manager %s", m.name)
+	}
+	return fmt.Sprintf(cmd, ssh.ShellQuote(name)), nil
+}
+
 func serviceHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Service == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing service parameters"}
 	}
-	var before string
-	if ctx.Diff {
-		before = ssh.RunShellCommand(ctx.Host, fmt.Sprintf("sudo service %s status || true", task.Service.Name)).Output
+	m, err := detectServiceManager(ctx)
+	if err != nil {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	return manageService(ctx, m, task.Service)
+}
+
+// serviceVerbs maps Ansible style states (and the plain verbs older
+// playbooks used) to the command verb.
+var serviceVerbs = map[string]string{
+	"started": "start", "start": "start",
+	"stopped": "stop", "stop": "stop",
+	"restarted": "restart", "restart": "restart",
+	"reloaded": "reload", "reload": "reload",
+}
+
+// serviceStatus is the observed state of a unit.
+type serviceStatus struct {
+	Active  string
+	Enabled string
+}
+
+func (s serviceStatus) String() string {
+	return fmt.Sprintf("active: %s\nenabled: %s\n", s.Active, s.Enabled)
+}
+
+// fixedBootStates are systemd unit states that enable and disable cannot
+// change: static and generated units have no [Install] section, indirect
+// ones are pulled in by other units and aliases follow their unit. They
+// count as enabled.
+var fixedBootStates = map[string]bool{"static": true, "indirect": true, "generated": true, "transient": true, "alias": true}
+
+// bootChange reports whether the boot state must change for want.
+func bootChange(state string, want bool) bool {
+	if fixedBootStates[state] {
+		return false
+	}
+	enabled := state == "enabled" || state == "enabled-runtime"
+	return enabled != want
+}
+
+// queryService reads the current state with the commands of m.
+func queryService(ctx Context, m serviceManager, name string) (serviceStatus, error) {
+	q := ssh.ShellQuote(name)
+	enabled := "echo unknown"
+	if m.enabled != "" {
+		enabled = fmt.Sprintf(m.enabled, q)
+	}
+	cmd := fmt.Sprintf(`echo "$(%s)"; echo "$(%s)"`, fmt.Sprintf(m.active, q), enabled)
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+	if res.ReturnMsg != "CHANGED" {
+		return serviceStatus{}, fmt.Errorf("query service %s: %s", name, strings.TrimSpace(res.Output))
+	}
+	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
+	st := serviceStatus{Active: "unknown", Enabled: "unknown"}
+	if len(lines) > 0 && lines[0] != "" {
+		st.Active = strings.TrimSpace(lines[0])
+	}
+	if len(lines) > 1 && lines[1] != "" {
+		st.Enabled = strings.TrimSpace(lines[1])
+	}
+	return st, nil
+}
+
+// manageService compares the desired state of svc with the host and only
+// runs the commands of m needed to converge. restarted and reloaded always
+// act.
+func manageService(ctx Context, m serviceManager, svc *parser.ServiceAction) ssh.CommandResult {
+	fail := func(msg string) ssh.CommandResult {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: msg}
+	}
+	before, err := queryService(ctx, m, svc.Name)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	var cmds []string
+	predicted := before
+	if svc.State != "" {
+		verb, ok := serviceVerbs[svc.State]
+		if !ok {
+			return fail(fmt.Sprintf("unsupported service state %q", svc.State))
+		}
+		active := before.Active == "active"
+		if (verb == "start" && !active) || (verb == "stop" && active) || verb == "restart" || verb == "reload" {
+			cmds = append(cmds, m.command(svc.Name, verb))
+			predicted.Active = "active"
+			if verb == "stop" {
+				predicted.Active = "inactive"
+			}
+		}
+	}
+	if svc.Enabled != nil && bootChange(before.Enabled, *svc.Enabled) {
+		cmd, err := m.boot(svc.Name, *svc.Enabled)
+		if err != nil {
+			return fail(err.Error())
+		}
+		cmds = append(cmds, cmd)
+		predicted.Enabled = "disabled"
+		if *svc.Enabled {
+			predicted.Enabled = "enabled"
+		}
+	}
+
+	data := map[string]interface{}{"name": svc.Name, "state": before.Active, "enabled": before.Enabled}
+	if len(cmds) == 0 {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: before.String(), Data: data}
+	}
+	if ctx.Check {
+		res := ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED", Output: "would run: " + strings.Join(cmds, " && "), Data: data}
+		if ctx.Diff {
+			res.Output = ssh.Diff(before.String(), predicted.String(), svc.Name)
+		}
+		return res
 	}
-	cmd := fmt.Sprintf("sudo service %s %s", task.Service.Name, task.Service.State)
-	if task.Service.Enabled {
-		cmd = fmt.Sprintf("%s && sudo systemctl enable %s", cmd, task.Service.Name)
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, strings.Join(cmds, " && "))
+	if res.ReturnMsg != "CHANGED" {
+		return res
 	}
-	res := ssh.RunShellCommand(ctx.Host, cmd)
-	if ctx.Diff {
-		after := ssh.RunShellCommand(ctx.Host, fmt.Sprintf("sudo service %s status || true", task.Service.Name)).Output
-		res.Output = ssh.Diff(before, after, task.Service.Name)
+	after, err := queryService(ctx, m, svc.Name)
+	if err == nil {
+		data["state"], data["enabled"] = after.Active, after.Enabled
+		if ctx.Diff {
+			res.Output = ssh.Diff(before.String(), after.String(), svc.Name)
+		}
 	}
+	res.Data = data
 	return res
 }
 
@@ -0,0 +1,84 @@
+package modules
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xconfig/internal/ssh"
+)
+
+func TestServiceManagers(t *testing.T) {
+	for out, want := range map[string]string{"systemd\n": "systemd", "init": "sysvinit", "": "unknown"} {
+		if got := serviceMgrName(out); got != want {
+			t.Errorf("serviceMgrName(%q) = %q, want %q", out, got, want)
+		}
+	}
+
+	ctx := Context{Vars: map[string]interface{}{"ansible_service_mgr": "openrc"}}
+	m, err := detectServiceManager(ctx)
+	if err != nil || m.name != "openrc" {
+		t.Fatalf("expected openrc from facts, got %q, %v", m.name, err)
+	}
+	if cmd, _ := m.boot("sshd", true); cmd != "rc-update add 'sshd' default" {
+		t.Errorf("openrc enable = %q", cmd)
+	}
+	if got := m.command("sshd", "restart"); got != "rc-service 'sshd' restart" {
+		t.Errorf("openrc restart = %q", got)
+	}
+	if cmd, _ := systemdServices.boot("nginx", false); cmd != "systemctl disable 'nginx'" {
+		t.Errorf("systemd disable = %q", cmd)
+	}
+	if cmd, _ := sysvinitServices.boot("nginx", true); !strings.Contains(cmd, "chkconfig 'nginx' on") || !strings.Contains(cmd, "update-rc.d 'nginx' defaults") {
+		t.Errorf("sysvinit enable = %q", cmd)
+	}
+
+	ctx.Vars["ansible_service_mgr"] = "launchd"
+	if m, err = detectServiceManager(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.boot("nginx", true); err == nil || !strings.Contains(err.Error(), "launchd") {
+		t.Fatalf("expected enabled to be unsupported on launchd, got %v", err)
+	}
+}
+
+func TestBootChange(t *testing.T) {
+	for _, tc := range []struct {
+		state string
+		want  bool
+		ok    bool
+	}{
+		{"enabled", true, false},
+		{"disabled", true, true},
+		{"enabled", false, true},
+		{"enabled-runtime", false, true},
+		{"static", true, false},
+		{"static", false, false},
+		{"indirect", true, false},
+		{"masked", true, true},
+		{"disabled", false, false},
+	} {
+		if got := bootChange(tc.state, tc.want); got != tc.ok {
+			t.Errorf("bootChange(%q, %v) = %v, want %v", tc.state, tc.want, got, tc.ok)
+		}
+	}
+}
+
+func TestOpenrcEnabledQuery(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf ' sshd | default\\n crond | default\\n'\n"
+	if err := os.WriteFile(filepath.Join(dir, "rc-update"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"sshd": "enabled", "ssh": "disabled"} {
+		cmd := exec.Command("sh", "-c", fmt.Sprintf(openrcServices.enabled, ssh.ShellQuote(name)))
+		cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		out, err := cmd.Output()
+		if err != nil || strings.TrimSpace(string(out)) != want {
+			t.Errorf("%s: got %q, %v, want %s", name, out, err, want)
+		}
+	}
+}
@@ -1,16 +1,497 @@
 package modules
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"xconfig/core/parser"
 	"xconfig/internal/ssh"
 )
 
+var (
+	// FactCacheDir stores gathered facts as <host>.json so later runs can
+	// skip gathering. Empty disables the cache.
+	FactCacheDir string
+	// FactCacheTTL is how long cached facts stay valid.
+	FactCacheTTL = 24 * time.Hour
+)
+
+// factScript collects everything setup needs in one round trip. Each section
+// starts with an "@@name" line; commands missing on the host simply leave
+// their section empty.
+const factScript = `
+echo @@uname; uname -s; uname -r; uname -m; uname -n; hostname -f 2>/dev/null || uname -n
+echo @@os-release; cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release 2>/dev/null
+echo @@nproc; getconf _NPROCESSORS_ONLN 2>/dev/null || nproc 2>/dev/null
+echo @@cpuinfo; grep -E '^(processor|physical id|cpu cores|model name)' /proc/cpuinfo 2>/dev/null
+echo @@meminfo; cat /proc/meminfo 2>/dev/null
+echo @@mounts; cat /proc/mounts 2>/dev/null
+echo @@df; df -P -k 2>/dev/null
+echo @@devices; for d in /sys/block/*; do [ -e "$d/size" ] || continue; echo "${d##*/} $(cat $d/size) $(cat $d/removable 2>/dev/null || echo 0) $(cat $d/queue/rotational 2>/dev/null || echo 0) $(cat $d/device/model 2>/dev/null)"; done
+echo @@link; ip -o link show 2>/dev/null
+echo @@addr; ip -o addr show 2>/dev/null
+echo @@route; ip -4 route show default 2>/dev/null
+echo @@service_mgr; ` + serviceMgrScript + `
+echo @@pkg_mgr; ` + pkgMgrScript + `
+true
+`
+
+// setupHandler gathers facts about the host. They are stored both as
+// ansible_facts.<name> and as top level ansible_<name> variables.
 func setupHandler(ctx Context, task parser.Task) ssh.CommandResult {
-	res := ssh.RunShellCommand(ctx.Host, "uname -a")
-	ctx.Vars["ansible_facts"] = res.Output
+	facts, cached := loadCachedFacts(ctx.Host.Name)
+	var res ssh.CommandResult
+	if cached {
+		res = ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: "facts loaded from cache"}
+	} else {
+		res = ssh.RunShellCommand(ctx.Ctx, ctx.Host, factScript)
+		if res.ReturnMsg != "CHANGED" {
+			return res
+		}
+		facts = parseFacts(res.Stdout)
+		storeCachedFacts(ctx.Host.Name, facts)
+		res.ReturnMsg = "OK"
+		res.Output = fmt.Sprintf("%v %v (%v), %v vCPUs, %v MB memory",
+			facts["distribution"], facts["distribution_version"], facts["architecture"], facts["processor_vcpus"], facts["memtotal_mb"])
+	}
+
+	ctx.Vars["ansible_facts"] = facts
+	for k, v := range facts {
+		ctx.Vars["ansible_"+k] = v
+	}
+	res.Data = map[string]interface{}{"ansible_facts": facts}
 	return res
 }
 
+func factCacheFile(host string) string {
+	return filepath.Join(FactCacheDir, strings.ReplaceAll(host, string(filepath.Separator), "_")+".json")
+}
+
+func loadCachedFacts(host string) (map[string]interface{}, bool) {
+	if FactCacheDir == "" {
+		return nil, false
+	}
+	file := factCacheFile(host)
+	info, err := os.Stat(file)
+	if err != nil || (FactCacheTTL > 0 && time.Since(info.ModTime()) >= FactCacheTTL) {
+		return nil, false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var facts map[string]interface{}
+	if json.Unmarshal(data, &facts) != nil {
+		return nil, false
+	}
+	return facts, true
+}
+
+func storeCachedFacts(host string, facts map[string]interface{}) {
+	if FactCacheDir == "" {
+		return
+	}
+	if data, err := json.MarshalIndent(facts, "", "  "); err == nil {
+		if os.MkdirAll(FactCacheDir, 0o700) == nil {
+			os.WriteFile(factCacheFile(host), data, 0o600)
+		}
+	}
+}
+
+// parseFacts turns the output of factScript into the facts map. Keys carry
+// no "ansible_" prefix.
+func parseFacts(out string) map[string]interface{} {
+	sections := make(map[string][]string)
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "@@") {
+			current = line[2:]
+			continue
+		}
+		if current != "" && strings.TrimSpace(line) != "" {
+			sections[current] = append(sections[current], line)
+		}
+	}
+
+	facts := make(map[string]interface{})
+	unameFacts(facts, sections["uname"])
+	osFacts(facts, sections["os-release"])
+	cpuFacts(facts, sections["nproc"], sections["cpuinfo"])
+	memFacts(facts, sections["meminfo"])
+	facts["mounts"] = mountFacts(sections["mounts"], sections["df"])
+	facts["devices"] = deviceFacts(sections["devices"])
+	networkFacts(facts, sections["link"], sections["addr"], sections["route"])
+
+	facts["service_mgr"] = serviceMgrName(strings.Join(sections["service_mgr"], "\n"))
+	facts["pkg_mgr"] = pkgMgrName(strings.Join(sections["pkg_mgr"], "\n"))
+	return facts
+}
+
+func unameFacts(facts map[string]interface{}, lines []string) {
+	get := func(i int) string {
+		if i < len(lines) {
+			return strings.TrimSpace(lines[i])
+		}
+		return ""
+	}
+	facts["system"] = get(0)
+	facts["kernel"] = get(1)
+	facts["architecture"] = get(2)
+	facts["machine"] = get(2)
+	facts["nodename"] = get(3)
+	facts["hostname"] = strings.SplitN(get(3), ".", 2)[0]
+	facts["fqdn"] = get(4)
+}
+
+// distributions maps os-release IDs to Ansible's distribution names.
+var distributions = map[string]string{
+	"ubuntu": "Ubuntu", "debian": "Debian", "raspbian": "Debian", "linuxmint": "Linux Mint",
+	"rhel": "RedHat", "centos": "CentOS", "fedora": "Fedora", "rocky": "Rocky", "almalinux": "AlmaLinux",
+	"ol": "OracleLinux", "amzn": "Amazon", "alpine": "Alpine", "arch": "Archlinux",
+	"opensuse-leap": "openSUSE Leap", "opensuse-tumbleweed": "openSUSE Tumbleweed", "sles": "SLES",
+}
+
+// osFamilies maps distributions (and ID_LIKE entries) to Ansible's os_family.
+var osFamilies = map[string]string{
+	"Ubuntu": "Debian", "Debian": "Debian", "Linux Mint": "Debian", "debian": "Debian", "ubuntu": "Debian",
+	"RedHat": "RedHat", "CentOS": "RedHat", "Fedora": "RedHat", "Rocky": "RedHat", "AlmaLinux": "RedHat",
+	"OracleLinux": "RedHat", "Amazon": "RedHat", "rhel": "RedHat", "fedora": "RedHat", "centos": "RedHat",
+	"Alpine": "Alpine", "Archlinux": "Archlinux", "arch": "Archlinux",
+	"openSUSE Leap": "Suse", "openSUSE Tumbleweed": "Suse", "SLES": "Suse", "suse": "Suse", "opensuse": "Suse",
+}
+
+func osFacts(facts map[string]interface{}, lines []string) {
+	release := make(map[string]string)
+	for _, line := range lines {
+		k, v, ok := strings.Cut(line, "=")
+		if ok {
+			release[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+		}
+	}
+
+	dist, ok := distributions[release["ID"]]
+	switch {
+	case ok:
+	case release["ID"] != "":
+		dist = strings.ToUpper(release["ID"][:1]) + release["ID"][1:]
+	default:
+		dist, _ = facts["system"].(string)
+	}
+	family, ok := osFamilies[dist]
+	if !ok {
+		family = dist
+		for _, like := range strings.Fields(release["ID_LIKE"]) {
+			if f, ok := osFamilies[like]; ok {
+				family = f
+				break
+			}
+		}
+	}
+
+	version := release["VERSION_ID"]
+	facts["distribution"] = dist
+	facts["os_family"] = family
+	facts["distribution_version"] = version
+	facts["distribution_major_version"] = strings.SplitN(version, ".", 2)[0]
+	facts["distribution_release"] = release["VERSION_CODENAME"]
+}
+
+func cpuFacts(facts map[string]interface{}, nproc, cpuinfo []string) {
+	vcpus, sockets, cores := 0, make(map[string]bool), 0
+	var models []interface{}
+	seen := make(map[string]bool)
+	for _, line := range cpuinfo {
+		k, v, _ := strings.Cut(line, ":")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "processor":
+			vcpus++
+		case "physical id":
+			sockets[v] = true
+		case "cpu cores":
+			cores, _ = strconv.Atoi(v)
+		case "model name":
+			if !seen[v] {
+				seen[v] = true
+				models = append(models, v)
+			}
+		}
+	}
+	if len(nproc) > 0 {
+		if n, err := strconv.Atoi(strings.TrimSpace(nproc[0])); err == nil {
+			vcpus = n
+		}
+	}
+	count := len(sockets)
+	if count == 0 {
+		count = 1
+	}
+	if cores == 0 {
+		cores = vcpus / count
+	}
+	facts["processor_vcpus"] = vcpus
+	facts["processor_count"] = count
+	facts["processor_cores"] = cores
+	facts["processor"] = models
+}
+
+func memFacts(facts map[string]interface{}, lines []string) {
+	kb := make(map[string]int)
+	for _, line := range lines {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if f := strings.Fields(v); len(f) > 0 {
+			kb[k], _ = strconv.Atoi(f[0])
+		}
+	}
+	facts["memtotal_mb"] = kb["MemTotal"] / 1024
+	facts["memfree_mb"] = kb["MemFree"] / 1024
+	facts["memavailable_mb"] = kb["MemAvailable"] / 1024
+	facts["swaptotal_mb"] = kb["SwapTotal"] / 1024
+	facts["swapfree_mb"] = kb["SwapFree"] / 1024
+}
+
+// networkFilesystems are mounts without a device path that are still
+// reported, like Ansible does.
+var networkFilesystems = map[string]bool{"overlay": true, "nfs": true, "nfs4": true, "cifs": true, "zfs": true, "fuse.sshfs": true}
+
+func mountFacts(mounts, df []string) []interface{} {
+	type usage struct{ total, avail int64 }
+	sizes := make(map[string]usage)
+	for _, line := range df {
+		f := strings.Fields(line)
+		if len(f) < 6 || f[0] == "Filesystem" {
+			continue
+		}
+		total, _ := strconv.ParseInt(f[1], 10, 64)
+		avail, _ := strconv.ParseInt(f[3], 10, 64)
+		sizes[strings.Join(f[5:], " ")] = usage{total * 1024, avail * 1024}
+	}
+
+	list := []interface{}{}
+	for _, line := range mounts {
+		f := strings.Fields(line)
+		if len(f) < 4 || !(strings.HasPrefix(f[0], "/") || networkFilesystems[f[2]]) {
+			continue
+		}
+		point := unescapeMount(f[1])
+		m := map[string]interface{}{
+			"device":  unescapeMount(f[0]),
+			"mount":   point,
+			"fstype":  f[2],
+			"options": f[3],
+		}
+		if u, ok := sizes[point]; ok {
+			m["size_total"] = u.total
+			m["size_available"] = u.avail
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// unescapeMount decodes the octal escapes /proc/mounts uses for spaces and
+// other special characters.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func deviceFacts(lines []string) map[string]interface{} {
+	devices := make(map[string]interface{})
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 4 || strings.HasPrefix(f[0], "loop") || strings.HasPrefix(f[0], "ram") {
+			continue
+		}
+		sectors, _ := strconv.ParseInt(f[1], 10, 64)
+		devices[f[0]] = map[string]interface{}{
+			"sectors":    sectors,
+			"size":       humanSize(sectors * 512),
+			"removable":  f[2],
+			"rotational": f[3],
+			"model":      strings.Join(f[4:], " "),
+		}
+	}
+	return devices
+}
+
+func humanSize(bytes int64) string {
+	units := []string{"bytes", "KB", "MB", "GB", "TB", "PB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", size, units[i])
+}
+
+// networkFacts parses `ip -o link`, `ip -o addr` and the default route into
+// interfaces, ansible_<iface> entries, the address lists and default_ipv4.
+func networkFacts(facts map[string]interface{}, links, addrs, routes []string) {
+	ifaces := make(map[string]map[string]interface{})
+	iface := func(name string) map[string]interface{} {
+		if i, ok := ifaces[name]; ok {
+			return i
+		}
+		i := map[string]interface{}{"device": name, "active": false}
+		ifaces[name] = i
+		return i
+	}
+
+	for _, line := range links {
+		// 2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 ... link/ether 52:54:00:12:34:56 brd ...
+		f := strings.Fields(strings.ReplaceAll(line, `\`, " "))
+		if len(f) < 3 {
+			continue
+		}
+		i := iface(ifaceName(f[1]))
+		i["active"] = strings.Contains(","+strings.Trim(f[2], "<>")+",", ",UP,")
+		for n := 3; n+1 < len(f); n++ {
+			switch {
+			case f[n] == "mtu":
+				i["mtu"], _ = strconv.Atoi(f[n+1])
+			case strings.HasPrefix(f[n], "link/"):
+				i["type"] = strings.TrimPrefix(f[n], "link/")
+				if f[n] != "link/loopback" {
+					i["macaddress"] = f[n+1]
+				}
+			}
+		}
+	}
+
+	var all4, all6 []interface{}
+	for _, line := range addrs {
+		// 2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\ valid_lft ...
+		f := strings.Fields(strings.ReplaceAll(line, `\`, " "))
+		if len(f) < 4 {
+			continue
+		}
+		ip, ipnet, err := net.ParseCIDR(f[3])
+		if err != nil {
+			continue
+		}
+		i := iface(ifaceName(f[1]))
+		prefix, _ := ipnet.Mask.Size()
+		switch f[2] {
+		case "inet":
+			addr := map[string]interface{}{
+				"address": ip.String(),
+				"netmask": net.IP(ipnet.Mask).String(),
+				"network": ipnet.IP.String(),
+				"prefix":  strconv.Itoa(prefix),
+			}
+			if _, ok := i["ipv4"]; ok {
+				secondaries, _ := i["ipv4_secondaries"].([]interface{})
+				i["ipv4_secondaries"] = append(secondaries, addr)
+			} else {
+				i["ipv4"] = addr
+			}
+			if !ip.IsLoopback() {
+				all4 = append(all4, ip.String())
+			}
+		case "inet6":
+			scope := ""
+			for n := 4; n+1 < len(f); n++ {
+				if f[n] == "scope" {
+					scope = f[n+1]
+				}
+			}
+			list, _ := i["ipv6"].([]interface{})
+			i["ipv6"] = append(list, map[string]interface{}{"address": ip.String(), "prefix": strconv.Itoa(prefix), "scope": scope})
+			if !ip.IsLoopback() {
+				all6 = append(all6, ip.String())
+			}
+		}
+	}
+
+	names := make([]string, 0, len(ifaces))
+	for name, i := range ifaces {
+		names = append(names, name)
+		facts[strings.NewReplacer("-", "_", ".", "_").Replace(name)] = i
+	}
+	sort.Strings(names)
+	facts["interfaces"] = stringValues(names)
+	facts["all_ipv4_addresses"] = nonNil(all4)
+	facts["all_ipv6_addresses"] = nonNil(all6)
+
+	def := map[string]interface{}{}
+	for _, line := range routes {
+		// default via 10.0.0.1 dev eth0 proto dhcp src 10.0.0.5 metric 100
+		f := strings.Fields(line)
+		if len(f) == 0 || f[0] != "default" {
+			continue
+		}
+		for n := 1; n+1 < len(f); n++ {
+			switch f[n] {
+			case "via":
+				def["gateway"] = f[n+1]
+			case "dev":
+				def["interface"] = f[n+1]
+			}
+		}
+		break
+	}
+	if name, ok := def["interface"].(string); ok {
+		if i, ok := ifaces[name]; ok {
+			if v4, ok := i["ipv4"].(map[string]interface{}); ok {
+				for k, v := range v4 {
+					def[k] = v
+				}
+			}
+			if mac, ok := i["macaddress"]; ok {
+				def["macaddress"] = mac
+			}
+		}
+	}
+	facts["default_ipv4"] = def
+}
+
+// ifaceName strips the trailing colon and the "@peer" suffix veth pairs get.
+func ifaceName(s string) string {
+	s = strings.TrimSuffix(s, ":")
+	if at := strings.Index(s, "@"); at > 0 {
+		s = s[:at]
+	}
+	return s
+}
+
+func stringValues(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
+
+func nonNil(list []interface{}) []interface{} {
+	if list == nil {
+		return []interface{}{}
+	}
+	return list
+}
+
 func init() {
 	Register("setup", setupHandler)
 	Register("gather_facts", setupHandler)
@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const ubuntuFacts = `@@uname
+Linux
+6.8.0-45-generic
+x86_64
+web1.example.com
+web1.example.com
+@@os-release
+NAME="Ubuntu"
+VERSION_ID="24.04"
+VERSION_CODENAME=noble
+ID=ubuntu
+ID_LIKE=debian
+@@nproc
+4
+@@cpuinfo
+processor	: 0
+model name	: AMD EPYC 7B13
+physical id	: 0
+cpu cores	: 2
+processor	: 1
+model name	: AMD EPYC 7B13
+physical id	: 0
+cpu cores	: 2
+@@meminfo
+MemTotal:        8148832 kB
+MemFree:          612340 kB
+MemAvailable:    5922136 kB
+SwapTotal:             0 kB
+SwapFree:              0 kB
+@@mounts
+/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw 0 0
+tmpfs /run tmpfs rw 0 0
+/dev/sdb1 /mnt/data\040disk xfs rw 0 0
+@@df
+Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         40581564 9812336  30752844      25% /
+/dev/sdb1        104857600       0 104857600       0% /mnt/data disk
+@@devices
+loop0 0 0 0
+sda 83886080 0 1 QEMU HARDDISK
+@@link
+1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP mode DEFAULT group default qlen 1000\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+3: veth1@if4: <BROADCAST,MULTICAST> mtu 1500 qdisc noop state DOWN mode DEFAULT group default qlen 1000\    link/ether 0a:58:0a:f4:00:01 brd ff:ff:ff:ff:ff:ff
+@@addr
+1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+1: lo    inet6 ::1/128 scope host \       valid_lft forever preferred_lft forever
+2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet 10.0.0.6/24 brd 10.0.0.255 scope global secondary eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet6 fe80::5054:ff:fe12:3456/64 scope link \       valid_lft forever preferred_lft forever
+@@route
+default via 10.0.0.1 dev eth0 proto dhcp src 10.0.0.5 metric 100
+@@service_mgr
+systemd
+@@pkg_mgr
+apt-get
+`
+
+func TestParseFacts(t *testing.T) {
+	f := parseFacts(ubuntuFacts)
+
+	want := map[string]interface{}{
+		"system": "Linux", "kernel": "6.8.0-45-generic", "architecture": "x86_64",
+		"hostname": "web1", "fqdn": "web1.example.com",
+		"distribution": "Ubuntu", "os_family": "Debian", "distribution_version": "24.04",
+		"distribution_major_version": "24", "distribution_release": "noble",
+		"processor_vcpus": 4, "processor_count": 1, "processor_cores": 2,
+		"memtotal_mb": 7957, "swaptotal_mb": 0, "service_mgr": "systemd",
+		"pkg_mgr": "apt",
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(f[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, f[k], v)
+		}
+	}
+
+	mounts := f["mounts"].([]interface{})
+	if len(mounts) != 2 {
+		t.Fatalf("expected 2 device mounts, got %v", mounts)
+	}
+	data := mounts[1].(map[string]interface{})
+	if data["mount"] != "/mnt/data disk" || data["fstype"] != "xfs" || data["size_total"] != int64(104857600*1024) {
+		t.Fatalf("unexpected mount: %v", data)
+	}
+
+	devices := f["devices"].(map[string]interface{})
+	if _, ok := devices["loop0"]; ok || devices["sda"].(map[string]interface{})["size"] != "40.00 GB" {
+		t.Fatalf("unexpected devices: %v", devices)
+	}
+
+	if !reflect.DeepEqual(f["interfaces"], []interface{}{"eth0", "lo", "veth1"}) {
+		t.Fatalf("unexpected interfaces: %v", f["interfaces"])
+	}
+	eth0 := f["eth0"].(map[string]interface{})
+	if eth0["macaddress"] != "52:54:00:12:34:56" || eth0["active"] != true || eth0["mtu"] != 1500 {
+		t.Fatalf("unexpected eth0: %v", eth0)
+	}
+	if v4 := eth0["ipv4"].(map[string]interface{}); v4["address"] != "10.0.0.5" || v4["netmask"] != "255.255.255.0" {
+		t.Fatalf("unexpected eth0 ipv4: %v", v4)
+	}
+	if len(eth0["ipv4_secondaries"].([]interface{})) != 1 {
+		t.Fatalf("expected one secondary address: %v", eth0)
+	}
+	if f["veth1"].(map[string]interface{})["active"] != false {
+		t.Fatalf("expected veth1 to be down")
+	}
+	if !reflect.DeepEqual(f["all_ipv4_addresses"], []interface{}{"10.0.0.5", "10.0.0.6"}) {
+		t.Fatalf("unexpected ipv4 list: %v", f["all_ipv4_addresses"])
+	}
+	def := f["default_ipv4"].(map[string]interface{})
+	if def["gateway"] != "10.0.0.1" || def["interface"] != "eth0" || def["address"] != "10.0.0.5" {
+		t.Fatalf("unexpected default_ipv4: %v", def)
+	}
+}
+
+func TestParseFactsFamilyFromIDLike(t *testing.T) {
+	f := parseFacts("@@uname\nLinux\n@@os-release\nID=pop\nID_LIKE=\"ubuntu debian\"\nVERSION_ID=22.04\n")
+	if f["distribution"] != "Pop" || f["os_family"] != "Debian" {
+		t.Fatalf("unexpected distribution: %v / %v", f["distribution"], f["os_family"])
+	}
+}
+
+func TestFactCache(t *testing.T) {
+	FactCacheDir = t.TempDir()
+	defer func() { FactCacheDir = "" }()
+
+	if _, ok := loadCachedFacts("web1"); ok {
+		t.Fatalf("expected an empty cache")
+	}
+	storeCachedFacts("web1", map[string]interface{}{"distribution": "Ubuntu"})
+	facts, ok := loadCachedFacts("web1")
+	if !ok || facts["distribution"] != "Ubuntu" {
+		t.Fatalf("expected cached facts, got %v %v", facts, ok)
+	}
+
+	old := time.Now().Add(-2 * FactCacheTTL)
+	os.Chtimes(filepath.Join(FactCacheDir, "web1.json"), old, old)
+	if _, ok := loadCachedFacts("web1"); ok {
+		t.Fatalf("expected expired facts to be ignored")
+	}
+}
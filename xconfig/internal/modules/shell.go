@@ -2,6 +2,9 @@ package modules
 
 import (
 	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
 	"text/template"
 
 	"xconfig/core/parser"
@@ -18,7 +21,61 @@ func shellHandler(ctx Context, task parser.Task) ssh.CommandResult {
 			}
 		}
 	}
-	return ssh.RunShellCommand(ctx.Host, cmd)
+	return runCommand(ctx, cmd, task)
+}
+
+// freeFormArg matches creates=/removes= given inline, e.g.
+// `command: tar xf app.tgz creates=/opt/app`.
+var freeFormArg = regexp.MustCompile(`(^|\s)(creates|removes)=(\S+)`)
+
+// runCommand runs cmd unless its creates/removes guard says the work is
+// already done, in which case the result is OK. Tasks with async run cmd as
+// a background job.
+func runCommand(ctx Context, cmd string, task parser.Task) ssh.CommandResult {
+	var guard parser.CommandArgs
+	if task.Args != nil {
+		guard = *task.Args
+	}
+	for _, m := range freeFormArg.FindAllStringSubmatch(cmd, -1) {
+		if m[2] == "creates" {
+			guard.Creates = m[3]
+		} else {
+			guard.Removes = m[3]
+		}
+	}
+	cmd = strings.TrimSpace(freeFormArg.ReplaceAllString(cmd, "$1"))
+
+	if guard.Creates != "" && remoteExists(ctx, guard.Creates) {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: fmt.Sprintf("skipped, since %s exists", guard.Creates)}
+	}
+	if guard.Removes != "" && !remoteExists(ctx, guard.Removes) {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", Output: fmt.Sprintf("skipped, since %s does not exist", guard.Removes)}
+	}
+	if ctx.Check {
+		return checkSkipped(ctx)
+	}
+	if task.Async > 0 {
+		return runAsync(ctx, cmd, task)
+	}
+	return ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+}
+
+// checkSkipped is returned by handlers whose effect cannot be predicted.
+func checkSkipped(ctx Context) ssh.CommandResult {
+	return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "SKIPPED", Output: "skipped in check mode"}
+}
+
+func remoteExists(ctx Context, path string) bool {
+	return ssh.RunShellCommand(ctx.Ctx, ctx.Host, "test -e "+remotePath(path)).ReturnMsg == "CHANGED"
+}
+
+// remotePath quotes path for the remote shell, keeping a leading ~/ pointing
+// at the remote user's home directory.
+func remotePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return `"$HOME"/` + ssh.ShellQuote(path[2:])
+	}
+	return ssh.ShellQuote(path)
 }
 
 func init() {
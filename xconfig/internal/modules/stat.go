@@ -1,17 +1,71 @@
 package modules
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"xconfig/core/parser"
 	"xconfig/internal/ssh"
-	"fmt"
 )
 
+// statFormat is passed to `stat -c`; the fields are parsed by parseStat.
+const statFormat = "%F|%s|%a|%u|%g|%U|%G|%Y"
+
 func statHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Stat == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing stat parameters"}
 	}
-	cmd := fmt.Sprintf("test -e %s && echo exists || echo missing", task.Stat.Path)
-	return ssh.RunShellCommand(ctx.Host, cmd)
+	path := ssh.ShellQuote(task.Stat.Path)
+	cmd := fmt.Sprintf("if [ -e %s ] || [ -L %s ]; then stat -c '%s' %s; else echo missing; fi", path, path, statFormat, path)
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+	st, err := parseStat(task.Stat.Path, res.Stdout)
+	if err != nil {
+		res.ReturnMsg = "FAILED"
+		res.ReturnCode = 1
+		res.Output = err.Error()
+		return res
+	}
+	res.ReturnMsg = "OK"
+	res.Data = map[string]interface{}{"stat": st}
+	if st["exists"] == true {
+		res.Output = "exists"
+	} else {
+		res.Output = "missing"
+	}
+	return res
+}
+
+// parseStat turns the output of statFormat into the fields Ansible exposes
+// under `<register>.stat`.
+func parseStat(path, out string) (map[string]interface{}, error) {
+	out = strings.TrimSpace(out)
+	if out == "missing" {
+		return map[string]interface{}{"exists": false, "path": path}, nil
+	}
+	f := strings.Split(out, "|")
+	if len(f) != 8 {
+		return nil, fmt.Errorf("unexpected stat output: %q", out)
+	}
+	atoi := func(s string) int { n, _ := strconv.Atoi(s); return n }
+	kind := f[0]
+	return map[string]interface{}{
+		"exists":  true,
+		"path":    path,
+		"isdir":   kind == "directory",
+		"isreg":   strings.Contains(kind, "regular"),
+		"islnk":   kind == "symbolic link",
+		"size":    atoi(f[1]),
+		"mode":    fmt.Sprintf("%04s", f[2]),
+		"uid":     atoi(f[3]),
+		"gid":     atoi(f[4]),
+		"pw_name": f[5],
+		"gr_name": f[6],
+		"mtime":   atoi(f[7]),
+	}, nil
 }
 
 func init() { Register("stat", statHandler) }
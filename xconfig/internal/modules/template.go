@@ -9,7 +9,9 @@ func templateHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Template == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "template missing"}
 	}
-	return ssh.RenderTemplate(ctx.Host, task.Template.Src, task.Template.Dest, ctx.Vars, ctx.Diff)
+	t := task.Template
+	attrs := ssh.FileAttrs{Mode: t.Mode, Owner: t.Owner, Group: t.Group}
+	return ssh.RenderTemplate(ctx.Ctx, ctx.Host, t.Src, t.Dest, attrs, ctx.Vars, ctx.Diff, ctx.Check)
 }
 
 func init() {
@@ -1,6 +1,8 @@
 package modules
 
 import (
+	"context"
+
 	"xconfig/core/parser"
 	"xconfig/internal/inventory"
 	"xconfig/internal/ssh"
@@ -8,10 +10,19 @@ import (
 
 // Context provides information for task execution.
 type Context struct {
+	// Ctx carries the task timeout and the run's cancellation; handlers pass
+	// it to every remote call.
+	Ctx  context.Context
 	Host inventory.Host
 	Vars map[string]interface{}
 	Diff bool
+	// Check asks the handler to probe the host and predict the result
+	// without changing anything. Handlers report a predicted change as
+	// CHANGED (with a diff when Diff is set) and return SKIPPED when they
+	// cannot predict the outcome.
+	Check bool
 }
 
-// TaskHandler executes a task and returns the result.
+// TaskHandler executes a task and returns the result. Handlers must honour
+// ctx.Check.
 type TaskHandler func(ctx Context, task parser.Task) ssh.CommandResult
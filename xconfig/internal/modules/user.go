@@ -0,0 +1,283 @@
+package modules
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
+)
+
+// userQuery prints the account "$n" as "field<TAB>value" lines: its passwd
+// entry (empty when missing), primary group, groups and password hash. The
+// hash is only readable as root. Without getent the files are searched for
+// the exact name, never a pattern.
+const userQuery = `export n=%s
+printf 'passwd\t%%s\n' "$(getent passwd "$n" 2>/dev/null || awk -F: '$1 == ENVIRON["n"]' /etc/passwd)"
+printf 'group\t%%s\n' "$(id -gn "$n" 2>/dev/null)"
+printf 'groups\t%%s\n' "$(id -Gn "$n" 2>/dev/null)"
+printf 'shadow\t%%s\n' "$( (getent shadow "$n" 2>/dev/null || awk -F: '$1 == ENVIRON["n"]' /etc/shadow 2>/dev/null) | cut -d: -f2)"
+`
+
+// hiddenPassword stands for password hashes in change reports.
+const hiddenPassword = "********"
+
+// account is the current state of a local user.
+type account struct {
+	exists                         bool
+	uid, gid, comment, home, shell string
+	group                          string
+	groups                         []string
+	password                       string
+}
+
+// parseAccount reads the output of userQuery.
+func parseAccount(out string) account {
+	var a account
+	for _, line := range strings.Split(out, "\n") {
+		field, value, ok := strings.Cut(strings.TrimRight(line, "\r"), "\t")
+		if !ok {
+			continue
+		}
+		switch field {
+		case "passwd":
+			// name:x:uid:gid:comment:home:shell
+			f := strings.Split(value, ":")
+			if len(f) == 7 {
+				a.exists = true
+				a.uid, a.gid, a.comment, a.home, a.shell = f[2], f[3], f[4], f[5], f[6]
+			}
+		case "group":
+			a.group = value
+		case "groups":
+			a.groups = strings.Fields(value)
+		case "shadow":
+			a.password = value
+		}
+	}
+	return a
+}
+
+// supplementary returns the groups of the account besides its primary one.
+func (a account) supplementary() []string {
+	var out []string
+	for _, g := range a.groups {
+		if g != a.group {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// queryAccount returns the current state of the user name.
+func queryAccount(ctx Context, name string) (account, error) {
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, fmt.Sprintf(userQuery, ssh.ShellQuote(name)))
+	if res.ReturnMsg != "CHANGED" {
+		return account{}, fmt.Errorf("query user %s: %s", name, strings.TrimSpace(res.Output))
+	}
+	return parseAccount(res.Stdout), nil
+}
+
+// userPlan compares u with the current account and returns the changes and
+// the command that makes them; cmd is empty when nothing changes.
+func userPlan(u parser.UserAction, a account) (changes [][3]string, cmd string, err error) {
+	if u.UID != "" {
+		if _, err := strconv.ParseUint(u.UID, 10, 32); err != nil {
+			return nil, "", fmt.Errorf("user uid must be a number, got %q", u.UID)
+		}
+	}
+	switch u.UpdatePassword {
+	case "", "always", "on_create":
+	default:
+		return nil, "", fmt.Errorf("unsupported update_password %q (want always or on_create)", u.UpdatePassword)
+	}
+	name := ssh.ShellQuote(u.Name)
+
+	switch u.State {
+	case "absent":
+		if !a.exists {
+			return nil, "", nil
+		}
+		cmd = "userdel "
+		if u.Remove {
+			cmd += "-r "
+		}
+		return [][3]string{{"state", "present", "absent"}}, cmd + name, nil
+	case "", "present":
+	default:
+		return nil, "", fmt.Errorf("unsupported user state %q (want present or absent)", u.State)
+	}
+
+	var args []string
+	set := func(field, before, after, flag string) {
+		shown := after
+		if field == "password" {
+			// Never show hashes; an unreadable or missing one stays empty.
+			if before != "" {
+				before = hiddenPassword
+			}
+			shown = hiddenPassword + " (new)"
+		}
+		changes = append(changes, [3]string{field, before, shown})
+		args = append(args, flag, ssh.ShellQuote(after))
+	}
+
+	if !a.exists {
+		changes = append(changes, [3]string{"state", "absent", "present"})
+		for _, f := range []struct{ field, value, flag string }{
+			{"uid", u.UID, "-u"}, {"group", u.Group, "-g"}, {"groups", u.Groups, "-G"},
+			{"home", u.Home, "-d"}, {"shell", u.Shell, "-s"}, {"comment", u.Comment, "-c"}, {"password", u.Password, "-p"},
+		} {
+			if f.value != "" {
+				set(f.field, "", f.value, f.flag)
+			}
+		}
+		if u.System {
+			args = append(args, "-r")
+		}
+		if u.CreateHome == nil || *u.CreateHome {
+			args = append(args, "-m")
+		} else {
+			args = append(args, "-M")
+		}
+		return changes, "useradd " + strings.Join(append(args, name), " "), nil
+	}
+
+	if u.UID != "" && u.UID != a.uid {
+		set("uid", a.uid, u.UID, "-u")
+	}
+	if u.Group != "" && u.Group != a.group && u.Group != a.gid {
+		set("group", a.group, u.Group, "-g")
+	}
+	if u.Groups != "" || u.GroupsSet {
+		// Like the current groups, the wanted ones leave out the primary
+		// group, which usermod -G does not manage.
+		current := a.supplementary()
+		var want []string
+		for _, g := range packageNames(u.Groups) {
+			if g != a.group && g != a.gid && g != u.Group {
+				want = append(want, g)
+			}
+		}
+		if u.Append {
+			var missing []string
+			for _, g := range want {
+				if !slices.Contains(current, g) && !slices.Contains(missing, g) {
+					missing = append(missing, g)
+				}
+			}
+			if len(missing) > 0 {
+				changes = append(changes, [3]string{"groups", strings.Join(current, ","), strings.Join(append(current, missing...), ",")})
+				args = append(args, "-a", "-G", ssh.ShellQuote(strings.Join(missing, ",")))
+			}
+		} else if !sameSet(current, want) {
+			set("groups", strings.Join(current, ","), strings.Join(want, ","), "-G")
+		}
+	}
+	if u.Home != "" && u.Home != a.home {
+		set("home", a.home, u.Home, "-d")
+		if u.MoveHome {
+			args = append(args, "-m")
+		}
+	}
+	if u.Shell != "" && u.Shell != a.shell {
+		set("shell", a.shell, u.Shell, "-s")
+	}
+	if u.Comment != "" && u.Comment != a.comment {
+		set("comment", a.comment, u.Comment, "-c")
+	}
+	if u.Password != "" && u.UpdatePassword != "on_create" && u.Password != a.password {
+		set("password", a.password, u.Password, "-p")
+	}
+	if len(args) == 0 {
+		return nil, "", nil
+	}
+	return changes, "usermod " + strings.Join(append(args, name), " "), nil
+}
+
+// sameSet reports whether a and b hold the same strings, ignoring order
+// and duplicates.
+func sameSet(a, b []string) bool {
+	for _, s := range a {
+		if !slices.Contains(b, s) {
+			return false
+		}
+	}
+	for _, s := range b {
+		if !slices.Contains(a, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// userData is the registered result of the user module.
+func userData(u parser.UserAction, a account, changes [][3]string) map[string]interface{} {
+	state := "absent"
+	if a.exists {
+		state = "present"
+	}
+	fields := make([]interface{}, 0, len(changes))
+	for _, c := range changes {
+		fields = append(fields, c[0])
+	}
+	data := map[string]interface{}{"name": u.Name, "state": state, "system": u.System, "changed_fields": fields}
+	if a.exists {
+		data["uid"] = a.uid
+		if uid, err := strconv.Atoi(a.uid); err == nil {
+			data["uid"] = uid
+		}
+		data["group"] = a.group
+		data["home"] = a.home
+		data["shell"] = a.shell
+		data["comment"] = a.comment
+		groups := make([]interface{}, 0, len(a.groups))
+		for _, g := range a.supplementary() {
+			groups = append(groups, g)
+		}
+		data["groups"] = groups
+	}
+	return data
+}
+
+func userHandler(ctx Context, task parser.Task) ssh.CommandResult {
+	u := task.User
+	host := ctx.Host.Name
+	if u == nil || u.Name == "" {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: "user needs name"}
+	}
+	before, err := queryAccount(ctx, u.Name)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	changes, cmd, err := userPlan(*u, before)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	if cmd == "" {
+		state := "absent"
+		if before.exists {
+			state = "present"
+		}
+		return ssh.CommandResult{Host: host, ReturnMsg: "OK", Output: fmt.Sprintf("user %s is %s", u.Name, state), Data: userData(*u, before, nil)}
+	}
+	if ctx.Check {
+		return ssh.CommandResult{Host: host, ReturnMsg: "CHANGED", Output: describeChanges(ctx, "user "+u.Name, changes), Data: userData(*u, before, changes)}
+	}
+
+	res := ssh.RunShellCommand(ctx.Ctx, ctx.Host, cmd)
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+	after, err := queryAccount(ctx, u.Name)
+	if err != nil {
+		return ssh.CommandResult{Host: host, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	res.Output = describeChanges(ctx, "user "+u.Name, changes)
+	res.Data = userData(*u, after, changes)
+	return res
+}
+
+func init() { Register("user", userHandler) }
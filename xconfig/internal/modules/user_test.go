@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"xconfig/core/parser"
+)
+
+const deployAccount = "passwd\tdeploy:x:1001:1001:Deploy:/home/deploy:/bin/sh\n" +
+	"group\tdeploy\n" +
+	"groups\tdeploy docker adm\n" +
+	"shadow\t$6$salt$hash\n"
+
+func TestParseAccount(t *testing.T) {
+	a := parseAccount(deployAccount)
+	if !a.exists || a.uid != "1001" || a.home != "/home/deploy" || a.shell != "/bin/sh" || a.group != "deploy" || a.password != "$6$salt$hash" {
+		t.Fatalf("unexpected account: %+v", a)
+	}
+	if got := a.supplementary(); !reflect.DeepEqual(got, []string{"docker", "adm"}) {
+		t.Fatalf("supplementary groups = %v", got)
+	}
+	if a := parseAccount("passwd\t\ngroup\t\ngroups\t\nshadow\t\n"); a.exists {
+		t.Fatalf("expected a missing account, got %+v", a)
+	}
+}
+
+func TestUserPlan(t *testing.T) {
+	no := false
+	changes, cmd, err := userPlan(parser.UserAction{Name: "alice", UID: "1050", Groups: "sudo,docker", Shell: "/bin/bash", Password: "$6$x$y", System: true, CreateHome: &no}, account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "useradd -u '1050' -G 'sudo,docker' -s '/bin/bash' -p '$6$x$y' -r -M 'alice'" {
+		t.Fatalf("unexpected useradd: %s", cmd)
+	}
+	if changes[0] != [3]string{"state", "absent", "present"} || strings.Contains(changes[len(changes)-1][2], "$6$") {
+		t.Fatalf("unexpected changes (password must be hidden): %v", changes)
+	}
+
+	a := parseAccount(deployAccount)
+	same := parser.UserAction{Name: "deploy", UID: "1001", Group: "1001", Groups: "adm,docker", Shell: "/bin/sh", Password: "$6$salt$hash"}
+	if changes, cmd, err := userPlan(same, a); err != nil || cmd != "" || len(changes) != 0 {
+		t.Fatalf("expected no change, got %v %q %v", changes, cmd, err)
+	}
+
+	changes, cmd, err = userPlan(parser.UserAction{Name: "deploy", Groups: "docker", Shell: "/bin/bash", Password: "$6$new$hash"}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "usermod -G 'docker' -s '/bin/bash' -p '$6$new$hash' 'deploy'" {
+		t.Fatalf("unexpected usermod: %s", cmd)
+	}
+	want := [][3]string{{"groups", "docker,adm", "docker"}, {"shell", "/bin/sh", "/bin/bash"}, {"password", hiddenPassword, hiddenPassword + " (new)"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+
+	_, cmd, _ = userPlan(parser.UserAction{Name: "deploy", Groups: "docker,sudo", Append: true, Password: "$6$new$hash", UpdatePassword: "on_create"}, a)
+	if cmd != "usermod -a -G 'sudo' 'deploy'" {
+		t.Fatalf("unexpected append usermod: %s", cmd)
+	}
+
+	// Listing the primary group among the groups changes nothing.
+	withPrimary := parser.UserAction{Name: "deploy", Group: "deploy", Groups: "deploy,docker,adm"}
+	if changes, cmd, _ := userPlan(withPrimary, a); cmd != "" || len(changes) != 0 {
+		t.Fatalf("expected the primary group to be ignored, got %v %q", changes, cmd)
+	}
+	if _, cmd, _ := userPlan(parser.UserAction{Name: "deploy", Groups: "deploy", Append: true}, a); cmd != "" {
+		t.Fatalf("expected append of the primary group to change nothing, got %q", cmd)
+	}
+
+	// An explicit empty list removes every supplementary group; a missing
+	// one leaves them alone.
+	changes, cmd, _ = userPlan(parser.UserAction{Name: "deploy", GroupsSet: true}, a)
+	if cmd != "usermod -G '' 'deploy'" || changes[0] != [3]string{"groups", "docker,adm", ""} {
+		t.Fatalf("unexpected group removal: %v %q", changes, cmd)
+	}
+	if _, cmd, _ := userPlan(parser.UserAction{Name: "deploy"}, a); cmd != "" {
+		t.Fatalf("expected no change without groups, got %q", cmd)
+	}
+
+	if _, cmd, _ := userPlan(parser.UserAction{Name: "deploy", State: "absent", Remove: true}, a); cmd != "userdel -r 'deploy'" {
+		t.Fatalf("unexpected userdel: %s", cmd)
+	}
+	if _, cmd, _ := userPlan(parser.UserAction{Name: "ghost", State: "absent"}, account{}); cmd != "" {
+		t.Fatalf("expected no change for a missing user, got %s", cmd)
+	}
+	if _, _, err := userPlan(parser.UserAction{Name: "x", UID: "abc"}, account{}); err == nil {
+		t.Fatalf("expected an invalid uid to be rejected")
+	}
+}
+
+func TestGroupPlan(t *testing.T) {
+	if _, cmd, _ := groupPlan(parser.GroupAction{Name: "ops", GID: "2000", System: true}, false, ""); cmd != "groupadd -g 2000 -r 'ops'" {
+		t.Fatalf("unexpected groupadd: %s", cmd)
+	}
+	if changes, cmd, _ := groupPlan(parser.GroupAction{Name: "ops", GID: "2000"}, true, "2000"); cmd != "" || changes != nil {
+		t.Fatalf("expected no change, got %v %s", changes, cmd)
+	}
+	if changes, cmd, _ := groupPlan(parser.GroupAction{Name: "ops", GID: "2001"}, true, "2000"); cmd != "groupmod -g 2001 'ops'" || changes[0] != [3]string{"gid", "2000", "2001"} {
+		t.Fatalf("unexpected groupmod: %v %s", changes, cmd)
+	}
+	if _, cmd, _ := groupPlan(parser.GroupAction{Name: "ops", State: "absent"}, true, "2000"); cmd != "groupdel 'ops'" {
+		t.Fatalf("unexpected groupdel: %s", cmd)
+	}
+}
@@ -1,14 +1,13 @@
 package modules
 
 import (
-	"context"
 	"fmt"
 	"os"
 
-	"xconfig/core/parser"
-	"xconfig/internal/ssh"
 	"github.com/vultr/govultr/v3"
 	"golang.org/x/oauth2"
+	"xconfig/core/parser"
+	"xconfig/internal/ssh"
 )
 
 func vultrHandler(ctx Context, task parser.Task) ssh.CommandResult {
@@ -25,10 +24,26 @@ func vultrHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	}
 
 	config := &oauth2.Config{}
-	ctxAPI := context.Background()
+	ctxAPI := ctx.Ctx
 	ts := config.TokenSource(ctxAPI, &oauth2.Token{AccessToken: apiKey})
 	client := govultr.NewClient(oauth2.NewClient(ctxAPI, ts))
 
+	// A labelled instance is only created once; reruns report the existing one.
+	if task.Vultr.Label != "" {
+		existing, _, _, err := client.Instance.List(ctxAPI, &govultr.ListOptions{Label: task.Vultr.Label, Region: task.Vultr.Region})
+		if err != nil {
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+		}
+		if len(existing) > 0 {
+			inst := existing[0]
+			return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "OK", ReturnCode: 0, Output: fmt.Sprintf("ID:%s IP:%s", inst.ID, inst.MainIP)}
+		}
+	}
+
+	if ctx.Check {
+		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "CHANGED", ReturnCode: 0, Output: fmt.Sprintf("would create instance %q in %s", task.Vultr.Label, task.Vultr.Region)}
+	}
+
 	req := &govultr.InstanceCreateReq{
 		Region: task.Vultr.Region,
 		Plan:   task.Vultr.Plan,
@@ -3,16 +3,13 @@ package modules
 import (
 	"xconfig/core/parser"
 	"xconfig/internal/ssh"
-	"fmt"
 )
 
 func yumHandler(ctx Context, task parser.Task) ssh.CommandResult {
 	if task.Yum == nil {
 		return ssh.CommandResult{Host: ctx.Host.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: "missing yum parameters"}
 	}
-	pkg := task.Yum.Name
-	cmd := fmt.Sprintf("sudo yum -y install %s", pkg)
-	return ssh.RunShellCommand(ctx.Host, cmd)
+	return yumManager.ensure(ctx, *task.Yum)
 }
 
 func init() { Register("yum", yumHandler) }
@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"xconfig/internal/inventory"
+)
+
+// 异步任务在远端后台运行，不依赖 SSH session 存活。每个任务一个目录：
+// stdout / stderr 为命令输出，pid 为进程号，rc 在命令结束后写入。
+
+// NewJobID returns a unique id for an async job.
+func NewJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%d.%s", time.Now().Unix(), hex.EncodeToString(b))
+}
+
+func jobDir(jid string) string {
+	return `"$HOME"/.xconfig_async/` + ShellQuote(jid)
+}
+
+// StartAsync launches command in the background on h and returns at once.
+// The result carries ansible_job_id, started and finished in Data.
+func StartAsync(ctx context.Context, h inventory.Host, command, jid string) CommandResult {
+	job := fmt.Sprintf(`sh -c %s >"$d/stdout" 2>"$d/stderr" </dev/null & echo $! >"$d/pid"; wait $!; echo $? >"$d/rc.tmp"; mv "$d/rc.tmp" "$d/rc"`, ShellQuote(command))
+	script := fmt.Sprintf("d=%s\nmkdir -p \"$d\" || exit 1\nexport d\nnohup sh -c %s >/dev/null 2>&1 &", jobDir(jid), ShellQuote(job))
+	res := RunShellCommand(ctx, h, script)
+	if res.ReturnMsg != "CHANGED" {
+		return res
+	}
+	res.Output = fmt.Sprintf("started async job %s", jid)
+	res.Data = map[string]interface{}{"ansible_job_id": jid, "started": 1, "finished": 0}
+	return res
+}
+
+// AsyncStatus checks on a job. While it runs the result is OK with finished
+// 0; once it ends the result holds the job's output and exit status and the
+// job directory is removed.
+func AsyncStatus(ctx context.Context, h inventory.Host, jid string) (CommandResult, bool) {
+	dir := jobDir(jid)
+	res := RunShellCommand(ctx, h, fmt.Sprintf(`d=%s; [ -d "$d" ] || { echo "unknown async job" >&2; exit 1; }; cat "$d/rc" 2>/dev/null || echo running`, dir))
+	if res.ReturnMsg != "CHANGED" {
+		return res, false
+	}
+	data := map[string]interface{}{"ansible_job_id": jid, "started": 1, "finished": 0}
+	status := strings.TrimSpace(res.Stdout)
+	if status == "running" {
+		return CommandResult{Host: h.Name, ReturnMsg: "OK", Output: fmt.Sprintf("async job %s is running", jid), Data: data}, false
+	}
+	rc, err := strconv.Atoi(status)
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("async job %s: bad exit status %q", jid, status)}, true
+	}
+
+	out := RunShellCommand(ctx, h, fmt.Sprintf(`d=%s; cat "$d/stdout"; cat "$d/stderr" >&2; rm -rf "$d"`, dir))
+	if out.ReturnMsg != "CHANGED" {
+		return out, true
+	}
+	out.ReturnCode = rc
+	if rc != 0 {
+		out.ReturnMsg = "FAILED"
+	}
+	data["finished"] = 1
+	out.Data = data
+	return out, true
+}
+
+// KillAsync stops a job and removes its directory.
+func KillAsync(ctx context.Context, h inventory.Host, jid string) {
+	RunShellCommand(ctx, h, fmt.Sprintf(`d=%s; [ -f "$d/pid" ] && kill "$(cat "$d/pid")" 2>/dev/null; rm -rf "$d"`, jobDir(jid)))
+}
@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shellServer answers exec requests by running them with the local sh, so
+// the async and transfer scripts are exercised for real. It also serves
+// SFTP from the local filesystem.
+func shellServer(t *testing.T) *testServer {
+	t.Helper()
+	home := t.TempDir()
+	srv := newTestServer(t)
+	srv.sftp = true
+	srv.exec = func(cmd string, stdin io.Reader) (string, int) {
+		c := exec.Command("sh", "-c", cmd)
+		c.Env = []string{"HOME=" + home, "PATH=/usr/bin:/bin"}
+		if srv.binDir != "" {
+			c.Env[1] = "PATH=" + srv.binDir + ":/usr/bin:/bin"
+		}
+		c.Stdin = stdin
+		out, err := c.CombinedOutput()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(out), exitErr.ExitCode()
+		}
+		return string(out), 0
+	}
+	return srv
+}
+
+// becomeShellServer is a shellServer whose sudo runs the command as the
+// current user and whose setfacl records "<acl> <path> <mode>" lines in the
+// returned log instead of changing anything.
+func becomeShellServer(t *testing.T) (*testServer, string) {
+	t.Helper()
+	srv := shellServer(t)
+	srv.binDir = t.TempDir()
+	log := filepath.Join(t.TempDir(), "setfacl.log")
+	scripts := map[string]string{
+		"sudo":    "#!/bin/sh\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n",
+		"setfacl": "#!/bin/sh\necho \"$2 $3 $(stat -c %a \"$3\")\" >> " + log + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(srv.binDir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return srv, log
+}
+
+// aclLog reads the setfacl calls recorded by becomeShellServer.
+func aclLog(t *testing.T, log string) [][]string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("no setfacl calls: %v", err)
+	}
+	var calls [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		calls = append(calls, strings.Fields(line))
+	}
+	return calls
+}
+
+func TestAsyncJobLifecycle(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	ctx := context.Background()
+
+	jid := NewJobID()
+	res := StartAsync(ctx, h, "sleep 0.3; echo done; exit 3", jid)
+	if res.ReturnMsg != "CHANGED" || res.Data["ansible_job_id"] != jid {
+		t.Fatalf("unexpected start result: %+v", res)
+	}
+	if st, finished := AsyncStatus(ctx, h, jid); finished || st.ReturnMsg != "OK" || st.Data["finished"] != 0 {
+		t.Fatalf("expected the job to be running, got %+v", st)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, finished := AsyncStatus(ctx, h, jid)
+		if finished {
+			if st.ReturnMsg != "FAILED" || st.ReturnCode != 3 || st.Stdout != "done\n" || st.Data["finished"] != 1 {
+				t.Fatalf("unexpected final status: %+v", st)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The job directory is removed once the result has been collected.
+	if st, _ := AsyncStatus(ctx, h, jid); st.ReturnMsg != "FAILED" {
+		t.Fatalf("expected an unknown job error, got %+v", st)
+	}
+}
+
+func TestKillAsync(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	ctx := context.Background()
+
+	jid := NewJobID()
+	StartAsync(ctx, h, "sleep 30", jid)
+	KillAsync(ctx, h, jid)
+	if st, _ := AsyncStatus(ctx, h, jid); st.ReturnMsg != "FAILED" {
+		t.Fatalf("expected the job to be gone, got %+v", st)
+	}
+}
@@ -0,0 +1,198 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"xconfig/internal/inventory"
+)
+
+// Authentication methods are offered to the server in this order:
+//
+//  1. OpenSSH user certificate: Host.CertFile, or <KeyFile>-cert.pub when it
+//     exists, paired with the private key in Host.KeyFile.
+//  2. The private key in Host.KeyFile. Passphrase-protected keys are unlocked
+//     with Host.PassphraseFile, then DefaultPassphraseFile, then PassphrasePrompt.
+//  3. Every identity held by the ssh-agent listening on SSH_AUTH_SOCK.
+//  4. Host.Password.
+//
+// Signers from steps 1-3 are combined into a single publickey method because
+// the SSH client only attempts each method type once per connection.
+
+var (
+	// DefaultPassphraseFile is used for encrypted keys when the host does not
+	// set ansible_ssh_private_key_passphrase_file.
+	DefaultPassphraseFile = ""
+	// PassphrasePrompt asks the operator for the passphrase of an encrypted
+	// key. It is nil when no terminal is available.
+	PassphrasePrompt func(keyFile string) ([]byte, error)
+)
+
+// passphrases caches unlocked passphrases per key file so a run against many
+// hosts prompts at most once per key.
+var (
+	passphraseMu sync.Mutex
+	passphrases  = make(map[string][]byte)
+)
+
+// authMethods builds the ordered list of auth methods for h. The returned
+// closer releases the agent connection once the handshake is done.
+func authMethods(h inventory.Host) ([]ssh.AuthMethod, []string, func(), error) {
+	var signers []ssh.Signer
+	var used []string
+	var problems []string
+	closer := func() {}
+
+	if h.KeyFile != "" {
+		signer, err := loadKeySigner(h)
+		switch {
+		case err == nil:
+			if cert, err := loadCertSigner(h, signer); err != nil {
+				problems = append(problems, err.Error())
+			} else if cert != nil {
+				signers = append(signers, cert)
+				used = append(used, "certificate")
+			}
+			signers = append(signers, signer)
+			used = append(used, "key")
+		case !errors.Is(err, os.ErrNotExist):
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err == nil && len(agentSigners) > 0 {
+				signers = append(signers, agentSigners...)
+				used = append(used, "agent")
+				closer = func() { conn.Close() }
+			} else {
+				conn.Close()
+			}
+		}
+	}
+
+	var methods []ssh.AuthMethod
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if h.Password != "" {
+		methods = append(methods, ssh.Password(h.Password))
+		used = append(used, "password")
+	}
+
+	if len(methods) == 0 {
+		closer()
+		msg := "No valid SSH authentication method found (certificate, key, agent or password)"
+		if len(problems) > 0 {
+			msg += ": " + strings.Join(problems, "; ")
+		}
+		return nil, nil, nil, errors.New(msg)
+	}
+	return methods, used, closer, nil
+}
+
+func loadKeySigner(h inventory.Host) (ssh.Signer, error) {
+	keyBytes, err := os.ReadFile(h.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %v", h.KeyFile, err)
+		}
+		return signer, nil
+	}
+
+	passphrase, err := keyPassphrase(h)
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, passphrase)
+	if err != nil {
+		passphraseMu.Lock()
+		delete(passphrases, h.KeyFile)
+		passphraseMu.Unlock()
+		return nil, fmt.Errorf("decrypt key %s: %v", h.KeyFile, err)
+	}
+	return signer, nil
+}
+
+func keyPassphrase(h inventory.Host) ([]byte, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	if p, ok := passphrases[h.KeyFile]; ok {
+		return p, nil
+	}
+
+	var passphrase []byte
+	file := h.PassphraseFile
+	if file == "" {
+		file = DefaultPassphraseFile
+	}
+	switch {
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read passphrase file: %v", err)
+		}
+		passphrase = []byte(strings.TrimRight(string(data), "\r\n"))
+	case PassphrasePrompt != nil:
+		p, err := PassphrasePrompt(h.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = p
+	default:
+		return nil, fmt.Errorf("key %s is encrypted and no passphrase is available", h.KeyFile)
+	}
+	passphrases[h.KeyFile] = passphrase
+	return passphrase, nil
+}
+
+// loadCertSigner pairs the private key with its OpenSSH user certificate. It
+// returns nil when the host has no certificate.
+func loadCertSigner(h inventory.Host, signer ssh.Signer) (ssh.Signer, error) {
+	certFile := h.CertFile
+	if certFile == "" {
+		certFile = h.KeyFile + "-cert.pub"
+		if _, err := os.Stat(certFile); err != nil {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate %s: %v", certFile, err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s: %v", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an OpenSSH certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %v", certFile, err)
+	}
+	return certSigner, nil
+}
+
+// forwardAgent lets commands on the remote host use the local ssh-agent.
+func forwardAgent(client *ssh.Client) error {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return errors.New("agent forwarding requested but SSH_AUTH_SOCK is not set")
+	}
+	return agent.ForwardToRemote(client, sock)
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeKey(t *testing.T, dir string, passphrase string) (string, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	return path, signer
+}
+
+func TestEncryptedKeyWithPassphraseFile(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+	srv := newTestServer(t)
+	keyFile, signer := writeKey(t, dir, "hunter2")
+	srv.authorized = []ssh.PublicKey{signer.PublicKey()}
+
+	passFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passFile, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatalf("write passphrase: %v", err)
+	}
+
+	h := srv.host("web1")
+	h.Password = ""
+	h.HostKeyChecking = HostKeyOff
+	h.KeyFile = keyFile
+	h.PassphraseFile = passFile
+
+	client, err := dial(context.Background(), h, nil)
+	if err != nil {
+		t.Fatalf("dial with encrypted key: %v", err)
+	}
+	client.Close()
+
+	h.KeyFile = keyFile
+	h.PassphraseFile = ""
+	passphraseMu.Lock()
+	delete(passphrases, keyFile)
+	passphraseMu.Unlock()
+	if _, err := dial(context.Background(), h, nil); err == nil {
+		t.Fatalf("expected failure without a passphrase")
+	}
+}
+
+func TestCertificateIsOfferedFirst(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+	srv := newTestServer(t)
+	keyFile, signer := writeKey(t, dir, "")
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ca: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("ca signer: %v", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"tester"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("sign cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	srv.ca = caSigner.PublicKey()
+
+	h := srv.host("web1")
+	h.Password = ""
+	h.HostKeyChecking = HostKeyOff
+	h.KeyFile = keyFile
+
+	client, err := dial(context.Background(), h, nil)
+	if err != nil {
+		t.Fatalf("dial with certificate: %v", err)
+	}
+	client.Close()
+	if _, ok := srv.keyUsed.(*ssh.Certificate); !ok {
+		t.Fatalf("expected the certificate to authenticate, got %T", srv.keyUsed)
+	}
+}
@@ -0,0 +1,158 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"xconfig/internal/inventory"
+)
+
+// Supported privilege escalation methods.
+const (
+	BecomeSudo = "sudo"
+	BecomeSu   = "su"
+	BecomeDoas = "doas"
+)
+
+// ShellQuote wraps s in single quotes for safe use in a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// becomeUserPattern matches the user names become accepts: POSIX login
+// names, which never need quoting.
+var becomeUserPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+
+// suPrompt is matched against the terminal output of su and doas, whose
+// password prompts cannot be chosen.
+const suPrompt = "assword:"
+
+// becomeCommand wraps command so that it runs as h.BecomeUser using
+// h.BecomeMethod. Commands are returned unchanged when become is disabled.
+// With a password, prompt is the text the method asks for it with: sudo
+// prints a unique prompt on stderr, su and doas print theirs on a terminal,
+// which becomeNeedsPty reports. The command itself then reads /dev/null, so
+// the password can never reach it.
+func becomeCommand(h inventory.Host, command string) (wrapped, prompt string, err error) {
+	if !h.Become {
+		return command, "", nil
+	}
+	user := h.BecomeUser
+	if user == "" {
+		user = "root"
+	}
+	if !becomeUserPattern.MatchString(user) {
+		return "", "", fmt.Errorf("invalid become_user %q", user)
+	}
+	if h.BecomePassword != "" {
+		command = "exec </dev/null\n" + command
+	}
+	quoted := ShellQuote(command)
+	switch h.BecomeMethod {
+	case "", BecomeSudo:
+		if h.BecomePassword != "" {
+			prompt = "[xconfig-become-" + randomSuffix() + "] password: "
+			return fmt.Sprintf("sudo -H -S -p %s -u %s -- /bin/sh -c %s", ShellQuote(prompt), user, quoted), prompt, nil
+		}
+		return fmt.Sprintf("sudo -H -n -u %s -- /bin/sh -c %s", user, quoted), "", nil
+	case BecomeSu:
+		if h.BecomePassword != "" {
+			prompt = suPrompt
+		}
+		return fmt.Sprintf("su - %s -c %s", user, quoted), prompt, nil
+	case BecomeDoas:
+		if h.BecomePassword != "" {
+			return fmt.Sprintf("doas -u %s /bin/sh -c %s", user, quoted), suPrompt, nil
+		}
+		return fmt.Sprintf("doas -n -u %s /bin/sh -c %s", user, quoted), "", nil
+	default:
+		return "", "", fmt.Errorf("unsupported become_method %q (want sudo, su or doas)", h.BecomeMethod)
+	}
+}
+
+func becomeNeedsPty(h inventory.Host) bool {
+	return h.Become && h.BecomePassword != "" && (h.BecomeMethod == BecomeSu || h.BecomeMethod == BecomeDoas)
+}
+
+// stripBecomePrompt removes the password prompt echoed by su/doas on the
+// terminal and normalises the terminal's CRLF line endings.
+func stripBecomePrompt(output string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	if i := strings.Index(output, "\n"); i >= 0 && strings.Contains(strings.ToLower(output[:i]), "password") {
+		return output[i+1:]
+	}
+	return output
+}
+
+// promptAnswer passes the output of a become command through to out and
+// writes the password to stdin once prompt shows up, instead of feeding it
+// to whatever reads stdin first. When the prompt comes back the password was
+// rejected; stdin is then closed so the method gives up instead of waiting.
+type promptAnswer struct {
+	mu       sync.Mutex
+	prompt   []byte
+	password string
+	stdin    io.WriteCloser
+	out      io.Writer
+	strip    bool // drop the prompt from out
+	once     bool // stop watching after the first answer
+	pending  []byte
+	answered bool
+	done     bool
+}
+
+func (a *promptAnswer) Write(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.done {
+		return a.out.Write(b)
+	}
+	a.pending = append(a.pending, b...)
+	for !a.done {
+		i := bytes.Index(a.pending, a.prompt)
+		if i < 0 {
+			break
+		}
+		end := i + len(a.prompt)
+		if a.strip {
+			a.out.Write(a.pending[:i])
+		} else {
+			a.out.Write(a.pending[:end])
+		}
+		a.pending = a.pending[end:]
+		a.answer()
+	}
+	// Hold back what may be the start of a prompt split across writes.
+	keep := len(a.prompt) - 1
+	if a.done {
+		keep = 0
+	}
+	if n := len(a.pending) - keep; n > 0 {
+		a.out.Write(a.pending[:n])
+		a.pending = append([]byte(nil), a.pending[n:]...)
+	}
+	return len(b), nil
+}
+
+func (a *promptAnswer) answer() {
+	if a.answered {
+		a.stdin.Close()
+		a.done = true
+		return
+	}
+	io.WriteString(a.stdin, a.password+"\n")
+	a.answered = true
+	a.done = a.once
+}
+
+// Flush writes out whatever output is still held back.
+func (a *promptAnswer) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.out.Write(a.pending)
+	a.pending = nil
+}
@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"xconfig/internal/inventory"
+)
+
+func TestBecomeCommand(t *testing.T) {
+	h := inventory.Host{Become: true}
+	cmd, prompt, err := becomeCommand(h, "echo 'hi'")
+	if err != nil {
+		t.Fatalf("becomeCommand: %v", err)
+	}
+	if want := `sudo -H -n -u root -- /bin/sh -c 'echo '\''hi'\'''`; cmd != want || prompt != "" {
+		t.Fatalf("got %s (prompt %q), want %s", cmd, prompt, want)
+	}
+
+	h.BecomePassword = "s3cret"
+	cmd, prompt, _ = becomeCommand(h, "id")
+	if !strings.HasPrefix(prompt, "[xconfig-become-") || cmd != "sudo -H -S -p "+ShellQuote(prompt)+" -u root -- /bin/sh -c 'exec </dev/null\nid'" {
+		t.Fatalf("unexpected sudo command %s (prompt %q)", cmd, prompt)
+	}
+	if _, again, _ := becomeCommand(h, "id"); again == prompt {
+		t.Fatalf("expected a fresh prompt per command, got %q twice", prompt)
+	}
+	h.BecomePassword = ""
+
+	h.BecomeMethod = BecomeSu
+	h.BecomeUser = "postgres"
+	if cmd, _, _ := becomeCommand(h, "id"); cmd != "su - postgres -c 'id'" {
+		t.Fatalf("unexpected su command %s", cmd)
+	}
+
+	for _, user := range []string{"root; rm -rf /", "$(id)", "-u", "a b"} {
+		h.BecomeUser = user
+		if _, _, err := becomeCommand(h, "id"); err == nil || !strings.Contains(err.Error(), "invalid become_user") {
+			t.Fatalf("expected %q to be rejected, got %v", user, err)
+		}
+	}
+	h.BecomeUser = "www-data"
+	if _, _, err := becomeCommand(h, "id"); err != nil {
+		t.Fatalf("www-data: %v", err)
+	}
+
+	h.BecomeMethod = "pbrun"
+	if _, _, err := becomeCommand(h, "id"); err == nil {
+		t.Fatalf("expected unsupported method error")
+	}
+
+	if cmd, _, _ := becomeCommand(inventory.Host{}, "id"); cmd != "id" {
+		t.Fatalf("expected command unchanged without become, got %s", cmd)
+	}
+}
+
+func TestRunShellCommandWrapsBecome(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.host("web1")
+	h.HostKeyChecking = HostKeyOff
+	h.Become = true
+	h.BecomeUser = "app"
+	defer CloseAll()
+
+	res := RunShellCommand(context.Background(), h, "whoami")
+	if !strings.HasPrefix(res.Output, "ran: sudo -H -n -u app -- /bin/sh -c 'whoami'") {
+		t.Fatalf("unexpected output %q", res.Output)
+	}
+}
+
+// fakeSudo answers exec requests like sudo -S -p. When ask is set it prints
+// the prompt given on the command line to stderr and reports the line it
+// then reads from stdin; otherwise it reports whatever arrives on stdin.
+func fakeSudo(ask bool) func(cmd string, stdin io.Reader) (string, int) {
+	return func(cmd string, stdin io.Reader) (string, int) {
+		if ask {
+			prompt := strings.SplitN(cmd, "'", 3)[1]
+			stdin.(ssh.Channel).Stderr().Write([]byte(prompt))
+			line, _ := bufio.NewReader(stdin).ReadString('\n')
+			return "password: " + line, 0
+		}
+		got := make(chan string, 1)
+		go func() {
+			b := make([]byte, 64)
+			n, _ := stdin.Read(b)
+			got <- string(b[:n])
+		}()
+		select {
+		case s := <-got:
+			return "stdin: " + s, 0
+		case <-time.After(200 * time.Millisecond):
+			return "stdin: nothing", 0
+		}
+	}
+}
+
+func TestBecomePasswordFollowsPrompt(t *testing.T) {
+	defer CloseAll()
+	for _, ask := range []bool{true, false} {
+		srv := newTestServer(t)
+		srv.exec = fakeSudo(ask)
+		h := srv.host("web1")
+		h.HostKeyChecking = HostKeyOff
+		h.Become = true
+		h.BecomePassword = "s3cret"
+
+		res := RunShellCommand(context.Background(), h, "id")
+		want := "stdin: nothing"
+		if ask {
+			want = "password: s3cret\n"
+		}
+		if res.Stdout != want || res.Stderr != "" {
+			t.Fatalf("ask=%v: unexpected stdout %q, stderr %q", ask, res.Stdout, res.Stderr)
+		}
+	}
+}
+
+func TestPromptAnswer(t *testing.T) {
+	var out bytes.Buffer
+	stdin := &recordingPipe{}
+	a := &promptAnswer{prompt: []byte("[p] "), password: "pw", stdin: stdin, out: &out, strip: true}
+	// The prompt arrives split across writes.
+	a.Write([]byte("warning\n[p"))
+	a.Write([]byte("] "))
+	if stdin.String() != "pw\n" || stdin.closed {
+		t.Fatalf("expected one answer, got %q closed=%v", stdin.String(), stdin.closed)
+	}
+	// Asked again: the password was wrong.
+	a.Write([]byte("Sorry, try again.\n[p] "))
+	a.Write([]byte("sudo: no password was provided\n"))
+	a.Flush()
+	if stdin.String() != "pw\n" || !stdin.closed {
+		t.Fatalf("expected stdin closed after a second prompt, got %q closed=%v", stdin.String(), stdin.closed)
+	}
+	if want := "warning\nSorry, try again.\nsudo: no password was provided\n"; out.String() != want {
+		t.Fatalf("output %q, want %q", out.String(), want)
+	}
+}
+
+type recordingPipe struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (p *recordingPipe) Close() error {
+	p.closed = true
+	return nil
+}
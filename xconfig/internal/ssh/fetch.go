@@ -0,0 +1,284 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"xconfig/internal/inventory"
+)
+
+// 文件拉取：远端文件经 SFTP（或回退为 session stdout）流式下载到本地目标目录中的临时
+// 文件，边下载边计算 SHA-256 与远端校验和比对，一致后再 rename 到目标路径。启用
+// become 时先以提权身份把文件复制到 /tmp 并交给登录用户，再下载该副本。
+
+// FetchOptions tune FetchFile.
+type FetchOptions struct {
+	// Flat stores the file at dest instead of dest/<host>/<src>.
+	Flat bool
+	// FailOnMissing fails when src does not exist on the host.
+	FailOnMissing bool
+	// ValidateChecksum compares the downloaded file with the remote SHA-256.
+	ValidateChecksum bool
+}
+
+// FetchDest returns the local path a file fetched from host is stored at.
+func FetchDest(host, src, dest string, flat bool) string {
+	if flat {
+		if strings.HasSuffix(dest, "/") {
+			return filepath.Join(dest, path.Base(src))
+		}
+		return dest
+	}
+	// Cleaning against "/" drops any ".." so the file stays under dest.
+	rel := strings.TrimPrefix(path.Clean("/"+src), "/")
+	return filepath.Join(dest, host, filepath.FromSlash(rel))
+}
+
+// FetchFile downloads src from the host to the local tree under dest. The
+// result is OK when the local copy already matches; in check mode nothing
+// is written.
+func FetchFile(ctx context.Context, h inventory.Host, src, dest string, opts FetchOptions, diff, check bool) CommandResult {
+	local := FetchDest(h.Name, src, dest, opts.Flat)
+	st, err := statRemote(ctx, h, src)
+	if err != nil {
+		return transferError(ctx, h, err)
+	}
+	data := map[string]interface{}{"file": src, "dest": local}
+	switch {
+	case !st.Exists && opts.FailOnMissing:
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("the remote file %s does not exist", src), Data: data}
+	case !st.Exists:
+		return CommandResult{Host: h.Name, ReturnMsg: "OK", Output: fmt.Sprintf("the remote file %s does not exist, not transferring", src), Data: data}
+	case st.IsDir:
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("%s is a directory, fetch only copies files", src), Data: data}
+	}
+	data["remote_checksum"] = st.Checksum
+	data["size"] = st.Size
+
+	before, localSum, err := readLocal(local)
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error(), Data: data}
+	}
+	if localSum == st.Checksum {
+		data["checksum"] = localSum
+		return CommandResult{Host: h.Name, ReturnMsg: "OK", Output: fmt.Sprintf("%s is up to date", local), Data: data}
+	}
+
+	if check {
+		res := CommandResult{Host: h.Name, ReturnMsg: "CHANGED", Output: fmt.Sprintf("would fetch %s to %s", src, local), Data: data}
+		if diff {
+			var after []byte
+			if before != nil && st.Size <= maxDiffSize {
+				if out := RunShellCommand(ctx, h, "cat "+ShellQuote(src)); out.ReturnMsg == "CHANGED" {
+					after = []byte(out.Stdout)
+				}
+			}
+			if d := localDiff(local, before, after); d != "" {
+				res.Output = d
+			}
+		}
+		return res
+	}
+
+	tmp, sum, after, err := download(ctx, h, src, local, st)
+	if err != nil {
+		return transferError(ctx, h, fmt.Errorf("fetch %s: %w", src, err))
+	}
+	data["checksum"] = sum
+	if opts.ValidateChecksum && sum != st.Checksum {
+		os.Remove(tmp)
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1,
+			Output: fmt.Sprintf("checksum mismatch for %s: remote %s, downloaded %s", src, st.Checksum, sum), Data: data}
+	}
+	if err := os.Rename(tmp, local); err != nil {
+		os.Remove(tmp)
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error(), Data: data}
+	}
+
+	res := CommandResult{Host: h.Name, ReturnMsg: "CHANGED", Output: fmt.Sprintf("fetched %s to %s", src, local), Data: data}
+	if diff {
+		if d := localDiff(local, before, after); d != "" {
+			res.Output = d
+		}
+	}
+	return res
+}
+
+// readLocal returns the checksum of a previously fetched copy and, when it
+// is small enough to diff, its content. A missing file has an empty
+// checksum and empty (non-nil) content.
+func readLocal(p string) ([]byte, string, error) {
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return []byte{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		return nil, "", fmt.Errorf("%s is a directory", p)
+	}
+	hash := sha256.New()
+	var content bytes.Buffer
+	w := io.Writer(hash)
+	if info.Size() <= maxDiffSize {
+		w = io.MultiWriter(hash, &content)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return nil, "", err
+	}
+	if info.Size() > maxDiffSize {
+		return nil, hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	return content.Bytes(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// localDiff compares the previous local copy with the fetched content; nil
+// content means the file was too large to diff.
+func localDiff(local string, before, after []byte) string {
+	if before == nil || after == nil {
+		return fmt.Sprintf("%s: diff skipped, file larger than %d bytes\n", local, maxDiffSize)
+	}
+	if bytes.IndexByte(before, 0) >= 0 || bytes.IndexByte(after, 0) >= 0 {
+		return fmt.Sprintf("%s: diff skipped, binary file\n", local)
+	}
+	return Diff(string(before), string(after), local)
+}
+
+// download streams src into a temporary file next to local and returns
+// its path, its checksum and, for small files, its content. The caller
+// renames the file into place.
+func download(ctx context.Context, h inventory.Host, src, local string, st remoteStat) (string, string, []byte, error) {
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return "", "", nil, err
+	}
+	// Keep private files private on the control node.
+	perm := os.FileMode(0o600)
+	if m, err := strconv.ParseUint(st.Mode, 8, 32); err == nil && m&0o004 != 0 {
+		perm = 0o644
+	}
+	tmp := filepath.Join(filepath.Dir(local), "."+filepath.Base(local)+".xconfig-"+randomSuffix())
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return "", "", nil, err
+	}
+	hash := sha256.New()
+	var content bytes.Buffer
+	w := io.MultiWriter(f, hash)
+	if st.Size <= maxDiffSize {
+		w = io.MultiWriter(f, hash, &content)
+	}
+
+	remote := src
+	if h.Become {
+		// The login user may not be able to read src; copy it as the become
+		// user to a file the login user can download.
+		var cleanup func()
+		remote, cleanup, err = stageForFetch(ctx, h, src)
+		if cleanup != nil {
+			defer cleanup()
+		}
+	}
+	if err == nil {
+		err = streamFrom(ctx, h, remote, w)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", "", nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if st.Size > maxDiffSize {
+		return tmp, sum, nil, nil
+	}
+	return tmp, sum, content.Bytes(), nil
+}
+
+// stageForFetch copies src as the become user into a private staging
+// directory and hands the copy to the login user: root chowns it, another
+// become user grants the login user read access through an ACL. cleanup
+// removes the directory as the login user.
+func stageForFetch(ctx context.Context, h inventory.Host, src string) (string, func(), error) {
+	res := RunShellCommand(ctx, loginHost(h), "id -un")
+	if res.ReturnMsg != "CHANGED" {
+		return "", nil, fmt.Errorf("id -un: %s", strings.TrimSpace(res.Output))
+	}
+	user := strings.TrimSpace(res.Stdout)
+	dir, cleanup, err := stagingDir(ctx, h, "wx")
+	if err != nil {
+		return "", nil, err
+	}
+	tmp := path.Join(dir, path.Base(src))
+	give := "chown " + ShellQuote(user) + ` "$tmp"`
+	if sharedUser(h) != "" {
+		give = grantScript(user, "r", tmp)
+	}
+	res = RunShellCommand(ctx, h, fmt.Sprintf("set -e\numask 077\ntmp=%s\ncp %s \"$tmp\"\n%s", ShellQuote(tmp), ShellQuote(src), give))
+	if res.ReturnMsg != "CHANGED" {
+		cleanup()
+		return "", nil, fmt.Errorf("stage %s: %s", src, strings.TrimSpace(res.Output))
+	}
+	return tmp, cleanup, nil
+}
+
+// streamFrom copies the remote file p into w as the login user, over SFTP
+// when the host offers it.
+func streamFrom(ctx context.Context, h inventory.Host, p string, w io.Writer) error {
+	client, err := defaultPool.SFTP(ctx, h)
+	if errors.Is(err, errNoSFTP) {
+		return streamFromShell(ctx, h, p, w)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	f, err := client.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteTo(w)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// streamFromShell reads the remote file with `cat` for servers without SFTP.
+func streamFromShell(ctx context.Context, h inventory.Host, p string, w io.Writer) error {
+	session, err := defaultPool.NewSession(ctx, h)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stderr lockedBuffer
+	session.Stdout = w
+	session.Stderr = &stderr
+	if err := runSession(ctx, session, "cat "+ShellQuote(p)); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchDest(t *testing.T) {
+	cases := []struct {
+		src, dest string
+		flat      bool
+		want      string
+	}{
+		{"/var/log/app.log", "backup", false, filepath.Join("backup", "web1", "var", "log", "app.log")},
+		{"../../etc/passwd", "backup", false, filepath.Join("backup", "web1", "etc", "passwd")},
+		{"/etc/app.conf", "out/app-web1.conf", true, "out/app-web1.conf"},
+		{"/etc/app.conf", "out/", true, filepath.Join("out", "app.conf")},
+	}
+	for _, c := range cases {
+		if got := FetchDest("web1", c.src, c.dest, c.flat); got != c.want {
+			t.Errorf("FetchDest(%q, %q, %v) = %q, want %q", c.src, c.dest, c.flat, got, c.want)
+		}
+	}
+}
+
+func TestFetchFile(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web1")
+	opts := FetchOptions{FailOnMissing: true, ValidateChecksum: true}
+
+	src := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(src, []byte("started\n"), 0o640)
+	dest := t.TempDir()
+	local := FetchDest("web1", src, dest, false)
+
+	res := FetchFile(context.Background(), h, src, dest, opts, false, true)
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "would fetch") {
+		t.Fatalf("check mode: expected a predicted fetch, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Fatalf("check mode wrote %s", local)
+	}
+
+	res = FetchFile(context.Background(), h, src, dest, opts, false, false)
+	if res.ReturnMsg != "CHANGED" || res.Data["checksum"] != Checksum([]byte("started\n")) || res.Data["dest"] != local {
+		t.Fatalf("expected CHANGED with checksum, got %s: %s %v", res.ReturnMsg, res.Output, res.Data)
+	}
+	if info, _ := os.Stat(local); readFile(t, local) != "started\n" || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected local copy, mode %v", info.Mode())
+	}
+	if res := FetchFile(context.Background(), h, src, dest, opts, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK on rerun, got %s: %s", res.ReturnMsg, res.Output)
+	}
+
+	os.WriteFile(src, []byte("started\nstopped\n"), 0o640)
+	res = FetchFile(context.Background(), h, src, dest, opts, true, false)
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "+stopped") {
+		t.Fatalf("expected a diff against the previous copy, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(local))
+	if len(entries) != 1 {
+		t.Fatalf("expected no temporary file left behind, got %v", entries)
+	}
+}
+
+func TestFetchFileMissingAndDirectory(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web1")
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.log")
+
+	if res := FetchFile(context.Background(), h, missing, t.TempDir(), FetchOptions{FailOnMissing: true}, false, false); res.ReturnMsg != "FAILED" {
+		t.Fatalf("expected a missing file to fail, got %s", res.ReturnMsg)
+	}
+	if res := FetchFile(context.Background(), h, missing, t.TempDir(), FetchOptions{}, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected a missing file to be ignored, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if res := FetchFile(context.Background(), h, dir, t.TempDir(), FetchOptions{}, false, false); res.ReturnMsg != "FAILED" || !strings.Contains(res.Output, "directory") {
+		t.Fatalf("expected a directory to be refused, got %s: %s", res.ReturnMsg, res.Output)
+	}
+}
+
+func TestFetchFileFlatWithoutSFTP(t *testing.T) {
+	srv := shellServer(t)
+	srv.sftp = false
+	defer CloseAll()
+	h := srv.host("web1")
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("port=80\n"), 0o644)
+	dest := filepath.Join(t.TempDir(), "configs") + "/"
+
+	res := FetchFile(context.Background(), h, src, dest, FetchOptions{Flat: true, ValidateChecksum: true}, false, false)
+	local := filepath.Join(dest, "app.conf")
+	if res.ReturnMsg != "CHANGED" || res.Data["dest"] != local {
+		t.Fatalf("expected a flat fetch, got %s: %s %v", res.ReturnMsg, res.Output, res.Data)
+	}
+	if info, _ := os.Stat(local); readFile(t, local) != "port=80\n" || info.Mode().Perm() != 0o644 {
+		t.Fatalf("unexpected local copy, mode %v", info.Mode())
+	}
+}
+
+func TestFetchFileStagesPrivatelyForBecomeUser(t *testing.T) {
+	srv, log := becomeShellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	h.Become, h.BecomeUser = true, "app"
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("secret=1\n"), 0o600)
+	dest := t.TempDir() + "/"
+	res := FetchFile(context.Background(), h, src, dest, FetchOptions{Flat: true}, false, false)
+	if res.ReturnMsg != "CHANGED" || readFile(t, filepath.Join(dest, "app.conf")) != "secret=1\n" {
+		t.Fatalf("unexpected fetch: %s: %s", res.ReturnMsg, res.Output)
+	}
+
+	// app may create files in the private staging directory; its copy is
+	// only opened to the login user.
+	calls := aclLog(t, log)
+	if len(calls) != 2 {
+		t.Fatalf("expected two setfacl calls, got %v", calls)
+	}
+	dir, file := calls[0], calls[1]
+	if dir[0] != "u:app:wx" || dir[2] != "700" || !strings.HasSuffix(file[0], ":r") || file[2] != "600" || filepath.Dir(file[1]) != dir[1] {
+		t.Fatalf("unexpected hand-off: %v", calls)
+	}
+	if _, err := os.Stat(dir[1]); !os.IsNotExist(err) {
+		t.Fatalf("staging directory %s was left behind: %v", dir[1], err)
+	}
+}
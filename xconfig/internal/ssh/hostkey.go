@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"xconfig/internal/inventory"
+)
+
+// Host key checking modes.
+const (
+	// HostKeyStrict only accepts hosts whose key is already in known_hosts.
+	HostKeyStrict = "strict"
+	// HostKeyAcceptNew records unknown hosts on first use (TOFU) but still
+	// rejects a changed key.
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyOff disables host key verification entirely.
+	HostKeyOff = "off"
+)
+
+var (
+	// DefaultHostKeyChecking applies to hosts that do not set
+	// ansible_host_key_checking. The CLI overrides it with --host-key-checking.
+	DefaultHostKeyChecking = HostKeyAcceptNew
+	// DefaultKnownHostsFile applies to hosts that do not set
+	// ansible_ssh_known_hosts_file. Empty means ~/.ssh/known_hosts.
+	DefaultKnownHostsFile = ""
+)
+
+// knownHostsMu serialises appends to known_hosts files in accept-new mode.
+var knownHostsMu sync.Mutex
+
+// HostKeyMismatchError is returned when a host presents a key that differs from
+// the one recorded in known_hosts, which may indicate a man-in-the-middle.
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+	Known       []knownhosts.KnownKey
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	var lines []string
+	for _, k := range e.Known {
+		lines = append(lines, fmt.Sprintf("%s:%d", k.Filename, k.Line))
+	}
+	return fmt.Sprintf("HOST KEY VERIFICATION FAILED: %s presented %s which does not match known_hosts (%s); possible man-in-the-middle attack",
+		e.Host, e.Fingerprint, strings.Join(lines, ", "))
+}
+
+// HostKeyUnknownError is returned in strict mode for hosts without a
+// known_hosts entry.
+type HostKeyUnknownError struct {
+	Host        string
+	Fingerprint string
+	File        string
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key for %s (%s) is not in %s and host key checking is strict", e.Host, e.Fingerprint, e.File)
+}
+
+// NormalizeHostKeyChecking maps user input (including Ansible's boolean form)
+// to one of the HostKey* modes.
+func NormalizeHostKeyChecking(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "strict", "yes", "true":
+		return HostKeyStrict, nil
+	case "accept-new", "accept_new", "tofu":
+		return HostKeyAcceptNew, nil
+	case "off", "no", "false":
+		return HostKeyOff, nil
+	default:
+		return "", fmt.Errorf("unknown host key checking mode %q (want strict, accept-new or off)", mode)
+	}
+}
+
+func knownHostsPath(h inventory.Host) string {
+	path := h.KnownHostsFile
+	if path == "" {
+		path = DefaultKnownHostsFile
+	}
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+// hostKeyConfig returns the callback and preferred host key algorithms to use
+// when dialing h.
+func hostKeyConfig(h inventory.Host) (ssh.HostKeyCallback, []string, error) {
+	mode := h.HostKeyChecking
+	if mode == "" {
+		mode = DefaultHostKeyChecking
+	}
+	mode, err := NormalizeHostKeyChecking(mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	path := knownHostsPath(h)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if mode == HostKeyStrict {
+			return nil, nil, fmt.Errorf("known_hosts file %s does not exist and host key checking is strict", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, nil, err
+		}
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err := knownhosts.New(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load known_hosts %s: %v", path, err)
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := db(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			return &HostKeyMismatchError{Host: hostname, Fingerprint: fingerprint, Known: keyErr.Want}
+		}
+		if mode == HostKeyStrict {
+			return &HostKeyUnknownError{Host: hostname, Fingerprint: fingerprint, File: path}
+		}
+		return appendKnownHost(path, hostname, key)
+	}
+	return callback, knownAlgorithms(db, h), nil
+}
+
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+// probeKey never matches a known key, so checking it reveals which key types
+// known_hosts holds for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "" }
+func (probeKey) Marshal() []byte                     { return nil }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// knownAlgorithms restricts negotiation to the key types already recorded for
+// h. Without it a server offering a different key type than the one on file
+// would be reported as a mismatch.
+func knownAlgorithms(db ssh.HostKeyCallback, h inventory.Host) []string {
+	addr := net.JoinHostPort(h.Address, h.Port)
+	remote := &net.TCPAddr{IP: net.ParseIP(h.Address)}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(db(addr, remote, probeKey{}), &keyErr) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var algos []string
+	add := func(names ...string) {
+		for _, n := range names {
+			if !seen[n] {
+				seen[n] = true
+				algos = append(algos, n)
+			}
+		}
+	}
+	for _, k := range keyErr.Want {
+		switch t := k.Key.Type(); t {
+		case ssh.KeyAlgoRSA:
+			add(ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			add(t)
+		}
+	}
+	return algos
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyAcceptNewThenStrict(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.host("web1")
+	h.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	h.HostKeyChecking = HostKeyStrict
+	if _, err := dial(context.Background(), h, nil); err == nil {
+		t.Fatalf("expected strict mode to reject a missing known_hosts file")
+	}
+
+	h.HostKeyChecking = HostKeyAcceptNew
+	client, err := dial(context.Background(), h, nil)
+	if err != nil {
+		t.Fatalf("accept-new dial: %v", err)
+	}
+	client.Close()
+
+	data, err := os.ReadFile(h.KnownHostsFile)
+	if err != nil {
+		t.Fatalf("read known_hosts: %v", err)
+	}
+	if !strings.Contains(string(data), "ssh-ed25519") {
+		t.Fatalf("expected recorded key, got %q", data)
+	}
+
+	h.HostKeyChecking = HostKeyStrict
+	client, err = dial(context.Background(), h, nil)
+	if err != nil {
+		t.Fatalf("strict dial after recording key: %v", err)
+	}
+	client.Close()
+}
+
+func TestHostKeyMismatch(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.host("web1")
+	h.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	h.HostKeyChecking = HostKeyAcceptNew
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	other, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("public key: %v", err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, other)
+	if err := os.WriteFile(h.KnownHostsFile, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("write known_hosts: %v", err)
+	}
+
+	_, err = dial(context.Background(), h, nil)
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected HostKeyMismatchError, got %v", err)
+	}
+
+	res := RunShellCommand(context.Background(), h, "id")
+	if res.ReturnMsg != "UNREACHABLE" || !strings.Contains(res.Output, "HOST KEY VERIFICATION FAILED") {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+	"xconfig/internal/inventory"
+)
+
+// jumpHost returns the bastion that directly precedes h in its jump chain.
+// The bastion keeps the earlier hops as its own JumpHosts, so chains are
+// resolved recursively. A bastion defined in the inventory connects with its
+// own user, port and credentials; the spec overrides user and port, and the
+// user, credentials and host key settings of h fill in whatever is missing.
+func jumpHost(h inventory.Host) inventory.Host {
+	n := len(h.JumpHosts)
+	spec := h.JumpHosts[n-1]
+	user, host, port := inventory.ParseJumpSpec(spec)
+	b, ok := h.Bastions[spec]
+	if !ok {
+		b = inventory.Host{Address: host, Port: "22"}
+	}
+	b.Name = spec
+	if user != "" {
+		b.User = user
+	}
+	if port != "" {
+		b.Port = port
+	}
+	if b.User == "" {
+		b.User = h.User
+	}
+	if b.KeyFile == "" && b.Password == "" {
+		b.KeyFile, b.CertFile, b.PassphraseFile, b.Password = h.KeyFile, h.CertFile, h.PassphraseFile, h.Password
+	}
+	if b.HostKeyChecking == "" {
+		b.HostKeyChecking = h.HostKeyChecking
+	}
+	if b.KnownHostsFile == "" {
+		b.KnownHostsFile = h.KnownHostsFile
+	}
+	b.JumpHosts = h.JumpHosts[:n-1]
+	b.Bastions = h.Bastions
+	return b
+}
+
+// dialThrough opens a TCP connection to addr from the bastion and performs
+// the SSH handshake over it.
+func dialThrough(ctx context.Context, bastion *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := bastion.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, addr, config)
+}
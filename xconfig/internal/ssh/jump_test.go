@@ -0,0 +1,75 @@
+package ssh
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"xconfig/internal/inventory"
+)
+
+func TestClientPoolDialsThroughBastions(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile, signer := writeKey(t, t.TempDir(), "")
+	first := newTestServer(t)
+	second := newTestServer(t)
+	target := newTestServer(t)
+	for _, s := range []*testServer{first, second, target} {
+		s.authorized = []ssh.PublicKey{signer.PublicKey()}
+	}
+
+	pool := NewClientPool()
+	defer pool.Close()
+	h := target.host("private1")
+	h.Password = ""
+	h.KeyFile = keyFile
+	h.HostKeyChecking = HostKeyOff
+	h.JumpHosts = []string{"tester@" + first.addr, second.addr}
+
+	session, err := pool.NewSession(context.Background(), h)
+	if err != nil {
+		t.Fatalf("NewSession through bastions: %v", err)
+	}
+	out, err := session.CombinedOutput("hostname")
+	session.Close()
+	if err != nil || string(out) != "ran: hostname" {
+		t.Fatalf("unexpected result %q: %v", out, err)
+	}
+	for name, s := range map[string]*testServer{"first": first, "second": second, "target": target} {
+		if s.connections() != 1 {
+			t.Fatalf("%s: expected 1 connection, got %d", name, s.connections())
+		}
+	}
+}
+
+func TestJumpHostCredentials(t *testing.T) {
+	target := inventory.Host{
+		User:     "deploy",
+		KeyFile:  "/keys/deploy",
+		Password: "target-secret",
+		Bastions: map[string]inventory.Host{
+			"edge":         {Address: "203.0.113.10", User: "ops", Port: "2222", Password: "edge-secret"},
+			"root@edge:22": {Address: "203.0.113.10", User: "ops", Port: "2222", Password: "edge-secret"},
+			"gate":         {Address: "203.0.113.11", Port: "22"},
+		},
+	}
+	for _, c := range []struct {
+		spec                               string
+		address, user, port, key, password string
+	}{
+		{"edge", "203.0.113.10", "ops", "2222", "", "edge-secret"},
+		{"root@edge:22", "203.0.113.10", "root", "22", "", "edge-secret"},
+		{"gate", "203.0.113.11", "deploy", "22", "/keys/deploy", "target-secret"},
+		{"10.0.0.5", "10.0.0.5", "deploy", "22", "/keys/deploy", "target-secret"},
+	} {
+		h := target
+		h.JumpHosts = []string{"first", c.spec}
+		b := jumpHost(h)
+		if b.Address != c.address || b.User != c.user || b.Port != c.port || b.KeyFile != c.key || b.Password != c.password {
+			t.Errorf("%s: unexpected bastion %+v", c.spec, b)
+		}
+		if len(b.JumpHosts) != 1 || b.JumpHosts[0] != "first" || b.Bastions == nil {
+			t.Errorf("%s: expected the earlier hops to stay with the bastion, got %v", c.spec, b.JumpHosts)
+		}
+	}
+}
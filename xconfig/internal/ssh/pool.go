@@ -0,0 +1,242 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"xconfig/internal/inventory"
+)
+
+// ClientPool keeps one authenticated *ssh.Client per host so that every
+// command of a run shares a single TCP connection and handshake. Each command
+// still gets its own session, which SSH multiplexes over the connection.
+type ClientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+}
+
+type pooledClient struct {
+	mu     sync.Mutex
+	client *ssh.Client
+	// dialing is the connection attempt in flight, shared by every caller
+	// that needs the host meanwhile.
+	dialing *dialCall
+}
+
+// dialCall is one connection attempt; done is closed once client and err
+// are set.
+type dialCall struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// NewClientPool creates an empty connection pool.
+func NewClientPool() *ClientPool {
+	return &ClientPool{clients: make(map[string]*pooledClient)}
+}
+
+// defaultPool backs RunShellCommand and the helpers built on top of it.
+var defaultPool = NewClientPool()
+
+// CloseAll closes every connection held by the default pool. The executor
+// calls it when a run ends.
+func CloseAll() { defaultPool.Close() }
+
+func poolKey(h inventory.Host) string {
+	key := fmt.Sprintf("%s@%s:%s", h.User, h.Address, h.Port)
+	if len(h.JumpHosts) > 0 {
+		key += " via " + strings.Join(h.JumpHosts, ",")
+	}
+	return key
+}
+
+func (p *ClientPool) entry(h inventory.Host) *pooledClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := poolKey(h)
+	pc, ok := p.clients[key]
+	if !ok {
+		pc = &pooledClient{}
+		p.clients[key] = pc
+	}
+	return pc
+}
+
+// Client returns the cached client for h, dialing a new one if none is alive.
+// Concurrent callers share a single dial, which runs without holding any
+// lock; a caller whose ctx ends stops waiting for it.
+func (p *ClientPool) Client(ctx context.Context, h inventory.Host) (*ssh.Client, error) {
+	pc := p.entry(h)
+	for {
+		pc.mu.Lock()
+		if pc.client != nil {
+			client := pc.client
+			pc.mu.Unlock()
+			return client, nil
+		}
+		call := pc.dialing
+		if call == nil {
+			call = &dialCall{done: make(chan struct{})}
+			pc.dialing = call
+			pc.mu.Unlock()
+			p.connect(ctx, h, pc, call)
+			return call.client, call.err
+		}
+		pc.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// A dial cut short by its own caller's context says nothing about
+		// the host; try again with ours.
+		if call.err != nil && (errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			continue
+		}
+		return call.client, call.err
+	}
+}
+
+// connect dials h for call and caches the client in pc.
+func (p *ClientPool) connect(ctx context.Context, h inventory.Host, pc *pooledClient, call *dialCall) {
+	defer close(call.done)
+	// Hosts behind bastions are reached through the pooled connection of the
+	// last jump host, which in turn may sit behind the ones before it.
+	var via *ssh.Client
+	if len(h.JumpHosts) > 0 {
+		bastion := jumpHost(h)
+		c, err := p.Client(ctx, bastion)
+		if err != nil {
+			call.err = fmt.Errorf("jump host %s: %w", bastion.Name, err)
+		}
+		via = c
+	}
+	if call.err == nil {
+		call.client, call.err = dial(ctx, h, via)
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.dialing = nil
+	if call.err != nil {
+		return
+	}
+	client := call.client
+	pc.client = client
+	// Drop the cached client as soon as the connection goes away so the next
+	// caller reconnects instead of failing on a dead transport.
+	go func() {
+		client.Wait()
+		pc.mu.Lock()
+		if pc.client == client {
+			pc.client = nil
+		}
+		pc.mu.Unlock()
+	}()
+}
+
+// NewSession opens a new session on the pooled connection for h. When the
+// cached connection turns out to be broken it is discarded and a single
+// reconnect is attempted.
+func (p *ClientPool) NewSession(ctx context.Context, h inventory.Host) (*ssh.Session, error) {
+	session, err := p.newSession(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	if h.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("agent forwarding: %v", err)
+		}
+	}
+	return session, nil
+}
+
+func (p *ClientPool) newSession(ctx context.Context, h inventory.Host) (*ssh.Session, error) {
+	client, err := p.Client(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	p.invalidate(h, client)
+	client, err = p.Client(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Session error: %v", err)
+	}
+	return session, nil
+}
+
+// errNoSFTP means the host does not offer the sftp subsystem.
+var errNoSFTP = errors.New("sftp subsystem unavailable")
+
+// SFTP opens an SFTP client on the pooled connection for h. The client has
+// its own session; closing it leaves the connection open.
+func (p *ClientPool) SFTP(ctx context.Context, h inventory.Host) (*sftpClient, error) {
+	session, err := p.newSession(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("%w: %v", errNoSFTP, err)
+	}
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &sftpClient{Client: client, closeSession: session.Close}, nil
+}
+
+func (p *ClientPool) invalidate(h inventory.Host, client *ssh.Client) {
+	pc := p.entry(h)
+	pc.mu.Lock()
+	if pc.client == client {
+		pc.client = nil
+	}
+	pc.mu.Unlock()
+	client.Close()
+}
+
+// Close closes all pooled connections and empties the pool.
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = make(map[string]*pooledClient)
+	p.mu.Unlock()
+
+	for _, pc := range clients {
+		pc.mu.Lock()
+		if pc.client != nil {
+			pc.client.Close()
+			pc.client = nil
+		}
+		pc.mu.Unlock()
+	}
+}
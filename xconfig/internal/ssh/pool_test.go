@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"xconfig/internal/inventory"
+)
+
+func TestClientPoolReusesConnection(t *testing.T) {
+	srv := newTestServer(t)
+	pool := NewClientPool()
+	defer pool.Close()
+	h := srv.host("web1")
+
+	for i := 0; i < 3; i++ {
+		session, err := pool.NewSession(context.Background(), h)
+		if err != nil {
+			t.Fatalf("NewSession: %v", err)
+		}
+		out, err := session.CombinedOutput("id")
+		session.Close()
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if string(out) != "ran: id" {
+			t.Fatalf("unexpected output %q", out)
+		}
+	}
+	if got := srv.connections(); got != 1 {
+		t.Fatalf("expected 1 connection, got %d", got)
+	}
+}
+
+func TestClientPoolReconnectsAfterDrop(t *testing.T) {
+	srv := newTestServer(t)
+	pool := NewClientPool()
+	defer pool.Close()
+	h := srv.host("web1")
+
+	client, err := pool.Client(context.Background(), h)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	client.Close()
+
+	session, err := pool.NewSession(context.Background(), h)
+	if err != nil {
+		t.Fatalf("NewSession after drop: %v", err)
+	}
+	session.Close()
+	if got := srv.connections(); got != 2 {
+		t.Fatalf("expected a reconnect, got %d connections", got)
+	}
+}
+
+func TestClientPoolSharesConcurrentDials(t *testing.T) {
+	srv := newTestServer(t)
+	pool := NewClientPool()
+	defer pool.Close()
+	h := srv.host("web1")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pool.Client(context.Background(), h)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Client: %v", err)
+		}
+	}
+	if got := srv.connections(); got != 1 {
+		t.Fatalf("expected one shared dial, got %d connections", got)
+	}
+}
+
+func TestClientPoolDialHonoursContext(t *testing.T) {
+	// The listener accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, c)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	h := inventory.Host{Name: "stuck", Address: host, Port: port, User: "tester", Password: "secret", HostKeyChecking: HostKeyOff}
+
+	pool := NewClientPool()
+	defer pool.Close()
+	// The first caller dials; the second waits for that dial. Both give up
+	// when their own context ends.
+	for _, wait := range []time.Duration{200 * time.Millisecond, 100 * time.Millisecond} {
+		go func() { pool.Client(context.Background(), h) }()
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		start := time.Now()
+		_, err := pool.Client(ctx, h)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the deadline to stop the dial, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("dial ignored the context for %v", elapsed)
+		}
+	}
+}
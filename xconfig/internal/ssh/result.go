@@ -1,8 +1,69 @@
 package ssh
 
+import (
+	"strings"
+	"time"
+)
+
 type CommandResult struct {
 	Host       string
 	ReturnCode int
 	ReturnMsg  string
 	Output     string
+
+	// Stdout and Stderr hold the separated command streams. Output keeps the
+	// combined text that is printed to the user.
+	Stdout string
+	Stderr string
+	// Start and End bound the task execution on the host.
+	Start time.Time
+	End   time.Time
+	// Data carries module specific fields (e.g. "stat" for the stat module)
+	// that are merged into the registered result.
+	Data map[string]interface{}
+}
+
+// Registered converts the result into the map stored by `register:`, so that
+// later tasks can use expressions such as `result.rc != 0`,
+// `result.stdout_lines | length` or `st.stat.exists`.
+func (r CommandResult) Registered() map[string]interface{} {
+	stdout, stderr := r.Stdout, r.Stderr
+	if stdout == "" && stderr == "" {
+		stdout = r.Output
+	}
+	m := map[string]interface{}{
+		"stdout":       stdout,
+		"stderr":       stderr,
+		"stdout_lines": lines(stdout),
+		"stderr_lines": lines(stderr),
+		"rc":           r.ReturnCode,
+		"msg":          r.Output,
+		"changed":      r.ReturnMsg == "CHANGED",
+		"failed":       r.ReturnMsg == "FAILED" || r.ReturnMsg == "UNREACHABLE",
+		"skipped":      r.ReturnMsg == "SKIPPED",
+		"unreachable":  r.ReturnMsg == "UNREACHABLE",
+	}
+	if !r.Start.IsZero() {
+		m["start"] = r.Start.Format("2006-01-02 15:04:05.000000")
+		m["end"] = r.End.Format("2006-01-02 15:04:05.000000")
+		m["delta"] = r.End.Sub(r.Start).String()
+	}
+	for k, v := range r.Data {
+		m[k] = v
+	}
+	return m
+}
+
+// lines splits command output like Ansible's *_lines fields.
+func lines(s string) []interface{} {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return []interface{}{}
+	}
+	parts := strings.Split(s, "\n")
+	out := make([]interface{}, len(parts))
+	for i, p := range parts {
+		out[i] = strings.TrimSuffix(p, "\r")
+	}
+	return out
 }
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunShellCommandSeparatesStreams(t *testing.T) {
+	srv := newTestServer(t)
+	defer CloseAll()
+	srv.handler = func(cmd string) (string, int) { return "line1\nline2\n", 3 }
+	srv.stderr = func(cmd string) string { return "boom\n" }
+
+	res := RunShellCommand(context.Background(), srv.host("web"), "false")
+	if res.ReturnMsg != "FAILED" || res.ReturnCode != 3 {
+		t.Fatalf("expected FAILED rc=3, got %s rc=%d", res.ReturnMsg, res.ReturnCode)
+	}
+	if res.Stdout != "line1\nline2\n" || res.Stderr != "boom\n" {
+		t.Fatalf("streams not separated: stdout=%q stderr=%q", res.Stdout, res.Stderr)
+	}
+	if res.Start.IsZero() || res.End.Before(res.Start) {
+		t.Fatalf("expected start/end times, got %v %v", res.Start, res.End)
+	}
+
+	reg := res.Registered()
+	if reg["rc"] != 3 || reg["failed"] != true || reg["changed"] != false {
+		t.Fatalf("unexpected registered flags: %v", reg)
+	}
+	if lines := reg["stdout_lines"].([]interface{}); len(lines) != 2 || lines[1] != "line2" {
+		t.Fatalf("unexpected stdout_lines: %v", lines)
+	}
+	if lines := reg["stderr_lines"].([]interface{}); len(lines) != 1 || lines[0] != "boom" {
+		t.Fatalf("unexpected stderr_lines: %v", lines)
+	}
+	if _, ok := reg["delta"]; !ok {
+		t.Fatalf("expected delta in registered result")
+	}
+}
+
+func TestRegisteredMergesModuleData(t *testing.T) {
+	res := CommandResult{Host: "web", ReturnMsg: "OK", Output: "exists",
+		Data: map[string]interface{}{"stat": map[string]interface{}{"exists": true}}}
+	reg := res.Registered()
+	if reg["stdout"] != "exists" || reg["changed"] != false || reg["failed"] != false {
+		t.Fatalf("unexpected registered result: %v", reg)
+	}
+	if reg["stat"].(map[string]interface{})["exists"] != true {
+		t.Fatalf("expected stat.exists to be merged")
+	}
+}
+
+func TestRunShellCommandStopsAtDeadline(t *testing.T) {
+	srv := newTestServer(t)
+	defer CloseAll()
+	release := make(chan struct{})
+	defer close(release)
+	srv.handler = func(cmd string) (string, int) {
+		<-release
+		return "", 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res := RunShellCommand(ctx, srv.host("web"), "sleep 600")
+	if res.ReturnMsg != "FAILED" || res.ReturnCode != 124 || res.Output != "command timed out" {
+		t.Fatalf("expected a timeout failure, got %s rc=%d %q", res.ReturnMsg, res.ReturnCode, res.Output)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("command was not interrupted: took %s", time.Since(start))
+	}
+
+	cancelled, stop := context.WithCancel(context.Background())
+	stop()
+	if res := RunShellCommand(cancelled, srv.host("web"), "true"); res.ReturnCode != 130 {
+		t.Fatalf("expected rc=130 for a cancelled run, got %d", res.ReturnCode)
+	}
+}
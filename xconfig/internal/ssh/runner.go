@@ -1,89 +1,239 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 	"xconfig/internal/inventory"
 )
 
-// RunShellCommand 使用 Go 原生 SSH 实现，优先使用私钥，失败时回退密码认证
-func RunShellCommand(h inventory.Host, command string) CommandResult {
-	var authMethods []ssh.AuthMethod
-	var authMethodUsed string
-
-	// 优先尝试私钥认证
-	if h.KeyFile != "" {
-		if keyBytes, err := os.ReadFile(h.KeyFile); err == nil {
-			if signer, err := ssh.ParsePrivateKey(keyBytes); err == nil {
-				authMethods = append(authMethods, ssh.PublicKeys(signer))
-				authMethodUsed = "key"
-			}
-		}
-	}
+// dialTimeout bounds the TCP connect and the SSH handshake of a dial.
+const dialTimeout = 5 * time.Second
 
-	// 若未成功加载私钥，则尝试密码认证
-	if authMethodUsed != "key" && h.Password != "" {
-		authMethods = append(authMethods, ssh.Password(h.Password))
-		authMethodUsed = "password"
+// dial 使用 Go 原生 SSH 建立连接，认证方式的尝试顺序见 auth.go。
+// via 不为空时通过该跳板机的连接转发 TCP（等价于 ProxyJump）。
+// ctx 结束时中止尚未完成的连接与握手。
+func dial(ctx context.Context, h inventory.Host, via *ssh.Client) (*ssh.Client, error) {
+	auth, used, releaseAgent, err := authMethods(h)
+	if err != nil {
+		return nil, err
 	}
+	defer releaseAgent()
+	authMethodUsed := strings.Join(used, "+")
 
-	// 若无有效认证方式，返回失败
-	if len(authMethods) == 0 {
-		return CommandResult{
-			Host:       h.Name,
-			ReturnMsg:  "FAILED",
-			ReturnCode: 1,
-			Output:     "No valid SSH authentication method found (key or password)",
-		}
+	hostKeyCallback, hostKeyAlgorithms, err := hostKeyConfig(h)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &ssh.ClientConfig{
-		User:            h.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 跳过 host key 校验（生产环境建议自定义）
-		Timeout:         5 * time.Second,
+		User:              h.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
 	}
 
-	addr := fmt.Sprintf("%s:%s", h.Address, h.Port)
-	client, err := ssh.Dial("tcp", addr, config)
+	addr := net.JoinHostPort(h.Address, h.Port)
+	var client *ssh.Client
+	if via == nil {
+		var conn net.Conn
+		d := net.Dialer{Timeout: dialTimeout}
+		if conn, err = d.DialContext(ctx, "tcp", addr); err == nil {
+			client, err = handshake(ctx, conn, addr, config)
+		}
+	} else {
+		client, err = dialThrough(ctx, via, addr, config)
+	}
 	if err != nil {
-		return CommandResult{
-			Host:       h.Name,
-			ReturnMsg:  "FAILED",
-			ReturnCode: 1,
-			Output:     fmt.Sprintf("SSH dial error (%s): %v", authMethodUsed, err),
+		return nil, fmt.Errorf("SSH dial error (%s): %w", authMethodUsed, err)
+	}
+	if h.ForwardAgent {
+		if err := forwardAgent(client); err != nil {
+			client.Close()
+			return nil, err
 		}
 	}
-	defer client.Close()
+	return client, nil
+}
 
-	session, err := client.NewSession()
-	if err != nil {
-		return CommandResult{
-			Host:       h.Name,
-			ReturnMsg:  "FAILED",
-			ReturnCode: 1,
-			Output:     fmt.Sprintf("Session error: %v", err),
+// handshake performs the SSH handshake over conn. The handshake must finish
+// within dialTimeout, and conn is closed as soon as ctx is done; the deadline
+// is cleared once the client is up.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
 		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// RunShellCommand 在主机的复用连接上新开一个 session 执行命令，
+// 若主机启用了 become 则按提权方式包装命令。
+// ctx 取消或超时后关闭 session，命令以 rc=124（超时）或 rc=130（中断）失败。
+func RunShellCommand(ctx context.Context, h inventory.Host, command string) CommandResult {
+	if err := ctx.Err(); err != nil {
+		return Interrupted(h, err, CommandResult{})
+	}
+	command, prompt, err := becomeCommand(h, command)
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+
+	session, err := defaultPool.NewSession(ctx, h)
+	if err != nil {
+		return unreachable(h, err)
 	}
 	defer session.Close()
 
-	output, err := session.CombinedOutput(command)
+	usePty := becomeNeedsPty(h)
+	if usePty {
+		if err := session.RequestPty("xterm", 80, 200, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+			return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("request pty for become: %v", err)}
+		}
+	}
+
+	var stdout, stderr lockedBuffer
+	session.Stdout = &stdout
+	if usePty {
+		// A pty merges both streams; everything arrives on stdout.
+		session.Stderr = &stdout
+	} else {
+		session.Stderr = &stderr
+	}
+	// The password is only written once the become method asks for it.
+	var answer *promptAnswer
+	if prompt != "" {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("become: %v", err)}
+		}
+		if usePty {
+			answer = &promptAnswer{prompt: []byte(prompt), password: h.BecomePassword, stdin: stdin, out: &stdout, once: true}
+			session.Stdout, session.Stderr = answer, answer
+		} else {
+			answer = &promptAnswer{prompt: []byte(prompt), password: h.BecomePassword, stdin: stdin, out: &stderr, strip: true}
+			session.Stderr = answer
+		}
+	}
+	start := time.Now()
+	err = runSession(ctx, session, command)
+	if answer != nil {
+		answer.Flush()
+	}
 
 	result := CommandResult{
 		Host:   h.Name,
-		Output: string(output),
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Start:  start,
+		End:    time.Now(),
 	}
+	if usePty {
+		result.Stdout = stripBecomePrompt(result.Stdout)
+	}
+	result.Output = result.Stdout + result.Stderr
 
-	if err != nil {
-		result.ReturnMsg = "FAILED"
-		result.ReturnCode = 1
-	} else {
+	var exitErr *ssh.ExitError
+	switch {
+	case ctx.Err() != nil && err != nil:
+		return Interrupted(h, ctx.Err(), result)
+	case err == nil:
 		result.ReturnMsg = "CHANGED"
 		result.ReturnCode = 0
+	case errors.As(err, &exitErr):
+		result.ReturnMsg = "FAILED"
+		result.ReturnCode = exitErr.ExitStatus()
+	default:
+		result.ReturnMsg = "FAILED"
+		result.ReturnCode = 1
+		if result.Output == "" {
+			result.Output = err.Error()
+		}
 	}
 
 	return result
 }
+
+// runSession runs command and closes the session as soon as ctx is done, so a
+// hung remote command cannot block the caller. Closing the channel makes sshd
+// hang up on the remote process.
+func runSession(ctx context.Context, session *ssh.Session, command string) error {
+	if err := session.Start(command); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
+	}
+}
+
+// lockedBuffer collects session output. A cancelled command returns while
+// the session may still be copying output, so reads and writes are guarded.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Interrupted turns a cancelled or expired context into a failed result,
+// keeping whatever output the command produced before it was stopped.
+func Interrupted(h inventory.Host, err error, partial CommandResult) CommandResult {
+	partial.Host = h.Name
+	partial.ReturnMsg = "FAILED"
+	msg := "command cancelled"
+	partial.ReturnCode = 130
+	if errors.Is(err, context.DeadlineExceeded) {
+		msg = "command timed out"
+		partial.ReturnCode = 124
+	}
+	if partial.Output != "" && !strings.HasSuffix(partial.Output, "\n") {
+		partial.Output += "\n"
+	}
+	partial.Output += msg
+	return partial
+}
+
+// unreachable reports a connection-level failure. Host key problems are
+// surfaced verbatim so they stand out from ordinary command failures.
+func unreachable(h inventory.Host, err error) CommandResult {
+	return CommandResult{
+		Host:       h.Name,
+		ReturnMsg:  "UNREACHABLE",
+		ReturnCode: 255,
+		Output:     err.Error(),
+	}
+}
@@ -1,17 +1,20 @@
 package ssh
 
 import (
-	"encoding/base64"
+	"context"
 	"fmt"
 	"os"
-	"time"
+	"path"
+	"strings"
 
 	"xconfig/internal/inventory"
 )
 
-// RunRemoteScript uploads a script to a remote host, executes it, and cleans up
-func RunRemoteScript(h inventory.Host, scriptPath string) CommandResult {
-	content, err := os.ReadFile(scriptPath)
+// RunRemoteScript uploads a script to a remote host, executes it, and cleans up.
+// The script is streamed into a private staging directory, so neither other
+// users nor the process list ever see its content.
+func RunRemoteScript(ctx context.Context, h inventory.Host, scriptPath string) CommandResult {
+	f, err := os.Open(scriptPath)
 	if err != nil {
 		return CommandResult{
 			Host:       h.Name,
@@ -20,17 +23,24 @@ func RunRemoteScript(h inventory.Host, scriptPath string) CommandResult {
 			Output:     fmt.Sprintf("read script failed: %v", err),
 		}
 	}
+	defer f.Close()
 
-	encoded := base64.StdEncoding.EncodeToString(content)
-        remotePath := fmt.Sprintf("/tmp/xconfig-%d.sh", time.Now().UnixNano())
-
-	script := fmt.Sprintf(`
-		echo "%s" | base64 -d > %s && \
-		chmod +x %s && \
-		(if command -v bash >/dev/null 2>&1; then bash %s; else sh %s; fi); code=$? && \
-		rm -f %s && \
-		exit $code
-	`, encoded, remotePath, remotePath, remotePath, remotePath, remotePath)
+	dir, cleanup, err := stagingDir(ctx, h, "x")
+	if err != nil {
+		return transferError(ctx, h, fmt.Errorf("upload script: %w", err))
+	}
+	defer cleanup()
+	remotePath := path.Join(dir, path.Base(scriptPath))
+	if err := streamFile(ctx, h, f, remotePath); err != nil {
+		return transferError(ctx, h, fmt.Errorf("upload script: %w", err))
+	}
+	if user := sharedUser(h); user != "" {
+		if res := RunShellCommand(ctx, loginHost(h), grantScript(user, "r", remotePath)); res.ReturnMsg != "CHANGED" {
+			return transferError(ctx, h, fmt.Errorf("upload script: hand it to %s: %s", user, strings.TrimSpace(res.Output)))
+		}
+	}
 
-	return RunShellCommand(h, script)
+	p := ShellQuote(remotePath)
+	script := fmt.Sprintf("if command -v bash >/dev/null 2>&1; then bash %s; else sh %s; fi", p, p)
+	return RunShellCommand(ctx, h, script)
 }
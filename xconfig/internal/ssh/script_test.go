@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRemoteScript(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "setup.sh")
+	os.WriteFile(src, []byte("echo \"running $0\"\nexit 3\n"), 0o644)
+	res := RunRemoteScript(context.Background(), h, src)
+	if res.ReturnMsg != "FAILED" || res.ReturnCode != 3 || !strings.Contains(res.Stdout, "running /tmp/.xconfig-") {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	ran := strings.TrimPrefix(strings.TrimSpace(res.Stdout), "running ")
+	if _, err := os.Stat(filepath.Dir(ran)); !os.IsNotExist(err) {
+		t.Fatalf("staging directory for %s was left behind: %v", ran, err)
+	}
+
+	if res := RunRemoteScript(context.Background(), h, filepath.Join(t.TempDir(), "missing.sh")); res.ReturnMsg != "FAILED" || !strings.Contains(res.Output, "read script failed") {
+		t.Fatalf("expected a missing script to fail, got %+v", res)
+	}
+}
+
+func TestRunRemoteScriptStagesPrivatelyForBecomeUser(t *testing.T) {
+	srv, log := becomeShellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	h.Become, h.BecomeUser = true, "app"
+
+	src := filepath.Join(t.TempDir(), "setup.sh")
+	os.WriteFile(src, []byte("echo token=s3cret\n"), 0o600)
+	if res := RunRemoteScript(context.Background(), h, src); res.ReturnMsg != "CHANGED" || strings.TrimSpace(res.Stdout) != "token=s3cret" {
+		t.Fatalf("expected the script to run, got %s: %s", res.ReturnMsg, res.Output)
+	}
+
+	calls := aclLog(t, log)
+	if len(calls) != 2 {
+		t.Fatalf("expected two setfacl calls, got %v", calls)
+	}
+	dir, file := calls[0], calls[1]
+	if dir[0] != "u:app:x" || dir[2] != "700" || file[0] != "u:app:r" || file[2] != "600" || filepath.Dir(file[1]) != dir[1] {
+		t.Fatalf("unexpected hand-off: %v", calls)
+	}
+	if _, err := os.Stat(dir[1]); !os.IsNotExist(err) {
+		t.Fatalf("staging directory %s was left behind: %v", dir[1], err)
+	}
+}
@@ -0,0 +1,208 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"xconfig/internal/inventory"
+)
+
+// testServer is a minimal in-process SSH server. Every exec request is
+// answered by handler, which returns the command output and exit status;
+// stderr, when set, supplies text written to the error stream.
+type testServer struct {
+	addr    string
+	hostKey ssh.Signer
+	conns   int32
+	handler func(cmd string) (string, int)
+	stderr  func(cmd string) string
+	// exec, when set, replaces handler and also receives the session stdin.
+	exec func(cmd string, stdin io.Reader) (string, int)
+	// sftp enables the sftp subsystem, served from the local filesystem.
+	sftp bool
+	ln   net.Listener
+	// knownHosts keeps the host keys clients learn out of ~/.ssh.
+	knownHosts string
+	// binDir is searched before the system PATH by shellServer.
+	binDir string
+
+	// authorized lists the public keys accepted for publickey auth; ca, when
+	// set, additionally accepts user certificates it has signed.
+	authorized []ssh.PublicKey
+	ca         ssh.PublicKey
+	keyUsed    ssh.PublicKey
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &testServer{
+		addr:       ln.Addr().String(),
+		hostKey:    signer,
+		ln:         ln,
+		knownHosts: filepath.Join(t.TempDir(), "known_hosts"),
+		handler:    func(cmd string) (string, int) { return "ran: " + cmd, 0 },
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("auth rejected")
+		},
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return s.ca != nil && bytes.Equal(auth.Marshal(), s.ca.Marshal())
+		},
+		UserKeyFallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range s.authorized {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("key rejected")
+		},
+	}
+	config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		perms, err := checker.Authenticate(c, key)
+		if err == nil {
+			s.keyUsed = key
+		}
+		return perms, err
+	}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go s.serve(nc, config)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *testServer) serve(nc net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() == "direct-tcpip" {
+			go forwardTCP(nch)
+			continue
+		}
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type == "subsystem" && s.sftp {
+					req.Reply(true, nil)
+					go ssh.DiscardRequests(chReqs)
+					if server, err := sftp.NewServer(ch); err == nil {
+						server.Serve()
+						server.Close()
+					}
+					return
+				}
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+				var out string
+				var code int
+				if s.exec != nil {
+					out, code = s.exec(payload.Command, ch)
+				} else {
+					out, code = s.handler(payload.Command)
+				}
+				ch.Write([]byte(out))
+				if s.stderr != nil {
+					ch.Stderr().Write([]byte(s.stderr(payload.Command)))
+				}
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(code))
+				ch.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+// forwardTCP serves a direct-tcpip channel so the server can act as a bastion.
+func forwardTCP(nch ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nch.ExtraData(), &payload); err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, target)
+		ch.CloseWrite()
+	}()
+	io.Copy(target, ch)
+	target.Close()
+}
+
+// host returns an inventory entry pointing at the test server.
+func (s *testServer) host(name string) inventory.Host {
+	host, port, _ := net.SplitHostPort(s.addr)
+	return inventory.Host{Name: name, Address: host, Port: port, User: "tester", Password: "secret", KnownHostsFile: s.knownHosts}
+}
+
+func (s *testServer) connections() int { return int(atomic.LoadInt32(&s.conns)) }
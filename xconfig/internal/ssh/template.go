@@ -2,16 +2,23 @@ package ssh
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"text/template"
 
 	"xconfig/internal/inventory"
 )
 
-// RenderTemplate renders the given template file with data and uploads it to the remote host
-func RenderTemplate(h inventory.Host, src, dest string, data map[string]interface{}, diff bool) CommandResult {
+// RenderTemplate renders the given template file with data and uploads it to the remote host.
+// In check mode the host is only probed and the predicted result is returned.
+func RenderTemplate(ctx context.Context, h inventory.Host, src, dest string, attrs FileAttrs, data map[string]interface{}, diff, check bool) CommandResult {
 	content, err := os.ReadFile(src)
 	if err != nil {
 		return CommandResult{
@@ -42,39 +49,179 @@ func RenderTemplate(h inventory.Host, src, dest string, data map[string]interfac
 		}
 	}
 
-	newContent := buf.Bytes()
-	var diffText string
-	if diff {
-		before := RunShellCommand(h, fmt.Sprintf("cat %s 2>/dev/null || true", dest)).Output
-		diffText = Diff(before, string(newContent), dest)
-	}
+	return WriteContent(ctx, h, buf.Bytes(), dest, attrs, diff, check)
+}
 
-	encoded := base64.StdEncoding.EncodeToString(newContent)
-	script := fmt.Sprintf("echo \"%s\" | base64 -d > %s", encoded, dest)
+// WriteContent makes the remote file dest hold content with attrs. The file
+// is only rewritten when its checksum differs; attributes alone are fixed in
+// place.
+func WriteContent(ctx context.Context, h inventory.Host, content []byte, dest string, attrs FileAttrs, diff, check bool) CommandResult {
+	if err := attrs.Validate(); err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	return putFile(ctx, h, bytesSource(content), dest, attrs, diff, check)
+}
 
-	res := RunShellCommand(h, script)
-	if diff {
-		res.Output = diffText
+// UploadFile copies a local file to the remote host at dest path. A file is
+// placed inside dest when dest is a directory or ends with "/". A directory
+// is copied recursively into dest, or only its contents when src ends with
+// "/".
+func UploadFile(ctx context.Context, h inventory.Host, src, dest string, attrs FileAttrs, diff, check bool) CommandResult {
+	if err := attrs.Validate(); err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read file failed: %v", err)}
+	}
+	if info.IsDir() {
+		return uploadDir(ctx, h, src, dest, attrs, diff, check)
+	}
+	source, err := localSource(src)
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read file failed: %v", err)}
+	}
+	if strings.HasSuffix(dest, "/") {
+		dest = path.Join(dest, source.name)
+	}
+	res := putFile(ctx, h, source, dest, attrs, diff, check)
+	if res.Data != nil {
+		res.Data["src"] = src
 	}
 	return res
 }
 
-// UploadFile copies a local file to the remote host at dest path.
-func UploadFile(h inventory.Host, src, dest string, diff bool) CommandResult {
-	content, err := os.ReadFile(src)
+// uploadDir copies the tree under src file by file; every file is
+// transferred (and checked for changes) on its own.
+func uploadDir(ctx context.Context, h inventory.Host, src, dest string, attrs FileAttrs, diff, check bool) CommandResult {
+	root := dest
+	if !strings.HasSuffix(src, "/") {
+		root = path.Join(dest, filepath.Base(src))
+	}
+	var dirs, files []string
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, path.Join(root, filepath.ToSlash(rel)))
+		case d.Type().IsRegular():
+			files = append(files, rel)
+		}
+		return nil
+	})
 	if err != nil {
-		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read file failed: %v", err)}
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read directory failed: %v", err)}
 	}
-	var diffText string
-	if diff {
-		before := RunShellCommand(h, fmt.Sprintf("cat %s 2>/dev/null || true", dest)).Output
-		diffText = Diff(before, string(content), dest)
+
+	changed := false
+	var out []string
+	if created, res := makeRemoteDirs(ctx, h, dirs, attrs, check); res.ReturnMsg == "FAILED" {
+		return res
+	} else if len(created) > 0 {
+		changed = true
+		for _, d := range created {
+			out = append(out, d+"/ created")
+		}
 	}
-	encoded := base64.StdEncoding.EncodeToString(content)
-	script := fmt.Sprintf("echo \"%s\" | base64 -d > %s", encoded, dest)
-	res := RunShellCommand(h, script)
-	if diff {
-		res.Output = diffText
+
+	var changedFiles []interface{}
+	for _, rel := range files {
+		source, err := localSource(filepath.Join(src, rel))
+		if err != nil {
+			return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read file failed: %v", err)}
+		}
+		source.name = ""
+		target := path.Join(root, filepath.ToSlash(rel))
+		res := putFile(ctx, h, source, target, attrs, diff, check)
+		switch res.ReturnMsg {
+		case "CHANGED":
+			changed = true
+			changedFiles = append(changedFiles, target)
+			out = append(out, strings.TrimRight(res.Output, "\n"))
+		case "OK":
+		default:
+			res.Output = strings.Join(append(out, res.Output), "\n")
+			return res
+		}
+	}
+
+	res := CommandResult{Host: h.Name, ReturnMsg: "OK", Data: map[string]interface{}{"dest": root, "src": src, "files": len(files), "changed_files": changedFiles}}
+	if changed {
+		res.ReturnMsg = "CHANGED"
+		res.Output = strings.Join(out, "\n")
+	} else {
+		res.Output = fmt.Sprintf("%s is up to date (%d files)", root, len(files))
 	}
 	return res
 }
+
+// makeRemoteDirs creates the missing directories among dirs, applying the
+// owner and group of attrs to new ones, and returns those it created (or
+// would create in check mode).
+func makeRemoteDirs(ctx context.Context, h inventory.Host, dirs []string, attrs FileAttrs, check bool) ([]string, CommandResult) {
+	if len(dirs) == 0 {
+		return nil, CommandResult{}
+	}
+	var b strings.Builder
+	b.WriteString("set -e\nfor d in")
+	for _, d := range dirs {
+		b.WriteString(" " + ShellQuote(d))
+	}
+	b.WriteString("; do\n  [ -d \"$d\" ] && continue\n  echo \"$d\"\n")
+	if !check {
+		b.WriteString("  mkdir -p \"$d\"\n")
+		b.WriteString(FileAttrs{Owner: attrs.Owner, Group: attrs.Group}.commands("d"))
+	}
+	b.WriteString("done")
+	res := RunShellCommand(ctx, h, b.String())
+	if res.ReturnMsg != "CHANGED" {
+		return nil, res
+	}
+	out := strings.TrimRight(res.Stdout, "\n")
+	if out == "" {
+		return nil, res
+	}
+	return strings.Split(out, "\n"), res
+}
+
+// Checksum returns the hex encoded SHA-256 of content, the same value
+// RemoteChecksum reports for a remote file.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// RemoteChecksum returns the SHA-256 of path on the host, or "" when the file
+// does not exist.
+func RemoteChecksum(ctx context.Context, h inventory.Host, path string) (string, error) {
+	q := ShellQuote(path)
+	res := RunShellCommand(ctx, h, fmt.Sprintf("if [ -f %s ]; then (sha256sum %s 2>/dev/null || shasum -a 256 %s) | cut -d' ' -f1; fi", q, q, q))
+	if res.ReturnMsg != "CHANGED" {
+		return "", fmt.Errorf("checksum %s: %s", path, strings.TrimSpace(res.Output))
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// ReadRemoteFile returns the content of path on the host, read as the become
+// user when become is enabled. exists is false when there is no such file.
+func ReadRemoteFile(ctx context.Context, h inventory.Host, path string) (content []byte, exists bool, err error) {
+	q := ShellQuote(path)
+	res := RunShellCommand(ctx, h, fmt.Sprintf("if [ -d %s ]; then echo %s is a directory >&2; exit 1; elif [ -e %s ]; then echo present && cat %s; fi", q, q, q, q))
+	if res.ReturnMsg != "CHANGED" {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		return nil, false, fmt.Errorf("read %s: %s", path, strings.TrimSpace(res.Output))
+	}
+	out, ok := strings.CutPrefix(res.Stdout, "present\n")
+	if !ok {
+		return nil, false, nil
+	}
+	return []byte(out), true, nil
+}
@@ -0,0 +1,291 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The transfer tests use shellServer: the "remote" host is the local
+// filesystem, reached through real sh and SFTP.
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUploadFileSkipsUnchangedContent(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("port=80\n"), 0o644)
+
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("first upload: expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	before, _ := os.Stat(dest)
+	res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false)
+	after, _ := os.Stat(dest)
+	if res.ReturnMsg != "OK" || !os.SameFile(before, after) {
+		t.Fatalf("second upload: expected OK without writing, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if res.Data["checksum"] != Checksum([]byte("port=80\n")) || res.Data["src"] != src {
+		t.Fatalf("unexpected result data: %v", res.Data)
+	}
+
+	os.WriteFile(src, []byte("port=8080\n"), 0o644)
+	res = UploadFile(context.Background(), h, src, dest, FileAttrs{}, true, false)
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "+port=8080") {
+		t.Fatalf("diff upload: expected CHANGED with diff, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, true, false); res.ReturnMsg != "OK" {
+		t.Fatalf("diff rerun: expected OK, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if got := readFile(t, dest); got != "port=8080\n" {
+		t.Fatalf("unexpected remote content: %q", got)
+	}
+}
+
+func TestUploadFileReplacesAtomically(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "app.conf")
+	os.WriteFile(dest, []byte("old\n"), 0o640)
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("new\n"), 0o600)
+
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	info, _ := os.Stat(dest)
+	if readFile(t, dest) != "new\n" || info.Mode().Perm() != 0o640 {
+		t.Fatalf("expected new content with the existing mode kept, got mode %v", info.Mode())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected no temporary file left behind, got %v", entries)
+	}
+}
+
+func TestUploadFileAppliesMode(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "run.sh")
+	os.WriteFile(src, []byte("#!/bin/sh\n"), 0o644)
+	dest := filepath.Join(t.TempDir(), "run.sh")
+
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{Mode: "0750"}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if info, _ := os.Stat(dest); info.Mode().Perm() != 0o750 {
+		t.Fatalf("expected mode 0750, got %v", info.Mode())
+	}
+
+	res := UploadFile(context.Background(), h, src, dest, FileAttrs{Mode: "0700"}, false, false)
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "mode 0700") {
+		t.Fatalf("expected an attribute-only change, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if info, _ := os.Stat(dest); info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected mode 0700, got %v", info.Mode())
+	}
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{Mode: "700"}, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK on rerun, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{Mode: "u+x"}, false, false); res.ReturnMsg != "FAILED" {
+		t.Fatalf("expected an invalid mode to fail, got %s", res.ReturnMsg)
+	}
+}
+
+func TestUploadFileIntoDirectory(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "motd")
+	os.WriteFile(src, []byte("hi\n"), 0o644)
+	dest := t.TempDir()
+
+	res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false)
+	if res.ReturnMsg != "CHANGED" || res.Data["dest"] != filepath.Join(dest, "motd") {
+		t.Fatalf("expected the file to be placed in the directory, got %s: %v", res.ReturnMsg, res.Data)
+	}
+	if readFile(t, filepath.Join(dest, "motd")) != "hi\n" {
+		t.Fatalf("unexpected content")
+	}
+}
+
+func TestUploadDirectory(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "site")
+	os.MkdirAll(filepath.Join(src, "css"), 0o755)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte("<h1>hi</h1>\n"), 0o644)
+	os.WriteFile(filepath.Join(src, "css", "app.css"), []byte("body{}\n"), 0o644)
+	dest := t.TempDir()
+
+	res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false)
+	if res.ReturnMsg != "CHANGED" || res.Data["files"] != 2 {
+		t.Fatalf("expected CHANGED for 2 files, got %s: %s %v", res.ReturnMsg, res.Output, res.Data)
+	}
+	if readFile(t, filepath.Join(dest, "site", "css", "app.css")) != "body{}\n" {
+		t.Fatalf("nested file not copied")
+	}
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK on rerun, got %s: %s", res.ReturnMsg, res.Output)
+	}
+
+	os.WriteFile(filepath.Join(src, "index.html"), []byte("<h1>hello</h1>\n"), 0o644)
+	res = UploadFile(context.Background(), h, src+"/", dest, FileAttrs{}, false, true)
+	if res.ReturnMsg != "CHANGED" || len(res.Data["changed_files"].([]interface{})) != 2 {
+		t.Fatalf("expected contents-only copy to report 2 files, got %s: %v", res.ReturnMsg, res.Data)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "index.html")); !os.IsNotExist(err) {
+		t.Fatalf("check mode wrote to the host")
+	}
+}
+
+func TestWriteContent(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	dest := filepath.Join(t.TempDir(), "hosts.allow")
+
+	if res := WriteContent(context.Background(), h, []byte("sshd: ALL\n"), dest, FileAttrs{Mode: "0600"}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if info, _ := os.Stat(dest); readFile(t, dest) != "sshd: ALL\n" || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected file: mode %v", info.Mode())
+	}
+	if res := WriteContent(context.Background(), h, []byte("sshd: ALL\n"), dest, FileAttrs{Mode: "0600"}, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK on rerun, got %s", res.ReturnMsg)
+	}
+}
+
+func TestUploadFileWithoutSFTP(t *testing.T) {
+	srv := shellServer(t)
+	srv.sftp = false
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("port=80\n"), 0o644)
+	dest := filepath.Join(t.TempDir(), "app.conf")
+
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if readFile(t, dest) != "port=80\n" {
+		t.Fatalf("unexpected content")
+	}
+}
+
+func TestRenderTemplateIsIdempotent(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	src := filepath.Join(t.TempDir(), "motd.tmpl")
+	os.WriteFile(src, []byte("hello {{ .name }}\n"), 0o644)
+	dest := filepath.Join(t.TempDir(), "motd")
+	vars := map[string]interface{}{"name": "web"}
+
+	if res := RenderTemplate(context.Background(), h, src, dest, FileAttrs{}, vars, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if res := RenderTemplate(context.Background(), h, src, dest, FileAttrs{}, vars, false, false); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK on rerun, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if got := readFile(t, dest); got != "hello web\n" {
+		t.Fatalf("unexpected rendered file: %q", got)
+	}
+}
+
+func TestUploadFileCheckModeDoesNotWrite(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(dest, []byte("port=80\n"), 0o644)
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("port=8080\n"), 0o644)
+
+	res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, true, true)
+	if res.ReturnMsg != "CHANGED" || !strings.Contains(res.Output, "-port=80") || !strings.Contains(res.Output, "+port=8080") {
+		t.Fatalf("expected predicted change with diff, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if readFile(t, dest) != "port=80\n" {
+		t.Fatalf("check mode modified the host")
+	}
+	os.WriteFile(src, []byte("port=80\n"), 0o644)
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, true); res.ReturnMsg != "OK" {
+		t.Fatalf("expected OK for matching content, got %s", res.ReturnMsg)
+	}
+}
+
+func TestReadRemoteFile(t *testing.T) {
+	srv := shellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sysctl.conf")
+	os.WriteFile(path, []byte("vm.swappiness = 60\n"), 0o644)
+
+	content, exists, err := ReadRemoteFile(context.Background(), h, path)
+	if err != nil || !exists || string(content) != "vm.swappiness = 60\n" {
+		t.Fatalf("ReadRemoteFile = %q, %v, %v", content, exists, err)
+	}
+	if _, exists, err := ReadRemoteFile(context.Background(), h, filepath.Join(dir, "missing")); err != nil || exists {
+		t.Fatalf("expected a missing file, got %v, %v", exists, err)
+	}
+	if _, _, err := ReadRemoteFile(context.Background(), h, dir); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("expected a directory to be refused, got %v", err)
+	}
+}
+
+func TestUploadFileStagesPrivatelyForBecomeUser(t *testing.T) {
+	srv, log := becomeShellServer(t)
+	defer CloseAll()
+	h := srv.host("web")
+	h.Become, h.BecomeUser = true, "app"
+
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte("secret=1\n"), 0o600)
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	if res := UploadFile(context.Background(), h, src, dest, FileAttrs{}, false, false); res.ReturnMsg != "CHANGED" {
+		t.Fatalf("expected CHANGED, got %s: %s", res.ReturnMsg, res.Output)
+	}
+	if readFile(t, dest) != "secret=1\n" {
+		t.Fatalf("unexpected content")
+	}
+
+	// The staging directory and file stay private to the login user; app
+	// only gets ACL entries.
+	calls := aclLog(t, log)
+	if len(calls) != 2 {
+		t.Fatalf("expected two setfacl calls, got %v", calls)
+	}
+	dir, file := calls[0], calls[1]
+	if dir[0] != "u:app:x" || dir[2] != "700" || file[0] != "u:app:r" || file[2] != "600" || filepath.Dir(file[1]) != dir[1] {
+		t.Fatalf("unexpected hand-off: %v", calls)
+	}
+	if _, err := os.Stat(dir[1]); !os.IsNotExist(err) {
+		t.Fatalf("staging directory %s was left behind: %v", dir[1], err)
+	}
+}
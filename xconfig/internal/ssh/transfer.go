@@ -0,0 +1,444 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"xconfig/internal/inventory"
+)
+
+// 文件传输：内容经 SFTP 流式写入目标目录中的临时文件（0600），再在同一目录内
+// rename 到目标路径，目标文件始终是完整的旧内容或新内容。启用 become 时先以登录用户
+// 上传到 /tmp 下的私有目录（0700），再以提权身份复制到目标目录后 rename；非 root 的
+// become 用户通过 ACL 获得读取权限，暂存文件从不对其他用户可读。远端未开启 SFTP
+// 子系统时，回退为通过 session 的 stdin 流式传输。
+
+// maxDiffSize bounds the files shown in diff mode; larger or binary files
+// are reported without a diff.
+const maxDiffSize = 1 << 20
+
+// FileAttrs are the permissions and ownership applied to transferred files.
+// Empty fields leave the attribute alone: a replaced file keeps its mode and
+// owner, a new file gets the remote user's umask and ownership.
+type FileAttrs struct {
+	Mode  string // octal, e.g. "0644"
+	Owner string
+	Group string
+}
+
+// Validate checks that Mode is an octal permission.
+func (a FileAttrs) Validate() error {
+	if a.Mode == "" {
+		return nil
+	}
+	if m, err := strconv.ParseUint(a.Mode, 8, 32); err != nil || m > 0o7777 {
+		return fmt.Errorf("invalid mode %q: use an octal mode such as 0644", a.Mode)
+	}
+	return nil
+}
+
+// changes lists the attributes of an existing file that differ from a.
+func (a FileAttrs) changes(st remoteStat) []string {
+	var out []string
+	if a.Mode != "" {
+		want, _ := strconv.ParseUint(a.Mode, 8, 32)
+		have, err := strconv.ParseUint(st.Mode, 8, 32)
+		if err != nil || want != have {
+			out = append(out, fmt.Sprintf("mode %04o", want))
+		}
+	}
+	if a.Owner != "" && a.Owner != st.Owner && a.Owner != st.UID {
+		out = append(out, "owner "+a.Owner)
+	}
+	if a.Group != "" && a.Group != st.Group && a.Group != st.GID {
+		out = append(out, "group "+a.Group)
+	}
+	return out
+}
+
+// commands returns shell commands applying a to the file in variable v.
+func (a FileAttrs) commands(v string) string {
+	var b strings.Builder
+	if a.Mode != "" {
+		fmt.Fprintf(&b, "chmod %s \"$%s\"\n", ShellQuote(a.Mode), v)
+	}
+	if a.Owner != "" {
+		fmt.Fprintf(&b, "chown %s \"$%s\"\n", ShellQuote(a.Owner), v)
+	}
+	if a.Group != "" {
+		fmt.Fprintf(&b, "chgrp %s \"$%s\"\n", ShellQuote(a.Group), v)
+	}
+	return b.String()
+}
+
+// remoteStat describes a remote path as reported by statScript.
+type remoteStat struct {
+	Exists   bool
+	IsDir    bool
+	Checksum string
+	Mode     string
+	UID      string
+	Owner    string
+	GID      string
+	Group    string
+	Size     int64
+}
+
+// statScript prints "absent", "dir" or "file <sha256> <mode> <uid> <user>
+// <gid> <group> <size>", with GNU/busybox stat or BSD stat.
+const statScript = `p=%s
+if [ -d "$p" ]; then echo dir
+elif [ -e "$p" ]; then
+  sum=$( (sha256sum "$p" 2>/dev/null || shasum -a 256 "$p") | cut -d' ' -f1)
+  echo file "$sum" $(stat -c '%%a %%u %%U %%g %%G %%s' "$p" 2>/dev/null || stat -f '%%Lp %%u %%Su %%g %%Sg %%z' "$p")
+else echo absent
+fi`
+
+func statRemote(ctx context.Context, h inventory.Host, p string) (remoteStat, error) {
+	res := RunShellCommand(ctx, h, fmt.Sprintf(statScript, ShellQuote(p)))
+	if res.ReturnMsg != "CHANGED" {
+		return remoteStat{}, fmt.Errorf("stat %s: %s", p, strings.TrimSpace(res.Output))
+	}
+	f := strings.Fields(res.Stdout)
+	switch {
+	case len(f) == 1 && f[0] == "absent":
+		return remoteStat{}, nil
+	case len(f) == 1 && f[0] == "dir":
+		return remoteStat{Exists: true, IsDir: true}, nil
+	case len(f) == 8 && f[0] == "file":
+		size, _ := strconv.ParseInt(f[7], 10, 64)
+		return remoteStat{Exists: true, Checksum: f[1], Mode: f[2], UID: f[3], Owner: f[4], GID: f[5], Group: f[6], Size: size}, nil
+	}
+	return remoteStat{}, fmt.Errorf("stat %s: unexpected output %q", p, res.Stdout)
+}
+
+// fileSource is the content of a transfer: a local file streamed from disk
+// or an in-memory buffer. content is kept when the file is small enough to
+// be diffed.
+type fileSource struct {
+	name    string // base name used when dest is a directory
+	open    func() (io.ReadCloser, error)
+	sum     string
+	size    int64
+	content []byte
+}
+
+func bytesSource(b []byte) fileSource {
+	return fileSource{
+		open:    func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil },
+		sum:     Checksum(b),
+		size:    int64(len(b)),
+		content: b,
+	}
+}
+
+// localSource hashes a local file without loading it into memory.
+func localSource(p string) (fileSource, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return fileSource{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fileSource{}, err
+	}
+	src := fileSource{
+		name: path.Base(p),
+		open: func() (io.ReadCloser, error) { return os.Open(p) },
+		sum:  hex.EncodeToString(hash.Sum(nil)),
+		size: size,
+	}
+	if size <= maxDiffSize {
+		if src.content, err = os.ReadFile(p); err != nil {
+			return fileSource{}, err
+		}
+	}
+	return src, nil
+}
+
+// putFile makes dest hold src with attrs. The result is OK when nothing had
+// to change; in check mode nothing is written.
+func putFile(ctx context.Context, h inventory.Host, src fileSource, dest string, attrs FileAttrs, diff, check bool) CommandResult {
+	st, err := statRemote(ctx, h, dest)
+	if err == nil && st.IsDir && src.name != "" {
+		dest = path.Join(dest, src.name)
+		st, err = statRemote(ctx, h, dest)
+	}
+	if err != nil {
+		return transferError(ctx, h, err)
+	}
+	if st.IsDir {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("%s is a directory", dest)}
+	}
+
+	data := map[string]interface{}{"checksum": src.sum, "dest": dest, "size": src.size}
+	contentChanged := !st.Exists || st.Checksum != src.sum
+	var attrChanges []string
+	if st.Exists {
+		attrChanges = attrs.changes(st)
+	}
+	if !contentChanged && len(attrChanges) == 0 {
+		return CommandResult{Host: h.Name, ReturnMsg: "OK", Output: fmt.Sprintf("%s is up to date", dest), Data: data}
+	}
+
+	var diffText string
+	if diff && contentChanged {
+		diffText = fileDiff(ctx, h, st, src, dest)
+	}
+	summary := fmt.Sprintf("%s updated", dest)
+	if !contentChanged {
+		summary = fmt.Sprintf("%s: set %s", dest, strings.Join(attrChanges, ", "))
+	}
+	if check {
+		res := CommandResult{Host: h.Name, ReturnMsg: "CHANGED", Output: "would update " + dest, Data: data}
+		if !contentChanged {
+			res.Output = "would " + strings.TrimPrefix(summary, dest+": ") + " on " + dest
+		}
+		if diffText != "" {
+			res.Output = diffText
+		}
+		return res
+	}
+
+	var res CommandResult
+	if contentChanged {
+		res = upload(ctx, h, src, dest, attrs)
+	} else {
+		res = RunShellCommand(ctx, h, fmt.Sprintf("set -e\nf=%s\n%s", ShellQuote(dest), attrs.commands("f")))
+	}
+	res.Data = data
+	if res.ReturnMsg == "CHANGED" {
+		res.Output = summary
+		if diffText != "" {
+			res.Output = diffText
+		}
+	}
+	return res
+}
+
+// fileDiff compares the remote file with src for diff mode.
+func fileDiff(ctx context.Context, h inventory.Host, st remoteStat, src fileSource, dest string) string {
+	if src.content == nil || st.Size > maxDiffSize {
+		return fmt.Sprintf("%s: diff skipped, file larger than %d bytes\n", dest, maxDiffSize)
+	}
+	before := ""
+	if st.Exists {
+		res := RunShellCommand(ctx, h, "cat "+ShellQuote(dest))
+		if res.ReturnMsg != "CHANGED" {
+			return ""
+		}
+		before = res.Stdout
+	}
+	if bytes.IndexByte(src.content, 0) >= 0 || strings.IndexByte(before, 0) >= 0 {
+		return fmt.Sprintf("%s: diff skipped, binary file\n", dest)
+	}
+	return Diff(before, string(src.content), dest)
+}
+
+// finalizeScript moves an uploaded file into place: it copies the staged
+// file next to dest when they differ, carries over the mode and owner of a
+// replaced file (or applies the umask to a new one), applies attrs and
+// renames it over dest. A staged copy in /tmp is left to the login user,
+// who owns it, to remove.
+func finalizeScript(staging, tmp, dest string, attrs FileAttrs) string {
+	return fmt.Sprintf(`set -e
+src=%s
+tmp=%s
+dest=%s
+trap 'rm -f "$tmp"' EXIT
+if [ "$src" != "$tmp" ]; then cp "$src" "$tmp"; fi
+if [ -e "$dest" ]; then
+  chmod "$(stat -c %%a "$dest" 2>/dev/null || stat -f %%Lp "$dest")" "$tmp"
+  chown "$(stat -c %%u:%%g "$dest" 2>/dev/null || stat -f %%u:%%g "$dest")" "$tmp" 2>/dev/null || true
+else
+  chmod "$(printf %%o $((0666 & ~$(umask))))" "$tmp"
+fi
+%smv -f "$tmp" "$dest"
+`, ShellQuote(staging), ShellQuote(tmp), ShellQuote(dest), attrs.commands("tmp"))
+}
+
+// loginHost returns h without become, for commands that must run as the
+// login user.
+func loginHost(h inventory.Host) inventory.Host {
+	h.Become = false
+	return h
+}
+
+// sharedUser returns the become user when it is neither empty nor root:
+// that user cannot read the login user's private files on its own.
+func sharedUser(h inventory.Host) string {
+	if h.Become && h.BecomeUser != "" && h.BecomeUser != "root" {
+		return h.BecomeUser
+	}
+	return ""
+}
+
+// grantScript gives user perm ("r", "wx", ...) on p through an ACL, leaving
+// the mode bits, and so everybody else, alone.
+func grantScript(user, perm, p string) string {
+	return fmt.Sprintf("setfacl -m %s %s", ShellQuote("u:"+user+":"+perm), ShellQuote(p))
+}
+
+// stagingDir creates a private (0700) directory in /tmp through which files
+// pass between the login user and the become user. A become user other than
+// root is granted perm on it through an ACL. cleanup removes the directory
+// as the login user, who owns it.
+func stagingDir(ctx context.Context, h inventory.Host, perm string) (string, func(), error) {
+	login := loginHost(h)
+	dir := path.Join("/tmp", ".xconfig-"+randomSuffix())
+	script := "umask 077 && mkdir " + ShellQuote(dir)
+	user := sharedUser(h)
+	if user != "" {
+		script += " && " + grantScript(user, perm, dir)
+	}
+	cleanup := func() { RunShellCommand(context.WithoutCancel(ctx), login, "rm -rf "+ShellQuote(dir)) }
+	if res := RunShellCommand(ctx, login, script); res.ReturnMsg != "CHANGED" {
+		cleanup()
+		msg := strings.TrimSpace(res.Output)
+		if user != "" {
+			msg += fmt.Sprintf(" (become_user %s needs setfacl on the host)", user)
+		}
+		return "", nil, fmt.Errorf("create staging directory: %s", msg)
+	}
+	return dir, cleanup, nil
+}
+
+// upload streams src to a temporary file and renames it over dest.
+func upload(ctx context.Context, h inventory.Host, src fileSource, dest string, attrs FileAttrs) CommandResult {
+	tmp := path.Join(path.Dir(dest), "."+path.Base(dest)+".xconfig-"+randomSuffix())
+	staging := tmp
+	if h.Become {
+		// The login user usually cannot write to dest's directory; stage the
+		// file in a private directory and let the become user copy it into
+		// place.
+		dir, cleanup, err := stagingDir(ctx, h, "x")
+		if err != nil {
+			return transferError(ctx, h, fmt.Errorf("upload %s: %w", dest, err))
+		}
+		defer cleanup()
+		staging = path.Join(dir, path.Base(tmp))
+	}
+
+	r, err := src.open()
+	if err != nil {
+		return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: fmt.Sprintf("read %s: %v", src.name, err)}
+	}
+	defer r.Close()
+	if err := streamFile(ctx, h, r, staging); err != nil {
+		return transferError(ctx, h, fmt.Errorf("upload %s: %w", dest, err))
+	}
+	if user := sharedUser(h); user != "" {
+		if res := RunShellCommand(ctx, loginHost(h), grantScript(user, "r", staging)); res.ReturnMsg != "CHANGED" {
+			return transferError(ctx, h, fmt.Errorf("upload %s: hand the staged file to %s: %s", dest, user, strings.TrimSpace(res.Output)))
+		}
+	}
+	return RunShellCommand(ctx, h, finalizeScript(staging, tmp, dest, attrs))
+}
+
+// streamFile writes r to remotePath as the login user, over SFTP when the
+// host offers it. The file is created private (0600).
+func streamFile(ctx context.Context, h inventory.Host, r io.Reader, remotePath string) error {
+	const mode = os.FileMode(0o600)
+	client, err := defaultPool.SFTP(ctx, h)
+	if errors.Is(err, errNoSFTP) {
+		return streamViaShell(ctx, h, r, remotePath, mode)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	// Closing the client aborts a transfer stuck on the network.
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	f, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err == nil {
+		_, err = f.ReadFrom(ctxReader{ctx, r})
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		client.Remove(remotePath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// streamViaShell pipes r into `cat` on the host for servers without SFTP.
+func streamViaShell(ctx context.Context, h inventory.Host, r io.Reader, remotePath string, mode os.FileMode) error {
+	session, err := defaultPool.NewSession(ctx, h)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stderr lockedBuffer
+	session.Stdin = r
+	session.Stderr = &stderr
+	cmd := fmt.Sprintf("umask 077 && cat > %s && chmod %04o %s", ShellQuote(remotePath), mode, ShellQuote(remotePath))
+	if err := runSession(ctx, session, cmd); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// ctxReader stops a transfer once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// transferError reports err, or the interruption when ctx is done.
+func transferError(ctx context.Context, h inventory.Host, err error) CommandResult {
+	if ctx.Err() != nil {
+		return Interrupted(h, ctx.Err(), CommandResult{})
+	}
+	return CommandResult{Host: h.Name, ReturnMsg: "FAILED", ReturnCode: 1, Output: err.Error()}
+}
+
+func randomSuffix() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sftpClient is an SFTP client bound to its own session on a pooled
+// connection.
+type sftpClient struct {
+	*sftp.Client
+	closeSession func() error
+}
+
+func (c *sftpClient) Close() error {
+	err := c.Client.Close()
+	c.closeSession()
+	return err
+}
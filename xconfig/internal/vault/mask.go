@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every registered secret in output.
+const Mask = "********"
+
+// minSecretLen keeps short guessed values (flags, small numbers, common
+// words) from turning unrelated output into asterisks. Explicit secrets are
+// registered whatever their length.
+const minSecretLen = 6
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]bool)
+	replacer  *strings.Replacer
+)
+
+// AddSecret registers a value to be masked. explicit marks values the
+// author declared secret, such as decrypted !vault values and the contents
+// of encrypted files; they are masked however short they are. Multi-line
+// values are also masked line by line, skipping lines shorter than
+// minSecretLen.
+func AddSecret(s string, explicit bool) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	added := false
+	for i, v := range append([]string{s}, strings.Split(s, "\n")...) {
+		v = strings.TrimSpace(v)
+		if v == "" || secrets[v] || (len(v) < minSecretLen && !(explicit && i == 0)) {
+			continue
+		}
+		secrets[v] = true
+		added = true
+	}
+	if !added {
+		return
+	}
+	list := make([]string, 0, len(secrets))
+	for v := range secrets {
+		list = append(list, v)
+	}
+	// Longer secrets first, so one containing another is masked whole.
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, 2*len(list))
+	for _, v := range list {
+		pairs = append(pairs, v, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// MaskSecrets hides every registered secret in s.
+func MaskSecrets(s string) string {
+	secretsMu.RLock()
+	r := replacer
+	secretsMu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// ResetSecrets forgets all registered secrets.
+func ResetSecrets() {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = make(map[string]bool)
+	replacer = nil
+}
@@ -0,0 +1,200 @@
+// Package vault encrypts secrets with AES-256-GCM under a key derived from a
+// password with scrypt. An encrypted payload is armored as text:
+//
+//	$XCONFIG_VAULT;1.0;AES256GCM
+//	<base64 of salt | nonce | ciphertext, wrapped at 64 columns>
+//
+// Whole files can be encrypted, or single YAML values tagged with !vault.
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Header starts every encrypted payload.
+const Header = "$XCONFIG_VAULT;1.0;AES256GCM"
+
+const (
+	saltSize  = 16
+	nonceSize = 12
+	lineWidth = 64
+)
+
+var (
+	// PasswordFile holds the vault password. An executable file is run and
+	// its output used instead, so the password can come from a secret store.
+	PasswordFile string
+	// PasswordPrompt asks the operator for the password when no file is set.
+	PasswordPrompt func() ([]byte, error)
+
+	// ErrDecrypt is returned for a wrong password or a corrupted payload.
+	ErrDecrypt = errors.New("vault: wrong password or corrupted data")
+
+	passwordMu sync.Mutex
+	password   []byte
+
+	keyMu    sync.Mutex
+	keyCache = make(map[[sha256.Size]byte][]byte)
+)
+
+// SetPassword sets the password used by Password, bypassing PasswordFile
+// and PasswordPrompt. A nil password clears it.
+func SetPassword(p []byte) {
+	passwordMu.Lock()
+	defer passwordMu.Unlock()
+	password = p
+}
+
+// Password returns the vault password, reading PasswordFile or asking
+// PasswordPrompt the first time it is needed.
+func Password() ([]byte, error) {
+	passwordMu.Lock()
+	defer passwordMu.Unlock()
+	if password != nil {
+		return password, nil
+	}
+	var p []byte
+	switch {
+	case PasswordFile != "":
+		var err error
+		if p, err = ReadPasswordFile(PasswordFile); err != nil {
+			return nil, err
+		}
+	case PasswordPrompt != nil:
+		var err error
+		if p, err = PasswordPrompt(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("vault: encrypted data found but no vault password given (use --vault-password-file)")
+	}
+	if len(p) == 0 {
+		return nil, errors.New("vault: empty vault password")
+	}
+	password = p
+	return p, nil
+}
+
+// ReadPasswordFile reads a password from path, running it when executable.
+func ReadPasswordFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("vault password file: %w", err)
+	}
+	var data []byte
+	if info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+		cmd := exec.Command(path)
+		cmd.Stderr = os.Stderr
+		data, err = cmd.Output()
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vault password file %s: %w", path, err)
+	}
+	p := bytes.TrimRight(data, "\r\n")
+	if len(p) == 0 {
+		return nil, fmt.Errorf("vault password file %s is empty", path)
+	}
+	return p, nil
+}
+
+// IsEncrypted reports whether data is an armored vault payload.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(Header))
+}
+
+// Encrypt seals plaintext with password and returns the armored payload,
+// ending in a newline.
+func Encrypt(plaintext, password []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	raw := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, []byte(Header))...)
+
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	var out bytes.Buffer
+	out.WriteString(Header + "\n")
+	for len(encoded) > 0 {
+		n := min(lineWidth, len(encoded))
+		out.WriteString(encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt opens an armored payload produced by Encrypt.
+func Decrypt(data, password []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, Header) {
+		return nil, errors.New("vault: not an encrypted payload")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text[len(Header):]), ""))
+	if err != nil || len(raw) < saltSize+nonceSize {
+		return nil, ErrDecrypt
+	}
+	gcm, err := newGCM(password, raw[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, raw[saltSize:saltSize+nonceSize], raw[saltSize+nonceSize:], []byte(Header))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// DecryptWithPassword decrypts data with the configured vault password.
+func DecryptWithPassword(data []byte) ([]byte, error) {
+	p, err := Password()
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(data, p)
+}
+
+// newGCM derives the key for salt. scrypt is deliberately slow, so keys are
+// cached per password and salt; files with many inline values stay fast to
+// load.
+func newGCM(password, salt []byte) (cipher.AEAD, error) {
+	id := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	keyMu.Lock()
+	key, ok := keyCache[id]
+	keyMu.Unlock()
+	if !ok {
+		var err error
+		if key, err = scrypt.Key(password, salt, 1<<15, 8, 1, 32); err != nil {
+			return nil, err
+		}
+		keyMu.Lock()
+		keyCache[id] = key
+		keyMu.Unlock()
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,129 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func usePassword(t *testing.T, p string) {
+	t.Helper()
+	SetPassword([]byte(p))
+	t.Cleanup(func() {
+		SetPassword(nil)
+		ResetSecrets()
+	})
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	plain := []byte("db_password: hunter22\n")
+	out, err := Encrypt(plain, []byte("s3cret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(out) || strings.Contains(string(out), "hunter22") {
+		t.Fatalf("unexpected payload:\n%s", out)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n")[1:] {
+		if len(line) > lineWidth {
+			t.Fatalf("payload line longer than %d: %q", lineWidth, line)
+		}
+	}
+
+	got, err := Decrypt(out, []byte("s3cret"))
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+	if _, err := Decrypt(out, []byte("wrong")); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt for a wrong password, got %v", err)
+	}
+	tampered := []byte(strings.Replace(string(out), Header, Header+"\nAAAA", 1))
+	if _, err := Decrypt(tampered, []byte("s3cret")); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt for a corrupted payload, got %v", err)
+	}
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "pw")
+	if err := os.WriteFile(plain, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "pw.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho from-script\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := ReadPasswordFile(plain); err != nil || string(p) != "s3cret" {
+		t.Fatalf("ReadPasswordFile(plain) = %q, %v", p, err)
+	}
+	if p, err := ReadPasswordFile(script); err != nil || string(p) != "from-script" {
+		t.Fatalf("ReadPasswordFile(script) = %q, %v", p, err)
+	}
+}
+
+func TestUnmarshalInlineAndWholeFile(t *testing.T) {
+	usePassword(t, "s3cret")
+	token, err := Encrypt([]byte("tok-123456"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := "user: deploy\n" + FormatInline("token", token)
+
+	var vars map[string]interface{}
+	if err := Unmarshal([]byte(doc), &vars); err != nil {
+		t.Fatalf("Unmarshal inline: %v", err)
+	}
+	if vars["user"] != "deploy" || vars["token"] != "tok-123456" {
+		t.Fatalf("unexpected vars: %v", vars)
+	}
+	if got := MaskSecrets("using tok-123456 as deploy"); got != "using "+Mask+" as deploy" {
+		t.Fatalf("inline value not masked: %q", got)
+	}
+
+	whole, err := Encrypt([]byte("db_user: postgres\ndb_pw: hunter22\nport: 543210\nenabled: true\npin: '4711'\n"+
+		"api:\n  url: https://example.com\n  tokens: [tok-aaaaaa, tok-bbbbbb]\nsmtp_login: mailpass\n"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars = nil
+	if err := UnmarshalVars(whole, &vars); err != nil {
+		t.Fatalf("UnmarshalVars: %v", err)
+	}
+	if vars["db_pw"] != "hunter22" || vars["db_user"] != "postgres" {
+		t.Fatalf("unexpected vars: %v", vars)
+	}
+	// Every string of an encrypted file is masked whatever its key or
+	// length; keys, numbers and booleans stay readable.
+	got := MaskSecrets("postgres:hunter22 db_pw pin=4711 543210 true https://example.com tok-aaaaaa tok-bbbbbb mailpass")
+	want := Mask + ":" + Mask + " db_pw pin=" + Mask + " 543210 true " + Mask + " " + Mask + " " + Mask + " " + Mask
+	if got != want {
+		t.Fatalf("MaskSecrets = %q, want %q", got, want)
+	}
+
+	SetPassword([]byte("other"))
+	if err := Unmarshal([]byte(doc), &vars); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt with the wrong password, got %v", err)
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	t.Cleanup(ResetSecrets)
+	AddSecret("abc", false)
+	AddSecret("password", false)
+	AddSecret("password123", false)
+	AddSecret("line-one\nline-two", false)
+	// A short value declared secret is masked anyway.
+	AddSecret("4711", true)
+
+	got := MaskSecrets("abc password123 password line-two pin 4711")
+	if want := "abc " + Mask + " " + Mask + " " + Mask + " pin " + Mask; got != want {
+		t.Fatalf("MaskSecrets = %q, want %q", got, want)
+	}
+	ResetSecrets()
+	if got := MaskSecrets("password"); got != "password" {
+		t.Fatalf("expected no masking after reset, got %q", got)
+	}
+}
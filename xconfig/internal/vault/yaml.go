@@ -0,0 +1,115 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tag marks a YAML scalar holding an encrypted value.
+const Tag = "!vault"
+
+// DecodeNode parses YAML that may be a whole encrypted file or contain
+// values tagged with !vault, and returns the decrypted document. Decrypted
+// !vault values are registered as secrets; so is every string value of a
+// whole encrypted file when secretFile is set.
+func DecodeNode(data []byte, secretFile bool) (*yaml.Node, error) {
+	encrypted := IsEncrypted(data)
+	if encrypted {
+		plain, err := DecryptWithPassword(data)
+		if err != nil {
+			return nil, err
+		}
+		data = plain
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := decryptNodes(&doc); err != nil {
+		return nil, err
+	}
+	if encrypted && secretFile {
+		addScalars(&doc)
+	}
+	return &doc, nil
+}
+
+// Unmarshal is yaml.Unmarshal with transparent vault decryption.
+func Unmarshal(data []byte, out interface{}) error {
+	doc, err := DecodeNode(data, false)
+	if err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	return doc.Decode(out)
+}
+
+// UnmarshalVars decodes a variables file. The string values of a wholly
+// encrypted vars file are masked in output.
+func UnmarshalVars(data []byte, out interface{}) error {
+	doc, err := DecodeNode(data, true)
+	if err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	return doc.Decode(out)
+}
+
+func decryptNodes(n *yaml.Node) error {
+	if n.Tag == Tag {
+		plain, err := DecryptWithPassword([]byte(n.Value))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		n.Tag = "!!str"
+		n.Value = string(plain)
+		n.Style = 0
+		AddSecret(n.Value, true)
+	}
+	for _, c := range n.Content {
+		if err := decryptNodes(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addScalars registers every string value below n as a secret: encrypting
+// the whole file marks all of it secret. Booleans, numbers and nulls are
+// skipped, and keys are left readable.
+func addScalars(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!str" {
+			AddSecret(n.Value, true)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			addScalars(n.Content[i])
+		}
+	default:
+		for _, c := range n.Content {
+			addScalars(c)
+		}
+	}
+}
+
+// FormatInline renders an encrypted value as a `name: !vault |` YAML entry,
+// or just the tagged block when name is empty.
+func FormatInline(name string, armored []byte) string {
+	var b strings.Builder
+	if name != "" {
+		b.WriteString(name + ": ")
+	}
+	b.WriteString(Tag + " |\n")
+	for _, line := range strings.Split(strings.TrimRight(string(armored), "\n"), "\n") {
+		b.WriteString("  " + line + "\n")
+	}
+	return b.String()
+}